@@ -20,7 +20,10 @@ It is not affiliated with or connected to Haystack Software.
 
 Passwords
 
-Arqfs reads necessary passwords from the OS X keychain.
+Arqfs reads necessary passwords from the keychain, as described
+in the documentation for package code.google.com/p/rsc/keychain:
+it consults environment variables first, then, on OS X, the system
+keychain, and then an encrypted password file and $HOME/.netrc.
 It expects at least two entries:
 
 The keychain entry for s3.amazonaws.com should list the Amazon S3 access ID
@@ -11,13 +11,31 @@
 // Decrypt input to output using password:
 //	crypt -d password <input >output
 //
+// Re-encrypt input, which may use an older encryption format,
+// to output in the current format:
+//	crypt -u password <input >output
+//
+// Generate a key pair, writing the private key to keyfile
+// and printing the public key:
+//	crypt -keygen >keyfile
+//
+// Encrypt input to output for the holders of one or more private keys,
+// giving the public keys directly (-r) or in a file, one per line (-R).
+// If a password is also given, it too can decrypt the output.
+//	crypt -r publickey -R recipientfile [password] <input >output
+//
+// Decrypt input to output using the private key in keyfile:
+//	crypt -d -i keyfile <input >output
+//
 // Yes, the password is a command-line argument. This is a demo of the
 // code.google.com/p/rsc/crypt package. It's not intended for real use.
 //
 package main
 
 import (
+	"crypto/rand"
 	"encoding/base64"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -26,29 +44,109 @@ import (
 	"code.google.com/p/rsc/crypt"
 )
 
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: crypt [-d | -u] [-r publickey] [-R recipientfile] [-i keyfile] [password] < input > output\n")
+	fmt.Fprintf(os.Stderr, "       crypt -keygen > keyfile\n")
+	os.Exit(2)
+}
+
+// A listFlag is a flag that can be repeated.
+type listFlag []string
+
+func (x *listFlag) String() string     { return strings.Join(*x, ",") }
+func (x *listFlag) Set(s string) error { *x = append(*x, s); return nil }
+
+var (
+	decryptFlag = flag.Bool("d", false, "decrypt")
+	upgradeFlag = flag.Bool("u", false, "re-encrypt in current format")
+	keygenFlag  = flag.Bool("keygen", false, "generate key pair")
+	identFlag   = flag.String("i", "", "decrypt using private key in `file`")
+	recipFlag   listFlag
+	recipFiles  listFlag
+)
+
 func main() {
-	args := os.Args[1:]
-	encrypt := true
-	if len(args) >= 1 && args[0] == "-d" {
-		encrypt = false
-		args = args[1:]
+	flag.Var(&recipFlag, "r", "encrypt to public `key`")
+	flag.Var(&recipFiles, "R", "encrypt to public keys listed in `file`")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *keygenFlag {
+		if flag.NArg() != 0 || *decryptFlag || *upgradeFlag {
+			usage()
+		}
+		keygen()
+		return
+	}
+
+	var password string
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		password = flag.Arg(0)
+	default:
+		usage()
+	}
+	decrypt := *decryptFlag || *upgradeFlag
+	encrypt := !*decryptFlag
+
+	var recips []*crypt.PublicKey
+	for _, s := range recipFlag {
+		k, err := crypt.ParsePublicKey(s)
+		if err != nil {
+			fatalf("-r %s: %v", s, err)
+		}
+		recips = append(recips, k)
+	}
+	for _, file := range recipFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		list, err := crypt.ParseRecipients(data)
+		if err != nil {
+			fatalf("%s: %v", file, err)
+		}
+		recips = append(recips, list...)
 	}
-	if len(args) != 1 || strings.HasPrefix(args[0], "-") {
-		fmt.Fprintf(os.Stderr, "usage: crypt [-d] password < input > output\n")
-		os.Exit(2)
+	var ident *crypt.PrivateKey
+	if *identFlag != "" {
+		ident = readIdentity(*identFlag)
+	}
+
+	if decrypt && ident == nil && password == "" ||
+		encrypt && len(recips) == 0 && password == "" ||
+		!encrypt && len(recips) > 0 || !decrypt && ident != nil {
+		usage()
 	}
-	password := args[0]
 
 	data, err := ioutil.ReadAll(os.Stdin)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "reading stdin: %v\n", err)
-		os.Exit(1)
+		fatalf("reading stdin: %v", err)
+	}
+	if decrypt {
+		pkt, err := base64.StdEncoding.DecodeString(strings.Map(noSpace, string(data)))
+		if err != nil {
+			fatalf("decoding input: %v", err)
+		}
+		if ident != nil {
+			data, err = crypt.DecryptWith(ident, pkt)
+		} else {
+			data, err = crypt.Decrypt(password, pkt)
+		}
+		if err != nil {
+			fatalf("%v", err)
+		}
 	}
 	if encrypt {
-		pkt, err := crypt.Encrypt(password, data)
+		var pkt []byte
+		if len(recips) > 0 {
+			pkt, err = crypt.EncryptTo(recips, password, data)
+		} else {
+			pkt, err = crypt.Encrypt(password, data)
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-			os.Exit(1)
+			fatalf("%v", err)
 		}
 		str := base64.StdEncoding.EncodeToString(pkt)
 		for len(str) > 60 {
@@ -57,18 +155,47 @@ func main() {
 		}
 		fmt.Printf("%s\n", str)
 	} else {
-		pkt, err := base64.StdEncoding.DecodeString(strings.Map(noSpace, string(data)))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "decoding input: %v\n", err)
-			os.Exit(1)
+		os.Stdout.Write(data)
+	}
+}
+
+// keygen writes a new private key to standard output,
+// preceded by a comment giving the public key,
+// and prints the public key to standard error.
+func keygen() {
+	pub, priv, err := crypt.GenerateKey(rand.Reader)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	fmt.Printf("# public key: %s\n%s\n", pub, priv)
+	fmt.Fprintf(os.Stderr, "public key: %s\n", pub)
+}
+
+// readIdentity reads the private key from file,
+// skipping blank lines and # comments.
+func readIdentity(file string) *crypt.PrivateKey {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
-		dec, err := crypt.Decrypt(password, pkt)
+		k, err := crypt.ParsePrivateKey(line)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-			os.Exit(1)
+			fatalf("%s: %v", file, err)
 		}
-		os.Stdout.Write(dec)
+		return k
 	}
+	fatalf("%s: no private key", file)
+	return nil
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
 }
 
 func noSpace(r rune) rune {
@@ -0,0 +1,153 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Mkext2 creates an EXT2 file system image, using
+// code.google.com/p/rsc/ext2.
+//
+// Create image, size bytes long, holding a copy of the directory tree dir:
+//
+//	mkext2 -d dir image size
+//
+// The size may have a K, M, or G suffix.  The copy preserves modes,
+// owners, modification times, and symbolic links; -root makes root
+// the owner of every file instead.
+//
+// For reproducible images, the creation time and the time of every
+// change is taken from -T, which defaults to $SOURCE_DATE_EPOCH if set,
+// and the volume UUID is derived from the settings unless given by -U.
+// Mkext2 checks the new file system before exiting.
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.google.com/p/rsc/ext2"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: mkext2 [-b blocksize] [-I inodesize] [-i bytes-per-inode] [-N inodes]\n")
+	fmt.Fprintf(os.Stderr, "              [-L label] [-U uuid] [-T time] [-d dir] [-root] image size\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+var (
+	blockFlag = flag.Int("b", 0, "block `size` in bytes (default 1024, or 4096 from 512M)")
+	inodeFlag = flag.Int("I", 0, "inode `size` in bytes (default 256)")
+	ratioFlag = flag.Int("i", 0, "`bytes` per inode (default 4096, or 16384 from 512M)")
+	countFlag = flag.Int("N", 0, "`number` of inodes (overrides -i)")
+	labelFlag = flag.String("L", "", "volume `label`")
+	uuidFlag  = flag.String("U", "", "volume `uuid`")
+	timeFlag  = flag.String("T", os.Getenv("SOURCE_DATE_EPOCH"), "creation `time`, as Unix seconds or RFC 3339 (default now)")
+	dirFlag   = flag.String("d", "", "copy the tree rooted at `dir` into the image")
+	rootFlag  = flag.Bool("root", false, "make root the owner of all files")
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		usage()
+	}
+	file := flag.Arg(0)
+	size, err := parseSize(flag.Arg(1))
+	if err != nil {
+		fatalf("invalid size %s", flag.Arg(1))
+	}
+
+	opt := &ext2.MkfsOptions{
+		BlockSize:     *blockFlag,
+		InodeSize:     *inodeFlag,
+		BytesPerInode: *ratioFlag,
+		Inodes:        *countFlag,
+		Label:         *labelFlag,
+	}
+	if *timeFlag != "" {
+		if opt.Time, err = parseTime(*timeFlag); err != nil {
+			fatalf("invalid time %s", *timeFlag)
+		}
+	}
+	if *uuidFlag != "" {
+		u, err := hex.DecodeString(strings.Replace(*uuidFlag, "-", "", -1))
+		if err != nil || len(u) != len(opt.UUID) {
+			fatalf("invalid uuid %s", *uuidFlag)
+		}
+		copy(opt.UUID[:], u)
+	}
+
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if err := f.Truncate(size); err != nil {
+		fatalf("%v", err)
+	}
+	efs, err := ext2.Mkfs(f, size, opt)
+	if err != nil {
+		fatalf("%s: %v", file, err)
+	}
+	if *dirFlag != "" {
+		var owner func(fs.FileInfo) (uid, gid int)
+		if *rootFlag {
+			owner = func(fs.FileInfo) (uid, gid int) { return 0, 0 }
+		}
+		root, err := efs.Root()
+		if err != nil {
+			fatalf("%s: %v", file, err)
+		}
+		if err := root.CopyFS(os.DirFS(*dirFlag), owner); err != nil {
+			fatalf("%s: copying %s: %v", file, *dirFlag, err)
+		}
+	}
+	if err := efs.Close(); err != nil {
+		fatalf("%s: %v", file, err)
+	}
+	if err := efs.Check(); err != nil {
+		fatalf("%s: check failed:\n%v", file, err)
+	}
+	if err := f.Close(); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// parseSize parses a size in bytes, with an optional K, M, or G suffix.
+func parseSize(s string) (int64, error) {
+	shift := uint(0)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		shift = 10
+	case strings.HasSuffix(s, "M"):
+		shift = 20
+	case strings.HasSuffix(s, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 || n > 1<<(63-shift)-1 {
+		return 0, fmt.Errorf("invalid size")
+	}
+	return n << shift, nil
+}
+
+// parseTime parses a time given as Unix seconds or in RFC 3339 format.
+func parseTime(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "mkext2: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -12,24 +12,68 @@ import (
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
 	"fmt"
 	"io"
 
 	"code.google.com/p/go.crypto/pbkdf2"
+	"code.google.com/p/go.crypto/scrypt"
 )
 
-// This program manipulates encrypted, signed packets with the following format:
-//	1 byte version
+// Password-encrypted packets have one of two formats,
+// distinguished by the version byte.
+// (Packets encrypted to public keys use version 2; see EncryptTo.)
+//
+// Version 0, which Encrypt no longer writes:
+//	1 byte version (0)
 //	8 byte salt
 //	4 byte key hash
 //	aes.BlockSize-byte IV
 //	aes.BlockSize-byte encryption (maybe longer)
 //	sha1.Size-byte HMAC signature
+//
+// The version 0 keys are derived using PBKDF2-SHA1 (see deriveKey),
+// and the data is encrypted with AES-128-CBC and signed with HMAC-SHA1.
+//
+// Version 1:
+//	1 byte version (1)
+//	3 byte scrypt parameters: log₂ N, r, p
+//	16 byte salt
+//	4 byte key hash
+//	12 byte nonce
+//	AES-GCM encryption, including 16-byte tag
+//
+// The version 1 key is derived using scrypt (see deriveKey1) with the
+// parameters recorded in the packet, and the data is encrypted with
+// AES-256-GCM, using everything before the encryption as additional
+// authenticated data.
+
+const version = 1
+
+// Default scrypt parameters for new packets: 32 MB of memory.
+const (
+	scryptLogN = 15
+	scryptR    = 8
+	scryptP    = 1
+)
+
+// Limits on the scrypt parameters accepted when decrypting.
+// Scrypt uses 128·r·N bytes of memory and time proportional to p·r·N.
+const (
+	maxScryptMem  = 1 << 30                               // 1 GB
+	maxScryptWork = 128 * scryptP * scryptR << scryptLogN // 128 times the default
+)
 
-const version = 0
+// Version 1 header layout.
+const (
+	saltLen1   = 16
+	nonceLen1  = 12
+	headerLen1 = 1 + 3 + saltLen1 + 4 + nonceLen1
+)
 
 // deriveKey returns the AES key, HMAC-SHA1 key, and key hash for
 // the given password, salt combination.
+// It is used for version 0 packets.
 func deriveKey(password string, salt []byte) (aesKey, hmacKey, keyHash []byte) {
 	const keySize = 16
 	key := pbkdf2.Key([]byte(password), salt, 4096, 2*keySize, sha1.New)
@@ -41,60 +85,108 @@ func deriveKey(password string, salt []byte) (aesKey, hmacKey, keyHash []byte) {
 	return
 }
 
+// deriveKey1 returns the AES key and key hash for the given password,
+// salt, and scrypt parameters (log₂ N, r, p).
+// It is used for version 1 packets.
+func deriveKey1(password string, salt []byte, params []byte) (aesKey, keyHash []byte, err error) {
+	logN, r, p := int64(params[0]), int64(params[1]), int64(params[2])
+	// Refuse parameters that would take unreasonable time or memory to check.
+	if logN < 1 || logN > 30 || r < 1 || p < 1 ||
+		128*r<<uint(logN) > maxScryptMem || p*r<<uint(logN) > maxScryptWork {
+		return nil, nil, fmt.Errorf("invalid key derivation parameters")
+	}
+	aesKey, err = scrypt.Key([]byte(password), salt, 1<<uint(logN), int(r), int(p), 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	h := sha256.New()
+	h.Write(aesKey)
+	keyHash = h.Sum(nil)[:4]
+	return aesKey, keyHash, nil
+}
+
+// newGCM returns an AES-GCM cipher using key.
+func newGCM(key []byte) cipher.AEAD {
+	aesBlock, err := aes.NewCipher(key)
+	if err != nil {
+		// Cannot happen - key is right size.
+		panic("aes: " + err.Error())
+	}
+	aead, err := cipher.NewGCM(aesBlock)
+	if err != nil {
+		panic("aes: " + err.Error())
+	}
+	return aead
+}
+
 // Encrypt encrypts the plaintext into an encrypted packet
 // using the given password. The password is required for
 // decryption.
 func Encrypt(password string, plaintext []byte) (encrypted []byte, err error) {
-	// Derive key material from password and salt.
-	salt := make([]byte, 8)
-	_, err = io.ReadFull(rand.Reader, salt)
+	pkt := make([]byte, headerLen1, headerLen1+len(plaintext)+16)
+	pkt[0] = version
+	params := pkt[1:4]
+	params[0], params[1], params[2] = scryptLogN, scryptR, scryptP
+	salt := pkt[4 : 4+saltLen1]
+	hash := pkt[4+saltLen1 : 4+saltLen1+4]
+	nonce := pkt[headerLen1-nonceLen1 : headerLen1]
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	aesKey, keyHash, err := deriveKey1(password, salt, params)
 	if err != nil {
 		return nil, err
 	}
-	aesKey, hmacKey, keyHash := deriveKey(password, salt)
+	copy(hash, keyHash)
+	return newGCM(aesKey).Seal(pkt, nonce, plaintext, pkt[:headerLen1]), nil
+}
+
+// Decrypt decrypts the encrypted packet using the given password.
+// It returns the decrypted data.
+// Decrypt reads packets of both the current and the older format,
+// as well as packets created by EncryptTo with a password.
+func Decrypt(password string, encrypted []byte) (plaintext []byte, err error) {
+	if len(encrypted) > 0 {
+		switch encrypted[0] {
+		case 1:
+			return decrypt1(password, encrypted)
+		case version2:
+			return decryptPassword2(password, encrypted)
+		}
+	}
+	return decrypt0(password, encrypted)
+}
 
-	// Pad.
-	n := aes.BlockSize - len(plaintext)%aes.BlockSize
-	dec := make([]byte, len(plaintext)+n)
-	copy(dec, plaintext)
-	for i := len(plaintext); i < len(dec); i++ {
-		dec[i] = byte(n)
+// decrypt1 decrypts a version 1 packet.
+func decrypt1(password string, encrypted []byte) ([]byte, error) {
+	if len(encrypted) < headerLen1+16 {
+		return nil, fmt.Errorf("encrypted packet too short")
 	}
+	header := encrypted[:headerLen1]
+	params := header[1:4]
+	salt := header[4 : 4+saltLen1]
+	hash := header[4+saltLen1 : 4+saltLen1+4]
+	nonce := header[headerLen1-nonceLen1:]
 
-	// Encrypt.
-	iv := make([]byte, aes.BlockSize)
-	_, err = io.ReadFull(rand.Reader, iv)
+	aesKey, keyHash, err := deriveKey1(password, salt, params)
 	if err != nil {
 		return nil, err
 	}
-	aesBlock, err := aes.NewCipher(aesKey)
+	if !bytes.Equal(hash, keyHash) {
+		return nil, fmt.Errorf("incorrect password")
+	}
+	dec, err := newGCM(aesKey).Open(nil, nonce, encrypted[headerLen1:], header)
 	if err != nil {
-		// Cannot happen - key is right size.
-		panic("aes: " + err.Error())
+		return nil, fmt.Errorf("cannot authenticate encrypted packet")
 	}
-	m := cipher.NewCBCEncrypter(aesBlock, iv)
-	enc := make([]byte, len(dec))
-	m.CryptBlocks(enc, dec)
-
-	// Construct packet.
-	var pkt []byte
-	pkt = append(pkt, version)
-	pkt = append(pkt, salt...)
-	pkt = append(pkt, keyHash...)
-	pkt = append(pkt, iv...)
-	pkt = append(pkt, enc...)
-
-	// Sign.
-	h := hmac.New(sha1.New, hmacKey)
-	h.Write(pkt)
-	pkt = append(pkt, h.Sum(nil)...)
-
-	return pkt, nil
+	return dec, nil
 }
 
-// Decrypt decrypts the encrypted packet using the given password.
-// It returns the decrypted data.
-func Decrypt(password string, encrypted []byte) (plaintext []byte, err error) {
+// decrypt0 decrypts a version 0 packet.
+func decrypt0(password string, encrypted []byte) (plaintext []byte, err error) {
 	// Pull apart packet.
 	pkt := encrypted
 	if len(pkt) < 1+8+4+2*aes.BlockSize+sha1.Size {
@@ -106,7 +198,7 @@ func Decrypt(password string, encrypted []byte) (plaintext []byte, err error) {
 	iv, pkt := pkt[:aes.BlockSize], pkt[aes.BlockSize:]
 	enc, sig := pkt[:len(pkt)-sha1.Size], pkt[len(pkt)-sha1.Size:]
 
-	if vers[0] != version || len(enc)%aes.BlockSize != 0 {
+	if vers[0] != 0 || len(enc)%aes.BlockSize != 0 {
 		return nil, fmt.Errorf("malformed encrypted packet")
 	}
 
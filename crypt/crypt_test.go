@@ -0,0 +1,175 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestEncrypt(t *testing.T) {
+	for _, text := range []string{"", "hello, world", string(make([]byte, 1000))} {
+		pkt, err := Encrypt("password", []byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkt[0] != version {
+			t.Errorf("Encrypt wrote version %d, want %d", pkt[0], version)
+		}
+		out, err := Decrypt("password", pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != text {
+			t.Errorf("Decrypt(Encrypt(%.10q)) = %.10q", text, out)
+		}
+		if _, err := Decrypt("wrong", pkt); err == nil {
+			t.Errorf("Decrypt with wrong password succeeded")
+		}
+		for _, i := range []int{1, 4, headerLen1 - 1, len(pkt) - 1} {
+			bad := append([]byte(nil), pkt...)
+			bad[i] ^= 1
+			if _, err := Decrypt("password", bad); err == nil {
+				t.Errorf("Decrypt with byte %d modified succeeded", i)
+			}
+		}
+	}
+}
+
+func TestScryptLimits(t *testing.T) {
+	pkt, err := Encrypt("password", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stream bytes.Buffer
+	w, err := NewWriter(&stream, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, params := range [][3]byte{
+		{0, 8, 1},
+		{22, 32, 1}, // 16 GB
+		{20, 16, 1}, // 2 GB
+		{15, 8, 255},
+		{31, 1, 1},
+	} {
+		bad := append([]byte(nil), pkt...)
+		copy(bad[1:4], params[:])
+		if _, err := Decrypt("password", bad); err == nil || !strings.Contains(err.Error(), "invalid key derivation parameters") {
+			t.Errorf("Decrypt with scrypt parameters %v = %v, want invalid parameters", params, err)
+		}
+		bad = append([]byte(nil), stream.Bytes()...)
+		copy(bad[1:4], params[:])
+		if _, err := NewReader(bytes.NewReader(bad), "password"); err == nil || !strings.Contains(err.Error(), "invalid key derivation parameters") {
+			t.Errorf("NewReader with scrypt parameters %v = %v, want invalid parameters", params, err)
+		}
+	}
+}
+
+// A version 0 packet written by an earlier version of Encrypt.
+const packet0 = "00db558d3d178822839af9ce90f4e81f48bcc83e6ce7bd968da682edb7301bb2c7707cccb7dbdcec945b5ef052bb6af3f9df78432bbf90a923ab6905c623517d9831ab972d67cb552e5b1604331c419ddd"
+
+func TestDecrypt0(t *testing.T) {
+	pkt, err := hex.DecodeString(packet0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Decrypt("old password", pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "version 0 plaintext\n"; string(out) != want {
+		t.Errorf("Decrypt = %q, want %q", out, want)
+	}
+	if _, err := Decrypt("new password", pkt); err == nil {
+		t.Errorf("Decrypt with wrong password succeeded")
+	}
+}
+
+func TestEncryptTo(t *testing.T) {
+	var pubs []*PublicKey
+	var privs []*PrivateKey
+	for i := 0; i < 3; i++ {
+		pub, priv, err := GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubs = append(pubs, pub)
+		privs = append(privs, priv)
+	}
+	text := []byte("for your eyes only")
+
+	pkt, err := EncryptTo(pubs[:2], "", text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, priv := range privs {
+		out, err := DecryptWith(priv, pkt)
+		if i < 2 && (err != nil || !bytes.Equal(out, text)) {
+			t.Errorf("DecryptWith(recipient %d) = %q, %v", i, out, err)
+		}
+		if i == 2 && err == nil {
+			t.Errorf("DecryptWith(non-recipient) succeeded")
+		}
+	}
+	if _, err := Decrypt("", pkt); err == nil {
+		t.Errorf("Decrypt with empty password succeeded")
+	}
+	bad := append([]byte(nil), pkt...)
+	bad[5] ^= 1
+	if _, err := DecryptWith(privs[0], bad); err == nil {
+		t.Errorf("DecryptWith of modified stanza succeeded")
+	}
+
+	pkt, err = EncryptTo(pubs[2:], "password", text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := Decrypt("password", pkt); err != nil || !bytes.Equal(out, text) {
+		t.Errorf("Decrypt(EncryptTo with password) = %q, %v", out, err)
+	}
+	if out, err := DecryptWith(privs[2], pkt); err != nil || !bytes.Equal(out, text) {
+		t.Errorf("DecryptWith(EncryptTo with password) = %q, %v", out, err)
+	}
+	if _, err := Decrypt("wrong", pkt); err == nil {
+		t.Errorf("Decrypt with wrong password succeeded")
+	}
+
+	if _, err := EncryptTo(nil, "", text); err == nil {
+		t.Errorf("EncryptTo with no recipients succeeded")
+	}
+}
+
+func TestKeyText(t *testing.T) {
+	pub, priv, err := GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub1, err := ParsePublicKey(pub.String())
+	if err != nil || *pub1 != *pub {
+		t.Errorf("ParsePublicKey(%s) = %v, %v", pub, pub1, err)
+	}
+	priv1, err := ParsePrivateKey(priv.String())
+	if err != nil || *priv1 != *priv {
+		t.Errorf("ParsePrivateKey round trip failed: %v", err)
+	}
+	if _, err := ParsePublicKey(priv.String()); err == nil {
+		t.Errorf("ParsePublicKey accepted private key")
+	}
+
+	list, err := ParseRecipients([]byte("# team\n" + pub.String() + "\n\n  " + pub.String() + "  \n"))
+	if err != nil || len(list) != 2 {
+		t.Errorf("ParseRecipients = %v, %v", list, err)
+	}
+	if _, err := ParseRecipients([]byte("garbage\n")); err == nil {
+		t.Errorf("ParseRecipients accepted garbage")
+	}
+}
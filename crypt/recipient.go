@@ -0,0 +1,306 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"code.google.com/p/go.crypto/curve25519"
+	"code.google.com/p/go.crypto/hkdf"
+)
+
+// Packets encrypted to recipients have this format:
+//	1 byte version (2)
+//	1 byte number of stanzas
+//	stanzas
+//	12 byte nonce
+//	AES-GCM encryption, including 16-byte tag
+//
+// The data is encrypted with AES-256-GCM using a random file key,
+// and everything before the encryption is additional authenticated data.
+// Each stanza wraps the file key for one recipient, by sealing it with
+// AES-256-GCM under a wrapping key and a zero nonce.
+// An X25519 stanza is:
+//	1 byte type (stanzaX25519)
+//	32 byte ephemeral public key
+//	48 byte wrapped file key
+// The wrapping key is derived using HKDF-SHA256 from the shared secret
+// between the ephemeral key and the recipient's key, with the ephemeral
+// and recipient public keys as salt.
+// A password stanza is:
+//	1 byte type (stanzaPassword)
+//	3 byte scrypt parameters: log₂ N, r, p
+//	16 byte salt
+//	48 byte wrapped file key
+// The wrapping key is derived from the password by deriveKey1.
+
+const (
+	version2       = 2
+	stanzaX25519   = 1
+	stanzaPassword = 2
+	fileKeyLen     = 32
+	wrappedLen     = fileKeyLen + 16
+	x25519Len      = 1 + 32 + wrappedLen
+	passwordLen    = 1 + 3 + saltLen1 + wrappedLen
+)
+
+var zeroNonce [nonceLen1]byte
+
+// A PublicKey is an X25519 public key, identifying a recipient.
+type PublicKey [32]byte
+
+// A PrivateKey is an X25519 private key, used to decrypt
+// packets encrypted to the corresponding PublicKey.
+type PrivateKey [32]byte
+
+// GenerateKey returns a new key pair, using randomness from rand.
+func GenerateKey(rand io.Reader) (*PublicKey, *PrivateKey, error) {
+	priv := new(PrivateKey)
+	if _, err := io.ReadFull(rand, priv[:]); err != nil {
+		return nil, nil, err
+	}
+	return priv.Public(), priv, nil
+}
+
+// Public returns the public key corresponding to k.
+func (k *PrivateKey) Public() *PublicKey {
+	pub := new(PublicKey)
+	curve25519.ScalarBaseMult((*[32]byte)(pub), (*[32]byte)(k))
+	return pub
+}
+
+const (
+	publicPrefix  = "crypt-pub-"
+	privatePrefix = "crypt-key-"
+)
+
+// String returns the text form of k, which ParsePublicKey accepts.
+func (k *PublicKey) String() string {
+	return publicPrefix + base64.RawURLEncoding.EncodeToString(k[:])
+}
+
+// String returns the text form of k, which ParsePrivateKey accepts.
+func (k *PrivateKey) String() string {
+	return privatePrefix + base64.RawURLEncoding.EncodeToString(k[:])
+}
+
+// ParsePublicKey parses the text form of a public key.
+func ParsePublicKey(s string) (*PublicKey, error) {
+	k := new(PublicKey)
+	if err := parseKey(s, publicPrefix, k[:]); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ParsePrivateKey parses the text form of a private key.
+func ParsePrivateKey(s string) (*PrivateKey, error) {
+	k := new(PrivateKey)
+	if err := parseKey(s, privatePrefix, k[:]); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func parseKey(s, prefix string, k []byte) error {
+	what := "public"
+	if prefix == privatePrefix {
+		what = "private"
+	}
+	if !strings.HasPrefix(s, prefix) {
+		return fmt.Errorf("malformed %s key", what)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s[len(prefix):])
+	if err != nil || len(b) != len(k) {
+		return fmt.Errorf("malformed %s key", what)
+	}
+	copy(k, b)
+	return nil
+}
+
+// ParseRecipients parses a list of public keys, one per line.
+// Blank lines and lines beginning with # are ignored.
+func ParseRecipients(data []byte) ([]*PublicKey, error) {
+	var keys []*PublicKey
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, err := ParsePublicKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// wrapKeyX25519 returns the key wrapping the file key for
+// the recipient pub, given the shared secret.
+func wrapKeyX25519(shared, ephemeral, pub *[32]byte) ([]byte, error) {
+	var zero [32]byte
+	if bytes.Equal(shared[:], zero[:]) {
+		// Low-order public key.
+		return nil, fmt.Errorf("invalid public key")
+	}
+	salt := append(append([]byte(nil), ephemeral[:]...), pub[:]...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared[:], salt, []byte("crypt x25519")), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// EncryptTo encrypts the plaintext into an encrypted packet that
+// can be decrypted by any of the recipients, using DecryptWith, and,
+// if password is not empty, by anyone who knows password, using Decrypt.
+func EncryptTo(recipients []*PublicKey, password string, plaintext []byte) ([]byte, error) {
+	n := len(recipients)
+	if password != "" {
+		n++
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("no recipients")
+	}
+	if n > 255 {
+		return nil, fmt.Errorf("too many recipients")
+	}
+
+	fileKey := make([]byte, fileKeyLen)
+	if _, err := io.ReadFull(rand.Reader, fileKey); err != nil {
+		return nil, err
+	}
+	pkt := []byte{version2, byte(n)}
+	for _, pub := range recipients {
+		_, eph, err := GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		ephPub := eph.Public()
+		var shared [32]byte
+		curve25519.ScalarMult(&shared, (*[32]byte)(eph), (*[32]byte)(pub))
+		key, err := wrapKeyX25519(&shared, (*[32]byte)(ephPub), (*[32]byte)(pub))
+		if err != nil {
+			return nil, err
+		}
+		pkt = append(pkt, stanzaX25519)
+		pkt = append(pkt, ephPub[:]...)
+		pkt = newGCM(key).Seal(pkt, zeroNonce[:], fileKey, nil)
+	}
+	if password != "" {
+		params := []byte{scryptLogN, scryptR, scryptP}
+		salt := make([]byte, saltLen1)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return nil, err
+		}
+		key, _, err := deriveKey1(password, salt, params)
+		if err != nil {
+			return nil, err
+		}
+		pkt = append(pkt, stanzaPassword)
+		pkt = append(pkt, params...)
+		pkt = append(pkt, salt...)
+		pkt = newGCM(key).Seal(pkt, zeroNonce[:], fileKey, nil)
+	}
+
+	nonce := make([]byte, nonceLen1)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	pkt = append(pkt, nonce...)
+	return newGCM(fileKey).Seal(pkt, nonce, plaintext, pkt), nil
+}
+
+// DecryptWith decrypts a packet created by EncryptTo,
+// using the private key of one of its recipients.
+func DecryptWith(key *PrivateKey, encrypted []byte) ([]byte, error) {
+	return decrypt2(encrypted, func(typ byte, stanza []byte) []byte {
+		if typ != stanzaX25519 {
+			return nil
+		}
+		var eph, shared [32]byte
+		copy(eph[:], stanza[:32])
+		curve25519.ScalarMult(&shared, (*[32]byte)(key), &eph)
+		wrap, err := wrapKeyX25519(&shared, &eph, (*[32]byte)(key.Public()))
+		if err != nil {
+			return nil
+		}
+		fileKey, err := newGCM(wrap).Open(nil, zeroNonce[:], stanza[32:], nil)
+		if err != nil {
+			return nil
+		}
+		return fileKey
+	})
+}
+
+// decryptPassword2 decrypts a version 2 packet using a password.
+func decryptPassword2(password string, encrypted []byte) ([]byte, error) {
+	return decrypt2(encrypted, func(typ byte, stanza []byte) []byte {
+		if typ != stanzaPassword {
+			return nil
+		}
+		wrap, _, err := deriveKey1(password, stanza[3:3+saltLen1], stanza[:3])
+		if err != nil {
+			return nil
+		}
+		fileKey, err := newGCM(wrap).Open(nil, zeroNonce[:], stanza[3+saltLen1:], nil)
+		if err != nil {
+			return nil
+		}
+		return fileKey
+	})
+}
+
+// decrypt2 decrypts a version 2 packet.  It calls unwrap for each
+// stanza, passing the stanza type and body, until unwrap returns
+// a file key.
+func decrypt2(encrypted []byte, unwrap func(typ byte, stanza []byte) []byte) ([]byte, error) {
+	if len(encrypted) < 2 || encrypted[0] != version2 {
+		return nil, fmt.Errorf("malformed encrypted packet")
+	}
+	n := int(encrypted[1])
+	p := encrypted[2:]
+	var fileKey []byte
+	for i := 0; i < n; i++ {
+		if len(p) < 1 {
+			return nil, fmt.Errorf("encrypted packet too short")
+		}
+		size := 0
+		switch p[0] {
+		case stanzaX25519:
+			size = x25519Len
+		case stanzaPassword:
+			size = passwordLen
+		default:
+			return nil, fmt.Errorf("malformed encrypted packet")
+		}
+		if len(p) < size {
+			return nil, fmt.Errorf("encrypted packet too short")
+		}
+		if fileKey == nil {
+			fileKey = unwrap(p[0], p[1:size])
+		}
+		p = p[size:]
+	}
+	if len(p) < nonceLen1+16 {
+		return nil, fmt.Errorf("encrypted packet too short")
+	}
+	if fileKey == nil {
+		return nil, fmt.Errorf("packet not encrypted for this key or password")
+	}
+	header := encrypted[:len(encrypted)-len(p)+nonceLen1]
+	dec, err := newGCM(fileKey).Open(nil, p[:nonceLen1], p[nonceLen1:], header)
+	if err != nil {
+		return nil, fmt.Errorf("cannot authenticate encrypted packet")
+	}
+	return dec, nil
+}
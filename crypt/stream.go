@@ -0,0 +1,233 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crypt
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Encrypted streams have the following format:
+//	1 byte version (streamVersion)
+//	3 byte scrypt parameters: log₂ N, r, p
+//	16 byte salt
+//	4 byte key hash
+//	4 byte chunk size
+//	7 byte nonce prefix
+//	sequence of chunks
+//
+// Each chunk is the AES-GCM encryption of chunk size bytes of data,
+// except for the final chunk, which holds less (possibly no) data.
+// The GCM nonce for a chunk is the nonce prefix, the 4-byte chunk
+// number, and a final byte that is 1 for the final chunk and 0 otherwise,
+// so that reordered, dropped, or truncated chunks fail to authenticate.
+// The stream header is the additional authenticated data for every chunk.
+// The key is derived from the password by deriveKey1, as for Encrypt.
+
+const (
+	streamVersion   = 0x80
+	streamHeaderLen = 1 + 3 + saltLen1 + 4 + 4 + 7
+	streamChunkSize = 64 << 10
+	maxChunkSize    = 16 << 20
+)
+
+// A streamCipher holds the state shared by stream readers and writers.
+type streamCipher struct {
+	aead   cipher.AEAD
+	header []byte
+	nonce  [12]byte
+	chunk  uint32
+	size   int // chunk size
+}
+
+// newStreamCipher returns a streamCipher using aesKey.
+func newStreamCipher(aesKey, header []byte) *streamCipher {
+	s := &streamCipher{aead: newGCM(aesKey), header: header}
+	copy(s.nonce[:7], header[streamHeaderLen-7:])
+	s.size = int(binary.BigEndian.Uint32(header[streamHeaderLen-11:]))
+	return s
+}
+
+// next sets the nonce for the next chunk.
+func (s *streamCipher) next(final bool) []byte {
+	binary.BigEndian.PutUint32(s.nonce[7:11], s.chunk)
+	s.nonce[11] = 0
+	if final {
+		s.nonce[11] = 1
+	}
+	s.chunk++
+	return s.nonce[:]
+}
+
+type streamWriter struct {
+	w   io.Writer
+	s   *streamCipher
+	buf []byte
+	err error
+}
+
+// NewWriter returns a writer that encrypts the data written to it
+// using the given password and writes the encrypted stream to w.
+// Unlike Encrypt, it holds at most one chunk of data in memory,
+// and a reader can detect corruption in a chunk without reading
+// the rest of the stream.
+//
+// The caller must call Close to write the final chunk.
+// Close does not close w.
+func NewWriter(w io.Writer, password string) (io.WriteCloser, error) {
+	header := make([]byte, streamHeaderLen)
+	header[0] = streamVersion
+	params := header[1:4]
+	params[0], params[1], params[2] = scryptLogN, scryptR, scryptP
+	salt := header[4 : 4+saltLen1]
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aesKey, keyHash, err := deriveKey1(password, salt, params)
+	if err != nil {
+		return nil, err
+	}
+	copy(header[4+saltLen1:], keyHash)
+	binary.BigEndian.PutUint32(header[streamHeaderLen-11:], streamChunkSize)
+	if _, err := io.ReadFull(rand.Reader, header[streamHeaderLen-7:]); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	s := newStreamCipher(aesKey, header)
+	return &streamWriter{w: w, s: s, buf: make([]byte, 0, s.size+s.aead.Overhead())}, nil
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for len(p) > 0 {
+		// Flush a full chunk only once there is more data,
+		// because the final chunk must be shorter than a full one.
+		if len(w.buf) == w.s.size {
+			if err := w.flush(false); err != nil {
+				return n, err
+			}
+		}
+		m := w.s.size - len(w.buf)
+		if m > len(p) {
+			m = len(p)
+		}
+		w.buf = append(w.buf, p[:m]...)
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+func (w *streamWriter) flush(final bool) error {
+	w.buf = w.s.aead.Seal(w.buf[:0], w.s.next(final), w.buf, w.s.header)
+	_, err := w.w.Write(w.buf)
+	w.buf = w.buf[:0]
+	if err != nil {
+		w.err = err
+	}
+	return err
+}
+
+func (w *streamWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) == w.s.size {
+		if err := w.flush(false); err != nil {
+			return err
+		}
+	}
+	if err := w.flush(true); err != nil {
+		return err
+	}
+	w.err = fmt.Errorf("write to closed encrypted stream")
+	return nil
+}
+
+type streamReader struct {
+	r    io.Reader
+	s    *streamCipher
+	buf  []byte // encrypted chunk
+	data []byte // unread decrypted data
+	err  error
+}
+
+// NewReader returns a reader that decrypts the encrypted stream read from r,
+// which must have been written by a writer returned by NewWriter,
+// using the given password.  It returns an error immediately if the
+// password is incorrect.  Reads return an error if the stream
+// has been modified or truncated; data returned before the error
+// has been authenticated but may not be the whole stream.
+func NewReader(r io.Reader, password string) (io.Reader, error) {
+	header := make([]byte, streamHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("encrypted stream too short")
+		}
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[streamHeaderLen-11:])
+	if header[0] != streamVersion || size == 0 || size > maxChunkSize {
+		return nil, fmt.Errorf("malformed encrypted stream")
+	}
+	aesKey, keyHash, err := deriveKey1(password, header[4:4+saltLen1], header[1:4])
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[4+saltLen1:4+saltLen1+4], keyHash) {
+		return nil, fmt.Errorf("incorrect password")
+	}
+	s := newStreamCipher(aesKey, header)
+	return &streamReader{r: r, s: s, buf: make([]byte, s.size+s.aead.Overhead())}, nil
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.readChunk()
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// readChunk reads and decrypts the next chunk into r.data,
+// setting r.err at the end of the stream or on error.
+func (r *streamReader) readChunk() {
+	n, err := io.ReadFull(r.r, r.buf)
+	final := false
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		final = true
+	default:
+		r.err = err
+		return
+	}
+	if n < r.s.aead.Overhead() {
+		r.err = fmt.Errorf("encrypted stream truncated")
+		return
+	}
+	data, err := r.s.aead.Open(r.buf[:0], r.s.next(final), r.buf[:n], r.s.header)
+	if err != nil {
+		r.err = fmt.Errorf("cannot authenticate encrypted stream")
+		return
+	}
+	r.data = data
+	if final {
+		r.err = io.EOF
+	}
+}
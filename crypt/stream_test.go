@@ -0,0 +1,102 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crypt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func encryptStream(t *testing.T, password string, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Write in odd-sized pieces to exercise chunk buffering.
+	for p := data; len(p) > 0; {
+		n := 1000 + len(p)%7777
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decryptStream(password string, enc []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(enc), password)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestStream(t *testing.T) {
+	for _, n := range []int{0, 1, streamChunkSize - 1, streamChunkSize, streamChunkSize + 1, 3*streamChunkSize + 12345} {
+		data := make([]byte, n)
+		rand.New(rand.NewSource(int64(n))).Read(data)
+		enc := encryptStream(t, "password", data)
+		out, err := decryptStream("password", enc)
+		if err != nil {
+			t.Errorf("%d bytes: %v", n, err)
+			continue
+		}
+		if !bytes.Equal(out, data) {
+			t.Errorf("%d bytes: decrypted data does not match", n)
+		}
+	}
+}
+
+func TestStreamTamper(t *testing.T) {
+	data := make([]byte, 3*streamChunkSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	enc := encryptStream(t, "password", data)
+	chunk := streamChunkSize + 16
+
+	if _, err := NewReader(bytes.NewReader(enc), "wrong"); err == nil {
+		t.Errorf("NewReader with wrong password succeeded")
+	}
+
+	bad := map[string][]byte{}
+	for _, cut := range []int{streamHeaderLen, streamHeaderLen + chunk, streamHeaderLen + 2*chunk, len(enc) - 1, streamHeaderLen + 100} {
+		if _, err := decryptStream("password", enc[:cut]); err == nil {
+			t.Errorf("stream truncated to %d bytes decrypted successfully", cut)
+		}
+	}
+
+	flip := append([]byte(nil), enc...)
+	flip[streamHeaderLen+chunk+10] ^= 1
+	bad["bit flip"] = flip
+
+	swap := append([]byte(nil), enc...)
+	c1 := swap[streamHeaderLen : streamHeaderLen+chunk]
+	c2 := swap[streamHeaderLen+chunk : streamHeaderLen+2*chunk]
+	tmp := append([]byte(nil), c1...)
+	copy(c1, c2)
+	copy(c2, tmp)
+	bad["reordered"] = swap
+
+	drop := append(append([]byte(nil), enc[:streamHeaderLen+chunk]...), enc[streamHeaderLen+2*chunk:]...)
+	bad["dropped chunk"] = drop
+
+	hdr := append([]byte(nil), enc...)
+	hdr[20] ^= 1
+	bad["header"] = hdr
+
+	for name, enc := range bad {
+		if _, err := decryptStream("password", enc); err == nil {
+			t.Errorf("%s: stream decrypted successfully", name)
+		}
+	}
+}
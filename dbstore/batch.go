@@ -0,0 +1,436 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbstore
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"reflect"
+)
+
+// A preparer is a Context that can prepare statements,
+// such as a *sql.DB, *sql.Tx, or *Tx.
+type preparer interface {
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+// A stmt is a command to be executed many times with different arguments.
+// If the context can prepare statements, the command is prepared
+// when it is first run a second time, so that a command run only once,
+// as by Insert, costs a single round trip.
+type stmt struct {
+	ctxt  Context
+	query string
+	used  bool
+	st    *sql.Stmt
+	debug bool
+}
+
+func newStmt(ctxt Context, query string) *stmt {
+	return &stmt{ctxt: ctxt, query: query}
+}
+
+// prepared reports whether s should use a prepared statement,
+// preparing it if this is the second use.
+func (s *stmt) prepared() (bool, error) {
+	if s.st != nil {
+		return true, nil
+	}
+	if !s.used {
+		s.used = true
+		return false, nil
+	}
+	ctxt, c := unwrap(s.ctxt)
+	p, ok := ctxt.(preparer)
+	if !ok {
+		return false, nil
+	}
+	query := s.query
+	if c != nil {
+		query = c.rebind(query)
+		s.debug = c.debug
+	}
+	st, err := p.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	s.st = st
+	return true, nil
+}
+
+func (s *stmt) exec(args ...interface{}) (sql.Result, error) {
+	if ok, err := s.prepared(); !ok {
+		if err != nil {
+			return nil, err
+		}
+		return s.ctxt.Exec(s.query, args...)
+	}
+	if s.debug {
+		fmt.Fprintf(os.Stderr, "SQL: %s %v\n", s.query, args)
+	}
+	return s.st.Exec(args...)
+}
+
+func (s *stmt) queryRows(args ...interface{}) (*sql.Rows, error) {
+	if ok, err := s.prepared(); !ok {
+		if err != nil {
+			return nil, err
+		}
+		return s.ctxt.Query(s.query, args...)
+	}
+	if s.debug {
+		fmt.Fprintf(os.Stderr, "SQL: %s %v\n", s.query, args)
+	}
+	return s.st.Query(args...)
+}
+
+func (s *stmt) close() {
+	if s != nil && s.st != nil {
+		s.st.Close()
+	}
+}
+
+// An inserter inserts values of a single type.
+type inserter struct {
+	t       *dtype
+	d       Dialect
+	nonkeys []*field
+	update  *stmt // update of existing value, for upsert; nil if not used
+	replace *stmt // insert or replace
+	newrow  *stmt // insert with rowid assigned by database; nil if no rowid
+	kids    []*childWriter
+}
+
+// newInserter returns an inserter for values of type t.
+// If upsert is true, the inserter updates the non-key fields of an
+// existing value with the same key fields instead of replacing it.
+func newInserter(ctxt Context, d Dialect, t *dtype, upsert bool) *inserter {
+	ins := &inserter{t: t, d: d}
+	for _, col := range t.fields {
+		if !col.key {
+			ins.nonkeys = append(ins.nonkeys, col)
+		}
+	}
+	if upsert && len(ins.nonkeys) > 0 {
+		ins.update = newStmt(ctxt, t.updateSQL(d, ins.nonkeys))
+	}
+	ins.replace = newStmt(ctxt, t.insertSQL(d, true))
+	if t.rowid != nil {
+		ins.newrow = newStmt(ctxt, t.insertSQL(d, false))
+	}
+	for _, c := range t.children {
+		ins.kids = append(ins.kids, newChildWriter(ctxt, d, c))
+	}
+	return ins
+}
+
+func (ins *inserter) close() {
+	ins.update.close()
+	ins.replace.close()
+	ins.newrow.close()
+	for _, w := range ins.kids {
+		w.close()
+	}
+}
+
+// insertSQL returns the command to insert a value of type t.
+// If withRowid is false, the command omits the rowid column,
+// so that the database assigns one, and, for PostgreSQL,
+// returns the assigned rowid.  It also omits the PostgreSQL
+// conflict clause: inserting an explicit rowid does not advance
+// the identity sequence, so an assigned rowid may already be in use,
+// and that insert must fail rather than overwrite the existing row.
+func (t *dtype) insertSQL(d Dialect, withRowid bool) string {
+	var cols, keys []string
+	for _, col := range t.fields {
+		if col.rowid && !withRowid {
+			continue
+		}
+		cols = append(cols, col.name)
+	}
+	if withRowid {
+		for _, col := range t.identity() {
+			keys = append(keys, col.name)
+		}
+	}
+	query := d.insertSQL(t.name, cols, keys)
+	if d == PostgreSQL && !withRowid {
+		query += " returning " + d.quote(t.rowid.name)
+	}
+	return query
+}
+
+// insert inserts rval, which must be an addressable struct value.
+// If the type has a rowid field that is zero, insert sets it to
+// the rowid assigned by the database.
+// Insert also replaces the rows in t's child tables.
+func (ins *inserter) insert(rval reflect.Value) error {
+	if err := ins.insertRow(rval); err != nil {
+		return err
+	}
+	for _, w := range ins.kids {
+		if err := w.write(rval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertRow inserts the row for rval into t's own table.
+func (ins *inserter) insertRow(rval reflect.Value) error {
+	t := ins.t
+	newrow := t.rowid != nil && rval.FieldByIndex(t.rowid.index).Int() == 0
+	var cols []*field
+	for _, col := range t.fields {
+		if col.rowid && newrow {
+			continue
+		}
+		cols = append(cols, col)
+	}
+	args, err := args(cols, rval)
+	if err != nil {
+		return err
+	}
+
+	if ins.update != nil && !newrow {
+		uargs, err := t.updateArgs(ins.nonkeys, rval)
+		if err != nil {
+			return err
+		}
+		res, err := ins.update.exec(uargs...)
+		if err != nil {
+			return err
+		}
+		count, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		// fall through to ordinary insert command
+	}
+
+	if !newrow {
+		_, err := ins.replace.exec(args...)
+		return err
+	}
+	var id int64
+	if ins.d == PostgreSQL {
+		rows, err := ins.newrow.queryRows(args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf("insert into %s returned no rowid", t.name)
+		}
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+	} else {
+		res, err := ins.newrow.exec(args...)
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+	}
+	rval.FieldByIndex(t.rowid.index).SetInt(id)
+	return nil
+}
+
+// Upsert inserts the value into the database or, if the database
+// already holds a value with the same key fields, updates that
+// value's other fields in place.  Unlike Insert, Upsert keeps the
+// existing row, so its rowid is unchanged.
+func (db *Storage) Upsert(ctxt Context, val interface{}) error {
+	t, _, err := db.findType(val, "Upsert")
+	if err != nil {
+		return err
+	}
+	return db.atomic(ctxt, t, func(ctxt Context) error {
+		ins := newInserter(ctxt, db.Dialect, t, true)
+		defer ins.close()
+		return ins.insert(reflect.ValueOf(val).Elem())
+	})
+}
+
+// findSlice returns the type stored in the slice vals and the
+// addressable struct values it holds.  The slice may have element
+// type T or *T for a registered struct type T; vals may also be a
+// pointer to such a slice.
+func (db *Storage) findSlice(vals interface{}, op string) (*dtype, []reflect.Value, error) {
+	v := reflect.ValueOf(vals)
+	if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Slice {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice {
+		return nil, nil, fmt.Errorf("invalid type %T - must be slice", vals)
+	}
+	et := v.Type().Elem()
+	ptr := et.Kind() == reflect.Ptr
+	if ptr {
+		et = et.Elem()
+	}
+	t, _, err := db.findType(reflect.New(et).Interface(), op)
+	if err != nil {
+		return nil, nil, err
+	}
+	list := make([]reflect.Value, v.Len())
+	for i := range list {
+		elem := v.Index(i)
+		if ptr {
+			if elem.IsNil() {
+				return nil, nil, fmt.Errorf("%s: nil element %d in %T", op, i, vals)
+			}
+			elem = elem.Elem()
+		}
+		list[i] = elem
+	}
+	return t, list, nil
+}
+
+// batch runs f in a transaction if ctxt can begin one,
+// or else directly using ctxt.
+func (db *Storage) batch(ctxt Context, f func(Context) error) error {
+	if c, _ := unwrap(ctxt); isBeginner(c) {
+		return Transaction(c, func(tx *Tx) error { return f(db.context(tx)) })
+	}
+	return f(db.context(ctxt))
+}
+
+// InsertAll inserts the values in the slice vals into the database,
+// as if by calling Insert for each.
+// The slice may have element type T or *T for a registered struct type T.
+// If ctxt is a *sql.DB, the values are inserted in a single transaction,
+// so that either all or none are inserted.
+func (db *Storage) InsertAll(ctxt Context, vals interface{}) error {
+	t, list, err := db.findSlice(vals, "InsertAll")
+	if err != nil {
+		return err
+	}
+	return db.batch(ctxt, func(ctxt Context) error {
+		return insertAll(ctxt, db.Dialect, t, list, t.fts4)
+	})
+}
+
+// UpsertAll inserts or updates the values in the slice vals,
+// as if by calling Upsert for each.
+// It accepts the same slices as InsertAll and, like InsertAll,
+// uses a single transaction when ctxt is a *sql.DB.
+func (db *Storage) UpsertAll(ctxt Context, vals interface{}) error {
+	t, list, err := db.findSlice(vals, "UpsertAll")
+	if err != nil {
+		return err
+	}
+	return db.batch(ctxt, func(ctxt Context) error {
+		return insertAll(ctxt, db.Dialect, t, list, true)
+	})
+}
+
+func insertAll(ctxt Context, d Dialect, t *dtype, list []reflect.Value, upsert bool) error {
+	ins := newInserter(ctxt, d, t, upsert)
+	defer ins.close()
+	for _, rval := range list {
+		if err := ins.insert(rval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteAll writes the named columns of the values in the slice vals,
+// as if by calling Write for each.  If any value is missing from the
+// database, WriteAll returns ErrNotFound.
+// It accepts the same slices as InsertAll and, like InsertAll,
+// uses a single transaction when ctxt is a *sql.DB.
+func (db *Storage) WriteAll(ctxt Context, vals interface{}, columns ...string) error {
+	t, list, err := db.findSlice(vals, "WriteAll")
+	if err != nil {
+		return err
+	}
+	cols, kids, err := t.columns(columns)
+	if err != nil {
+		return err
+	}
+	return db.batch(ctxt, func(ctxt Context) error {
+		w := db.newWriter(ctxt, t, cols, kids)
+		defer w.close()
+		for _, rval := range list {
+			if err := w.write(ctxt, rval); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// A writer writes some columns of values of a single type.
+type writer struct {
+	db     *Storage
+	t      *dtype
+	cols   []*field
+	update *stmt // update of cols; nil if there are none
+	kids   []*childWriter
+}
+
+// newWriter returns a writer for the columns cols
+// and the child tables kids of values of type t.
+func (db *Storage) newWriter(ctxt Context, t *dtype, cols []*field, kids []*child) *writer {
+	w := &writer{db: db, t: t, cols: cols}
+	if len(cols) > 0 {
+		w.update = newStmt(ctxt, t.updateSQL(db.Dialect, cols))
+	}
+	for _, c := range kids {
+		w.kids = append(w.kids, newChildWriter(ctxt, db.Dialect, c))
+	}
+	return w
+}
+
+func (w *writer) close() {
+	w.update.close()
+	for _, cw := range w.kids {
+		cw.close()
+	}
+}
+
+// write writes the columns of rval, which must be an addressable struct value.
+func (w *writer) write(ctxt Context, rval reflect.Value) error {
+	if w.update == nil {
+		// nothing to set, but want to provide error if not there.
+		if err := w.db.Read(ctxt, rval.Addr().Interface()); err != nil {
+			return err
+		}
+	} else {
+		args, err := w.t.updateArgs(w.cols, rval)
+		if err != nil {
+			return err
+		}
+		res, err := w.update.exec(args...)
+		if err != nil {
+			return err
+		}
+		count, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrNotFound
+		}
+	}
+	for _, cw := range w.kids {
+		if err := cw.write(rval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
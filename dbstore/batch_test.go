@@ -0,0 +1,220 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "code.google.com/p/gosqlite/sqlite3"
+)
+
+func batchDB(t *testing.T) (*sql.DB, *Storage) {
+	return openStorage(t, nil, new(Person), new(Msg))
+}
+
+func TestInsertAll(t *testing.T) {
+	db, storage := batchDB(t)
+	defer db.Close()
+
+	msgs := []Msg{{Z: true}, {Z: false}, {X: 10}}
+	if err := storage.InsertAll(db, msgs); err != nil {
+		t.Fatal(err)
+	}
+	if msgs[0].X != 1 || msgs[1].X != 2 || msgs[2].X != 10 {
+		t.Errorf("rowids after InsertAll = %d, %d, %d, want 1, 2, 10", msgs[0].X, msgs[1].X, msgs[2].X)
+	}
+
+	list := []*Person{&people[0], &people[1]}
+	if err := storage.InsertAll(db, &list); err != nil {
+		t.Fatal(err)
+	}
+	var all []Person
+	if err := storage.Select(db, &all, "order by Name"); err != nil {
+		t.Fatal(err)
+	}
+	if names(all) != "alice bob" {
+		t.Errorf("after InsertAll: %q", names(all))
+	}
+
+	if err := storage.InsertAll(db, people[0]); err == nil {
+		t.Errorf("InsertAll of non-slice succeeded")
+	}
+	if err := storage.InsertAll(db, []*Person{nil}); err == nil {
+		t.Errorf("InsertAll of nil element succeeded")
+	}
+	if err := storage.InsertAll(db, []Data1{{}}); err == nil {
+		t.Errorf("InsertAll of unregistered type succeeded")
+	}
+}
+
+func rowid(t *testing.T, db *sql.DB, name string) int64 {
+	var id int64
+	if err := db.QueryRow(`select rowid from "code.google.com/p/rsc/dbstore.Person" where Name = ?`, name).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestUpsert(t *testing.T) {
+	db, storage := batchDB(t)
+	defer db.Close()
+
+	if err := storage.InsertAll(db, people); err != nil {
+		t.Fatal(err)
+	}
+	id := rowid(t, db, "alice")
+
+	// Insert replaces the row; Upsert updates it in place.
+	p := people[0]
+	p.Age = 31
+	if err := storage.Upsert(db, &p); err != nil {
+		t.Fatal(err)
+	}
+	if rowid(t, db, "alice") != id {
+		t.Errorf("Upsert changed rowid")
+	}
+	if err := storage.Insert(db, &p); err != nil {
+		t.Fatal(err)
+	}
+	if rowid(t, db, "alice") == id {
+		t.Errorf("Insert did not change rowid")
+	}
+
+	update := []Person{{Name: "bob", Age: 26}, {Name: "erin", Age: 40}}
+	if err := storage.UpsertAll(db, update); err != nil {
+		t.Fatal(err)
+	}
+	var all []Person
+	if err := storage.Query(new(Person)).OrderBy("Name").Select(db, &all); err != nil {
+		t.Fatal(err)
+	}
+	if names(all) != "alice bob carol dave erin" || all[1].Age != 26 || all[4].Age != 40 {
+		t.Errorf("after UpsertAll: %+v", all)
+	}
+}
+
+func TestWriteAll(t *testing.T) {
+	db, storage := batchDB(t)
+	defer db.Close()
+
+	if err := storage.InsertAll(db, people); err != nil {
+		t.Fatal(err)
+	}
+	list := []Person{{Name: "alice", Age: 1, Email: "x"}, {Name: "bob", Age: 2, Email: "y"}}
+	if err := storage.WriteAll(db, list, "Age"); err != nil {
+		t.Fatal(err)
+	}
+	var p Person
+	if err := storage.Query(new(Person)).Where("Name", "=", "bob").Select(db, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Age != 2 || p.Email != people[1].Email {
+		t.Errorf("after WriteAll: %+v", p)
+	}
+
+	// A missing value fails the whole batch.
+	list = []Person{{Name: "alice", Age: 100}, {Name: "nobody", Age: 100}}
+	if err := storage.WriteAll(db, list, "Age"); err != ErrNotFound {
+		t.Errorf("WriteAll with missing value: %v, want ErrNotFound", err)
+	}
+	if n, _ := storage.Query(new(Person)).Where("Age", "=", 100).Count(db); n != 0 {
+		t.Errorf("failed WriteAll wrote %d values", n)
+	}
+	if err := storage.WriteAll(db, list, "Agee"); err == nil {
+		t.Errorf("WriteAll of unknown column succeeded")
+	}
+}
+
+// A prepareCounter is a transaction that counts the statements it prepares.
+type prepareCounter struct {
+	*sql.Tx
+	n int
+}
+
+func (c *prepareCounter) Prepare(query string) (*sql.Stmt, error) {
+	c.n++
+	return c.Tx.Prepare(query)
+}
+
+func TestPrepare(t *testing.T) {
+	db, storage := batchDB(t)
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	c := &prepareCounter{Tx: tx}
+
+	// Commands run once are not prepared.
+	if err := storage.Insert(c, &people[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Upsert(c, &people[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Write(c, &people[1], "Age"); err != nil {
+		t.Fatal(err)
+	}
+	if c.n != 0 {
+		t.Errorf("Insert, Upsert, and Write prepared %d statements, want 0", c.n)
+	}
+
+	// Commands run many times are prepared once.
+	if err := storage.InsertAll(c, people); err != nil {
+		t.Fatal(err)
+	}
+	if c.n != 1 {
+		t.Errorf("InsertAll prepared %d statements, want 1", c.n)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	db, storage := batchDB(t)
+	defer db.Close()
+
+	errAbort := errors.New("abort")
+	err := Transaction(db, func(tx *Tx) error {
+		if err := storage.Insert(tx, &people[0]); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("Transaction = %v, want %v", err, errAbort)
+	}
+	if n, _ := storage.Query(new(Person)).Count(db); n != 0 {
+		t.Errorf("rolled back transaction left %d values", n)
+	}
+
+	err = Transaction(db, func(tx *Tx) error {
+		if _, err := Begin(tx); err == nil {
+			t.Errorf("nested Begin succeeded")
+		}
+		return storage.InsertAll(tx, people)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := storage.Query(new(Person)).Count(db); n != len(people) {
+		t.Errorf("committed transaction left %d values, want %d", n, len(people))
+	}
+
+	tx, err := Begin(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete(tx, &people[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Read(db, &Person{Name: people[0].Name}); err != nil {
+		t.Errorf("Read after rollback: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbstore
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A child is a table holding the elements of a slice field,
+// with a row for each element.  The rows are keyed by the
+// identity of the value holding the slice (see dtype.identity)
+// and by the element's index in the slice.
+//
+// The child's table is described by an ordinary dtype,
+// for the struct type row, which has fields holding
+// the identity fields of the parent, the index, and the element.
+type child struct {
+	field *field       // slice field in parent
+	keys  []*field     // identity fields of parent
+	t     *dtype       // child table
+	row   reflect.Type // struct type of rows in t
+}
+
+// newChild returns the child holding the slice field col of parent.
+func (db *Storage) newChild(parent *dtype, col *field) *child {
+	c := &child{
+		field: col,
+		keys:  parent.identity(),
+		t: &dtype{
+			name:        parent.name + "." + col.name,
+			fieldByName: make(map[string]*field),
+		},
+	}
+
+	var sf []reflect.StructField
+	for i, key := range c.keys {
+		sf = append(sf, reflect.StructField{Name: fmt.Sprintf("Key%d", i), Type: key.typ})
+		c.t.add(&field{
+			key:    true,
+			name:   "parent." + key.name,
+			goname: "parent." + key.goname,
+			typ:    key.typ,
+			kind:   key.kind,
+			codec:  key.codec,
+			index:  []int{i},
+		})
+	}
+	n := len(c.keys)
+	intType := reflect.TypeOf(0)
+	sf = append(sf,
+		reflect.StructField{Name: "Seq", Type: intType},
+		reflect.StructField{Name: "Elem", Type: col.typ.Elem()},
+	)
+	c.row = reflect.StructOf(sf)
+
+	c.t.add(&field{key: true, name: "seq", goname: "seq", typ: intType, kind: kindInt, index: []int{n}})
+	elem := &field{name: "value", goname: col.goname + "[]", typ: col.typ.Elem(), index: []int{n + 1}}
+	db.addField(c.t, elem, nil, "", col.goname+"[].", nil)
+	return c
+}
+
+// where returns the condition selecting the rows of c
+// for a single parent value.
+func (c *child) where(d Dialect) string {
+	return d.whereSQL(c.t.keys[:len(c.keys)])
+}
+
+// A childWriter replaces the rows of a child table.
+type childWriter struct {
+	c   *child
+	del *stmt
+	ins *inserter
+}
+
+func newChildWriter(ctxt Context, d Dialect, c *child) *childWriter {
+	del := newStmt(ctxt, fmt.Sprintf("delete from %s where %s", d.quote(c.t.name), c.where(d)))
+	return &childWriter{c, del, newInserter(ctxt, d, c.t, false)}
+}
+
+func (w *childWriter) close() {
+	if w != nil {
+		w.del.close()
+		w.ins.close()
+	}
+}
+
+// write replaces the rows for the slice in the parent value rval.
+func (w *childWriter) write(rval reflect.Value) error {
+	c := w.c
+	keys, err := args(c.keys, rval)
+	if err != nil {
+		return err
+	}
+	if _, err := w.del.exec(keys...); err != nil {
+		return err
+	}
+
+	n := len(c.keys)
+	row := reflect.New(c.row).Elem()
+	for i, key := range c.keys {
+		row.Field(i).Set(rval.FieldByIndex(key.index))
+	}
+	slice := rval.FieldByIndex(c.field.index)
+	for i := 0; i < slice.Len(); i++ {
+		row.Field(n).SetInt(int64(i))
+		row.Field(n + 1).Set(slice.Index(i))
+		if err := w.ins.insert(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readChild reads the slice for the parent value rval from c.
+func readChild(ctxt Context, d Dialect, c *child, rval reflect.Value) error {
+	keys, err := args(c.keys, rval)
+	if err != nil {
+		return err
+	}
+	rows := reflect.New(reflect.SliceOf(c.row))
+	query := fmt.Sprintf("where %s order by %s", c.where(d), d.quote("seq"))
+	if err := selectRows(ctxt, d, c.t, ptrSliceStruct, rows.Interface(), query, keys); err != nil {
+		return err
+	}
+
+	rows = rows.Elem()
+	v := rval.FieldByIndex(c.field.index)
+	v.Set(reflect.Zero(v.Type()))
+	if rows.Len() > 0 {
+		slice := reflect.MakeSlice(v.Type(), rows.Len(), rows.Len())
+		for i := 0; i < rows.Len(); i++ {
+			slice.Index(i).Set(rows.Index(i).Field(len(c.keys) + 1))
+		}
+		v.Set(slice)
+	}
+	return nil
+}
+
+// deleteChild deletes the rows for the parent value rval from c.
+func deleteChild(ctxt Context, d Dialect, c *child, rval reflect.Value) error {
+	keys, err := args(c.keys, rval)
+	if err != nil {
+		return err
+	}
+	_, err = ctxt.Exec(fmt.Sprintf("delete from %s where %s", d.quote(c.t.name), c.where(d)), keys...)
+	return err
+}
@@ -0,0 +1,258 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbstore
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type Address struct {
+	Street string
+	City   string `dbstore:",index"`
+}
+
+type Stamp struct {
+	Version int
+}
+
+type Line struct {
+	Product string
+	Qty     int
+}
+
+type Order struct {
+	ID int64 `dbstore:",rowid"`
+	Stamp
+	Ship   Address
+	Bill   Address `dbstore:"billing"`
+	Tags   []string
+	Lines  []Line
+	Scores []float64
+}
+
+func orderDB(t *testing.T) (*sql.DB, *Storage) {
+	return openStorage(t, nil, new(Order))
+}
+
+func TestNestedColumns(t *testing.T) {
+	storage := new(Storage)
+	storage.Register(new(Order))
+	dt := storage.types[0]
+	var names []string
+	for _, col := range dt.fields {
+		names = append(names, col.name)
+	}
+	want := "ID Version Ship.Street Ship.City billing.Street billing.City"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("columns = %s, want %s", got, want)
+	}
+
+	names = nil
+	for _, t := range dt.tables() {
+		names = append(names, t.name)
+	}
+	want = "code.google.com/p/rsc/dbstore.Order code.google.com/p/rsc/dbstore.Order.Tags code.google.com/p/rsc/dbstore.Order.Lines code.google.com/p/rsc/dbstore.Order.Scores"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("tables = %s, want %s", got, want)
+	}
+
+	want = `create table "code.google.com/p/rsc/dbstore.Order.Lines" ("parent.ID" integer,"seq" integer,"Product" ,"Qty" integer, unique ("parent.ID", "seq") on conflict replace)`
+	if got := SQLite.createSQL(dt.children[1].t); got != want {
+		t.Errorf("child table:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestChildren(t *testing.T) {
+	db, storage := orderDB(t)
+	defer db.Close()
+
+	o1 := &Order{
+		Stamp:  Stamp{1},
+		Ship:   Address{"1 Main St", "Springfield"},
+		Bill:   Address{"PO Box 2", "Shelbyville"},
+		Tags:   []string{"rush", "gift"},
+		Lines:  []Line{{"widget", 2}, {"gadget", 1}},
+		Scores: []float64{1.5},
+	}
+	o2 := &Order{Ship: Address{City: "Capital City"}, Tags: []string{"bulk"}}
+	for _, o := range []*Order{o1, o2} {
+		if err := storage.Insert(db, o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if o1.ID == 0 || o2.ID == 0 {
+		t.Fatalf("rowids not assigned: %d, %d", o1.ID, o2.ID)
+	}
+
+	var all []Order
+	if err := storage.Select(db, &all, "order by ID"); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || !reflect.DeepEqual(&all[0], o1) || !reflect.DeepEqual(&all[1], o2) {
+		t.Fatalf("Select = %+v, want %+v, %+v", all, *o1, *o2)
+	}
+
+	var list []*Order
+	if err := storage.Query(new(Order)).Where("Ship.City", "=", "Capital City").Select(db, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || !reflect.DeepEqual(list[0], o2) {
+		t.Fatalf("Query = %+v, want %+v", list, *o2)
+	}
+
+	o1.Tags = []string{"late"}
+	o1.Lines = o1.Lines[:1]
+	o1.Bill.City = "Ogdenville"
+	if err := storage.Write(db, o1, "Tags", "Lines"); err != nil {
+		t.Fatal(err)
+	}
+	o := &Order{ID: o1.ID}
+	if err := storage.Read(db, o, "Tags", "Lines", "billing.City"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(o.Tags, o1.Tags) || !reflect.DeepEqual(o.Lines, o1.Lines) || o.Bill.City != "Shelbyville" || o.Scores != nil {
+		t.Fatalf("Read after Write = %+v", *o)
+	}
+
+	o2.Tags = nil
+	if err := storage.Upsert(db, o2); err != nil {
+		t.Fatal(err)
+	}
+	o = &Order{ID: o2.ID}
+	if err := storage.Read(db, o, "ALL"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(o, o2) {
+		t.Fatalf("Read after Upsert = %+v, want %+v", *o, *o2)
+	}
+
+	if err := storage.Delete(db, o1); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.QueryRow(`select count(*) from "code.google.com/p/rsc/dbstore.Order.Lines"`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("Delete left %d child rows", n)
+	}
+}
+
+func TestChildrenWriteMissing(t *testing.T) {
+	db, storage := orderDB(t)
+	defer db.Close()
+
+	missing := &Order{ID: 42, Tags: []string{"orphan"}}
+	if err := storage.Write(db, missing, "Tags"); err != ErrNotFound {
+		t.Errorf("Write of missing value = %v, want ErrNotFound", err)
+	}
+	if err := storage.WriteAll(db, []*Order{missing}, "Tags"); err != ErrNotFound {
+		t.Errorf("WriteAll of missing value = %v, want ErrNotFound", err)
+	}
+	if err := storage.Read(db, &Order{ID: 42}, "Tags"); err != ErrNotFound {
+		t.Errorf("Read of missing value = %v, want ErrNotFound", err)
+	}
+	var n int
+	if err := db.QueryRow(`select count(*) from "code.google.com/p/rsc/dbstore.Order.Tags"`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("Write of missing value left %d child rows", n)
+	}
+}
+
+func TestChildrenInsertAll(t *testing.T) {
+	db, storage := orderDB(t)
+	defer db.Close()
+
+	orders := []Order{
+		{Tags: []string{"a", "b"}},
+		{Tags: []string{"c"}},
+	}
+	if err := storage.InsertAll(db, orders); err != nil {
+		t.Fatal(err)
+	}
+	var all []Order
+	if err := storage.Query(new(Order)).OrderBy("ID").Select(db, &all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || strings.Join(all[0].Tags, ",") != "a,b" || strings.Join(all[1].Tags, ",") != "c" {
+		t.Fatalf("after InsertAll: %+v", all)
+	}
+}
+
+func TestChildrenMigrate(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	storage := new(Storage)
+	storage.Register(new(Order))
+	changes, err := storage.Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChanges(t, changes,
+		"create table code.google.com/p/rsc/dbstore.Order",
+		"create table code.google.com/p/rsc/dbstore.Order.Tags",
+		"create table code.google.com/p/rsc/dbstore.Order.Lines",
+		"create table code.google.com/p/rsc/dbstore.Order.Scores",
+	)
+	changes, err = storage.Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChanges(t, changes)
+}
+
+type NestedSlice struct {
+	Name  string
+	Lists []struct{ Items []int }
+}
+
+type BadAttr struct {
+	Name string
+	Ship Address `dbstore:",key"`
+}
+
+type BadType struct {
+	Name string
+	M    map[string]int
+}
+
+type DupColumn struct {
+	Name string
+	Addr Address `dbstore:"x"`
+	X    string  `dbstore:"x.City"`
+}
+
+func TestRegisterErrors(t *testing.T) {
+	tests := []struct {
+		val interface{}
+		err string
+	}{
+		{new(NestedSlice), "cannot store slice field Lists[].Items in slice element"},
+		{new(BadAttr), "cannot use attributes key on struct field Ship"},
+		{new(BadType), "field M has unsupported type map[string]int"},
+		{new(DupColumn), "duplicate column x.City"},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				err, _ := recover().(string)
+				if !strings.Contains(err, tt.err) {
+					t.Errorf("Register(%T): panic %q, want %q", tt.val, err, tt.err)
+				}
+			}()
+			new(Storage).Register(tt.val)
+		}()
+	}
+}
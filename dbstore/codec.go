@@ -0,0 +1,149 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbstore
+
+import (
+	"database/sql"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// A Codec converts between the values of a field and the text
+// stored in its column.  Fields with a codec are stored in text columns.
+//
+// A field uses a codec if its struct tag has the attribute json or text,
+// which select the JSON and Text codecs, or if its type was passed to
+// RegisterCodec.  Other fields whose types implement both
+// encoding.TextMarshaler and encoding.TextUnmarshaler use Text.
+type Codec interface {
+	// Encode returns the text to store for v, a value of the field's type.
+	Encode(v interface{}) (string, error)
+
+	// Decode sets *v, where v is a pointer to the field,
+	// to the value encoded in text.
+	Decode(text string, v interface{}) error
+}
+
+// JSON is a Codec that stores values in JSON form, using encoding/json.
+var JSON Codec = jsonCodec{}
+
+// Text is a Codec that stores values using their MarshalText and
+// UnmarshalText methods, as defined by encoding.TextMarshaler
+// and encoding.TextUnmarshaler.
+var Text Codec = textCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+func (jsonCodec) Decode(text string, v interface{}) error {
+	return json.Unmarshal([]byte(text), v)
+}
+
+type textCodec struct{}
+
+func (textCodec) Encode(v interface{}) (string, error) {
+	m, ok := v.(encoding.TextMarshaler)
+	if !ok {
+		return "", fmt.Errorf("%T is not an encoding.TextMarshaler", v)
+	}
+	data, err := m.MarshalText()
+	return string(data), err
+}
+
+func (textCodec) Decode(text string, v interface{}) error {
+	u, ok := v.(encoding.TextUnmarshaler)
+	if !ok {
+		return fmt.Errorf("%T is not an encoding.TextUnmarshaler", v)
+	}
+	return u.UnmarshalText([]byte(text))
+}
+
+var (
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// isText reports whether values of type t can be stored using Text.
+func isText(t reflect.Type) bool {
+	return t.Implements(textMarshalerType) && reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// RegisterCodec records that fields with the type of *val, where val is
+// a pointer, should be stored using c.  It must be called before
+// registering the types with such fields.
+func (db *Storage) RegisterCodec(val interface{}, c Codec) {
+	t := reflect.TypeOf(val)
+	if t == nil || t.Kind() != reflect.Ptr {
+		panic(fmt.Sprintf("dbstore.RegisterCodec: type %T is not pointer", val))
+	}
+	if db.codecs == nil {
+		db.codecs = make(map[reflect.Type]Codec)
+	}
+	db.codecs[t.Elem()] = c
+}
+
+// encode returns the column value for v, a value of the field's type.
+func (col *field) encode(v interface{}) (interface{}, error) {
+	if col.codec == nil {
+		return v, nil
+	}
+	text, err := col.codec.Encode(v)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s: %v", col.goname, err)
+	}
+	return text, nil
+}
+
+// arg returns the column value for the field in the struct rval.
+func (col *field) arg(rval reflect.Value) (interface{}, error) {
+	return col.encode(rval.FieldByIndex(col.index).Interface())
+}
+
+// args returns the column values for the fields cols in rval.
+func args(cols []*field, rval reflect.Value) ([]interface{}, error) {
+	var list []interface{}
+	for _, col := range cols {
+		arg, err := col.arg(rval)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, arg)
+	}
+	return list, nil
+}
+
+// dest returns the destination for scanning the column for the field
+// in the struct rval, along with a function to call after the scan to
+// finish setting the field, or nil if there is nothing more to do.
+func (col *field) dest(rval reflect.Value) (interface{}, func() error) {
+	v := rval.FieldByIndex(col.index)
+	switch {
+	case col.codec != nil:
+		var text sql.NullString
+		return &text, func() error {
+			if text.String == "" {
+				// Column added by Migrate, with no value yet.
+				v.Set(reflect.Zero(v.Type()))
+				return nil
+			}
+			if err := col.codec.Decode(text.String, v.Addr().Interface()); err != nil {
+				return fmt.Errorf("decoding %s: %v", col.goname, err)
+			}
+			return nil
+		}
+	case col.utf8:
+		return v.Addr().Interface(), func() error {
+			fixUTF8(v)
+			return nil
+		}
+	}
+	return v.Addr().Interface(), nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbstore
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A Color is stored using its MarshalText and UnmarshalText methods.
+type Color struct {
+	R, G, B uint8
+}
+
+func (c Color) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)), nil
+}
+
+func (c *Color) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	return err
+}
+
+// A Point is stored using pointCodec.
+type Point [2]int
+
+type pointCodec struct{}
+
+func (pointCodec) Encode(v interface{}) (string, error) {
+	p := v.(Point)
+	return fmt.Sprintf("%d,%d", p[0], p[1]), nil
+}
+
+func (pointCodec) Decode(text string, v interface{}) error {
+	p := v.(*Point)
+	_, err := fmt.Sscanf(text, "%d,%d", &p[0], &p[1])
+	return err
+}
+
+type Thing struct {
+	Name  string `dbstore:",key"`
+	Color Color
+	Attrs map[string]string `dbstore:",json"`
+	Where Point
+	IP    net.IP
+	Parts []Color `dbstore:",json"`
+}
+
+func thingDB(t *testing.T) (*sql.DB, *Storage) {
+	storage := new(Storage)
+	storage.RegisterCodec(new(Point), pointCodec{})
+	return openStorage(t, storage, new(Thing))
+}
+
+func TestCodec(t *testing.T) {
+	db, storage := thingDB(t)
+	defer db.Close()
+
+	things := []*Thing{
+		{
+			Name:  "one",
+			Color: Color{0x12, 0x34, 0x56},
+			Attrs: map[string]string{"size": "large"},
+			Where: Point{3, 4},
+			IP:    net.IPv4(10, 0, 0, 1),
+			Parts: []Color{{1, 2, 3}},
+		},
+		{Name: "two", Color: Color{0xff, 0, 0}, IP: net.IPv4(10, 0, 0, 2)},
+	}
+	for _, th := range things {
+		if err := storage.Insert(db, th); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var text string
+	if err := db.QueryRow(`select "Color" || ' ' || "Attrs" || ' ' || "Where" from "code.google.com/p/rsc/dbstore.Thing" where "Name" = 'one'`).Scan(&text); err != nil {
+		t.Fatal(err)
+	}
+	if want := `#123456 {"size":"large"} 3,4`; text != want {
+		t.Errorf("stored %s, want %s", text, want)
+	}
+
+	var all []*Thing
+	if err := storage.Select(db, &all, "order by Name"); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || !reflect.DeepEqual(all[0], things[0]) || !reflect.DeepEqual(all[1], things[1]) {
+		t.Fatalf("Select = %+v, want %+v", all, things)
+	}
+
+	var red []Thing
+	if err := storage.Query(new(Thing)).Where("Color", "=", Color{0xff, 0, 0}).Select(db, &red); err != nil {
+		t.Fatal(err)
+	}
+	if len(red) != 1 || red[0].Name != "two" {
+		t.Fatalf("Query by Color = %+v", red)
+	}
+
+	err := storage.Query(new(Thing)).Where("Color", "=", "#ff0000").Err()
+	if err == nil || !strings.Contains(err.Error(), "cannot compare") {
+		t.Errorf("Where Color = string: err = %v, want cannot compare", err)
+	}
+
+	th := &Thing{Name: "one"}
+	if err := storage.Read(db, th, "Where", "Attrs"); err != nil {
+		t.Fatal(err)
+	}
+	if th.Where != things[0].Where || th.Attrs["size"] != "large" || th.Color != (Color{}) {
+		t.Fatalf("Read = %+v", *th)
+	}
+}
@@ -13,7 +13,6 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"os"
 	"reflect"
 	"strings"
 	"time"
@@ -23,8 +22,13 @@ import (
 // A Storage records information about the data structures being stored.
 // It must be initialized by one or more calls to Register before the other methods are called.
 type Storage struct {
+	// Dialect is the SQL dialect of the database.
+	// The zero value is SQLite.
+	Dialect Dialect
+
 	types         []*dtype
 	typeByReflect map[reflect.Type]*dtype
+	codecs        map[reflect.Type]Codec
 }
 
 type dtype struct {
@@ -34,6 +38,7 @@ type dtype struct {
 	keys        []*field
 	rowid       *field
 	fts4        bool
+	children    []*child
 }
 
 type field struct {
@@ -41,11 +46,26 @@ type field struct {
 	rowid   bool
 	autoinc bool
 	utf8    bool
+	indexed bool
 	name    string
-	dbtype  string
+	goname  string // Go field name
+	typ     reflect.Type
+	kind    int   // kindInt, kindFloat, and so on
+	codec   Codec // codec for column value, or nil
 	index   []int
 }
 
+// Kinds of field, which determine the column type.
+const (
+	kindOther = iota
+	kindInt
+	kindFloat
+	kindBool
+	kindString
+	kindTime
+	kindBytes
+)
+
 // A Context represents the underlying SQL database.
 // Typically a *sql.DB is used as the Context implementation,
 // but the interface allows debugging adapters to be substituted.
@@ -61,31 +81,10 @@ const (
 	ptrSlicePtrStruct
 )
 
-type debugCtxt struct {
-	ctxt Context
-}
-
-func (d *debugCtxt) Exec(query string, args ...interface{}) (sql.Result, error) {
-	fmt.Fprintf(os.Stderr, "SQL: %s %v\n", query, args)
-	return d.ctxt.Exec(query, args...)
-}
-
-func (d *debugCtxt) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	fmt.Fprintf(os.Stderr, "SQL: %s %v\n", query, args)
-	return d.ctxt.Query(query, args...)
-}
-
 // If Debug is set to true, each Storage method will print a log of the SQL
 // commands being executed.
 var Debug = false
 
-func debugContext(ctxt Context) Context {
-	if Debug {
-		return &debugCtxt{ctxt}
-	}
-	return ctxt
-}
-
 func (db *Storage) findType(val interface{}, op string) (*dtype, int, error) {
 	t := reflect.TypeOf(val)
 	if t.Kind() != reflect.Ptr {
@@ -115,21 +114,67 @@ func (db *Storage) findType(val interface{}, op string) (*dtype, int, error) {
 
 // Register records that the storage should store values with the type of val,
 // which should be a pointer to a struct with exported fields.
+//
+// Fields holding structs other than time.Time are flattened: each of
+// their fields is stored in its own column, named for the struct field
+// and the inner field joined by a dot, as in "Addr.City".  The fields of
+// embedded structs are stored as if they were fields of the outer struct.
+//
+// Fields holding slices other than []byte are stored in child tables,
+// one per field, with a row for each slice element.  The child table
+// for field F is named for the table and the field, as in
+// "full/import/path.TypeName.F".  Its rows are keyed by the key fields
+// (or rowid) of the value holding the slice, in columns with the prefix
+// "parent.", and by the element's index in the slice, in column "seq".
+// An element is stored in column "value" or, if it is a struct, flattened
+// into columns as above.  Elements cannot themselves hold slices.
+// Insert, Upsert, Write, and Delete replace or delete the child rows
+// along with the value, and Read and Select read them back.
+// An empty slice is read back as nil.
+//
+// Fields with other types are stored using a Codec.
 func (db *Storage) Register(val interface{}) {
 	t := reflect.TypeOf(val)
 	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct || t.Elem().Name() == "" {
-		panic(fmt.Sprintf("dbstore.Register: type %T Is not pointer to named struct"))
+		panic(fmt.Sprintf("dbstore.Register: type %T is not pointer to named struct", val))
 	}
 	t = t.Elem()
 	dt := &dtype{
 		name:        t.PkgPath() + "." + t.Name(),
 		fieldByName: make(map[string]*field),
 	}
-	first := true
-	haveKey := false
-	haveRowid := false
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
+	var slices []*field
+	db.addFields(dt, t, nil, "", "", &slices)
+
+	if len(dt.fields) == 0 {
+		panic(fmt.Sprintf("dbstore.Register %s: no fields to store", dt.name))
+	}
+
+	if len(dt.keys) == 0 {
+		// retroactively make the first field a key
+		dt.keys = append(dt.keys, dt.fields[0])
+		dt.fields[0].key = true
+	}
+
+	for _, col := range slices {
+		dt.children = append(dt.children, db.newChild(dt, col))
+	}
+
+	db.types = append(db.types, dt)
+	if db.typeByReflect == nil {
+		db.typeByReflect = make(map[reflect.Type]*dtype)
+	}
+	db.typeByReflect[t] = dt
+}
+
+// addFields adds the exported fields of the struct type st to dt.
+// The fields are found at index within the stored struct, and their
+// column and Go names begin with prefix and goprefix.
+// Slice fields, to be stored in child tables, are appended to *slices;
+// if slices is nil, they are not allowed.
+func (db *Storage) addFields(dt *dtype, st reflect.Type, index []int, prefix, goprefix string, slices *[]*field) {
+	for i := 0; i < st.NumField(); i++ {
+		f := st.Field(i)
 		if f.PkgPath != "" {
 			continue
 		}
@@ -143,238 +188,222 @@ func (db *Storage) Register(val interface{}) {
 			xname = x[0]
 		}
 		df := &field{
-			name:  xname,
-			index: []int{i},
-		}
-		for _, attr := range x[1:] {
-			switch attr {
-			case "fts4":
-				if !first {
-					panic(fmt.Sprintf("dbstore.Register %s: fts4 must be attribute on first field", dt.name))
-				}
-				dt.fts4 = true
-			case "rowid":
-				if haveKey {
-					panic(fmt.Sprintf("dbstore.Register %s: cannot use rowid and key attributes in same struct", dt.name))
-				}
-				if haveRowid {
-					panic(fmt.Sprintf("dbstore.Register %s: cannot use rowid attribute ion multiple fields", dt.name))
-				}
-				df.rowid = true
-			case "key":
-				if haveRowid {
-					panic(fmt.Sprintf("dbstore.Register %s: cannot use rowid and key attributes in same struct", dt.name))
-				}
-				df.key = true
-			case "autoinc":
-				df.autoinc = true
-			case "utf8":
-				df.utf8 = true
-				if f.Type.Kind() != reflect.String {
-					panic(fmt.Sprintf("dbstore.Register %s: field %s has attr utf8 but type %s", dt.name, f.Name, f.Type))
-				}
-			}
+			name:   prefix + xname,
+			goname: goprefix + f.Name,
+			typ:    f.Type,
+			index:  append(index[:len(index):len(index)], i),
 		}
-		if df.autoinc && !df.rowid {
-			panic(fmt.Sprintf("dbstore.Register %s: cannot use autoinc without rowid attribute", dt.name))
+		if f.Anonymous && x[0] == "" {
+			db.addField(dt, df, x[1:], prefix, goprefix, slices)
+		} else {
+			db.addField(dt, df, x[1:], df.name+".", df.goname+".", slices)
 		}
-		if df.rowid && f.Type.Kind() != reflect.Int64 {
-			panic(fmt.Sprintf("dbstore.Register %s: rowid attribute must be used with int64 field", dt.name))
+	}
+}
+
+// addField adds the field df, with the tag attributes attrs, to dt.
+// If df is a struct to be flattened, its fields are added instead,
+// with column and Go names beginning with prefix and goprefix.
+func (db *Storage) addField(dt *dtype, df *field, attrs []string, prefix, goprefix string, slices *[]*field) {
+	for _, attr := range attrs {
+		switch attr {
+		case "fts4":
+			if len(dt.fields) > 0 || len(df.index) > 1 {
+				panic(fmt.Sprintf("dbstore.Register %s: fts4 must be attribute on first field", dt.name))
+			}
+			dt.fts4 = true
+		case "rowid":
+			if len(dt.keys) > 0 {
+				panic(fmt.Sprintf("dbstore.Register %s: cannot use rowid and key attributes in same struct", dt.name))
+			}
+			if dt.rowid != nil {
+				panic(fmt.Sprintf("dbstore.Register %s: cannot use rowid attribute ion multiple fields", dt.name))
+			}
+			df.rowid = true
+		case "key":
+			if dt.rowid != nil {
+				panic(fmt.Sprintf("dbstore.Register %s: cannot use rowid and key attributes in same struct", dt.name))
+			}
+			df.key = true
+		case "autoinc":
+			df.autoinc = true
+		case "index":
+			df.indexed = true
+		case "utf8":
+			df.utf8 = true
+			if df.typ.Kind() != reflect.String {
+				panic(fmt.Sprintf("dbstore.Register %s: field %s has attr utf8 but type %s", dt.name, df.goname, df.typ))
+			}
+		case "json":
+			df.codec = JSON
+		case "text":
+			df.codec = Text
 		}
+	}
+	if df.autoinc && !df.rowid {
+		panic(fmt.Sprintf("dbstore.Register %s: cannot use autoinc without rowid attribute", dt.name))
+	}
+	if df.rowid && df.typ.Kind() != reflect.Int64 {
+		panic(fmt.Sprintf("dbstore.Register %s: rowid attribute must be used with int64 field", dt.name))
+	}
 
-		switch f.Type.Kind() {
+	if df.codec == nil {
+		df.codec = db.codecs[df.typ]
+	}
+	switch f := df.typ; {
+	case df.codec != nil:
+		df.kind = kindString
+	case f == timeType:
+		df.kind = kindTime
+	case f.Kind() == reflect.Slice && f.Elem().Kind() == reflect.Uint8:
+		df.kind = kindBytes
+	default:
+		switch f.Kind() {
 		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int,
 			reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint, reflect.Uintptr:
-			df.dbtype = "integer"
-			if df.rowid {
-				df.dbtype += " primary key"
-				if df.autoinc {
-					df.dbtype += " autoincrement"
-				}
-			}
+			df.kind = kindInt
 		case reflect.Float32, reflect.Float64:
-			df.dbtype = "real"
-		case reflect.Bool, reflect.String:
-			// ok
-		case reflect.Struct:
-			if f.Type != reflect.TypeOf(time.Time{}) {
+			df.kind = kindFloat
+		case reflect.Bool:
+			df.kind = kindBool
+		case reflect.String:
+			df.kind = kindString
+		}
+	}
+
+	if df.kind == kindOther {
+		switch {
+		case isText(df.typ):
+			df.codec = Text
+			df.kind = kindString
+		case df.typ.Kind() == reflect.Struct:
+			if len(attrs) > 0 {
+				panic(fmt.Sprintf("dbstore.Register %s: cannot use attributes %s on struct field %s", dt.name, strings.Join(attrs, ","), df.goname))
 			}
-			df.dbtype = "timestamp"
-		case reflect.Slice:
-			if f.Type.Elem() != reflect.TypeOf(byte(0)) {
+			db.addFields(dt, df.typ, df.index, prefix, goprefix, slices)
+			return
+		case df.typ.Kind() == reflect.Slice:
+			if slices == nil {
+				panic(fmt.Sprintf("dbstore.Register %s: cannot store slice field %s in slice element", dt.name, df.goname))
 			}
-			df.dbtype = "blob"
+			if len(attrs) > 0 {
+				panic(fmt.Sprintf("dbstore.Register %s: cannot use attributes %s on slice field %s", dt.name, strings.Join(attrs, ","), df.goname))
+			}
+			*slices = append(*slices, df)
+			return
+		default:
+			panic(fmt.Sprintf("dbstore.Register %s: field %s has unsupported type %s; use a Codec", dt.name, df.goname, df.typ))
 		}
+	}
 
-		if dt.fts4 {
-			df.dbtype = ""
-		}
-		first = false
+	dt.add(df)
+}
 
-		dt.fields = append(dt.fields, df)
-		dt.fieldByName[xname] = df
-		if df.rowid {
-			dt.rowid = df
-		}
-		if df.key {
-			dt.keys = append(dt.keys, df)
-		}
-		if dt.fts4 && df.rowid {
-			df.name = "docid" // required name for FTS4 rowid column
-		}
+// add adds the column for df to dt.
+func (dt *dtype) add(df *field) {
+	if dt.fieldByName[df.name] != nil {
+		panic(fmt.Sprintf("dbstore.Register %s: duplicate column %s", dt.name, df.name))
 	}
-
-	if len(dt.fields) == 0 {
-		panic(fmt.Sprintf("dbstore.Register %s: no fields to store", dt.name))
+	dt.fields = append(dt.fields, df)
+	dt.fieldByName[df.name] = df
+	if df.rowid {
+		dt.rowid = df
 	}
-
-	if len(dt.keys) == 0 {
-		// retroactively make the first field a key
-		dt.keys = append(dt.keys, dt.fields[0])
-		dt.fields[0].key = true
+	if df.key {
+		dt.keys = append(dt.keys, df)
 	}
+	if dt.fts4 && df.rowid {
+		df.name = "docid" // required name for FTS4 rowid column
+	}
+}
 
-	db.types = append(db.types, dt)
-	if db.typeByReflect == nil {
-		db.typeByReflect = make(map[reflect.Type]*dtype)
+var timeType = reflect.TypeOf(time.Time{})
+
+// tables returns the tables holding values of type t:
+// its own table followed by its child tables.
+func (t *dtype) tables() []*dtype {
+	list := []*dtype{t}
+	for _, c := range t.children {
+		list = append(list, c.t)
 	}
-	db.typeByReflect[t] = dt
+	return list
 }
 
-// NOTE: All these %q should really be a custom quoting mechanism
-// that emits sqlite3-double-quoted escapes, but since we don't expect
-// to see double quotes or backslashes in the names, using %q is fine.
+// identity returns the fields identifying a value of type t:
+// its rowid if it has one, or else its key fields.
+func (t *dtype) identity() []*field {
+	if t.rowid != nil {
+		return []*field{t.rowid}
+	}
+	return t.keys
+}
 
 // CreateTables creates the tables to hold the registered types.
 // It only needs to be called when creating a new database.
 // Each table is named for the type it stores, in the form "full/import/path.TypeName".
+// Fields with the index attribute are indexed, in indexes named "full/import/path.TypeName.Field".
 func (db *Storage) CreateTables(ctxt Context) error {
-	ctxt = debugContext(ctxt)
-	var buf bytes.Buffer
+	ctxt = db.context(ctxt)
 	for _, t := range db.types {
-		buf.Reset()
-		if t.fts4 {
-			fmt.Fprintf(&buf, "create virtual table %q using fts4", t.name)
-		} else {
-			fmt.Fprintf(&buf, "create table %q", t.name)
-		}
-
-		fmt.Fprintf(&buf, " (")
-		sep := ""
-		for _, col := range t.fields {
-			if t.fts4 && col.rowid {
-				continue // fts4 rowid is implicit
-			}
-			fmt.Fprintf(&buf, "%s%q %s", sep, col.name, col.dbtype)
-			sep = ","
-		}
-		if len(t.keys) > 0 && t.rowid == nil {
-			fmt.Fprintf(&buf, ", unique (")
-			for i, col := range t.keys {
-				if i > 0 {
-					fmt.Fprintf(&buf, ", ")
-				}
-				fmt.Fprintf(&buf, "%q", col.name)
+		for _, t := range t.tables() {
+			if err := createTable(ctxt, db.Dialect, t); err != nil {
+				return err
 			}
-			fmt.Fprintf(&buf, ") on conflict replace")
-		}
-		fmt.Fprintf(&buf, ")")
-
-		if _, err := ctxt.Exec(buf.String()); err != nil {
-			return fmt.Errorf("creating table %s [%s]: %v", t.name, buf.String(), err)
 		}
 	}
-
 	return nil
 }
 
-// Insert inserts the value into the database.
-func (db *Storage) Insert(ctxt Context, val interface{}) error {
-	ctxt = debugContext(ctxt)
-	t, _, err := db.findType(val, "Insert")
-	if err != nil {
-		return err
+// createTable creates the table and indexes for t.
+func createTable(ctxt Context, d Dialect, t *dtype) error {
+	query := d.createSQL(t)
+	if _, err := ctxt.Exec(query); err != nil {
+		return fmt.Errorf("creating table %s [%s]: %v", t.name, query, err)
 	}
-
-	var args []interface{}
-	rval := reflect.ValueOf(val).Elem()
-	var rowcol *field
-	for _, col := range t.fields {
-		rv := rval.FieldByIndex(col.index)
-		if col.rowid && rv.Int() == 0 {
-			rowcol = col
-			args = append(args, nil)
-		} else {
-			args = append(args, rval.FieldByIndex(col.index).Interface())
+	for _, ix := range d.indexes(t) {
+		if err := createIndex(ctxt, t, ix); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	var buf bytes.Buffer
-	if t.fts4 {
-		fmt.Fprintf(&buf, "update %q", t.name)
-		fmt.Fprintf(&buf, " set ")
-		sep := ""
-		var args1 []interface{}
-		for i, col := range t.fields {
-			if !col.key {
-				fmt.Fprintf(&buf, "%s%q = ?", sep, col.name)
-				sep = ", "
-				args1 = append(args1, args[i])
-			}
-		}
-		fmt.Fprintf(&buf, " where ")
-		sep = ""
-		for i, col := range t.fields {
-			if col.key {
-				fmt.Fprintf(&buf, "%s%q = ?", sep, col.name)
-				sep = ", "
-				args1 = append(args1, args[i])
-			}
-		}
+// indexName returns the name of the index on column col of t.
+func (t *dtype) indexName(col *field) string {
+	return t.name + "." + col.name
+}
 
-		res, err := ctxt.Exec(buf.String(), args1...)
-		if err != nil {
-			return err
-		}
-		count, err := res.RowsAffected()
-		if err != nil {
-			return err
-		}
-		if count > 0 {
-			return nil
-		}
-		// fall through to ordinary insert command
-		buf.Reset()
+// createIndex creates the index ix on t.
+func createIndex(ctxt Context, t *dtype, ix index) error {
+	if _, err := ctxt.Exec(ix.sql); err != nil {
+		return fmt.Errorf("creating index %s [%s]: %v", ix.name, ix.sql, err)
 	}
+	return nil
+}
 
-	fmt.Fprintf(&buf, "insert or replace into %q (", t.name)
-	for i, col := range t.fields {
-		if i > 0 {
-			fmt.Fprintf(&buf, ", ")
-		}
-		fmt.Fprintf(&buf, "%q", col.name)
-	}
-	fmt.Fprintf(&buf, ") values (")
-	for i := range t.fields {
-		if i > 0 {
-			fmt.Fprintf(&buf, ", ")
-		}
-		fmt.Fprintf(&buf, "?")
+// atomic calls f with the Context to use for changing a value of type t.
+// If t has child tables, so that the change takes multiple commands,
+// atomic runs f in a transaction when ctxt can begin one.
+func (db *Storage) atomic(ctxt Context, t *dtype, f func(Context) error) error {
+	if len(t.children) > 0 {
+		return db.batch(ctxt, f)
 	}
-	fmt.Fprintf(&buf, ")")
+	return f(db.context(ctxt))
+}
 
-	res, err := ctxt.Exec(buf.String(), args...)
+// Insert inserts the value into the database.
+// If the database already holds a value with the same key fields,
+// Insert replaces it.
+func (db *Storage) Insert(ctxt Context, val interface{}) error {
+	t, _, err := db.findType(val, "Insert")
 	if err != nil {
 		return err
 	}
-	if rowcol != nil {
-		id, err := res.LastInsertId()
-		if err != nil {
-			return err
-		}
-		rval.FieldByIndex(rowcol.index).SetInt(id)
-	}
-	return nil
+	return db.atomic(ctxt, t, func(ctxt Context) error {
+		ins := newInserter(ctxt, db.Dialect, t, t.fts4)
+		defer ins.close()
+		return ins.insert(reflect.ValueOf(val).Elem())
+	})
 }
 
 // Delete deletes the value from the database.
@@ -384,46 +413,44 @@ func (db *Storage) Insert(ctxt Context, val interface{}) error {
 //	delete from Structs
 //	where Key1 = val.Key1 and Key2 = val.Key2
 func (db *Storage) Delete(ctxt Context, val interface{}) error {
-	ctxt = debugContext(ctxt)
 	t, _, err := db.findType(val, "Delete")
 	if err != nil {
 		return err
 	}
 
-	var buf bytes.Buffer
-	var args []interface{}
+	d := db.Dialect
 	rval := reflect.ValueOf(val).Elem()
-
-	fmt.Fprintf(&buf, "delete from %q where ", t.name)
-	sep := ""
-	for _, col := range t.fields {
-		if !col.key {
-			continue
-		}
-		args = append(args, rval.FieldByIndex(col.index).Interface())
-		fmt.Fprintf(&buf, "%s%q = ?", sep, col.name)
-		sep = " and "
+	keys, err := args(t.keys, rval)
+	if err != nil {
+		return err
 	}
-
-	_, err = ctxt.Exec(buf.String(), args...)
-	return err
+	return db.atomic(ctxt, t, func(ctxt Context) error {
+		for _, c := range t.children {
+			if err := deleteChild(ctxt, d, c, rval); err != nil {
+				return err
+			}
+		}
+		_, err := ctxt.Exec(fmt.Sprintf("delete from %s where %s", d.quote(t.name), d.whereSQL(t.keys)), keys...)
+		return err
+	})
 }
 
 // Read reads the named columns from the database into val.
 // The key fields in val must already be set.
+// The columns may include slice fields, which are read from child tables.
 //
 // Read executes a command like:
 //	select columns from Structs
 //	where Key1 = val.Key1 AND Key2 = val.Key2
 func (db *Storage) Read(ctxt Context, val interface{}, columns ...string) error {
-	ctxt = debugContext(ctxt)
+	ctxt = db.context(ctxt)
 	t, _, err := db.findType(val, "Read")
 	if err != nil {
 		return err
 	}
 
 	var buf bytes.Buffer
-	var args, scanargs []interface{}
+	var scanargs []interface{}
 	rval := reflect.ValueOf(val).Elem()
 
 	want := make(map[string]bool)
@@ -431,9 +458,10 @@ func (db *Storage) Read(ctxt Context, val interface{}, columns ...string) error
 		want[name] = true
 	}
 
+	d := db.Dialect
 	fmt.Fprintf(&buf, "select ")
 	sep := ""
-	var fixes []func()
+	var fixes []func() error
 	for _, col := range t.fields {
 		if !want[col.name] && !want["ALL"] {
 			continue
@@ -442,24 +470,29 @@ func (db *Storage) Read(ctxt Context, val interface{}, columns ...string) error
 		if col.key {
 			continue // already set
 		}
-		fmt.Fprintf(&buf, "%s%q", sep, col.name)
+		fmt.Fprintf(&buf, "%s%s", sep, d.quote(col.name))
 		sep = ", "
-		scanargs = append(scanargs, rval.FieldByIndex(col.index).Addr().Interface())
-		if col.utf8 {
-			fixes = append(fixes, func() {
-				v := rval.FieldByIndex(col.index)
-				s := v.String()
-				if !utf8.ValidString(s) {
-					v.SetString(string([]rune(s)))
-				}
-			})
+		dest, fix := col.dest(rval)
+		scanargs = append(scanargs, dest)
+		if fix != nil {
+			fixes = append(fixes, fix)
 		}
 	}
+	var kids []*child
+	for _, c := range t.children {
+		if !want[c.field.name] && !want["ALL"] {
+			continue
+		}
+		delete(want, c.field.name)
+		kids = append(kids, c)
+	}
+	var count *int
 	if sep == "" {
 		// nothing to select, but want to provide error if not there.
 		// select count of rows.
 		fmt.Fprintf(&buf, "count(*)")
-		scanargs = append(scanargs, new(int))
+		count = new(int)
+		scanargs = append(scanargs, count)
 	}
 
 	delete(want, "ALL")
@@ -472,18 +505,36 @@ func (db *Storage) Read(ctxt Context, val interface{}, columns ...string) error
 		}
 	}
 
-	fmt.Fprintf(&buf, " from %q where ", t.name)
-	sep = ""
-	for _, col := range t.fields {
-		if !col.key {
-			continue
+	keys, err := args(t.keys, rval)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(&buf, " from %s where %s", d.quote(t.name), d.whereSQL(t.keys))
+	if err := queryRow(ctxt, buf.String(), keys, scanargs); err != nil {
+		return err
+	}
+	if count != nil && *count == 0 {
+		return ErrNotFound
+	}
+
+	for _, fix := range fixes {
+		if err := fix(); err != nil {
+			return err
+		}
+	}
+	for _, c := range kids {
+		if err := readChild(ctxt, d, c, rval); err != nil {
+			return err
 		}
-		fmt.Fprintf(&buf, "%s%q = ?", sep, col.name)
-		sep = " and "
-		args = append(args, rval.FieldByIndex(col.index).Interface())
 	}
 
-	rows, err := ctxt.Query(buf.String(), args...)
+	return nil
+}
+
+// queryRow runs the query and scans the first row of its result into dest.
+// It returns ErrNotFound if there are no rows.
+func queryRow(ctxt Context, query string, args, dest []interface{}) error {
+	rows, err := ctxt.Query(query, args...)
 	if err != nil {
 		return err
 	}
@@ -495,43 +546,40 @@ func (db *Storage) Read(ctxt Context, val interface{}, columns ...string) error
 		}
 		return ErrNotFound
 	}
-
-	if err := rows.Scan(scanargs...); err != nil {
-		return err
-	}
-
-	for _, fix := range fixes {
-		fix()
-	}
-
-	return nil
+	return rows.Scan(dest...)
 }
 
 // Write writes the named columns from val into the database.
 // The key fields in val must already be set and the value must already exist.
+// The columns may include slice fields, whose child table rows are replaced.
 //
 // Write executes a command like:
 //	update Structs
 //	set column1 = val.Column1, column2 = val.Column2
 //	where Key1 = val.Key1 AND Key2 = val.Key2
 func (db *Storage) Write(ctxt Context, val interface{}, columns ...string) error {
-	ctxt = debugContext(ctxt)
 	t, _, err := db.findType(val, "Write")
 	if err != nil {
 		return err
 	}
+	cols, kids, err := t.columns(columns)
+	if err != nil {
+		return err
+	}
+	return db.atomic(ctxt, t, func(ctxt Context) error {
+		w := db.newWriter(ctxt, t, cols, kids)
+		defer w.close()
+		return w.write(ctxt, reflect.ValueOf(val).Elem())
+	})
+}
 
-	var buf bytes.Buffer
-	var args []interface{}
-	rval := reflect.ValueOf(val).Elem()
-
+// columns returns the non-key fields and the children of t with the given names.
+func (t *dtype) columns(names []string) ([]*field, []*child, error) {
 	want := make(map[string]bool)
-	for _, name := range columns {
+	for _, name := range names {
 		want[name] = true
 	}
-
-	fmt.Fprintf(&buf, "update %q set ", t.name)
-	sep := ""
+	var cols []*field
 	for _, col := range t.fields {
 		if !want[col.name] {
 			continue
@@ -540,50 +588,44 @@ func (db *Storage) Write(ctxt Context, val interface{}, columns ...string) error
 		if col.key {
 			continue // already set
 		}
-		fmt.Fprintf(&buf, "%s%q = ?", sep, col.name)
-		sep = ", "
-		args = append(args, rval.FieldByIndex(col.index).Interface())
+		cols = append(cols, col)
 	}
-	if sep == "" {
-		// nothing to set, but want to provide error if not there.
-		return db.Read(ctxt, val)
+	var kids []*child
+	for _, c := range t.children {
+		if want[c.field.name] {
+			delete(want, c.field.name)
+			kids = append(kids, c)
+		}
 	}
-
 	if len(want) != 0 {
 		// some column wasn't found
-		for _, name := range columns {
+		for _, name := range names {
 			if want[name] {
-				return fmt.Errorf("unknown column %q", name)
+				return nil, nil, fmt.Errorf("unknown column %q", name)
 			}
 		}
 	}
+	return cols, kids, nil
+}
 
-	fmt.Fprintf(&buf, " where ")
-	sep = ""
-	for _, col := range t.fields {
-		if !col.key {
-			continue
+// updateSQL returns the command to set the columns cols of a value of type t.
+// Its arguments are the values of cols followed by the values of the key fields.
+func (t *dtype) updateSQL(d Dialect, cols []*field) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "update %s set ", d.quote(t.name))
+	for i, col := range cols {
+		if i > 0 {
+			fmt.Fprintf(&buf, ", ")
 		}
-		fmt.Fprintf(&buf, "%s%q = ?", sep, col.name)
-		sep = " and "
-		args = append(args, rval.FieldByIndex(col.index).Interface())
-	}
-
-	res, err := ctxt.Exec(buf.String(), args...)
-	if err != nil {
-		return err
-	}
-
-	count, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-
-	if count == 0 {
-		return ErrNotFound
+		fmt.Fprintf(&buf, "%s = ?", d.quote(col.name))
 	}
+	fmt.Fprintf(&buf, " where %s", d.whereSQL(t.keys))
+	return buf.String()
+}
 
-	return nil
+// updateArgs returns the arguments for the command returned by t.updateSQL(d, cols).
+func (t *dtype) updateArgs(cols []*field, rval reflect.Value) ([]interface{}, error) {
+	return args(append(cols[:len(cols):len(cols)], t.keys...), rval)
 }
 
 // ErrNotFound is the error returned by Read, Select, and Write when
@@ -600,66 +642,95 @@ var ErrNotFound = errors.New("database record not found")
 // Select executes a command like
 //	select Key1, Key2, Field3, Field4 from Structs
 //	<query here>
+//
+// To build the query from conditions on the fields instead, use Query.
 func (db *Storage) Select(ctxt Context, val interface{}, query string, args ...interface{}) error {
-	ctxt = debugContext(ctxt)
+	ctxt = db.context(ctxt)
 	t, kind, err := db.findType(val, "Select")
 	if err != nil {
 		return err
 	}
+	return selectRows(ctxt, db.Dialect, t, kind, val, query, args)
+}
 
+// selectRows is the implementation of Select, after finding the type.
+func selectRows(ctxt Context, d Dialect, t *dtype, kind int, val interface{}, query string, args []interface{}) error {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "select ")
 	sep := ""
-	var indexes [][]int
 	for _, col := range t.fields {
-		fmt.Fprintf(&buf, "%s%q", sep, col.name)
+		fmt.Fprintf(&buf, "%s%s", sep, d.quote(col.name))
 		sep = ", "
-		indexes = append(indexes, col.index)
 	}
-	fmt.Fprintf(&buf, " from %q %s", t.name, query)
+	fmt.Fprintf(&buf, " from %s %s", d.quote(t.name), query)
 
 	rows, err := ctxt.Query(buf.String(), args...)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
+	list, err := scanRows(rows, t, kind, reflect.ValueOf(val).Elem())
+	rows.Close()
+	if err != nil {
+		return err
+	}
 
-	rval := reflect.ValueOf(val).Elem()
+	// Read child tables only after closing rows,
+	// which may be holding the only connection.
+	for _, rval := range list {
+		for _, c := range t.children {
+			if err := readChild(ctxt, d, c, rval); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scanRows scans rows into rval, which holds a value
+// of the kind described by kind, and returns the
+// struct values that were set.
+func scanRows(rows *sql.Rows, t *dtype, kind int, rval reflect.Value) ([]reflect.Value, error) {
 	rval.Set(reflect.Zero(rval.Type()))
 	switch kind {
 	case ptrStruct:
 		if !rows.Next() {
-			return ErrNotFound
+			return nil, ErrNotFound
 		}
-		return scan1(rows, t, rval)
+		return []reflect.Value{rval}, scan1(rows, t, rval)
 
 	case ptrPtrStruct:
 		if !rows.Next() {
 			rval.Set(reflect.Zero(rval.Type()))
-			return nil
+			return nil, nil
 		}
 		rval.Set(reflect.New(rval.Type().Elem()))
-		return scan1(rows, t, rval.Elem())
+		return []reflect.Value{rval.Elem()}, scan1(rows, t, rval.Elem())
 
 	case ptrSliceStruct:
+		var list []reflect.Value
 		for rows.Next() {
 			n := rval.Len()
 			rval.Set(reflect.Append(rval, reflect.Zero(rval.Type().Elem())))
 			if err := scan1(rows, t, rval.Index(n)); err != nil {
-				return err
+				return nil, err
 			}
 		}
-		return nil
+		for i := 0; i < rval.Len(); i++ {
+			list = append(list, rval.Index(i))
+		}
+		return list, nil
 
 	case ptrSlicePtrStruct:
+		var list []reflect.Value
 		for rows.Next() {
 			n := rval.Len()
 			rval.Set(reflect.Append(rval, reflect.New(rval.Type().Elem().Elem())))
 			if err := scan1(rows, t, rval.Index(n).Elem()); err != nil {
-				return err
+				return nil, err
 			}
+			list = append(list, rval.Index(n).Elem())
 		}
-		return nil
+		return list, nil
 	}
 
 	panic("dbstore: internal error: unexpected kind")
@@ -667,22 +738,31 @@ func (db *Storage) Select(ctxt Context, val interface{}, query string, args ...i
 
 func scan1(rows *sql.Rows, t *dtype, rval reflect.Value) error {
 	var args []interface{}
+	var fixes []func() error
 	for _, col := range t.fields {
-		args = append(args, rval.FieldByIndex(col.index).Addr().Interface())
+		dest, fix := col.dest(rval)
+		args = append(args, dest)
+		if fix != nil {
+			fixes = append(fixes, fix)
+		}
 	}
 	if err := rows.Scan(args...); err != nil {
 		return err
 	}
 
-	for _, col := range t.fields {
-		if col.utf8 {
-			v := rval.FieldByIndex(col.index)
-			s := v.String()
-			if !utf8.ValidString(s) {
-				v.SetString(string([]rune(s)))
-			}
+	for _, fix := range fixes {
+		if err := fix(); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
+
+// fixUTF8 replaces invalid UTF-8 sequences in the string v.
+func fixUTF8(v reflect.Value) {
+	s := v.String()
+	if !utf8.ValidString(s) {
+		v.SetString(string([]rune(s)))
+	}
+}
@@ -12,6 +12,30 @@ import (
 	_ "code.google.com/p/gosqlite/sqlite3"
 )
 
+// openStorage opens an in-memory SQLite database and creates in it
+// the tables for types, after registering them in storage.
+// If storage is nil, openStorage uses a new Storage.
+func openStorage(t *testing.T, storage *Storage, types ...interface{}) (*sql.DB, *Storage) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keep a single connection, so that every statement
+	// sees the same in-memory database.
+	db.SetMaxOpenConns(1)
+	if storage == nil {
+		storage = new(Storage)
+	}
+	for _, typ := range types {
+		storage.Register(typ)
+	}
+	if err := storage.CreateTables(db); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	return db, storage
+}
+
 type Data1 struct {
 	Create string
 	Table  string
@@ -100,7 +124,33 @@ func TestRowidInsert(t *testing.T) {
 	}
 
 	if len(all) != 2 || all[0].X != 123 || all[1].X != 234 {
-		t.Fatal("wrong results: %v", all)
+		t.Fatalf("wrong results: %v", all)
+	}
+}
+
+func TestRowidInsertOldThenNew(t *testing.T) {
+	db, storage := openStorage(t, nil, new(Gadget))
+	defer db.Close()
+
+	// A new row must not reuse, and so replace, the explicit rowid.
+	old := Gadget{ID: 1, Name: "old"}
+	if err := storage.Insert(db, &old); err != nil {
+		t.Fatal(err)
+	}
+	g := Gadget{Name: "new"}
+	if err := storage.Insert(db, &g); err != nil {
+		t.Fatal(err)
+	}
+	if g.ID == 0 || g.ID == old.ID {
+		t.Fatalf("new row has rowid %d", g.ID)
+	}
+
+	var all []Gadget
+	if err := storage.Select(db, &all, "order by ID"); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Name != "old" || all[1].Name != "new" {
+		t.Fatalf("wrong results: %v", all)
 	}
 }
 
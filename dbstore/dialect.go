@@ -0,0 +1,467 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbstore
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A Dialect is a variant of SQL spoken by a particular database engine.
+// The Storage generates commands in the dialect named by its Dialect field.
+//
+// The dialects differ mainly in column types, in how they replace
+// existing rows, and in full-text search.  A type with the fts4
+// attribute is stored in an SQLite FTS4 virtual table, in a PostgreSQL
+// table with a GIN index on the tsvector of its string columns, or in
+// a MySQL table with a FULLTEXT index on its string columns.
+// Query.Match uses the corresponding search operator in each.
+//
+// When using MySQL, the connection should report the number of rows
+// matched instead of changed (clientFoundRows=true for the common
+// github.com/go-sql-driver/mysql driver), so that Write and Upsert
+// can tell an unchanged row from a missing one.
+type Dialect int
+
+const (
+	SQLite Dialect = iota
+	PostgreSQL
+	MySQL
+)
+
+var dialectNames = []string{
+	SQLite:     "SQLite",
+	PostgreSQL: "PostgreSQL",
+	MySQL:      "MySQL",
+}
+
+func (d Dialect) String() string {
+	if 0 <= d && int(d) < len(dialectNames) {
+		return dialectNames[d]
+	}
+	return fmt.Sprintf("Dialect(%d)", int(d))
+}
+
+// quote returns the quoted form of the identifier name.
+func (d Dialect) quote(name string) string {
+	if d == MySQL {
+		return "`" + strings.Replace(name, "`", "``", -1) + "`"
+	}
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// quoteList returns the quoted, comma-separated names of cols.
+func (d Dialect) quoteList(cols []*field) string {
+	var list []string
+	for _, col := range cols {
+		list = append(list, d.quote(col.name))
+	}
+	return strings.Join(list, ", ")
+}
+
+// whereSQL returns the condition that each of the columns cols
+// equals its argument.
+func (d Dialect) whereSQL(cols []*field) string {
+	var list []string
+	for _, col := range cols {
+		list = append(list, d.quote(col.name)+" = ?")
+	}
+	return strings.Join(list, " and ")
+}
+
+// implicit reports whether col is the implicit rowid of an SQLite
+// FTS4 table, which is not declared as a column.
+func (d Dialect) implicit(t *dtype, col *field) bool {
+	return d == SQLite && t.fts4 && col.rowid
+}
+
+// wide reports whether col needs a type that can hold long values,
+// as opposed to one that MySQL can index.
+func wide(col *field) bool {
+	return !col.key && !col.indexed
+}
+
+// colType returns the type of the column holding col,
+// in the form the database reports it.
+func (d Dialect) colType(t *dtype, col *field) string {
+	switch d {
+	case SQLite:
+		if t.fts4 {
+			return ""
+		}
+		switch col.kind {
+		case kindInt:
+			return "integer"
+		case kindFloat:
+			return "real"
+		case kindTime:
+			return "timestamp"
+		case kindBytes:
+			return "blob"
+		}
+		return ""
+
+	case PostgreSQL:
+		switch col.kind {
+		case kindInt:
+			return "bigint"
+		case kindFloat:
+			return "double precision"
+		case kindBool:
+			return "boolean"
+		case kindTime:
+			return "timestamp with time zone"
+		case kindBytes:
+			return "bytea"
+		}
+		return "text"
+
+	case MySQL:
+		switch col.kind {
+		case kindInt:
+			return "bigint"
+		case kindFloat:
+			return "double"
+		case kindBool:
+			return "boolean"
+		case kindTime:
+			return "datetime(6)"
+		case kindBytes:
+			if wide(col) {
+				return "longblob"
+			}
+			return "varbinary(255)"
+		}
+		if wide(col) {
+			return "longtext"
+		}
+		return "varchar(255)"
+	}
+	panic("dbstore: unknown dialect " + d.String())
+}
+
+// normType returns the column type typ, as reported by the database,
+// in the form returned by colType.
+func (d Dialect) normType(typ string) string {
+	typ = strings.ToLower(typ)
+	if d == MySQL {
+		// MySQL reports boolean as tinyint(1) and, before 8.0.19,
+		// gives integer types a display width.
+		if typ == "tinyint(1)" {
+			return "boolean"
+		}
+		if strings.HasPrefix(typ, "bigint(") {
+			return "bigint"
+		}
+	}
+	return typ
+}
+
+// colDef returns the definition of the column holding col,
+// not including its name.
+func (d Dialect) colDef(t *dtype, col *field) string {
+	typ := d.colType(t, col)
+	if !col.rowid || t.fts4 && d == SQLite {
+		return typ
+	}
+	switch d {
+	case SQLite:
+		typ += " primary key"
+		if col.autoinc {
+			typ += " autoincrement"
+		}
+	case PostgreSQL:
+		typ += " generated by default as identity primary key"
+	case MySQL:
+		typ += " primary key auto_increment"
+	}
+	return typ
+}
+
+// zero returns the SQL literal for the zero value of col,
+// or the empty string if there is none.
+func (d Dialect) zero(t *dtype, col *field) string {
+	switch col.kind {
+	case kindInt, kindFloat:
+		return "0"
+	case kindBool:
+		if d == SQLite {
+			return "0"
+		}
+		return "false"
+	case kindString:
+		if d == MySQL && wide(col) {
+			return "('')" // text columns take only expression defaults
+		}
+		return "''"
+	case kindTime:
+		switch d {
+		case PostgreSQL:
+			return "'0001-01-01 00:00:00+00'"
+		case MySQL:
+			return "'0001-01-01 00:00:00'"
+		}
+		return "'0001-01-01 00:00:00+00:00'"
+	case kindBytes:
+		switch d {
+		case PostgreSQL:
+			return "''::bytea"
+		case MySQL:
+			if wide(col) {
+				return "('')"
+			}
+			return "''"
+		}
+		return "x''"
+	}
+	return ""
+}
+
+// textColumns returns the string columns of t, not counting
+// those stored using a Codec, which are the ones indexed for
+// full-text search.
+func textColumns(t *dtype) []*field {
+	var cols []*field
+	for _, col := range t.fields {
+		if col.kind == kindString && col.codec == nil {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// tsvector returns the PostgreSQL expression for the text of t
+// to be searched.  Match must use the same expression as the index
+// for the index to be used.
+func (d Dialect) tsvector(t *dtype) string {
+	var list []string
+	for _, col := range textColumns(t) {
+		list = append(list, fmt.Sprintf("coalesce(%s, '')", d.quote(col.name)))
+	}
+	return fmt.Sprintf("to_tsvector('english', %s)", strings.Join(list, " || ' ' || "))
+}
+
+// match returns the condition that a value of type t
+// matches the full-text search query given as its argument.
+func (d Dialect) match(t *dtype) string {
+	switch d {
+	case PostgreSQL:
+		return d.tsvector(t) + " @@ plainto_tsquery('english', ?)"
+	case MySQL:
+		return fmt.Sprintf("match (%s) against (?)", d.quoteList(textColumns(t)))
+	}
+	return fmt.Sprintf("%s match ?", d.quote(t.name))
+}
+
+// createSQL returns the command to create the table for t.
+func (d Dialect) createSQL(t *dtype) string {
+	var buf bytes.Buffer
+	if t.fts4 && d == SQLite {
+		fmt.Fprintf(&buf, "create virtual table %s using fts4", d.quote(t.name))
+	} else {
+		fmt.Fprintf(&buf, "create table %s", d.quote(t.name))
+	}
+
+	fmt.Fprintf(&buf, " (")
+	sep := ""
+	for _, col := range t.fields {
+		if d.implicit(t, col) {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s%s %s", sep, d.quote(col.name), d.colDef(t, col))
+		sep = ","
+	}
+	if len(t.keys) > 0 && t.rowid == nil {
+		fmt.Fprintf(&buf, ", unique (%s)", d.quoteList(t.keys))
+		if d == SQLite {
+			fmt.Fprintf(&buf, " on conflict replace")
+		}
+	}
+	if t.fts4 && d == MySQL {
+		fmt.Fprintf(&buf, ", fulltext (%s)", d.quoteList(textColumns(t)))
+	}
+	fmt.Fprintf(&buf, ")")
+	return buf.String()
+}
+
+// An index is an index on a table.
+type index struct {
+	name string
+	sql  string // command to create index
+}
+
+// indexes returns the indexes to create on the table for t,
+// after creating the table.
+func (d Dialect) indexes(t *dtype) []index {
+	var list []index
+	for _, col := range t.fields {
+		if col.indexed {
+			name := t.indexName(col)
+			list = append(list, index{name, fmt.Sprintf("create index %s on %s (%s)", d.quote(name), d.quote(t.name), d.quote(col.name))})
+		}
+	}
+	if t.fts4 && d == PostgreSQL {
+		name := t.name + ".fts"
+		list = append(list, index{name, fmt.Sprintf("create index %s on %s using gin (%s)", d.quote(name), d.quote(t.name), d.tsvector(t))})
+	}
+	return list
+}
+
+// insertSQL returns the command to insert a row into table,
+// setting the columns cols and replacing any existing row with
+// the same values in the columns keys.
+func (d Dialect) insertSQL(table string, cols, keys []string) string {
+	var buf bytes.Buffer
+	switch d {
+	case SQLite:
+		fmt.Fprintf(&buf, "insert or replace into ")
+	case PostgreSQL:
+		fmt.Fprintf(&buf, "insert into ")
+	case MySQL:
+		fmt.Fprintf(&buf, "replace into ")
+	}
+	fmt.Fprintf(&buf, "%s (", d.quote(table))
+	for i, col := range cols {
+		if i > 0 {
+			fmt.Fprintf(&buf, ", ")
+		}
+		fmt.Fprintf(&buf, "%s", d.quote(col))
+	}
+	fmt.Fprintf(&buf, ") values (")
+	for i := range cols {
+		if i > 0 {
+			fmt.Fprintf(&buf, ", ")
+		}
+		fmt.Fprintf(&buf, "?")
+	}
+	fmt.Fprintf(&buf, ")")
+
+	if d == PostgreSQL && len(keys) > 0 {
+		isKey := make(map[string]bool)
+		var list []string
+		for _, key := range keys {
+			isKey[key] = true
+			list = append(list, d.quote(key))
+		}
+		fmt.Fprintf(&buf, " on conflict (%s) do ", strings.Join(list, ", "))
+		var set []string
+		for _, col := range cols {
+			if !isKey[col] {
+				set = append(set, fmt.Sprintf("%s = excluded.%s", d.quote(col), d.quote(col)))
+			}
+		}
+		if len(set) == 0 {
+			fmt.Fprintf(&buf, "nothing")
+		} else {
+			fmt.Fprintf(&buf, "update set %s", strings.Join(set, ", "))
+		}
+	}
+	return buf.String()
+}
+
+// limitSQL returns the SQL for the limit and offset of a query,
+// along with its arguments.
+func (d Dialect) limitSQL(limit int, hasLim bool, offset int) (string, []interface{}) {
+	var buf bytes.Buffer
+	var args []interface{}
+	switch {
+	case hasLim:
+		fmt.Fprintf(&buf, " limit ?")
+		args = append(args, limit)
+	case offset == 0 || d == PostgreSQL:
+		// no limit
+	case d == SQLite:
+		// SQLite requires a limit before an offset; -1 means none.
+		fmt.Fprintf(&buf, " limit ?")
+		args = append(args, -1)
+	case d == MySQL:
+		// MySQL too, and it takes no negative limits.
+		// database/sql cannot pass the largest uint64 as an argument.
+		fmt.Fprintf(&buf, " limit 18446744073709551615")
+	}
+	if offset > 0 {
+		fmt.Fprintf(&buf, " offset ?")
+		args = append(args, offset)
+	}
+	return buf.String(), args
+}
+
+// A dialectCtxt is a Context that adapts commands,
+// written with ? placeholders, to the dialect, and that prints
+// the commands if debugging is enabled.
+type dialectCtxt struct {
+	ctxt  Context
+	d     Dialect
+	debug bool
+}
+
+// context returns the Context to use for running commands on ctxt.
+func (db *Storage) context(ctxt Context) Context {
+	if _, ok := ctxt.(*dialectCtxt); ok {
+		return ctxt
+	}
+	if !Debug && db.Dialect != PostgreSQL {
+		return ctxt
+	}
+	return &dialectCtxt{ctxt, db.Dialect, Debug}
+}
+
+// unwrap returns the Context underlying ctxt,
+// along with ctxt itself if it is a *dialectCtxt.
+func unwrap(ctxt Context) (Context, *dialectCtxt) {
+	if c, ok := ctxt.(*dialectCtxt); ok {
+		return c.ctxt, c
+	}
+	return ctxt, nil
+}
+
+// rebind returns query rewritten for the dialect.
+// PostgreSQL uses numbered placeholders $1, $2, and so on.
+func (c *dialectCtxt) rebind(query string) string {
+	if c.d != PostgreSQL {
+		return query
+	}
+	var buf bytes.Buffer
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '?':
+			n++
+			fmt.Fprintf(&buf, "$%d", n)
+			continue
+		}
+		buf.WriteByte(ch)
+	}
+	return buf.String()
+}
+
+func (c *dialectCtxt) log(query string, args []interface{}) {
+	if c.debug {
+		fmt.Fprintf(os.Stderr, "SQL: %s %v\n", query, args)
+	}
+}
+
+func (c *dialectCtxt) Exec(query string, args ...interface{}) (sql.Result, error) {
+	query = c.rebind(query)
+	c.log(query, args)
+	return c.ctxt.Exec(query, args...)
+}
+
+func (c *dialectCtxt) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	query = c.rebind(query)
+	c.log(query, args)
+	return c.ctxt.Query(query, args...)
+}
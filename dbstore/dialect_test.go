@@ -0,0 +1,151 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbstore
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+type Gadget struct {
+	ID     int64  `dbstore:",rowid"`
+	Name   string `dbstore:",index"`
+	Weight float64
+	OK     bool
+	Data   []byte
+}
+
+// A recorder is a Context that records the commands it is given
+// instead of running them.  Its queries all fail.
+type recorder struct {
+	buf bytes.Buffer
+}
+
+var errRecorded = errors.New("query recorded")
+
+type recordResult struct{}
+
+func (recordResult) LastInsertId() (int64, error) { return 1, nil }
+func (recordResult) RowsAffected() (int64, error) { return 1, nil }
+
+func (r *recorder) Exec(query string, args ...interface{}) (sql.Result, error) {
+	fmt.Fprintf(&r.buf, "%s; %v\n", query, args)
+	return recordResult{}, nil
+}
+
+func (r *recorder) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	fmt.Fprintf(&r.buf, "%s; %v\n", query, args)
+	return nil, errRecorded
+}
+
+var born = time.Date(1983, 1, 2, 0, 0, 0, 0, time.UTC)
+
+var dialectTests = []struct {
+	name string
+	f    func(db *Storage, ctxt Context) error
+}{
+	{"CreateTables", func(db *Storage, ctxt Context) error {
+		return db.CreateTables(ctxt)
+	}},
+	{"Insert", func(db *Storage, ctxt Context) error {
+		return db.Insert(ctxt, &Person{"alice", 30, born, "alice@example.com"})
+	}},
+	{"Insert new rowid", func(db *Storage, ctxt Context) error {
+		return db.Insert(ctxt, &Gadget{Name: "widget", Weight: 1.5, OK: true, Data: []byte("x")})
+	}},
+	{"Insert old rowid", func(db *Storage, ctxt Context) error {
+		return db.Insert(ctxt, &Gadget{ID: 3, Name: "widget"})
+	}},
+	{"Insert old then new rowid", func(db *Storage, ctxt Context) error {
+		return db.InsertAll(ctxt, []*Gadget{{ID: 1, Name: "old"}, {Name: "new"}})
+	}},
+	{"Insert fts4", func(db *Storage, ctxt Context) error {
+		return db.Insert(ctxt, &Doc{Text: "the quick brown fox"})
+	}},
+	{"Upsert", func(db *Storage, ctxt Context) error {
+		return db.Upsert(ctxt, &Person{"alice", 31, born, "alice@example.com"})
+	}},
+	{"Write", func(db *Storage, ctxt Context) error {
+		return db.Write(ctxt, &Person{Name: "alice", Age: 32}, "Age")
+	}},
+	{"Read", func(db *Storage, ctxt Context) error {
+		return db.Read(ctxt, &Person{Name: "alice"}, "ALL")
+	}},
+	{"Delete", func(db *Storage, ctxt Context) error {
+		return db.Delete(ctxt, &Person{Name: "alice"})
+	}},
+	{"Query", func(db *Storage, ctxt Context) error {
+		var list []Person
+		return db.Query(new(Person)).Where("Age", ">=", 25).Where("email", "like", "%.com").OrderBy("-Born").Limit(10).Offset(5).Select(ctxt, &list)
+	}},
+	{"Query empty in", func(db *Storage, ctxt Context) error {
+		var list []Person
+		return db.Query(new(Person)).Where("Name", "in", []string{}).Select(ctxt, &list)
+	}},
+	{"Count offset", func(db *Storage, ctxt Context) error {
+		_, err := db.Query(new(Person)).Offset(2).Count(ctxt)
+		return err
+	}},
+	{"Match", func(db *Storage, ctxt Context) error {
+		var list []Doc
+		return db.Query(new(Doc)).Match("quick fox").Select(ctxt, &list)
+	}},
+	{"Migrate", func(db *Storage, ctxt Context) error {
+		_, err := db.Migrate(ctxt)
+		return err
+	}},
+}
+
+func TestDialects(t *testing.T) {
+	for _, d := range []Dialect{SQLite, PostgreSQL, MySQL} {
+		db := &Storage{Dialect: d}
+		db.Register(new(Person))
+		db.Register(new(Doc))
+		db.Register(new(Gadget))
+
+		var out bytes.Buffer
+		for _, tt := range dialectTests {
+			r := new(recorder)
+			err := tt.f(db, r)
+			if err != nil && err != errRecorded {
+				t.Errorf("%s: %s: %v", d, tt.name, err)
+			}
+			fmt.Fprintf(&out, "-- %s\n%s\n", tt.name, r.buf.Bytes())
+		}
+
+		file := filepath.Join("testdata", d.String()+".sql")
+		if *update {
+			if err := ioutil.WriteFile(file, out.Bytes(), 0666); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("%s: commands differ from %s:\n%s", d, file, out.Bytes())
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	c := &dialectCtxt{d: PostgreSQL}
+	in := `select "a?" from "t" where "x" = ? and y = 'it''s?' and z in (?, ?)`
+	out := `select "a?" from "t" where "x" = $1 and y = 'it''s?' and z in ($2, $3)`
+	if got := c.rebind(in); got != out {
+		t.Errorf("rebind(%q) = %q, want %q", in, got, out)
+	}
+}
@@ -0,0 +1,348 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbstore
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// schemaTable is the name of the table in which Migrate
+// records the schema of each registered table.
+const schemaTable = "dbstore.schema"
+
+// A Change is a difference between a registered type and
+// the database table that holds it, found by Migrate.
+type Change struct {
+	Table  string // table name
+	Column string // column or index name, if the change affects only one
+	Op     string // description of change, such as "add column"
+
+	// Destructive changes could lose data or cannot be made
+	// to an existing table.  Migrate reports them but does not
+	// apply them; they must be made by hand.
+	Destructive bool
+}
+
+func (c Change) String() string {
+	s := c.Op + " " + c.Table
+	if c.Column != "" {
+		s += " " + c.Column
+	}
+	if c.Destructive {
+		s += " (not applied)"
+	}
+	return s
+}
+
+// Migrate updates the database to hold the registered types,
+// creating missing tables, adding missing columns, and creating
+// missing indexes.  It returns the list of differences found.
+// Changes that could lose data, such as dropping a column,
+// changing a column's type, or changing a table's key fields,
+// are marked Destructive and are not applied.
+//
+// Migrate records each table's schema in a table named "dbstore.schema",
+// along with a version number that increases each time the
+// schema changes.  A table's schema is recorded only once
+// the table matches its registered type exactly.
+func (db *Storage) Migrate(ctxt Context) ([]Change, error) {
+	ctxt = db.context(ctxt)
+	d := db.Dialect
+	keyType := "text"
+	if d == MySQL {
+		keyType = "varchar(255)"
+	}
+	query := fmt.Sprintf("create table if not exists %s (%s %s primary key, %s integer, %s text)",
+		d.quote(schemaTable), d.quote("table"), keyType, d.quote("version"), d.quote("schema"))
+	if _, err := ctxt.Exec(query); err != nil {
+		return nil, fmt.Errorf("creating table %s [%s]: %v", schemaTable, query, err)
+	}
+
+	var changes []Change
+	for _, t := range db.types {
+		for _, t := range t.tables() {
+			c, err := migrate(ctxt, d, t)
+			changes = append(changes, c...)
+			if err != nil {
+				return changes, err
+			}
+		}
+	}
+	return changes, nil
+}
+
+// SchemaVersion returns the schema version Migrate has recorded for
+// the table holding the type of val, or 0 if there is none.
+func (db *Storage) SchemaVersion(ctxt Context, val interface{}) (int, error) {
+	ctxt = db.context(ctxt)
+	t, _, err := db.findType(val, "SchemaVersion")
+	if err != nil {
+		return 0, err
+	}
+	version, _, err := schemaVersion(ctxt, db.Dialect, t)
+	return version, err
+}
+
+// migrate brings the table for t up to date.
+func migrate(ctxt Context, d Dialect, t *dtype) ([]Change, error) {
+	cols, err := liveColumns(ctxt, d, t.name)
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) == 0 {
+		if err := createTable(ctxt, d, t); err != nil {
+			return nil, err
+		}
+		return []Change{{Table: t.name, Op: "create table"}}, recordSchema(ctxt, d, t)
+	}
+
+	var changes []Change
+	destructive := false
+	change := func(col, op string, bad bool) {
+		changes = append(changes, Change{Table: t.name, Column: col, Op: op, Destructive: bad})
+		destructive = destructive || bad
+	}
+
+	have := make(map[string]bool)
+	for _, col := range t.fields {
+		if d.implicit(t, col) {
+			continue
+		}
+		have[col.name] = true
+		typ, ok := cols[col.name]
+		if !ok {
+			// SQLite cannot add columns to virtual tables,
+			// nor key or primary key columns to any table.
+			// The other dialects are held to the same rules,
+			// which also keep their full-text indexes intact.
+			if t.fts4 || col.key || col.rowid {
+				change(col.name, "add column", true)
+				continue
+			}
+			// Existing rows get the zero value, so that they can
+			// be read back into the struct.
+			query := fmt.Sprintf("alter table %s add column %s %s", d.quote(t.name), d.quote(col.name), d.colDef(t, col))
+			if zero := d.zero(t, col); zero != "" {
+				query += " default " + zero
+			}
+			if _, err := ctxt.Exec(query); err != nil {
+				return changes, fmt.Errorf("adding column %s.%s [%s]: %v", t.name, col.name, query, err)
+			}
+			change(col.name, "add column", false)
+			continue
+		}
+		if typ, want := d.normType(typ), d.colType(t, col); typ != want {
+			change(col.name, fmt.Sprintf("change type from %q to %q of column", typ, want), true)
+		}
+	}
+	var drop []string
+	for name := range cols {
+		if !have[name] {
+			drop = append(drop, name)
+		}
+	}
+	sort.Strings(drop)
+	for _, name := range drop {
+		change(name, "drop column", true)
+	}
+
+	if (d != SQLite || !t.fts4) && t.rowid == nil {
+		keys, err := liveKeys(ctxt, d, t.name)
+		if err != nil {
+			return changes, err
+		}
+		var want []string
+		for _, col := range t.keys {
+			want = append(want, col.name)
+		}
+		if strings.Join(keys, ",") != strings.Join(want, ",") {
+			change("", fmt.Sprintf("change key from (%s) to (%s) of", strings.Join(keys, ", "), strings.Join(want, ", ")), true)
+		}
+	}
+
+	indexes, err := liveIndexes(ctxt, d, t.name)
+	if err != nil {
+		return changes, err
+	}
+	for _, ix := range d.indexes(t) {
+		if !indexes[ix.name] {
+			if err := createIndex(ctxt, t, ix); err != nil {
+				return changes, err
+			}
+			change(ix.name, "create index", false)
+		}
+	}
+
+	if destructive {
+		return changes, nil
+	}
+	return changes, recordSchema(ctxt, d, t)
+}
+
+// currentSchema is the expression for the current schema (PostgreSQL)
+// or database (MySQL), which information_schema calls table_schema.
+func (d Dialect) currentSchema() string {
+	if d == MySQL {
+		return "database()"
+	}
+	return "current_schema()"
+}
+
+// queryRows runs the query and returns the result rows,
+// each a map from column name to value.
+func queryRows(ctxt Context, query string, args ...interface{}) ([]map[string]string, error) {
+	rows, err := ctxt.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(names))
+	for i := range vals {
+		vals[i] = new(sql.RawBytes)
+	}
+	var list []map[string]string
+	for rows.Next() {
+		if err := rows.Scan(vals...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]string)
+		for i, name := range names {
+			row[name] = string(*vals[i].(*sql.RawBytes))
+		}
+		list = append(list, row)
+	}
+	return list, rows.Err()
+}
+
+// liveColumns returns the columns of the named table in the database,
+// mapped to their types.  If the table does not exist, the map is empty.
+func liveColumns(ctxt Context, d Dialect, table string) (map[string]string, error) {
+	var rows []map[string]string
+	var err error
+	switch d {
+	case SQLite:
+		rows, err = queryRows(ctxt, fmt.Sprintf("pragma table_info(%s)", d.quote(table)))
+	case PostgreSQL:
+		rows, err = queryRows(ctxt, "select column_name as name, data_type as type from information_schema.columns where table_schema = current_schema() and table_name = ?", table)
+	case MySQL:
+		rows, err = queryRows(ctxt, "select column_name as name, column_type as type from information_schema.columns where table_schema = database() and table_name = ?", table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cols := make(map[string]string)
+	for _, row := range rows {
+		cols[row["name"]] = row["type"]
+	}
+	return cols, nil
+}
+
+// liveIndexes returns the set of names of indexes on the named table.
+func liveIndexes(ctxt Context, d Dialect, table string) (map[string]bool, error) {
+	var query string
+	switch d {
+	case SQLite:
+		query = "select name from sqlite_master where type = 'index' and tbl_name = ?"
+	case PostgreSQL:
+		query = "select indexname as name from pg_indexes where schemaname = current_schema() and tablename = ?"
+	case MySQL:
+		query = "select distinct index_name as name from information_schema.statistics where table_schema = database() and table_name = ?"
+	}
+	rows, err := queryRows(ctxt, query, table)
+	if err != nil {
+		return nil, err
+	}
+	indexes := make(map[string]bool)
+	for _, row := range rows {
+		indexes[row["name"]] = true
+	}
+	return indexes, nil
+}
+
+// liveKeys returns the columns in the unique constraint on the named table.
+func liveKeys(ctxt Context, d Dialect, table string) ([]string, error) {
+	if d != SQLite {
+		rows, err := queryRows(ctxt, "select k.column_name as name"+
+			" from information_schema.table_constraints c"+
+			" join information_schema.key_column_usage k"+
+			" on k.constraint_name = c.constraint_name and k.table_schema = c.table_schema and k.table_name = c.table_name"+
+			" where c.constraint_type = 'UNIQUE' and c.table_schema = "+d.currentSchema()+" and c.table_name = ?"+
+			" order by k.ordinal_position", table)
+		if err != nil {
+			return nil, err
+		}
+		var keys []string
+		for _, row := range rows {
+			keys = append(keys, row["name"])
+		}
+		return keys, nil
+	}
+
+	rows, err := queryRows(ctxt, fmt.Sprintf("pragma index_list(%s)", d.quote(table)))
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if row["origin"] != "u" {
+			continue
+		}
+		cols, err := queryRows(ctxt, fmt.Sprintf("pragma index_info(%s)", d.quote(row["name"])))
+		if err != nil {
+			return nil, err
+		}
+		var keys []string
+		for _, col := range cols {
+			keys = append(keys, col["name"])
+		}
+		return keys, nil
+	}
+	return nil, nil
+}
+
+// schemaVersion returns the schema version and schema recorded for t.
+func schemaVersion(ctxt Context, d Dialect, t *dtype) (version int, schema string, err error) {
+	rows, err := ctxt.Query(fmt.Sprintf("select %s, %s from %s where %s = ?",
+		d.quote("version"), d.quote("schema"), d.quote(schemaTable), d.quote("table")), t.name)
+	if err != nil {
+		return 0, "", err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, "", rows.Err()
+	}
+	var s sql.NullString
+	if err := rows.Scan(&version, &s); err != nil {
+		return 0, "", err
+	}
+	return version, s.String, nil
+}
+
+// recordSchema records the current schema for t,
+// incrementing the version if it has changed.
+func recordSchema(ctxt Context, d Dialect, t *dtype) error {
+	schema := []string{d.createSQL(t)}
+	for _, ix := range d.indexes(t) {
+		schema = append(schema, ix.sql)
+	}
+	text := strings.Join(schema, ";\n")
+
+	version, old, err := schemaVersion(ctxt, d, t)
+	if err != nil {
+		return err
+	}
+	if version > 0 && old == text {
+		return nil
+	}
+	query := d.insertSQL(schemaTable, []string{"table", "version", "schema"}, []string{"table"})
+	_, err = ctxt.Exec(query, t.name, version+1, text)
+	return err
+}
@@ -0,0 +1,166 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbstore
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	_ "code.google.com/p/gosqlite/sqlite3"
+)
+
+func checkChanges(t *testing.T, changes []Change, want ...string) {
+	var have []string
+	for _, c := range changes {
+		have = append(have, c.String())
+	}
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("changes:\nhave %q\nwant %q", have, want)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const table = "code.google.com/p/rsc/dbstore.Item"
+
+	// Version 1 of the Item struct, created with CreateTables.
+	{
+		type Item struct {
+			Name  string `dbstore:",key"`
+			Count int
+			Old   string
+		}
+		storage := new(Storage)
+		storage.Register(new(Item))
+		if err := storage.CreateTables(db); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Insert(db, &Item{Name: "x", Count: 1, Old: "old"}); err != nil {
+			t.Fatal(err)
+		}
+		changes, err := storage.Migrate(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkChanges(t, changes)
+		if v, err := storage.SchemaVersion(db, new(Item)); v != 1 || err != nil {
+			t.Errorf("SchemaVersion = %d, %v, want 1, nil", v, err)
+		}
+	}
+
+	// Version 2 adds a field and an index, which Migrate applies,
+	// and drops a field, which Migrate reports.
+	{
+		type Item struct {
+			Name  string `dbstore:",key"`
+			Count int    `dbstore:",index"`
+			Price float64
+		}
+		storage := new(Storage)
+		storage.Register(new(Item))
+		changes, err := storage.Migrate(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkChanges(t, changes,
+			"add column "+table+" Price",
+			"drop column "+table+" Old (not applied)",
+			"create index "+table+" "+table+".Count",
+		)
+		if v, err := storage.SchemaVersion(db, new(Item)); v != 1 || err != nil {
+			t.Errorf("SchemaVersion = %d, %v, want 1, nil", v, err)
+		}
+
+		item := Item{Name: "x"}
+		if err := storage.Read(db, &item, "ALL"); err != nil {
+			t.Fatal(err)
+		}
+		if item.Count != 1 || item.Price != 0 {
+			t.Errorf("Read after Migrate = %+v", item)
+		}
+		item.Price = 2.5
+		if err := storage.Write(db, &item, "Price"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Version 3 keeps the old field, so the table matches
+	// and the schema version increases.
+	// Migrating again changes nothing.
+	{
+		type Item struct {
+			Name  string `dbstore:",key"`
+			Count int    `dbstore:",index"`
+			Price float64
+			Old   string
+		}
+		storage := new(Storage)
+		storage.Register(new(Item))
+		for i := 0; i < 2; i++ {
+			changes, err := storage.Migrate(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkChanges(t, changes)
+			if v, err := storage.SchemaVersion(db, new(Item)); v != 2 || err != nil {
+				t.Errorf("SchemaVersion = %d, %v, want 2, nil", v, err)
+			}
+		}
+	}
+
+	// Version 4 changes the key and a column type.
+	{
+		type Item struct {
+			Name  string `dbstore:",key"`
+			Count string `dbstore:",key"`
+			Price float64
+			Old   string
+		}
+		storage := new(Storage)
+		storage.Register(new(Item))
+		changes, err := storage.Migrate(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkChanges(t, changes,
+			`change type from "integer" to "" of column `+table+" Count (not applied)",
+			"change key from (Name) to (Name, Count) of "+table+" (not applied)",
+		)
+	}
+}
+
+func TestMigrateCreate(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	storage := new(Storage)
+	storage.Register(new(Data1))
+	storage.Register(new(Msg))
+	changes, err := storage.Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChanges(t, changes,
+		"create table code.google.com/p/rsc/dbstore.Data1",
+		"create table code.google.com/p/rsc/dbstore.Msg",
+	)
+	if err := storage.Insert(db, &Msg{X: 1}); err != nil {
+		t.Fatal(err)
+	}
+	changes, err = storage.Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChanges(t, changes)
+}
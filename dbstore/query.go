@@ -0,0 +1,292 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbstore
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// A Query is a select query on the table for a registered type,
+// built from conditions on the type's fields instead of raw SQL.
+// A Query is created by Storage.Query and refined by calling its methods,
+// each of which returns the Query itself, so that calls can be chained:
+//
+//	var msgs []Msg
+//	err := storage.Query(new(Msg)).Where("Z", "=", true).OrderBy("-X").Limit(10).Select(db, &msgs)
+//
+// Each method checks its arguments against the registered fields
+// when it is called.  The first error found is recorded and returned
+// by Err, Select, and Count.
+type Query struct {
+	db     *Storage
+	t      *dtype
+	where  []string
+	args   []interface{}
+	order  []string
+	limit  int
+	hasLim bool
+	offset int
+	err    error
+}
+
+// Query returns a new query selecting all values with the type of val,
+// which must be a pointer to a registered struct type.
+func (db *Storage) Query(val interface{}) *Query {
+	t, _, err := db.findType(val, "Query")
+	return &Query{db: db, t: t, err: err}
+}
+
+// Err returns the first error found while building the query.
+func (q *Query) Err() error {
+	return q.err
+}
+
+func (q *Query) errorf(format string, args ...interface{}) {
+	if q.err == nil {
+		q.err = fmt.Errorf("dbstore query: "+format, args...)
+	}
+}
+
+// column returns the field for the named column,
+// which may be given by its column name or its Go field name.
+func (q *Query) column(name string) *field {
+	if q.t == nil {
+		return nil
+	}
+	for _, col := range q.t.fields {
+		if col.name == name || col.goname == name {
+			return col
+		}
+	}
+	q.errorf("unknown field %q in %s", name, q.t.name)
+	return nil
+}
+
+var whereOps = map[string]bool{
+	"=":    true,
+	"!=":   true,
+	"<":    true,
+	"<=":   true,
+	">":    true,
+	">=":   true,
+	"like": true,
+	"in":   true,
+}
+
+// Where restricts the query to values whose field satisfies the
+// condition "field op value".  The field may be given by its Go name
+// or its column name.  The operator is one of =, !=, <, <=, >, >=,
+// like (for string fields only), and in, for which value must be a
+// slice of possible values.  The value must have a type comparable
+// to the field's: a number for numeric fields, a string for string
+// fields, and so on.  Multiple Where conditions must all hold.
+func (q *Query) Where(field, op string, value interface{}) *Query {
+	col := q.column(field)
+	if col == nil {
+		return q
+	}
+	op = strings.ToLower(op)
+	if !whereOps[op] {
+		q.errorf("unknown operator %q", op)
+		return q
+	}
+	if op == "like" && col.typ.Kind() != reflect.String {
+		q.errorf("like used with non-string field %s", field)
+		return q
+	}
+	if op != "in" {
+		arg, err := checkValue(col, value)
+		if err != nil {
+			q.errorf("%s %s: %v", field, op, err)
+			return q
+		}
+		q.where = append(q.where, fmt.Sprintf("%s %s ?", q.db.Dialect.quote(col.name), op))
+		q.args = append(q.args, arg)
+		return q
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		q.errorf("%s in: value must be slice, not %T", field, value)
+		return q
+	}
+	if v.Len() == 0 {
+		// Nothing is in the empty list.
+		q.where = append(q.where, "1 = 0")
+		return q
+	}
+	marks := make([]string, v.Len())
+	for i := range marks {
+		arg, err := checkValue(col, v.Index(i).Interface())
+		if err != nil {
+			q.errorf("%s in: %v", field, err)
+			return q
+		}
+		marks[i] = "?"
+		q.args = append(q.args, arg)
+	}
+	q.where = append(q.where, fmt.Sprintf("%s in (%s)", q.db.Dialect.quote(col.name), strings.Join(marks, ", ")))
+	return q
+}
+
+// checkValue checks that value can be compared with the column col
+// and returns the column value to compare with.
+// Fields stored using a Codec can only be compared with values
+// of the field's type, which are encoded for the comparison.
+func checkValue(col *field, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, fmt.Errorf("nil value")
+	}
+	vt := reflect.TypeOf(value)
+	if col.codec != nil {
+		if vt != col.typ {
+			return nil, fmt.Errorf("cannot compare %s field %s with %T", col.typ, col.goname, value)
+		}
+		return col.encode(value)
+	}
+	ok := false
+	switch ft := col.typ; ft.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		switch vt.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+			reflect.Float32, reflect.Float64:
+			ok = true
+		}
+	case reflect.String, reflect.Bool:
+		ok = vt.Kind() == ft.Kind()
+	case reflect.Struct:
+		ok = vt == reflect.TypeOf(time.Time{})
+	case reflect.Slice:
+		ok = vt.Kind() == reflect.Slice && vt.Elem().Kind() == reflect.Uint8
+	}
+	if !ok {
+		return nil, fmt.Errorf("cannot compare %s field %s with %T", col.typ, col.goname, value)
+	}
+	return value, nil
+}
+
+// Match restricts the query to values matching the full-text search query text.
+// It can only be used with types stored in fts4 tables.
+func (q *Query) Match(text string) *Query {
+	if q.t == nil {
+		return q
+	}
+	if !q.t.fts4 {
+		q.errorf("Match used with non-fts4 type %s", q.t.name)
+		return q
+	}
+	q.where = append(q.where, q.db.Dialect.match(q.t))
+	q.args = append(q.args, text)
+	return q
+}
+
+// OrderBy sorts the results by the named field, in increasing order,
+// or in decreasing order if the name is prefixed by a minus sign, as in "-Date".
+// Multiple OrderBy calls sort by each field in turn.
+func (q *Query) OrderBy(field string) *Query {
+	desc := strings.HasPrefix(field, "-")
+	col := q.column(strings.TrimPrefix(field, "-"))
+	if col == nil {
+		return q
+	}
+	name := q.db.Dialect.quote(col.name)
+	if desc {
+		name += " desc"
+	}
+	q.order = append(q.order, name)
+	return q
+}
+
+// Limit restricts the query to return at most n values.
+func (q *Query) Limit(n int) *Query {
+	if n < 0 {
+		q.errorf("negative limit %d", n)
+	}
+	q.limit = n
+	q.hasLim = true
+	return q
+}
+
+// Offset skips the first n values that the query would return.
+func (q *Query) Offset(n int) *Query {
+	if n < 0 {
+		q.errorf("negative offset %d", n)
+	}
+	q.offset = n
+	return q
+}
+
+// tail returns the SQL following "select columns from table",
+// along with its arguments.
+func (q *Query) tail() (string, []interface{}) {
+	var buf bytes.Buffer
+	args := append([]interface{}(nil), q.args...)
+	if len(q.where) > 0 {
+		fmt.Fprintf(&buf, "where %s", strings.Join(q.where, " and "))
+	}
+	if len(q.order) > 0 {
+		fmt.Fprintf(&buf, " order by %s", strings.Join(q.order, ", "))
+	}
+	limit, limitArgs := q.db.Dialect.limitSQL(q.limit, q.hasLim, q.offset)
+	buf.WriteString(limit)
+	args = append(args, limitArgs...)
+	return strings.TrimSpace(buf.String()), args
+}
+
+// Select runs the query, reading the results into val,
+// which may have any of the types accepted by Storage.Select.
+// The struct type in val must be the one the query was created with.
+func (q *Query) Select(ctxt Context, val interface{}) error {
+	if q.err != nil {
+		return q.err
+	}
+	ctxt = q.db.context(ctxt)
+	t, kind, err := q.db.findType(val, "Select")
+	if err != nil {
+		return err
+	}
+	if t != q.t {
+		return fmt.Errorf("dbstore query: cannot select %s into %T", q.t.name, val)
+	}
+	tail, args := q.tail()
+	return selectRows(ctxt, q.db.Dialect, q.t, kind, val, tail, args)
+}
+
+// Count returns the number of values the query would return.
+func (q *Query) Count(ctxt Context) (int, error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+	ctxt = q.db.context(ctxt)
+	tail, args := q.tail()
+	table := q.db.Dialect.quote(q.t.name)
+	query := fmt.Sprintf("select count(*) from %s %s", table, tail)
+	if q.hasLim || q.offset > 0 {
+		query = fmt.Sprintf("select count(*) from (select 1 from %s %s) as q", table, tail)
+	}
+	rows, err := ctxt.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("dbstore query: count returned no rows")
+	}
+	var n int
+	if err := rows.Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
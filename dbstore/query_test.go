@@ -0,0 +1,163 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbstore
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	_ "code.google.com/p/gosqlite/sqlite3"
+)
+
+type Person struct {
+	Name  string `dbstore:",key"`
+	Age   int
+	Born  time.Time
+	Email string `dbstore:"email"`
+}
+
+type Doc struct {
+	ID   int64  `dbstore:",fts4,rowid"`
+	Text string `dbstore:"text"`
+}
+
+var people = []Person{
+	{"alice", 30, time.Date(1983, 1, 2, 0, 0, 0, 0, time.UTC), "alice@example.com"},
+	{"bob", 25, time.Date(1988, 3, 4, 0, 0, 0, 0, time.UTC), "bob@example.org"},
+	{"carol", 35, time.Date(1978, 5, 6, 0, 0, 0, 0, time.UTC), "carol@example.com"},
+	{"dave", 25, time.Date(1988, 7, 8, 0, 0, 0, 0, time.UTC), "dave@example.net"},
+}
+
+func queryDB(t *testing.T) (*sql.DB, *Storage) {
+	db, storage := openStorage(t, nil, new(Person), new(Doc))
+	for i := range people {
+		if err := storage.Insert(db, &people[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, text := range []string{"the quick brown fox", "the lazy dog", "a quick dog"} {
+		if err := storage.Insert(db, &Doc{Text: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, storage
+}
+
+func names(list []Person) string {
+	var s []string
+	for _, p := range list {
+		s = append(s, p.Name)
+	}
+	return strings.Join(s, " ")
+}
+
+func TestQuery(t *testing.T) {
+	db, storage := queryDB(t)
+	defer db.Close()
+
+	tests := []struct {
+		q    *Query
+		want string
+	}{
+		{storage.Query(new(Person)).OrderBy("Name"), "alice bob carol dave"},
+		{storage.Query(new(Person)).Where("Age", "=", 25).OrderBy("Name"), "bob dave"},
+		{storage.Query(new(Person)).Where("Age", ">=", 30.0).OrderBy("-Age"), "carol alice"},
+		{storage.Query(new(Person)).Where("email", "like", "%.com").OrderBy("Name"), "alice carol"},
+		{storage.Query(new(Person)).Where("Email", "like", "%.com").Where("Age", "<", 35).OrderBy("Name"), "alice"},
+		{storage.Query(new(Person)).Where("Name", "in", []string{"dave", "alice", "zed"}).OrderBy("Name"), "alice dave"},
+		{storage.Query(new(Person)).Where("Name", "in", []string{}), ""},
+		{storage.Query(new(Person)).Where("Born", "<", time.Date(1985, 1, 1, 0, 0, 0, 0, time.UTC)).OrderBy("Born"), "carol alice"},
+		{storage.Query(new(Person)).OrderBy("-Age").OrderBy("Name").Limit(3), "carol alice bob"},
+		{storage.Query(new(Person)).OrderBy("Name").Offset(1).Limit(2), "bob carol"},
+		{storage.Query(new(Person)).OrderBy("Name").Offset(3), "dave"},
+	}
+	for i, tt := range tests {
+		var list []Person
+		if err := tt.q.Select(db, &list); err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		if have := names(list); have != tt.want {
+			t.Errorf("#%d: Select = %q, want %q", i, have, tt.want)
+		}
+		n, err := tt.q.Count(db)
+		if err != nil {
+			t.Errorf("#%d: Count: %v", i, err)
+			continue
+		}
+		if n != len(list) {
+			t.Errorf("#%d: Count = %d, want %d", i, n, len(list))
+		}
+	}
+
+	var p *Person
+	if err := storage.Query(new(Person)).Where("Name", "=", "bob").Select(db, &p); err != nil || p == nil || p.Age != 25 {
+		t.Errorf("Select single = %+v, %v", p, err)
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	db, storage := queryDB(t)
+	defer db.Close()
+
+	var docs []*Doc
+	q := storage.Query(new(Doc)).Match("quick").OrderBy("ID")
+	if err := q.Select(db, &docs); err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, d := range docs {
+		texts = append(texts, d.Text)
+	}
+	if fmt.Sprint(texts) != "[the quick brown fox a quick dog]" {
+		t.Errorf("Match = %q", texts)
+	}
+	if n, err := storage.Query(new(Doc)).Match("dog").Count(db); n != 2 || err != nil {
+		t.Errorf("Match Count = %d, %v, want 2, nil", n, err)
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	db, storage := queryDB(t)
+	defer db.Close()
+
+	type unregistered struct{ X int }
+	tests := []*Query{
+		storage.Query(new(unregistered)),
+		storage.Query(new(Person)).Where("Nmae", "=", "alice"),
+		storage.Query(new(Person)).Where("Name", "~", "alice"),
+		storage.Query(new(Person)).Where("Name", "=", 1),
+		storage.Query(new(Person)).Where("Age", "=", "old"),
+		storage.Query(new(Person)).Where("Age", "like", "3%"),
+		storage.Query(new(Person)).Where("Age", "in", 3),
+		storage.Query(new(Person)).Where("Age", "in", []string{"x"}),
+		storage.Query(new(Person)).Where("Born", ">", "1980"),
+		storage.Query(new(Person)).Where("Name", "=", nil),
+		storage.Query(new(Person)).OrderBy("-Nmae"),
+		storage.Query(new(Person)).Limit(-1),
+		storage.Query(new(Person)).Match("alice"),
+	}
+	for i, q := range tests {
+		if q.Err() == nil {
+			t.Errorf("#%d: no error", i)
+			continue
+		}
+		var list []Person
+		if err := q.Select(db, &list); err == nil {
+			t.Errorf("#%d: Select succeeded", i)
+		}
+		if _, err := q.Count(db); err == nil {
+			t.Errorf("#%d: Count succeeded", i)
+		}
+	}
+
+	var docs []Doc
+	if err := storage.Query(new(Person)).Select(db, &docs); err == nil {
+		t.Errorf("Select of Person query into []Doc succeeded")
+	}
+}
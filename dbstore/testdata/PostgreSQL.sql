@@ -0,0 +1,51 @@
+-- CreateTables
+create table "code.google.com/p/rsc/dbstore.Person" ("Name" text,"Age" bigint,"Born" timestamp with time zone,"email" text, unique ("Name")); []
+create table "code.google.com/p/rsc/dbstore.Doc" ("docid" bigint generated by default as identity primary key,"text" text); []
+create index "code.google.com/p/rsc/dbstore.Doc.fts" on "code.google.com/p/rsc/dbstore.Doc" using gin (to_tsvector('english', coalesce("text", ''))); []
+create table "code.google.com/p/rsc/dbstore.Gadget" ("ID" bigint generated by default as identity primary key,"Name" text,"Weight" double precision,"OK" boolean,"Data" bytea); []
+create index "code.google.com/p/rsc/dbstore.Gadget.Name" on "code.google.com/p/rsc/dbstore.Gadget" ("Name"); []
+
+-- Insert
+insert into "code.google.com/p/rsc/dbstore.Person" ("Name", "Age", "Born", "email") values ($1, $2, $3, $4) on conflict ("Name") do update set "Age" = excluded."Age", "Born" = excluded."Born", "email" = excluded."email"; [alice 30 1983-01-02 00:00:00 +0000 UTC alice@example.com]
+
+-- Insert new rowid
+insert into "code.google.com/p/rsc/dbstore.Gadget" ("Name", "Weight", "OK", "Data") values ($1, $2, $3, $4) returning "ID"; [widget 1.5 true [120]]
+
+-- Insert old rowid
+insert into "code.google.com/p/rsc/dbstore.Gadget" ("ID", "Name", "Weight", "OK", "Data") values ($1, $2, $3, $4, $5) on conflict ("ID") do update set "Name" = excluded."Name", "Weight" = excluded."Weight", "OK" = excluded."OK", "Data" = excluded."Data"; [3 widget 0 false []]
+
+-- Insert old then new rowid
+insert into "code.google.com/p/rsc/dbstore.Gadget" ("ID", "Name", "Weight", "OK", "Data") values ($1, $2, $3, $4, $5) on conflict ("ID") do update set "Name" = excluded."Name", "Weight" = excluded."Weight", "OK" = excluded."OK", "Data" = excluded."Data"; [1 old 0 false []]
+insert into "code.google.com/p/rsc/dbstore.Gadget" ("Name", "Weight", "OK", "Data") values ($1, $2, $3, $4) returning "ID"; [new 0 false []]
+
+-- Insert fts4
+insert into "code.google.com/p/rsc/dbstore.Doc" ("text") values ($1) returning "docid"; [the quick brown fox]
+
+-- Upsert
+update "code.google.com/p/rsc/dbstore.Person" set "Age" = $1, "Born" = $2, "email" = $3 where "Name" = $4; [31 1983-01-02 00:00:00 +0000 UTC alice@example.com alice]
+
+-- Write
+update "code.google.com/p/rsc/dbstore.Person" set "Age" = $1 where "Name" = $2; [32 alice]
+
+-- Read
+select "Age", "Born", "email" from "code.google.com/p/rsc/dbstore.Person" where "Name" = $1; [alice]
+
+-- Delete
+delete from "code.google.com/p/rsc/dbstore.Person" where "Name" = $1; [alice]
+
+-- Query
+select "Name", "Age", "Born", "email" from "code.google.com/p/rsc/dbstore.Person" where "Age" >= $1 and "email" like $2 order by "Born" desc limit $3 offset $4; [25 %.com 10 5]
+
+-- Query empty in
+select "Name", "Age", "Born", "email" from "code.google.com/p/rsc/dbstore.Person" where 1 = 0; []
+
+-- Count offset
+select count(*) from (select 1 from "code.google.com/p/rsc/dbstore.Person" offset $1) as q; [2]
+
+-- Match
+select "docid", "text" from "code.google.com/p/rsc/dbstore.Doc" where to_tsvector('english', coalesce("text", '')) @@ plainto_tsquery('english', $1); [quick fox]
+
+-- Migrate
+create table if not exists "dbstore.schema" ("table" text primary key, "version" integer, "schema" text); []
+select column_name as name, data_type as type from information_schema.columns where table_schema = current_schema() and table_name = $1; [code.google.com/p/rsc/dbstore.Person]
+
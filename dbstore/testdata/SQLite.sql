@@ -0,0 +1,50 @@
+-- CreateTables
+create table "code.google.com/p/rsc/dbstore.Person" ("Name" ,"Age" integer,"Born" timestamp,"email" , unique ("Name") on conflict replace); []
+create virtual table "code.google.com/p/rsc/dbstore.Doc" using fts4 ("text" ); []
+create table "code.google.com/p/rsc/dbstore.Gadget" ("ID" integer primary key,"Name" ,"Weight" real,"OK" ,"Data" blob); []
+create index "code.google.com/p/rsc/dbstore.Gadget.Name" on "code.google.com/p/rsc/dbstore.Gadget" ("Name"); []
+
+-- Insert
+insert or replace into "code.google.com/p/rsc/dbstore.Person" ("Name", "Age", "Born", "email") values (?, ?, ?, ?); [alice 30 1983-01-02 00:00:00 +0000 UTC alice@example.com]
+
+-- Insert new rowid
+insert or replace into "code.google.com/p/rsc/dbstore.Gadget" ("Name", "Weight", "OK", "Data") values (?, ?, ?, ?); [widget 1.5 true [120]]
+
+-- Insert old rowid
+insert or replace into "code.google.com/p/rsc/dbstore.Gadget" ("ID", "Name", "Weight", "OK", "Data") values (?, ?, ?, ?, ?); [3 widget 0 false []]
+
+-- Insert old then new rowid
+insert or replace into "code.google.com/p/rsc/dbstore.Gadget" ("ID", "Name", "Weight", "OK", "Data") values (?, ?, ?, ?, ?); [1 old 0 false []]
+insert or replace into "code.google.com/p/rsc/dbstore.Gadget" ("Name", "Weight", "OK", "Data") values (?, ?, ?, ?); [new 0 false []]
+
+-- Insert fts4
+insert or replace into "code.google.com/p/rsc/dbstore.Doc" ("text") values (?); [the quick brown fox]
+
+-- Upsert
+update "code.google.com/p/rsc/dbstore.Person" set "Age" = ?, "Born" = ?, "email" = ? where "Name" = ?; [31 1983-01-02 00:00:00 +0000 UTC alice@example.com alice]
+
+-- Write
+update "code.google.com/p/rsc/dbstore.Person" set "Age" = ? where "Name" = ?; [32 alice]
+
+-- Read
+select "Age", "Born", "email" from "code.google.com/p/rsc/dbstore.Person" where "Name" = ?; [alice]
+
+-- Delete
+delete from "code.google.com/p/rsc/dbstore.Person" where "Name" = ?; [alice]
+
+-- Query
+select "Name", "Age", "Born", "email" from "code.google.com/p/rsc/dbstore.Person" where "Age" >= ? and "email" like ? order by "Born" desc limit ? offset ?; [25 %.com 10 5]
+
+-- Query empty in
+select "Name", "Age", "Born", "email" from "code.google.com/p/rsc/dbstore.Person" where 1 = 0; []
+
+-- Count offset
+select count(*) from (select 1 from "code.google.com/p/rsc/dbstore.Person" limit ? offset ?) as q; [-1 2]
+
+-- Match
+select "docid", "text" from "code.google.com/p/rsc/dbstore.Doc" where "code.google.com/p/rsc/dbstore.Doc" match ?; [quick fox]
+
+-- Migrate
+create table if not exists "dbstore.schema" ("table" text primary key, "version" integer, "schema" text); []
+pragma table_info("code.google.com/p/rsc/dbstore.Person"); []
+
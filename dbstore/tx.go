@@ -0,0 +1,88 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbstore
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// A Tx is a database transaction.
+// It implements Context, so that the Storage methods
+// can be used within the transaction.
+type Tx struct {
+	tx *sql.Tx
+}
+
+// A beginner is a Context that can begin a transaction, such as a *sql.DB.
+type beginner interface {
+	Begin() (*sql.Tx, error)
+}
+
+func isBeginner(ctxt Context) bool {
+	_, ok := ctxt.(beginner)
+	return ok
+}
+
+// Begin begins a transaction on ctxt, which must be a *sql.DB
+// or other Context with a method Begin() (*sql.Tx, error).
+// Transactions cannot be nested.
+func Begin(ctxt Context) (*Tx, error) {
+	ctxt, _ = unwrap(ctxt)
+	b, ok := ctxt.(beginner)
+	if !ok {
+		return nil, fmt.Errorf("cannot begin transaction on %T", ctxt)
+	}
+	tx, err := b.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx}, nil
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.tx.Exec(query, args...)
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.tx.Query(query, args...)
+}
+
+// Prepare prepares a statement for use within the transaction.
+func (tx *Tx) Prepare(query string) (*sql.Stmt, error) {
+	return tx.tx.Prepare(query)
+}
+
+// Commit commits the transaction.
+func (tx *Tx) Commit() error {
+	return tx.tx.Commit()
+}
+
+// Rollback aborts the transaction.
+func (tx *Tx) Rollback() error {
+	return tx.tx.Rollback()
+}
+
+// Transaction calls f with a new transaction begun on ctxt.
+// If f returns nil, Transaction commits the transaction.
+// Otherwise, or if f panics, Transaction rolls it back.
+// Transaction returns the error from f or from the commit.
+func Transaction(ctxt Context, f func(tx *Tx) error) error {
+	tx, err := Begin(ctxt)
+	if err != nil {
+		return err
+	}
+	done := false
+	defer func() {
+		if !done {
+			tx.Rollback()
+		}
+	}()
+	if err := f(tx); err != nil {
+		return err
+	}
+	done = true
+	return tx.Commit()
+}
@@ -0,0 +1,514 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const maxProblems = 50
+
+// Check checks the consistency of the file system, much as e2fsck -n does,
+// and returns an error listing the problems found, one per line.
+//
+// It checks that no block is used twice, that the bitmaps mark exactly
+// the blocks and inodes in use, that every directory is well formed and
+// reachable from the root, that link and block counts are right, and that
+// the free counts in the group descriptors and superblock match the bitmaps.
+// It does not verify checksums or journals.
+func (fs *FS) Check() error {
+	c := &checker{
+		fs:     fs,
+		blocks: make([]byte, (fs.NumBlock+7)/8),
+		links:  make(map[uint32]int),
+		parent: make(map[uint32]uint32),
+		ea:     make(map[uint64]bool),
+	}
+	c.check()
+	if len(c.problems) == 0 {
+		return nil
+	}
+	if c.nproblem > len(c.problems) {
+		c.problems = append(c.problems, fmt.Sprintf("and %d more problems", c.nproblem-len(c.problems)))
+	}
+	return errors.New(strings.Join(c.problems, "\n"))
+}
+
+type checker struct {
+	fs       *FS
+	blocks   []byte            // bitmap of blocks in use
+	inodes   []byte            // bitmap of inodes in use, per the group bitmaps
+	dirs     []*File           // directories in use
+	links    map[uint32]int    // number of directory entries for each inode
+	parent   map[uint32]uint32 // parent of each directory
+	ea       map[uint64]bool   // extended attribute blocks seen
+	problems []string
+	nproblem int
+}
+
+func (c *checker) errorf(format string, args ...interface{}) {
+	c.nproblem++
+	if len(c.problems) < maxProblems {
+		c.problems = append(c.problems, fmt.Sprintf(format, args...))
+	}
+}
+
+func testBit(bits []byte, i uint64) bool {
+	return bits[i>>3]&(1<<(i&7)) != 0
+}
+
+func setBit(bits []byte, i uint64) {
+	bits[i>>3] |= 1 << (i & 7)
+}
+
+// use records that block b is in use, for the given purpose.
+func (c *checker) use(b uint64, what string) {
+	if b < uint64(c.fs.firstBlock) || b >= uint64(c.fs.NumBlock) {
+		c.errorf("%s: block number %d out of range", what, b)
+		return
+	}
+	if testBit(c.blocks, b) {
+		c.errorf("%s: block %d used twice", what, b)
+		return
+	}
+	setBit(c.blocks, b)
+}
+
+func (c *checker) check() {
+	fs := c.fs
+	for gnum := uint32(0); gnum < fs.numGroup; gnum++ {
+		if err := fs.groupMetadata(gnum, c.use); err != nil {
+			c.errorf("%v", err)
+			return
+		}
+	}
+	if !c.loadInodes() {
+		return
+	}
+	c.checkDirs()
+	c.checkLinks()
+	c.checkBitmaps()
+}
+
+// groupMetadata calls use for each metadata block of group gnum:
+// the backup superblock and descriptors it holds, if any,
+// and its bitmaps and inode table, wherever they are.
+func (fs *FS) groupMetadata(gnum uint32, use func(b uint64, what string)) error {
+	g, err := fs.group(gnum)
+	if err != nil {
+		return err
+	}
+	start := uint64(fs.firstBlock) + uint64(gnum)*uint64(fs.blocksPerGroup)
+	if fs.hasSuper(gnum) {
+		use(start, "superblock")
+		ndesc := (fs.numGroup + fs.descPerBlock - 1) / fs.descPerBlock
+		if ndesc > fs.firstMetaBg {
+			ndesc = fs.firstMetaBg
+		}
+		if fs.compat(compatResizeInode) {
+			ndesc += uint32(fs.super.Reservedgdtblock)
+		}
+		for i := uint32(0); i < ndesc; i++ {
+			use(start+1+uint64(i), "group descriptors")
+		}
+	}
+	if m := gnum / fs.descPerBlock; m >= fs.firstMetaBg {
+		// The descriptor block for each meta group is stored in
+		// its first group, with backups in the second and last.
+		switch gnum % fs.descPerBlock {
+		case 0, 1, fs.descPerBlock - 1:
+			b := start
+			if fs.hasSuper(gnum) {
+				b++
+			}
+			use(b, "group descriptors")
+		}
+	}
+	use(g.blockBitmap(), "block bitmap")
+	use(g.inodeBitmap(), "inode bitmap")
+	n := (uint64(fs.inodesPerGroup)*uint64(fs.inodeSize) + uint64(fs.BlockSize) - 1) / uint64(fs.BlockSize)
+	for i := uint64(0); i < n; i++ {
+		use(g.inodeTable()+i, "inode table")
+	}
+	return nil
+}
+
+// uninit reports whether g has the given uninitialized flag,
+// which only counts when the descriptors have checksums.
+func (fs *FS) uninit(g *group, flag uint16) bool {
+	return g.Flags&flag != 0 && fs.rocompat(rocompatGdtCsum|rocompatMetadataCsum)
+}
+
+// readBitmap returns the bitmap in block b.
+func (fs *FS) readBitmap(b uint64) ([]byte, error) {
+	if b < uint64(fs.firstBlock) || b >= uint64(fs.NumBlock) {
+		return nil, fmt.Errorf("bitmap block number %d out of range", b)
+	}
+	return fs.readBlock(b)
+}
+
+// loadInodes finds the inodes in use and the blocks they use.
+// It reports whether the inode bitmaps could be read.
+func (c *checker) loadInodes() bool {
+	fs := c.fs
+	c.inodes = make([]byte, (uint64(fs.numGroup)*uint64(fs.inodesPerGroup)+8)/8)
+	for gnum := uint32(0); gnum < fs.numGroup; gnum++ {
+		g, err := fs.group(gnum)
+		if err != nil {
+			c.errorf("%v", err)
+			return false
+		}
+		if fs.uninit(g, bgInodeUninit) {
+			continue
+		}
+		bitmap, err := fs.readBitmap(g.inodeBitmap())
+		if err != nil {
+			c.errorf("group %d: %v", gnum, err)
+			return false
+		}
+		for i := uint32(0); i < fs.inodesPerGroup; i++ {
+			if testBit(bitmap, uint64(i)) {
+				setBit(c.inodes, uint64(gnum*fs.inodesPerGroup+i+1))
+			}
+		}
+	}
+
+	for inum := uint32(1); inum <= fs.numGroup*fs.inodesPerGroup; inum++ {
+		reserved := inum < fs.firstIno() && inum != rootInode
+		if !testBit(c.inodes, uint64(inum)) {
+			if reserved {
+				c.errorf("reserved inode %d not marked in use", inum)
+			}
+			continue
+		}
+		f, err := fs.inode(inum)
+		if err != nil {
+			c.errorf("inode %d: %v", inum, err)
+			continue
+		}
+		if !reserved {
+			switch f.ino.Mode & ifmt {
+			case ififo, ifchr, ifdir, ifblk, ifreg, iflnk, ifsock:
+				// okay
+			default:
+				c.errorf("inode %d: invalid mode %#o", inum, f.ino.Mode)
+				continue
+			}
+			if f.ino.Nlink == 0 {
+				c.errorf("inode %d: in use with link count 0", inum)
+			}
+			if f.IsDir() {
+				c.dirs = append(c.dirs, f)
+			}
+		}
+		c.inodeBlocks(f)
+	}
+	return true
+}
+
+// inodeBlocks records the blocks used by f
+// and checks the inode's block count.
+func (c *checker) inodeBlocks(f *File) {
+	fs := c.fs
+	what := fmt.Sprintf("inode %d", f.inum)
+	var n uint64
+	if f.inum == resizeInode && fs.compat(compatResizeInode) {
+		// The resize inode's double indirect block points at the
+		// reserved descriptor blocks, which are already marked.
+		if b := f.ino.Block[ind2Block]; b != 0 {
+			c.use(uint64(b), what)
+		}
+		return
+	}
+
+	switch {
+	case f.ino.Flags&inlineDataFl != 0:
+		// no blocks
+	case f.ino.Flags&extentsFl != 0:
+		n = c.extentBlocks(f, f.blockBytes(), 0)
+	case f.isFastSymlink():
+		// no blocks
+	default:
+		switch f.ino.Mode & ifmt {
+		case ififo, ifchr, ifblk, ifsock:
+			// no blocks
+		default:
+			for i, b := range f.ino.Block {
+				depth := 0
+				if i >= indBlock {
+					depth = i - indBlock + 1
+				}
+				n += c.indirectBlocks(what, uint64(b), depth)
+			}
+		}
+	}
+
+	ea := uint64(f.ino.Fileacl)
+	if fs.has64bit() {
+		ea |= uint64(f.ino.Fileaclhi) << 32
+	}
+	if ea != 0 {
+		n++
+		if !c.ea[ea] {
+			c.ea[ea] = true
+			c.use(ea, what+" extended attributes")
+		}
+	}
+
+	nblock := uint64(f.ino.Nblock)
+	if fs.rocompat(rocompatHugeFile) {
+		nblock |= uint64(f.ino.Blockshi) << 32
+	}
+	if fs.rocompat(rocompatHugeFile) && f.ino.Flags&hugeFileFl != 0 {
+		nblock *= uint64(fs.BlockSize / bytesPerSector)
+	}
+	if want := n * uint64(fs.BlockSize/bytesPerSector); nblock != want {
+		c.errorf("inode %d: block count %d, counted %d", f.inum, nblock, want)
+	}
+}
+
+// indirectBlocks records the blocks in the tree rooted at block b,
+// an indirect block of the given depth or a data block for depth 0.
+// It returns the number of blocks in the tree.
+func (c *checker) indirectBlocks(what string, b uint64, depth int) uint64 {
+	if b == 0 {
+		return 0
+	}
+	c.use(b, what)
+	if depth == 0 || b >= uint64(c.fs.NumBlock) {
+		return 1
+	}
+	buf, err := c.fs.readBlock(b)
+	if err != nil {
+		c.errorf("%s: %v", what, err)
+		return 1
+	}
+	n := uint64(1)
+	for i := 0; i < len(buf); i += 4 {
+		n += c.indirectBlocks(what, uint64(binary.LittleEndian.Uint32(buf[i:])), depth-1)
+	}
+	return n
+}
+
+// extentBlocks records the blocks in the extent tree node
+// at the given level and returns the number of blocks in the tree.
+func (c *checker) extentBlocks(f *File, node []byte, level int) uint64 {
+	var h extentHeader
+	if err := unpack(node, &h); err != nil || h.Magic != extentMagic ||
+		extentSize*(1+int(h.Entries)) > len(node) || level > maxExtentDepth {
+		c.errorf("inode %d: corrupt extent tree", f.inum)
+		return 0
+	}
+	what := fmt.Sprintf("inode %d", f.inum)
+	var n uint64
+	for i := 1; i <= int(h.Entries); i++ {
+		entry := node[extentSize*i:]
+		if h.Depth == 0 {
+			var e extent
+			unpack(entry, &e)
+			count := uint64(e.Len)
+			if count > initMaxLen {
+				count -= initMaxLen
+			}
+			start := uint64(e.Startlo) | uint64(e.Starthi)<<32
+			for j := uint64(0); j < count; j++ {
+				c.use(start+j, what)
+			}
+			n += count
+			continue
+		}
+		var ix extentIndex
+		unpack(entry, &ix)
+		b := uint64(ix.Leaflo) | uint64(ix.Leafhi)<<32
+		c.use(b, what)
+		n++
+		if b >= uint64(c.fs.NumBlock) {
+			continue
+		}
+		buf, err := c.fs.readBlock(b)
+		if err != nil {
+			c.errorf("%s: %v", what, err)
+			continue
+		}
+		n += c.extentBlocks(f, buf, level+1)
+	}
+	return n
+}
+
+// inUse reports whether inode inum is in use.
+func (c *checker) inUse(inum uint32) bool {
+	return inum > 0 && inum <= c.fs.numGroup*c.fs.inodesPerGroup && testBit(c.inodes, uint64(inum))
+}
+
+// checkDirs checks the entries in each directory.
+func (c *checker) checkDirs() {
+	for _, d := range c.dirs {
+		// ReadDir, not walkDir: reading inodes while walking
+		// could evict the directory block from the cache.
+		dirs, err := d.ReadDir()
+		if err != nil {
+			c.errorf("directory %d: %v", d.inum, err)
+		}
+		if len(dirs) < 2 || dirs[0].Name != "." || dirs[0].Inode != d.inum || dirs[1].Name != ".." {
+			c.errorf("directory %d: missing . or .. entry", d.inum)
+		}
+		for i, e := range dirs {
+			if i >= 2 && (e.Name == "." || e.Name == "..") {
+				c.errorf("directory %d: extra %s entry", d.inum, e.Name)
+			}
+			if !c.inUse(e.Inode) {
+				c.errorf("directory %d: entry %q refers to unused inode %d", d.inum, e.Name, e.Inode)
+				continue
+			}
+			c.links[e.Inode]++
+			if e.Name == "." || e.Name == ".." {
+				continue
+			}
+			f, err := c.fs.inode(e.Inode)
+			if err != nil {
+				c.errorf("directory %d: entry %q: %v", d.inum, e.Name, err)
+				continue
+			}
+			if f.IsDir() {
+				if p, ok := c.parent[e.Inode]; ok {
+					c.errorf("directory %d: entry %q links to directory %d, already in directory %d", d.inum, e.Name, e.Inode, p)
+				} else {
+					c.parent[e.Inode] = d.inum
+				}
+			}
+		}
+	}
+	c.parent[rootInode] = rootInode
+
+	// Check that .. entries match the parents
+	// and that every directory is reachable from the root.
+	for _, d := range c.dirs {
+		p, ok := c.parent[d.inum]
+		if !ok {
+			c.errorf("directory %d: not in any directory", d.inum)
+			continue
+		}
+		dotdot, err := d.lookupInode("..")
+		if err == nil && dotdot != p {
+			c.errorf("directory %d: .. is inode %d, want %d", d.inum, dotdot, p)
+		}
+		seen := map[uint32]bool{d.inum: true}
+		for p != rootInode {
+			if seen[p] {
+				c.errorf("directory %d: not reachable from root", d.inum)
+				break
+			}
+			seen[p] = true
+			p = c.parent[p]
+		}
+	}
+}
+
+// checkLinks checks the link count of each inode in use.
+func (c *checker) checkLinks() {
+	fs := c.fs
+	for inum := fs.firstIno(); inum <= fs.numGroup*fs.inodesPerGroup; inum++ {
+		c.checkLink(inum)
+	}
+	c.checkLink(rootInode)
+}
+
+func (c *checker) checkLink(inum uint32) {
+	if !c.inUse(inum) {
+		return
+	}
+	f, err := c.fs.inode(inum)
+	if err != nil {
+		return // reported already
+	}
+	n := c.links[inum]
+	switch {
+	case n == 0:
+		c.errorf("inode %d: not in any directory", inum)
+	case f.IsDir() && f.ino.Nlink == 1 && n >= 65000 && c.fs.rocompat(rocompatDirNlink):
+		// too many subdirectories to count
+	case int(f.ino.Nlink) != n:
+		c.errorf("inode %d: link count %d, counted %d", inum, f.ino.Nlink, n)
+	}
+}
+
+// checkBitmaps compares the bitmaps and free counts with the
+// blocks and inodes found in use.
+func (c *checker) checkBitmaps() {
+	fs := c.fs
+	var freeBlocks, freeInodes uint64
+	for gnum := uint32(0); gnum < fs.numGroup; gnum++ {
+		g, err := fs.group(gnum)
+		if err != nil {
+			c.errorf("%v", err)
+			return
+		}
+		start := uint64(fs.firstBlock) + uint64(gnum)*uint64(fs.blocksPerGroup)
+		n := fs.groupBlocks(gnum)
+		var bitmap []byte
+		if fs.uninit(g, bgBlockUninit) {
+			// The group only holds its own metadata.
+			bitmap = make([]byte, fs.BlockSize)
+			fs.groupMetadata(gnum, func(b uint64, what string) {
+				if start <= b && b < start+uint64(n) {
+					setBit(bitmap, b-start)
+				}
+			})
+		} else if bitmap, err = fs.readBitmap(g.blockBitmap()); err != nil {
+			c.errorf("group %d: %v", gnum, err)
+			return
+		}
+		var free uint32
+		for i := uint32(0); i < n; i++ {
+			marked, used := testBit(bitmap, uint64(i)), testBit(c.blocks, start+uint64(i))
+			switch {
+			case used && !marked:
+				c.errorf("block %d in use but marked free", start+uint64(i))
+			case !used && marked:
+				c.errorf("block %d marked in use but not used", start+uint64(i))
+			}
+			if !marked {
+				free++
+			}
+		}
+		if free != g.freeBlocks() {
+			c.errorf("group %d: free block count %d, counted %d", gnum, g.freeBlocks(), free)
+		}
+		freeBlocks += uint64(free)
+
+		var freeIno, dirs uint32
+		for i := uint32(0); i < fs.inodesPerGroup; i++ {
+			inum := gnum*fs.inodesPerGroup + i + 1
+			if !c.inUse(inum) {
+				freeIno++
+				continue
+			}
+			if f, err := fs.inode(inum); err == nil && f.IsDir() {
+				dirs++
+			}
+		}
+		if freeIno != g.freeInodes() {
+			c.errorf("group %d: free inode count %d, counted %d", gnum, g.freeInodes(), freeIno)
+		}
+		if dirs != g.usedDirs() {
+			c.errorf("group %d: directory count %d, counted %d", gnum, g.usedDirs(), dirs)
+		}
+		freeInodes += uint64(freeIno)
+	}
+
+	sbFree := uint64(fs.super.Freeblockcount)
+	if fs.has64bit() {
+		sbFree |= uint64(fs.super.Freeblockcounthi) << 32
+	}
+	if sbFree != freeBlocks {
+		c.errorf("superblock: free block count %d, counted %d", sbFree, freeBlocks)
+	}
+	if uint64(fs.super.Freeinodecount) != freeInodes {
+		c.errorf("superblock: free inode count %d, counted %d", fs.super.Freeinodecount, freeInodes)
+	}
+}
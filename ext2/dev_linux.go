@@ -0,0 +1,30 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package ext2
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileDev returns the device number recorded
+// in the system-specific part of info, if any.
+func fileDev(info fs.FileInfo) (major, minor uint32, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	major, minor = linuxDev(uint64(st.Rdev))
+	return major, minor, true
+}
+
+// linuxDev splits a Linux device number into major and minor numbers.
+func linuxDev(dev uint64) (major, minor uint32) {
+	major = uint32(dev>>8&0xfff | dev>>32&^0xfff)
+	minor = uint32(dev&0xff | dev>>12&^0xff)
+	return
+}
@@ -0,0 +1,17 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package ext2
+
+import "io/fs"
+
+// fileDev returns the device number recorded
+// in the system-specific part of info, if any.
+// Other systems encode device numbers differently,
+// so it reports none.
+func fileDev(info fs.FileInfo) (major, minor uint32, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,99 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext2
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// An EXT4 file using extents stores in its block pointers the root
+// of a tree mapping logical blocks to runs of disk blocks.
+// Each node is a header followed by entries: index entries
+// pointing at lower nodes, or, at depth 0, leaf extents.
+
+const (
+	extentMagic    = 0xF30A
+	extentSize     = 12 // size of header and each entry
+	maxExtentDepth = 5
+	initMaxLen     = 32768 // longer extents are uninitialized
+)
+
+type extentHeader struct {
+	Magic      uint16 /* probably will support different formats */
+	Entries    uint16 /* number of valid entries */
+	Max        uint16 /* capacity of store in entries */
+	Depth      uint16 /* has tree real underlying blocks? */
+	Generation uint32 /* generation of the tree */
+}
+
+type extentIndex struct {
+	Block  uint32 /* index covers logical blocks from 'block' */
+	Leaflo uint32 /* pointer to the physical block of the next level */
+	Leafhi uint16 /* high 16 bits of physical block */
+	Unused uint16
+}
+
+type extent struct {
+	Block   uint32 /* first logical block extent covers */
+	Len     uint16 /* number of blocks covered by extent */
+	Starthi uint16 /* high 16 bits of physical block */
+	Startlo uint32 /* low 32 bits of physical block */
+}
+
+// extentBlock is dblock for files using extents.
+// Blocks outside any extent or in uninitialized extents read as zeros,
+// so extentBlock returns 0 for them.
+func (f *File) extentBlock(block uint32) (uint64, error) {
+	node := f.blockBytes()
+	for level := 0; ; level++ {
+		var h extentHeader
+		if err := unpack(node, &h); err != nil {
+			return 0, err
+		}
+		if h.Magic != extentMagic {
+			return 0, fmt.Errorf("bad extent magic %#x wanted %#x", h.Magic, extentMagic)
+		}
+		if extentSize*(1+int(h.Entries)) > len(node) || level > maxExtentDepth {
+			return 0, fmt.Errorf("corrupt extent tree in inode %d", f.inum)
+		}
+
+		// Find the last entry starting at or before block.
+		// The logical block is the first field of both kinds of entry.
+		i := int(h.Entries)
+		for i > 0 && binary.LittleEndian.Uint32(node[extentSize*i:]) > block {
+			i--
+		}
+		if i == 0 {
+			return 0, nil
+		}
+		entry := node[extentSize*i:]
+
+		if h.Depth == 0 {
+			var e extent
+			if err := unpack(entry, &e); err != nil {
+				return 0, err
+			}
+			if e.Len > initMaxLen {
+				return 0, nil
+			}
+			if off := block - e.Block; off < uint32(e.Len) {
+				start := uint64(e.Startlo) | uint64(e.Starthi)<<32
+				return start + uint64(off), nil
+			}
+			return 0, nil
+		}
+
+		var ix extentIndex
+		if err := unpack(entry, &ix); err != nil {
+			return 0, err
+		}
+		buf, err := f.fs.readData(uint64(ix.Leaflo)|uint64(ix.Leafhi)<<32, 0, nil)
+		if err != nil {
+			return 0, err
+		}
+		node = buf
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext2
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Feature flags in the superblock.
+//
+// A file system may only be used by code that understands
+// all of its incompatible features.  Compatible and read-only
+// compatible features only matter when writing.
+const (
+	// Featurecompat
+	compatDirPrealloc  = 0x0001
+	compatImagicInodes = 0x0002
+	compatHasJournal   = 0x0004
+	compatExtAttr      = 0x0008
+	compatResizeInode  = 0x0010
+	compatDirIndex     = 0x0020
+	compatSparseSuper2 = 0x0200
+
+	// Featurerocompat
+	rocompatSparseSuper  = 0x0001
+	rocompatLargeFile    = 0x0002
+	rocompatHugeFile     = 0x0008
+	rocompatGdtCsum      = 0x0010
+	rocompatDirNlink     = 0x0020
+	rocompatExtraIsize   = 0x0040
+	rocompatMetadataCsum = 0x0400
+
+	// Featureincompat
+	incompatCompression = 0x0001
+	incompatFiletype    = 0x0002
+	incompatRecover     = 0x0004
+	incompatJournalDev  = 0x0008
+	incompatMetaBg      = 0x0010
+	incompatExtents     = 0x0040
+	incompat64bit       = 0x0080
+	incompatMMP         = 0x0100
+	incompatFlexBg      = 0x0200
+	incompatEAInode     = 0x0400
+	incompatDirdata     = 0x1000
+	incompatCsumSeed    = 0x2000
+	incompatLargedir    = 0x4000
+	incompatInlineData  = 0x8000
+	incompatEncrypt     = 0x10000
+	incompatCasefold    = 0x20000
+
+	// incompatSupported lists the incompatible features this package reads.
+	// A journal needing recovery (incompatRecover) is ignored:
+	// the file system reads as it was at the last checkpoint.
+	incompatSupported = incompatFiletype | incompatRecover | incompatMetaBg |
+		incompatExtents | incompat64bit | incompatMMP | incompatFlexBg |
+		incompatEAInode | incompatCsumSeed | incompatLargedir | incompatInlineData
+
+	// incompatWritable and rocompatWritable list the features this
+	// package maintains when writing.  In particular it does not
+	// write extents or checksums.
+	incompatWritable = incompatFiletype | incompatMetaBg
+	rocompatWritable = rocompatSparseSuper | rocompatLargeFile | rocompatHugeFile |
+		rocompatDirNlink | rocompatExtraIsize
+)
+
+type featureName struct {
+	flag uint32
+	name string
+}
+
+var rocompatNames = []featureName{
+	{rocompatSparseSuper, "sparse_super"},
+	{rocompatLargeFile, "large_file"},
+	{rocompatHugeFile, "huge_file"},
+	{rocompatGdtCsum, "uninit_bg"},
+	{rocompatDirNlink, "dir_nlink"},
+	{rocompatExtraIsize, "extra_isize"},
+	{rocompatMetadataCsum, "metadata_csum"},
+}
+
+var incompatNames = []featureName{
+	{incompatCompression, "compression"},
+	{incompatFiletype, "filetype"},
+	{incompatRecover, "needs_recovery"},
+	{incompatJournalDev, "journal_dev"},
+	{incompatMetaBg, "meta_bg"},
+	{incompatExtents, "extent"},
+	{incompat64bit, "64bit"},
+	{incompatMMP, "mmp"},
+	{incompatFlexBg, "flex_bg"},
+	{incompatEAInode, "ea_inode"},
+	{incompatDirdata, "dirdata"},
+	{incompatCsumSeed, "metadata_csum_seed"},
+	{incompatLargedir, "large_dir"},
+	{incompatInlineData, "inline_data"},
+	{incompatEncrypt, "encrypt"},
+	{incompatCasefold, "casefold"},
+}
+
+// featureNames returns the names of the features in flags,
+// as used by mke2fs and tune2fs, or in hexadecimal for unknown flags.
+func featureNames(flags uint32, table []featureName) string {
+	var names []string
+	for _, f := range table {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+			flags &^= f.flag
+		}
+	}
+	for bit := uint32(1); flags != 0; bit <<= 1 {
+		if flags&bit != 0 {
+			names = append(names, fmt.Sprintf("%#x", bit))
+			flags &^= bit
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// checkFeatures returns an error if the superblock uses features
+// that this package cannot read.
+func checkFeatures(super *diskSuper) error {
+	if super.Revlevel < 1 {
+		return nil
+	}
+	if bad := super.Featureincompat &^ incompatSupported; bad != 0 {
+		return fmt.Errorf("unsupported incompatible features: %s", featureNames(bad, incompatNames))
+	}
+	return nil
+}
+
+// checkWritable returns an error if the file system uses features
+// that this package cannot maintain when writing.
+func checkWritable(super *diskSuper) error {
+	if super.Revlevel >= 1 {
+		if bad := super.Featureincompat &^ incompatWritable; bad != 0 {
+			return fmt.Errorf("cannot write file system with incompatible features: %s", featureNames(bad, incompatNames))
+		}
+		if bad := super.Featurerocompat &^ rocompatWritable; bad != 0 {
+			return fmt.Errorf("cannot write file system with read-only compatible features: %s", featureNames(bad, rocompatNames))
+		}
+	}
+	if super.State&(validFS|errorFS) != validFS {
+		return fmt.Errorf("cannot write file system with errors or not cleanly unmounted; run e2fsck")
+	}
+	return nil
+}
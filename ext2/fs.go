@@ -2,7 +2,16 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package ext2 implements read-only access to EXT2 file systems.
+// Package ext2 implements access to EXT2 file systems.
+//
+// Init and Open return a read-only FS; InitWrite and OpenWrite
+// return one that can also create, write, and remove files.
+//
+// The package also reads the EXT3 and EXT4 file systems derived from EXT2,
+// including files stored using extents or inline data, hash-indexed
+// (htree) directories, 64-bit block numbers, and flexible block groups.
+// Init refuses file systems using incompatible features it does not
+// understand.
 package ext2
 
 import (
@@ -25,10 +34,11 @@ const (
 	superMagic = 0xEF53 // superblock magic
 
 	minBlockSize = 1024
-	maxBlockSize = 4096
+	maxBlockSize = 65536
 
-	rootInode  = 2
-	firstInode = 11
+	rootInode   = 2
+	resizeInode = 7 // reserved descriptor blocks, for resize_inode
+	firstInode  = 11
 
 	validFS = 0x0001
 	errorFS = 0x0002
@@ -59,6 +69,12 @@ const (
 	iflnk  = 0120000
 	ifsock = 0140000
 	ifwht  = 0160000
+
+	// flags in Inode.flags
+	indexFl      = 0x00001000 // hash-indexed directory
+	hugeFileFl   = 0x00040000 // block count is in file system blocks
+	extentsFl    = 0x00080000 // inode uses extents
+	inlineDataFl = 0x10000000 // inode has inline data
 )
 
 func dirlen(nameLen int) int {
@@ -93,9 +109,45 @@ type diskSuper struct {
 	Defresgid      uint16 /* Default gid for reserved blocks */
 
 	/* the following are only available with revlevel = 1 */
-	Firstino     uint32 /* First non-reserved inode */
-	Inosize      uint16 /* size of inode structure */
-	Blockgroupnr uint16 /* block group # of this super block */
+	Firstino         uint32     /* First non-reserved inode */
+	Inosize          uint16     /* size of inode structure */
+	Blockgroupnr     uint16     /* block group # of this super block */
+	Featurecompat    uint32     /* compatible feature set */
+	Featureincompat  uint32     /* incompatible feature set */
+	Featurerocompat  uint32     /* readonly-compatible feature set */
+	Uuid             [16]uint8  /* 128-bit uuid for volume */
+	Volumename       [16]uint8  /* volume name */
+	Lastmounted      [64]uint8  /* directory where last mounted */
+	Algousagebitmap  uint32     /* For compression */
+	Preallocblocks   uint8      /* Nr of blocks to try to preallocate*/
+	Preallocdirblock uint8      /* Nr to preallocate for dirs */
+	Reservedgdtblock uint16     /* Per group desc for online growth */
+	Journaluuid      [16]uint8  /* uuid of journal superblock */
+	Journalinum      uint32     /* inode number of journal file */
+	Journaldev       uint32     /* device number of journal file */
+	Lastorphan       uint32     /* start of list of inodes to delete */
+	Hashseed         [4]uint32  /* HTREE hash seed */
+	Defhashversion   uint8      /* Default hash version to use */
+	Jnlbackuptype    uint8      /* journal backup type */
+	Descsize         uint16     /* size of group descriptor */
+	Defaultmountopts uint32     /* default mount options */
+	Firstmetabg      uint32     /* First metablock block group */
+	Mkfstime         uint32     /* When the filesystem was created */
+	Jnlblocks        [17]uint32 /* Backup of the journal inode */
+	Nblockhi         uint32     /* Blocks count, high 32 bits */
+	Rblockcounthi    uint32     /* Reserved blocks count, high 32 bits */
+	Freeblockcounthi uint32     /* Free blocks count, high 32 bits */
+	Minextraisize    uint16     /* All inodes have at least # bytes */
+	Wantextraisize   uint16     /* New inodes should reserve # bytes */
+	Flags            uint32     /* Miscellaneous flags */
+	Raidstride       uint16     /* RAID stride */
+	Mmpinterval      uint16     /* # seconds to wait in MMP checking */
+	Mmpblock         uint64     /* Block for multi-mount protection */
+	Raidstripewidth  uint32     /* blocks on all data disks (N*stride)*/
+	Loggroupsperflex uint8      /* FLEX_BG group size */
+	Checksumtype     uint8      /* metadata checksum algorithm */
+	Pad2             [0xd6]uint8
+	Backupbgs        [2]uint32 /* groups with sparse_super2 backups */
 }
 
 type diskGroup struct {
@@ -105,28 +157,114 @@ type diskGroup struct {
 	Freeblockscount uint16 /* Free blocks count */
 	Freeinodescount uint16 /* Free inodes count */
 	Useddirscount   uint16 /* Directories count */
+	Flags           uint16 /* EXT4_BG_flags (INODE_UNINIT, etc) */
+	Excludebitmap   uint32 /* Exclude bitmap for snapshots */
+	Bitblockcsum    uint16 /* crc32c(s_uuid+grp_num+bbitmap) LE */
+	Inodebitcsum    uint16 /* crc32c(s_uuid+grp_num+ibitmap) LE */
+	Itableunused    uint16 /* Unused inodes count */
+	Checksum        uint16 /* crc16(sb_uuid+group+desc) */
+}
+
+// diskGroupHi is the second half of a 64-byte group descriptor,
+// used when the file system has the 64bit feature.
+type diskGroupHi struct {
+	Bitblock        uint32 /* Blocks bitmap block MSB */
+	Inodebitblock   uint32 /* Inodes bitmap block MSB */
+	Inodeaddr       uint32 /* Inodes table block MSB */
+	Freeblockscount uint16 /* Free blocks count MSB */
+	Freeinodescount uint16 /* Free inodes count MSB */
+	Useddirscount   uint16 /* Directories count MSB */
+	Itableunused    uint16 /* Unused inodes count MSB */
+	Excludebitmap   uint32 /* Exclude bitmap block MSB */
+	Bitblockcsum    uint16 /* crc32c(s_uuid+grp_num+bbitmap) BE */
+	Inodebitcsum    uint16 /* crc32c(s_uuid+grp_num+ibitmap) BE */
+	Reserved        uint32
+}
+
+const (
+	diskGroupSize     = 32
+	diskGroupSize64   = 64
+	diskInodeSize     = 128
+	bgInodeUninit     = 0x0001 // inode bitmap not initialized
+	bgBlockUninit     = 0x0002 // block bitmap not initialized
+	superFlagSigned   = 0x0001 // directory hashes use signed chars
+	superFlagUnsigned = 0x0002 // directory hashes use unsigned chars
+)
+
+// A group is a block group descriptor.
+// The hi half is zero unless the file system has the 64bit feature.
+type group struct {
+	diskGroup
+	hi    diskGroupHi
+	dirty bool // counts changed; write in Sync
+}
+
+func (g *group) blockBitmap() uint64 {
+	return uint64(g.Bitblock) | uint64(g.hi.Bitblock)<<32
+}
+
+func (g *group) inodeBitmap() uint64 {
+	return uint64(g.Inodebitblock) | uint64(g.hi.Inodebitblock)<<32
+}
+
+func (g *group) inodeTable() uint64 {
+	return uint64(g.Inodeaddr) | uint64(g.hi.Inodeaddr)<<32
+}
+
+func (g *group) freeBlocks() uint32 {
+	return uint32(g.Freeblockscount) | uint32(g.hi.Freeblockscount)<<16
+}
+
+func (g *group) freeInodes() uint32 {
+	return uint32(g.Freeinodescount) | uint32(g.hi.Freeinodescount)<<16
+}
+
+func (g *group) usedDirs() uint32 {
+	return uint32(g.Useddirscount) | uint32(g.hi.Useddirscount)<<16
+}
+
+func (g *group) addFreeBlocks(n int) {
+	x := uint32(int(g.freeBlocks()) + n)
+	g.Freeblockscount, g.hi.Freeblockscount = uint16(x), uint16(x>>16)
+	g.dirty = true
 }
 
-const diskGroupSize = 32
+func (g *group) addFreeInodes(n int) {
+	x := uint32(int(g.freeInodes()) + n)
+	g.Freeinodescount, g.hi.Freeinodescount = uint16(x), uint16(x>>16)
+	g.dirty = true
+}
+
+func (g *group) addUsedDirs(n int) {
+	x := uint32(int(g.usedDirs()) + n)
+	g.Useddirscount, g.hi.Useddirscount = uint16(x), uint16(x>>16)
+	g.dirty = true
+}
 
 type diskInode struct {
-	Mode    uint16 /* File mode */
-	Uid     uint16 /* Owner Uid */
-	Size    uint32 /* Size in bytes */
-	Atime   uint32 /* Access time */
-	Ctime   uint32 /* Creation time */
-	Mtime   uint32 /* Modification time */
-	Dtime   uint32 /* Deletion Time */
-	Gid     uint16 /* Group Id */
-	Nlink   uint16 /* Links count */
-	Nblock  uint32 /* Blocks count */
-	Flags   uint32 /* File flags */
-	Osd1    uint32
-	Block   [numBlocks]uint32 /* Pointers to blocks */
-	Version uint32            /* File version (for NFS) */
-	Fileacl uint32            /* File ACL */
-	Diracl  uint32            /* Directory ACL or high size bits */
-	Faddr   uint32            /* Fragment address */
+	Mode       uint16 /* File mode */
+	Uid        uint16 /* Owner Uid */
+	Size       uint32 /* Size in bytes */
+	Atime      uint32 /* Access time */
+	Ctime      uint32 /* Creation time */
+	Mtime      uint32 /* Modification time */
+	Dtime      uint32 /* Deletion Time */
+	Gid        uint16 /* Group Id */
+	Nlink      uint16 /* Links count */
+	Nblock     uint32 /* Blocks count */
+	Flags      uint32 /* File flags */
+	Osd1       uint32
+	Block      [numBlocks]uint32 /* Pointers to blocks */
+	Version    uint32            /* File version (for NFS) */
+	Fileacl    uint32            /* File ACL */
+	Diracl     uint32            /* Directory ACL or high size bits */
+	Faddr      uint32            /* Fragment address */
+	Blockshi   uint16            /* high 16 bits of Nblock */
+	Fileaclhi  uint16            /* high 16 bits of Fileacl */
+	Uidhi      uint16            /* high 16 bits of Uid */
+	Gidhi      uint16            /* high 16 bits of Gid */
+	Checksumlo uint16            /* crc32c(uuid+inum+inode) LE */
+	Reserved   uint16
 }
 
 type diskDirent struct {
@@ -148,16 +286,22 @@ type FS struct {
 	inodeSize      uint32
 	groupAddr      uint32
 	descPerBlock   uint32
+	descSize       uint32
 	firstBlock     uint32
-
-	g   []*diskGroup
-	r   io.ReaderAt
-	c   io.Closer
-	buf []byte
+	firstMetaBg    uint32
+	super          diskSuper
+	superDirty     bool
+
+	g     []*group
+	r     io.ReaderAt
+	w     io.WriterAt // nil if read-only
+	c     io.Closer
+	buf   []byte
+	files map[uint32]*File // files in use, when writing
+	now   func() time.Time
 
 	cache    [16]block
 	cacheAge int64
-	cacheHit int64
 }
 
 type block struct {
@@ -176,6 +320,14 @@ func (r *readerAtOffset) ReadAt(p []byte, off int64) (n int, err error) {
 	return r.r.ReadAt(p, off+r.off)
 }
 
+func (r *readerAtOffset) WriteAt(p []byte, off int64) (n int, err error) {
+	w, ok := r.r.(io.WriterAt)
+	if !ok {
+		return 0, fmt.Errorf("file system opened read-only")
+	}
+	return w.WriteAt(p, off+r.off)
+}
+
 // Open opens the file system in the named file.
 //
 // If the name contains an @ sign, it is taken to be
@@ -184,6 +336,16 @@ func (r *readerAtOffset) ReadAt(p []byte, off int64) (n int, err error) {
 // and the file system is assumed to start at the given
 // offset in the file instead of at the beginning of the file.
 func Open(name string) (*FS, error) {
+	return open(name, os.O_RDONLY)
+}
+
+// OpenWrite is like Open but opens the file system for writing, as InitWrite does.
+// The caller must call Close to write the updated free counts and close the file.
+func OpenWrite(name string) (*FS, error) {
+	return open(name, os.O_RDWR)
+}
+
+func open(name string, flag int) (*FS, error) {
 	var off int64
 	if i := strings.Index(name, "@"); i >= 0 {
 		v, err := strconv.ParseInt(name[i+1:], 0, 64)
@@ -194,17 +356,22 @@ func Open(name string) (*FS, error) {
 		name = name[:i]
 	}
 
-	f, err := os.Open(name)
+	f, err := os.OpenFile(name, flag, 0)
 	if err != nil {
 		return nil, err
 	}
 
-	var r io.ReaderAt = f
+	var r ReadWriterAt = f
 	if off != 0 {
 		r = &readerAtOffset{r, off}
 	}
 
-	fs, err := Init(r)
+	var fs *FS
+	if flag == os.O_RDONLY {
+		fs, err = Init(r)
+	} else {
+		fs, err = InitWrite(r)
+	}
 	if err != nil {
 		f.Close()
 		return nil, err
@@ -219,6 +386,7 @@ func Init(r io.ReaderAt) (*FS, error) {
 		r:         r,
 		buf:       make([]byte, 1024),
 		BlockSize: superSize,
+		now:       time.Now,
 	}
 
 	var super diskSuper
@@ -230,17 +398,34 @@ func Init(r io.ReaderAt) (*FS, error) {
 		return nil, fmt.Errorf("bad magic %#x wanted %#x", super.Magic, superMagic)
 	}
 
+	if err := checkFeatures(&super); err != nil {
+		return nil, err
+	}
+	if minBlockSize<<super.Logblocksize > maxBlockSize {
+		return nil, fmt.Errorf("invalid block size %d", minBlockSize<<super.Logblocksize)
+	}
+	if super.Blockspergroup == 0 || super.Inospergroup == 0 {
+		return nil, fmt.Errorf("invalid superblock: %d blocks, %d inodes per group", super.Blockspergroup, super.Inospergroup)
+	}
+
 	bsize := uint32(minBlockSize << super.Logblocksize)
+	fs.super = super
 	fs.BlockSize = int(bsize)
 	fs.NumBlock = int64(super.Nblock)
-	fs.numGroup = (super.Nblock + super.Blockspergroup - 1) / super.Blockspergroup
-	fs.g = make([]*diskGroup, fs.numGroup)
+	if fs.has64bit() {
+		fs.NumBlock |= int64(super.Nblockhi) << 32
+	}
+	fs.numGroup = uint32((fs.NumBlock - int64(super.Firstdatablock) + int64(super.Blockspergroup) - 1) / int64(super.Blockspergroup))
+	fs.g = make([]*group, fs.numGroup)
 	fs.inodesPerGroup = super.Inospergroup
 	fs.blocksPerGroup = super.Blockspergroup
 	if super.Revlevel >= 1 {
 		fs.inodeSize = uint32(super.Inosize)
 	} else {
-		fs.inodeSize = 128
+		fs.inodeSize = diskInodeSize
+	}
+	if fs.inodeSize < diskInodeSize || fs.inodeSize > bsize || fs.inodeSize&(fs.inodeSize-1) != 0 {
+		return nil, fmt.Errorf("invalid inode size %d", fs.inodeSize)
 	}
 	fs.inodesPerBlock = bsize / fs.inodeSize
 	if bsize == superOff {
@@ -248,34 +433,94 @@ func Init(r io.ReaderAt) (*FS, error) {
 	} else {
 		fs.groupAddr = 1
 	}
-	fs.descPerBlock = bsize / diskGroupSize
+	fs.descSize = diskGroupSize
+	if fs.has64bit() {
+		fs.descSize = uint32(super.Descsize)
+		if fs.descSize < diskGroupSize64 || fs.descSize > bsize || fs.descSize&(fs.descSize-1) != 0 {
+			return nil, fmt.Errorf("invalid group descriptor size %d", fs.descSize)
+		}
+	}
+	fs.descPerBlock = bsize / fs.descSize
 	fs.firstBlock = super.Firstdatablock
+	fs.firstMetaBg = ^uint32(0)
+	if fs.incompat(incompatMetaBg) {
+		fs.firstMetaBg = super.Firstmetabg
+	}
 
 	return fs, nil
 }
 
+func (fs *FS) incompat(flag uint32) bool {
+	return fs.super.Revlevel >= 1 && fs.super.Featureincompat&flag != 0
+}
+
+func (fs *FS) compat(flag uint32) bool {
+	return fs.super.Revlevel >= 1 && fs.super.Featurecompat&flag != 0
+}
+
+func (fs *FS) rocompat(flag uint32) bool {
+	return fs.super.Revlevel >= 1 && fs.super.Featurerocompat&flag != 0
+}
+
+func (fs *FS) has64bit() bool {
+	return fs.incompat(incompat64bit)
+}
+
+// hasSuper reports whether block group gnum holds a copy
+// of the superblock and group descriptors.
+func (fs *FS) hasSuper(gnum uint32) bool {
+	switch {
+	case gnum == 0:
+		return true
+	case fs.compat(compatSparseSuper2):
+		return gnum == fs.super.Backupbgs[0] || gnum == fs.super.Backupbgs[1]
+	case !fs.rocompat(rocompatSparseSuper) || gnum == 1:
+		return true
+	}
+	for _, p := range []uint32{3, 5, 7} {
+		n := p
+		for n < gnum {
+			n *= p
+		}
+		if n == gnum {
+			return true
+		}
+	}
+	return false
+}
+
+// groupDescBlock returns the block holding the descriptor for group gnum.
+func (fs *FS) groupDescBlock(gnum uint32) uint64 {
+	m := gnum / fs.descPerBlock
+	if m < fs.firstMetaBg {
+		return uint64(fs.groupAddr + m)
+	}
+	// With meta_bg, the descriptors for each run of descPerBlock groups
+	// are stored in the first group of the run.
+	g0 := m * fs.descPerBlock
+	b := uint64(fs.firstBlock) + uint64(g0)*uint64(fs.blocksPerGroup)
+	if fs.hasSuper(g0) {
+		b++
+	}
+	return b
+}
+
 // A File represents a file or directory in a file system.
 type File struct {
-	fs   *FS
-	inum uint32
-	ino  diskInode
+	fs    *FS
+	inum  uint32
+	ino   diskInode
+	extra []byte // inode bytes past diskInodeSize
+	next  uint64 // block allocation goal, when writing
 }
 
 // File returns the file with the given inode number.
 func (fs *FS) File(inode uint32) (*File, error) {
-	g, ioff, err := fs.igroup(inode)
+	file, err := fs.inode(inode)
 	if err != nil {
 		return nil, err
 	}
 
-	addr := int64(fs.BlockSize) * int64(g.Inodeaddr+ioff/fs.inodesPerBlock)
-	ivoff := (ioff % fs.inodesPerBlock) * fs.inodeSize
-
-	file := &File{fs: fs, inum: inode}
-	if _, err := fs.read(addr, int(ivoff), &file.ino); err != nil {
-		return nil, err
-	}
-
 	switch file.ino.Mode & ifmt {
 	case ififo, ifchr, ifdir, ifblk, ifreg, iflnk, ifsock:
 		// okay
@@ -286,9 +531,51 @@ func (fs *FS) File(inode uint32) (*File, error) {
 	return file, nil
 }
 
-func (fs *FS) igroup(inum uint32) (g *diskGroup, ioff uint32, err error) {
+// inode returns the file with the given inode number,
+// whether or not the inode is in use.
+//
+// When writing, the FS keeps a single File for each inode,
+// so that changes made through one are seen by all.
+func (fs *FS) inode(inode uint32) (*File, error) {
+	if f := fs.files[inode]; f != nil {
+		return f, nil
+	}
+
+	addr, ivoff, err := fs.inodeAddr(inode)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{fs: fs, inum: inode}
+	buf, err := fs.read(addr, int(ivoff), &file.ino)
+	if err != nil {
+		return nil, err
+	}
+	if fs.inodeSize > diskInodeSize {
+		file.extra = append([]byte(nil), buf[ivoff+diskInodeSize:ivoff+fs.inodeSize]...)
+	}
+
+	if fs.files != nil {
+		fs.files[inode] = file
+	}
+	return file, nil
+}
+
+// inodeAddr returns the location of the inode:
+// the address of the block holding it and its offset in the block.
+func (fs *FS) inodeAddr(inode uint32) (addr int64, voff uint32, err error) {
+	g, ioff, err := fs.igroup(inode)
+	if err != nil {
+		return 0, 0, err
+	}
+	addr = int64(fs.BlockSize) * int64(g.inodeTable()+uint64(ioff/fs.inodesPerBlock))
+	voff = (ioff % fs.inodesPerBlock) * fs.inodeSize
+	return addr, voff, nil
+}
+
+func (fs *FS) igroup(inum uint32) (g *group, ioff uint32, err error) {
 	gnum := (inum - 1) / fs.inodesPerGroup
-	if gnum >= fs.numGroup {
+	if inum == 0 || gnum >= fs.numGroup {
 		return nil, 0, fmt.Errorf("inode number %#x out of range", inum)
 	}
 	ioff = (inum - 1) % fs.inodesPerGroup
@@ -296,20 +583,30 @@ func (fs *FS) igroup(inum uint32) (g *diskGroup, ioff uint32, err error) {
 	return
 }
 
-func (fs *FS) group(gnum uint32) (g *diskGroup, err error) {
+func (fs *FS) group(gnum uint32) (g *group, err error) {
+	if gnum >= fs.numGroup {
+		return nil, fmt.Errorf("block group %d out of range", gnum)
+	}
+
 	// cache to avoid repeated loads from disk
 	if g := fs.g[gnum]; g != nil {
 		return g, nil
 	}
 
-	g = new(diskGroup)
-	addr := int64(fs.BlockSize) * int64(fs.groupAddr+gnum/fs.descPerBlock)
-	voff := gnum % fs.descPerBlock * diskGroupSize
-	if _, err := fs.read(addr, int(voff), g); err != nil {
+	g = new(group)
+	addr := int64(fs.BlockSize) * int64(fs.groupDescBlock(gnum))
+	voff := gnum % fs.descPerBlock * fs.descSize
+	buf, err := fs.read(addr, int(voff), &g.diskGroup)
+	if err != nil {
 		return nil, err
 	}
+	if fs.descSize >= diskGroupSize64 {
+		if err := unpack(buf[voff+diskGroupSize:], &g.hi); err != nil {
+			return nil, err
+		}
+	}
 
-	if g.Inodeaddr < fs.groupAddr+fs.numGroup/fs.descPerBlock {
+	if t := g.inodeTable(); t < uint64(fs.groupAddr) || t >= uint64(fs.NumBlock) {
 		return nil, fmt.Errorf("implausible inode group descriptor at %#x[%d:]: %+v", addr, voff, *g)
 	}
 
@@ -320,8 +617,13 @@ func (fs *FS) group(gnum uint32) (g *diskGroup, err error) {
 // Size returns the file's size in bytes.
 func (f *File) Size() int64 {
 	size := int64(f.ino.Size)
-	if f.ino.Mode&ifmt == ifreg {
+	switch f.ino.Mode & ifmt {
+	case ifreg:
 		size |= int64(f.ino.Diracl) << 32
+	case ifdir:
+		if f.fs.incompat(incompatLargedir) {
+			size |= int64(f.ino.Diracl) << 32
+		}
 	}
 	return size
 }
@@ -329,10 +631,21 @@ func (f *File) Size() int64 {
 // Mode returns the file's mode.
 func (f *File) Mode() os.FileMode {
 	mode := os.FileMode(f.ino.Mode & 0777)
+	if f.ino.Mode&isuid != 0 {
+		mode |= os.ModeSetuid
+	}
+	if f.ino.Mode&isgid != 0 {
+		mode |= os.ModeSetgid
+	}
+	if f.ino.Mode&isvtx != 0 {
+		mode |= os.ModeSticky
+	}
 	switch f.ino.Mode & ifmt {
 	case ififo:
 		mode |= os.ModeNamedPipe
-	default: // ifchr, ifblk, unknown
+	case ifchr:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	default: // ifblk, unknown
 		mode |= os.ModeDevice
 	case ifdir:
 		mode |= os.ModeDir
@@ -350,8 +663,24 @@ func (f *File) IsDir() bool {
 	return f.ino.Mode&ifmt == ifdir
 }
 
+// Uid returns the user ID of the file's owner.
+func (f *File) Uid() int {
+	return int(uint32(f.ino.Uid) | uint32(f.ino.Uidhi)<<16)
+}
+
+// Gid returns the file's group ID.
+func (f *File) Gid() int {
+	return int(uint32(f.ino.Gid) | uint32(f.ino.Gidhi)<<16)
+}
+
 // ModTime returns the file's modification time.
 func (f *File) ModTime() time.Time {
+	if extra, ok := f.extraTime(mtimeExtraOff); ok {
+		// The low two bits of extra extend the signed 32-bit seconds;
+		// the rest count nanoseconds.
+		sec := int64(int32(f.ino.Mtime)) + int64(extra&3)<<32
+		return time.Unix(sec, int64(extra>>2))
+	}
 	return time.Unix(int64(f.ino.Mtime), 0)
 }
 
@@ -365,6 +694,17 @@ func (f *File) ReadAt(buf []byte, off int64) (n int, err error) {
 		n = int(size - off)
 	}
 
+	if f.ino.Flags&inlineDataFl != 0 {
+		data, err := f.inlineData()
+		if err != nil {
+			return 0, err
+		}
+		if int64(len(data)) < off+int64(n) {
+			return 0, fmt.Errorf("inline data shorter than file size")
+		}
+		return copy(buf[:n], data[off:]), nil
+	}
+
 	lfrag := int(uint32(off) % uint32(f.fs.BlockSize))
 	off -= int64(lfrag)
 	want := lfrag + n
@@ -379,11 +719,23 @@ func (f *File) ReadAt(buf []byte, off int64) (n int, err error) {
 		if err != nil {
 			return 0, err
 		}
-		dbuf, err := f.fs.readData(b, 0, nil)
-		if err != nil {
-			return 0, err
+		var m int
+		if b == 0 {
+			// hole
+			m = len(buf)
+			if m > f.fs.BlockSize-lfrag {
+				m = f.fs.BlockSize - lfrag
+			}
+			for j := range buf[:m] {
+				buf[j] = 0
+			}
+		} else {
+			dbuf, err := f.fs.readData(b, 0, nil)
+			if err != nil {
+				return 0, err
+			}
+			m = copy(buf, dbuf[lfrag:])
 		}
-		m := copy(buf, dbuf[lfrag:])
 		buf = buf[m:]
 		lfrag = 0
 	}
@@ -398,7 +750,19 @@ func (f *File) ReadLink() (string, error) {
 
 	size := f.ino.Size
 
-	if f.ino.Nblock != 0 {
+	if f.ino.Flags&inlineDataFl != 0 {
+		data, err := f.inlineData()
+		if err != nil {
+			return "", err
+		}
+		if uint32(len(data)) < size {
+			return "", fmt.Errorf("invalid symlink size")
+		}
+		return string(data[:size]), nil
+	}
+
+	// A fast symlink stores its target in the block pointers.
+	if !f.isFastSymlink() {
 		if size > uint32(f.fs.BlockSize) {
 			return "", fmt.Errorf("invalid symlink size")
 		}
@@ -417,11 +781,18 @@ func (f *File) ReadLink() (string, error) {
 	if size > 4*numBlocks {
 		return "", fmt.Errorf("invalid symlink size")
 	}
-	var buf [4 * numBlocks]byte
+	return string(f.blockBytes()[:size]), nil
+}
+
+// blockBytes returns the inode's block pointers as bytes.
+// Symbolic links, extents, and inline data use the space
+// for other purposes.
+func (f *File) blockBytes() []byte {
+	buf := make([]byte, 4*numBlocks)
 	for i := 0; i < numBlocks; i++ {
 		binary.LittleEndian.PutUint32(buf[4*i:], f.ino.Block[i])
 	}
-	return string(buf[:size]), nil
+	return buf
 }
 
 // A Dir represents a directory entry.
@@ -435,42 +806,58 @@ func (f *File) walkDir(run func(name []byte, ino uint32) bool) error {
 		return fmt.Errorf("file is not a directory")
 	}
 
-	nblock := (int(f.ino.Size) + f.fs.BlockSize - 1) / f.fs.BlockSize
-	for i := 0; i < nblock; i++ {
+	if f.ino.Flags&inlineDataFl != 0 {
+		return f.walkInlineDir(run)
+	}
+
+	nblock := (f.Size() + int64(f.fs.BlockSize) - 1) / int64(f.fs.BlockSize)
+	for i := int64(0); i < nblock; i++ {
 		b, err := f.dblock(uint32(i))
 		if err != nil {
 			return err
 		}
+		if b == 0 {
+			continue
+		}
 		buf, err := f.fs.readData(b, 0, nil)
 		if err != nil {
 			return err
 		}
+		if more, err := walkDirents(buf, run); !more || err != nil {
+			return err
+		}
+	}
 
-		for len(buf) > 0 {
-			var de diskDirent
-			if err := unpack(buf, &de); err != nil {
-				return err
-			}
-			minLen := minDirentSize
-			recLen := int(de.Reclen)
-			nameLen := int(de.Namlen)
-			if minLen+nameLen > recLen || recLen > len(buf) {
-				return fmt.Errorf("corrupt directory entry")
-			}
-			name := buf[minLen : minLen+nameLen]
-			buf = buf[recLen:]
+	return nil
+}
 
-			if de.Ino == 0 {
-				continue
-			}
+// walkDirents calls run for each directory entry in buf,
+// stopping early if run returns false.
+// It reports whether the walk should continue.
+func walkDirents(buf []byte, run func(name []byte, ino uint32) bool) (more bool, err error) {
+	for len(buf) > 0 {
+		var de diskDirent
+		if err := unpack(buf, &de); err != nil {
+			return false, err
+		}
+		minLen := minDirentSize
+		recLen := int(de.Reclen)
+		nameLen := int(de.Namlen)
+		if minLen+nameLen > recLen || recLen > len(buf) {
+			return false, fmt.Errorf("corrupt directory entry")
+		}
+		name := buf[minLen : minLen+nameLen]
+		buf = buf[recLen:]
 
-			if !run(name, de.Ino) {
-				break
-			}
+		if de.Ino == 0 {
+			continue
 		}
-	}
 
-	return nil
+		if !run(name, de.Ino) {
+			return false, nil
+		}
+	}
+	return true, nil
 }
 
 // ReadDir returns all the directory entries in f.
@@ -485,30 +872,49 @@ func (f *File) ReadDir() ([]Dir, error) {
 
 // Lookup looks up the name in the directory f, returning the corresponding child file.
 func (f *File) Lookup(name string) (*File, error) {
+	ino, err := f.lookupInode(name)
+	if err != nil {
+		return nil, err
+	}
+	if ino == 0 {
+		return nil, fmt.Errorf("file not found")
+	}
+	return f.fs.File(ino)
+}
+
+// lookupInode returns the inode number for name in the directory f,
+// or 0 if there is no such entry.
+func (f *File) lookupInode(name string) (uint32, error) {
 	var bino uint32
 	bname := []byte(name)
-	err := f.walkDir(func(name []byte, ino uint32) bool {
+	match := func(name []byte, ino uint32) bool {
 		if bytes.Equal(bname, name) {
 			bino = ino
 			return false
 		}
 		return true
-	})
-	if err != nil {
-		return nil, err
 	}
-	if bino == 0 {
-		return nil, fmt.Errorf("file not found")
+	var err error
+	if f.isIndexed() && name != "." && name != ".." {
+		err = f.htreeLookup(bname, match)
+	} else {
+		err = f.walkDir(match)
 	}
-	return f.fs.File(bino)
+	return bino, err
 }
 
-func (f *File) dblock(block uint32) (uint32, error) {
+// dblock returns the disk block holding the file's given logical block,
+// or 0 if the block is a hole in a sparse file.
+func (f *File) dblock(block uint32) (uint64, error) {
+	if f.ino.Flags&extentsFl != 0 {
+		return f.extentBlock(block)
+	}
+
 	b := block
 
 	// direct?
 	if b < numDirBlocks {
-		return f.ino.Block[b], nil
+		return uint64(f.ino.Block[b]), nil
 	}
 	b -= numDirBlocks
 
@@ -516,10 +922,10 @@ func (f *File) dblock(block uint32) (uint32, error) {
 	p := uint32(f.fs.BlockSize) / 4
 	if b < p {
 		var b1 uint32
-		if _, err := f.fs.readData(f.ino.Block[indBlock], int(4*b), &b1); err != nil {
+		if _, err := f.fs.readIndirect(f.ino.Block[indBlock], int(4*b), &b1); err != nil {
 			return 0, err
 		}
-		return b1, nil
+		return uint64(b1), nil
 	}
 	b -= p
 
@@ -528,13 +934,13 @@ func (f *File) dblock(block uint32) (uint32, error) {
 		i1 := b / p
 		i2 := b % p
 		var b1, b2 uint32
-		if _, err := f.fs.readData(f.ino.Block[ind2Block], int(4*i1), &b1); err != nil {
+		if _, err := f.fs.readIndirect(f.ino.Block[ind2Block], int(4*i1), &b1); err != nil {
 			return 0, err
 		}
-		if _, err := f.fs.readData(b1, int(4*i2), &b2); err != nil {
+		if _, err := f.fs.readIndirect(b1, int(4*i2), &b2); err != nil {
 			return 0, err
 		}
-		return b2, nil
+		return uint64(b2), nil
 	}
 	b -= p * p
 
@@ -544,16 +950,16 @@ func (f *File) dblock(block uint32) (uint32, error) {
 		i2 := b % (p * p) / p
 		i3 := b % p
 		var b1, b2, b3 uint32
-		if _, err := f.fs.readData(f.ino.Block[ind3Block], int(4*i1), &b1); err != nil {
+		if _, err := f.fs.readIndirect(f.ino.Block[ind3Block], int(4*i1), &b1); err != nil {
 			return 0, err
 		}
-		if _, err := f.fs.readData(b1, int(4*i2), &b2); err != nil {
+		if _, err := f.fs.readIndirect(b1, int(4*i2), &b2); err != nil {
 			return 0, err
 		}
-		if _, err := f.fs.readData(b1, int(4*i3), &b3); err != nil {
+		if _, err := f.fs.readIndirect(b2, int(4*i3), &b3); err != nil {
 			return 0, err
 		}
-		return b3, nil
+		return uint64(b3), nil
 	}
 
 	return 0, fmt.Errorf("block number %d out of range", block)
@@ -593,17 +999,13 @@ func (fs *FS) read(off int64, voff int, val interface{}) ([]byte, error) {
 
 	// look in cache
 	fs.cacheAge++
-	if fs.cacheAge%10000 == 0 {
-		fmt.Printf("cache %d/%d\n", fs.cacheHit, fs.cacheAge)
-	}
 
 	var oldest *block
 	for i := range fs.cache {
 		b := &fs.cache[i]
-		if b.off == off {
+		if b.off == off && b.buf != nil {
 			b.lastUse = fs.cacheAge
 			buf = b.buf
-			fs.cacheHit++
 			goto unpack
 		}
 		if oldest == nil || b.lastUse < oldest.lastUse {
@@ -617,7 +1019,7 @@ func (fs *FS) read(off int64, voff int, val interface{}) ([]byte, error) {
 		if len(b.buf) < fs.BlockSize {
 			b.buf = make([]byte, fs.BlockSize)
 		}
-		b.off = 0
+		b.off = -1
 		n, err := fs.r.ReadAt(b.buf, off)
 		if n < fs.BlockSize {
 			if err == nil {
@@ -640,25 +1042,41 @@ unpack:
 	return buf, nil
 }
 
-func (fs *FS) readData(block uint32, voff int, val *uint32) ([]byte, error) {
+// readIndirect is like readData but reads an indirect block,
+// treating block 0 as a hole whose pointers are all 0.
+func (fs *FS) readIndirect(block uint32, voff int, val *uint32) ([]byte, error) {
+	if block == 0 {
+		*val = 0
+		return nil, nil
+	}
+	return fs.readData(uint64(block), voff, val)
+}
+
+func (fs *FS) readData(block uint64, voff int, val *uint32) ([]byte, error) {
 	b := block
 
-	if b < fs.firstBlock {
+	if b < uint64(fs.firstBlock) || b >= uint64(fs.NumBlock) {
 		return nil, fmt.Errorf("block number %d out of range", block)
 	}
-	b -= fs.firstBlock
+	b -= uint64(fs.firstBlock)
 
-	g, err := fs.group(b / fs.blocksPerGroup)
+	g, err := fs.group(uint32(b / uint64(fs.blocksPerGroup)))
 	if err != nil {
 		return nil, err
 	}
 
-	buf, err := fs.read(int64(g.Bitblock)*int64(fs.BlockSize), 0, nil)
+	// With group descriptor checksums, an uninitialized
+	// block bitmap means the group has no data blocks.
+	if g.Flags&bgBlockUninit != 0 && fs.rocompat(rocompatGdtCsum|rocompatMetadataCsum) {
+		return nil, fmt.Errorf("block %d not allocated", block)
+	}
+
+	buf, err := fs.read(int64(g.blockBitmap())*int64(fs.BlockSize), 0, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	boff := b % fs.blocksPerGroup
+	boff := uint32(b % uint64(fs.blocksPerGroup))
 	if buf[boff>>3]&(1<<(boff&7)) == 0 {
 		return nil, fmt.Errorf("block %d not allocated", block)
 	}
@@ -679,43 +1097,68 @@ func unpack(data []byte, val interface{}) error {
 	if v.Kind() != reflect.Struct || !v.CanSet() {
 		return fmt.Errorf("must unpack into ptr to struct")
 	}
+	_, err := unpackValue(data, v)
+	return err
+}
 
-	for i := 0; i < v.NumField(); i++ {
-		switch p := v.Field(i).Addr().Interface().(type) {
-		case *uint8:
-			if len(data) < 1 {
-				return fmt.Errorf("buffer smaller than data structure")
-			}
-			*p = data[0]
-			data = data[1:]
-
-		case *uint16:
-			if len(data) < 2 {
-				return fmt.Errorf("buffer smaller than data structure")
+// unpackValue sets v, which must hold fixed-size unsigned integers,
+// from the little-endian data, returning the remaining data.
+func unpackValue(data []byte, v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			var err error
+			if data, err = unpackValue(data, v.Field(i)); err != nil {
+				return nil, err
 			}
-			*p = binary.LittleEndian.Uint16(data)
-			data = data[2:]
+		}
+		return data, nil
 
-		case *uint32:
-			if len(data) < 4 {
-				return fmt.Errorf("buffer smaller than data structure")
-			}
-			*p = binary.LittleEndian.Uint32(data)
-			data = data[4:]
-
-		case *[numBlocks]uint32:
-			for i := 0; i < numBlocks; i++ {
-				if len(data) < 4 {
-					return fmt.Errorf("buffer smaller than data structure")
-				}
-				p[i] = binary.LittleEndian.Uint32(data)
-				data = data[4:]
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			var err error
+			if data, err = unpackValue(data, v.Index(i)); err != nil {
+				return nil, err
 			}
+		}
+		return data, nil
 
-		default:
-			return fmt.Errorf("unexpected field type %T", p)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n := int(v.Type().Size())
+		if len(data) < n {
+			return nil, fmt.Errorf("buffer smaller than data structure")
+		}
+		var x uint64
+		for i := n - 1; i >= 0; i-- {
+			x = x<<8 | uint64(data[i])
 		}
+		v.SetUint(x)
+		return data[n:], nil
 	}
+	return nil, fmt.Errorf("unexpected field type %s", v.Type())
+}
 
-	return nil
+// pack returns the little-endian encoding of the struct *val,
+// the inverse of unpack.
+func pack(val interface{}) []byte {
+	return packValue(nil, reflect.ValueOf(val).Elem())
+}
+
+func packValue(data []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			data = packValue(data, v.Field(i))
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			data = packValue(data, v.Index(i))
+		}
+	default:
+		x := v.Uint()
+		for i := 0; i < int(v.Type().Size()); i++ {
+			data = append(data, byte(x>>(8*uint(i))))
+		}
+	}
+	return data
 }
@@ -0,0 +1,271 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The test images were made by mke2fs -d from the same tree:
+//
+//	ext2.img: mke2fs -t ext2 -b 1024 (indirect blocks, 1 KB blocks)
+//	ext4.img: mkfs.ext4 -b 4096 -O inline_data,^has_journal
+//	meta.img: mke2fs -t ext4 -b 1024 -g 1024 -O meta_bg,^resize_inode,64bit,^has_journal
+//
+// followed by e2fsck -fD to index the directories.
+var images = []string{"ext2", "ext4", "meta"}
+
+func readImage(t *testing.T, name string) []byte {
+	f, err := os.Open("testdata/" + name + ".img.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func openImage(t *testing.T, name string) *FS {
+	fs, err := Init(bytes.NewReader(readImage(t, name)))
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return fs
+}
+
+func lookup(t *testing.T, fs *FS, path string) *File {
+	f, err := fs.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, elem := range strings.Split(path, "/") {
+		if f, err = f.Lookup(elem); err != nil {
+			t.Fatalf("lookup %s: %v", path, err)
+		}
+	}
+	return f
+}
+
+func readFile(t *testing.T, f *File) []byte {
+	r, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func dataLines() string {
+	var buf bytes.Buffer
+	for i := 0; i < 8000; i++ {
+		fmt.Fprintf(&buf, "line %d\n", i)
+	}
+	return buf.String()
+}
+
+func TestRead(t *testing.T) {
+	for _, name := range images {
+		fs := openImage(t, name)
+
+		files := []struct {
+			path string
+			data string
+		}{
+			{"hello.txt", "hello, world\n"},
+			{"medium.txt", strings.Repeat("0123456789", 10) + "\n"},
+			{"data.bin", dataLines()},
+			{"dir/sub/x", "hi\n"},
+			{"idir/c3", "c3\n"},
+			{"big/name-7-0000000", "file 7\n"},
+		}
+		for _, file := range files {
+			if data := readFile(t, lookup(t, fs, file.path)); string(data) != file.data {
+				t.Errorf("%s: %s: read %d bytes %.20q, want %d bytes %.20q", name, file.path, len(data), data, len(file.data), file.data)
+			}
+		}
+
+		hello := lookup(t, fs, "hello.txt")
+		want := time.Date(2020, 1, 2, 3, 4, 5, 123456789, time.UTC)
+		if mt := hello.ModTime(); !mt.Equal(want) {
+			t.Errorf("%s: hello.txt ModTime = %v, want %v", name, mt.UTC(), want)
+		}
+
+		var holes bytes.Buffer
+		for i := 0; i < 10; i++ {
+			holes.Write(make([]byte, i<<20-holes.Len()))
+			holes.WriteString(strings.Repeat(fmt.Sprintf("segment %d\n", i), 400))
+		}
+		if data := readFile(t, lookup(t, fs, "holes")); !bytes.Equal(data, holes.Bytes()) {
+			t.Errorf("%s: holes: wrong data", name)
+		}
+
+		sparse := lookup(t, fs, "sparse")
+		if sparse.Size() != 70<<20+4 {
+			t.Errorf("%s: sparse: Size = %d, want %d", name, sparse.Size(), 70<<20+4)
+		}
+		buf := make([]byte, 8)
+		for _, off := range []int64{0, 70<<20 - 4, 35 << 20} {
+			if _, err := sparse.ReadAt(buf, off); err != nil {
+				t.Fatalf("%s: sparse: ReadAt(%d): %v", name, off, err)
+			}
+			want := map[int64]string{0: "head\x00\x00\x00\x00", 70<<20 - 4: "\x00\x00\x00\x00tail"}[off]
+			if want == "" {
+				want = "\x00\x00\x00\x00\x00\x00\x00\x00"
+			}
+			if string(buf) != want {
+				t.Errorf("%s: sparse: ReadAt(%d) = %q, want %q", name, off, buf, want)
+			}
+		}
+
+		links := []struct {
+			path   string
+			target string
+		}{
+			{"fast", "hello.txt"},
+			{"slow", strings.Repeat("x", 100) + "/target"},
+		}
+		for _, link := range links {
+			target, err := lookup(t, fs, link.path).ReadLink()
+			if err != nil || target != link.target {
+				t.Errorf("%s: ReadLink(%s) = %q, %v, want %q", name, link.path, target, err, link.target)
+			}
+		}
+
+		big := lookup(t, fs, "big")
+		if !big.isIndexed() {
+			t.Errorf("%s: big is not indexed", name)
+		}
+		dirs, err := big.ReadDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dirs) != 402 {
+			t.Errorf("%s: big: ReadDir returned %d entries, want 402", name, len(dirs))
+		}
+		for _, d := range dirs {
+			f, err := big.Lookup(d.Name)
+			if err != nil {
+				t.Errorf("%s: big: Lookup(%s): %v", name, d.Name, err)
+				continue
+			}
+			if f.inum != d.Inode {
+				t.Errorf("%s: big: Lookup(%s) = inode %d, want %d", name, d.Name, f.inum, d.Inode)
+			}
+		}
+		if _, err := big.Lookup("name-7"); err == nil {
+			t.Errorf("%s: big: Lookup of missing name succeeded", name)
+		}
+
+		idir := lookup(t, fs, "idir")
+		dirs, err = idir.ReadDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, d := range dirs {
+			names = append(names, d.Name)
+		}
+		if got := strings.Join(names, " "); got != ". .. a1 b2 c3 d4" {
+			t.Errorf("%s: idir: ReadDir = %s", name, got)
+		}
+		if parent := lookup(t, fs, "idir/..").inum; parent != rootInode {
+			t.Errorf("%s: idir/.. = inode %d, want %d", name, parent, rootInode)
+		}
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	fs := openImage(t, "ext4")
+	for _, flag := range []uint32{incompatExtents, incompat64bit, incompatFlexBg, incompatInlineData} {
+		if !fs.incompat(flag) {
+			t.Errorf("ext4 missing %s", featureNames(flag, incompatNames))
+		}
+	}
+	if lookup(t, fs, "hello.txt").ino.Flags&inlineDataFl == 0 {
+		t.Errorf("ext4: hello.txt not inline")
+	}
+	if lookup(t, fs, "holes").ino.Flags&extentsFl == 0 {
+		t.Errorf("ext4: holes does not use extents")
+	}
+
+	fs = openImage(t, "meta")
+	if !fs.incompat(incompatMetaBg) || fs.numGroup <= fs.descPerBlock*(fs.firstMetaBg+1) {
+		t.Errorf("meta: no groups past first meta_bg")
+	}
+	for gnum := uint32(0); gnum < fs.numGroup; gnum++ {
+		if _, err := fs.group(gnum); err != nil {
+			t.Errorf("meta: %v", err)
+		}
+	}
+
+	data := readImage(t, "ext4")
+	const incompatOff = superOff + 0x60
+	data[incompatOff+2] |= 0x03 // encrypt, casefold
+	data[incompatOff+3] |= 0x80
+	_, err := Init(bytes.NewReader(data))
+	want := "unsupported incompatible features: encrypt, casefold, 0x80000000"
+	if err == nil || err.Error() != want {
+		t.Errorf("Init with unknown features: %v, want %s", err, want)
+	}
+}
+
+var hashTests = []struct {
+	name    string
+	version int
+	seed    bool
+	hash    uint32
+}{
+	{"a", dxHashLegacy, false, 0xe74b53e2},
+	{"hello.txt", dxHashLegacy, false, 0x65a05776},
+	{"é-ünïcode", dxHashLegacy, false, 0xf0c098f6},
+	{"é-ünïcode", dxHashLegacyUnsigned, false, 0xabdfdf84},
+	{strings.Repeat("long-name-", 6), dxHashLegacy, false, 0x38b6116},
+	{"a", dxHashHalfMD4, false, 0xd5fa7d7a},
+	{"a", dxHashHalfMD4, true, 0x35dc0cc4},
+	{"hello.txt", dxHashHalfMD4, false, 0xa26e1d86},
+	{"hello.txt", dxHashHalfMD4, true, 0x42a85304},
+	{"é-ünïcode", dxHashHalfMD4, false, 0x5b3588ce},
+	{"é-ünïcode", dxHashHalfMD4Unsigned, false, 0xf6779d44},
+	{"é-ünïcode", dxHashHalfMD4Unsigned, true, 0x4709c540},
+	{strings.Repeat("long-name-", 6), dxHashHalfMD4, false, 0xf538988a},
+	{strings.Repeat("long-name-", 6), dxHashHalfMD4, true, 0xab9f423c},
+	{"a", dxHashTea, false, 0x6d0ea4c0},
+	{"hello.txt", dxHashTea, false, 0x5107c3f2},
+	{"é-ünïcode", dxHashTea, false, 0x914990a0},
+	{"é-ünïcode", dxHashTeaUnsigned, false, 0x426bc1aa},
+	{strings.Repeat("long-name-", 6), dxHashTea, false, 0x60f58002},
+}
+
+func TestDirHash(t *testing.T) {
+	// Hashes computed by debugfs dx_hash, using the seed
+	// 01234567-89ab-cdef-0123-456789abcdef when seed is true.
+	for _, tt := range hashTests {
+		var seed [4]uint32
+		if tt.seed {
+			seed = [4]uint32{0x67452301, 0xefcdab89, 0x67452301, 0xefcdab89}
+		}
+		hash, err := dirHash([]byte(tt.name), tt.version, seed)
+		if err != nil || hash != tt.hash&^1 {
+			t.Errorf("dirHash(%q, %d, %v) = %#x, %v, want %#x", tt.name, tt.version, tt.seed, hash, err, tt.hash&^1)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext2
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// A hash-indexed (htree) directory is an ordinary directory whose
+// first block also holds the root of a tree of index blocks, keyed by a
+// hash of the entry names.  The index blocks are hidden inside directory
+// entries that appear empty, so the directory can still be read linearly.
+// Lookup uses the index to find the leaf block holding a name.
+
+const (
+	dxRootInfoOff = 24 // after the . and .. entries
+	dxNodeOff     = 8  // after an empty entry spanning the block
+	dxEntrySize   = 8
+	dxBlockMask   = 0x0fffffff
+)
+
+// Directory hash versions.
+const (
+	dxHashLegacy = iota
+	dxHashHalfMD4
+	dxHashTea
+	dxHashLegacyUnsigned
+	dxHashHalfMD4Unsigned
+	dxHashTeaUnsigned
+)
+
+type dxRootInfo struct {
+	Reservedzero   uint32
+	Hashversion    uint8
+	Infolength     uint8 /* 8 */
+	Indirectlevels uint8
+	Unusedflags    uint8
+}
+
+type dxCountLimit struct {
+	Limit uint16
+	Count uint16
+}
+
+// isIndexed reports whether f is a hash-indexed directory.
+func (f *File) isIndexed() bool {
+	return f.ino.Mode&ifmt == ifdir &&
+		f.ino.Flags&(indexFl|inlineDataFl) == indexFl &&
+		f.fs.compat(compatDirIndex)
+}
+
+// A dxFrame is a position in an index block during an htree lookup.
+type dxFrame struct {
+	entries []byte // index entries
+	i       int    // current entry
+}
+
+func (fr *dxFrame) count() int {
+	return len(fr.entries) / dxEntrySize
+}
+
+func (fr *dxFrame) hash(i int) uint32 {
+	if i == 0 {
+		return 0 // first entry holds count and limit instead
+	}
+	return binary.LittleEndian.Uint32(fr.entries[dxEntrySize*i:])
+}
+
+func (fr *dxFrame) block() uint32 {
+	return binary.LittleEndian.Uint32(fr.entries[dxEntrySize*fr.i+4:]) & dxBlockMask
+}
+
+// htreeLookup calls run for the entries in the leaf blocks of the
+// hash-indexed directory f that might contain name, stopping early
+// if run returns false.
+func (f *File) htreeLookup(name []byte, run func(name []byte, ino uint32) bool) error {
+	root, err := f.dirBlock(0)
+	if err != nil {
+		return err
+	}
+	var info dxRootInfo
+	if err := unpack(root[dxRootInfoOff:], &info); err != nil {
+		return err
+	}
+	maxLevels := 2
+	if f.fs.incompat(incompatLargedir) {
+		maxLevels = 3
+	}
+	if info.Reservedzero != 0 || info.Infolength != 8 || int(info.Indirectlevels) >= maxLevels {
+		return fmt.Errorf("corrupt directory index in inode %d", f.inum)
+	}
+	version := int(info.Hashversion)
+	if version <= dxHashTea && f.fs.super.Flags&superFlagUnsigned != 0 {
+		version += dxHashLegacyUnsigned
+	}
+	hash, err := dirHash(name, version, f.fs.super.Hashseed)
+	if err != nil {
+		return err
+	}
+
+	// Walk down to the leaf, recording the path in frames.
+	frames := make([]dxFrame, int(info.Indirectlevels)+1)
+	buf, off := root, dxRootInfoOff+int(info.Infolength)
+	for level := range frames {
+		if level > 0 {
+			if buf, err = f.dirBlock(frames[level-1].block()); err != nil {
+				return err
+			}
+			off = dxNodeOff
+		}
+		fr := &frames[level]
+		if err := fr.load(buf[off:]); err != nil {
+			return fmt.Errorf("corrupt directory index in inode %d: %v", f.inum, err)
+		}
+		fr.i = fr.count() - 1
+		for fr.i > 0 && fr.hash(fr.i) > hash {
+			fr.i--
+		}
+	}
+
+	for {
+		leaf, err := f.dirBlock(frames[len(frames)-1].block())
+		if err != nil {
+			return err
+		}
+		if more, err := walkDirents(leaf, run); !more || err != nil {
+			return err
+		}
+
+		// Entries with colliding hashes may continue in the next leaf,
+		// which is marked by setting the low bit of its hash.
+		level := len(frames) - 1
+		for level >= 0 && frames[level].i+1 >= frames[level].count() {
+			level--
+		}
+		if level < 0 {
+			return nil
+		}
+		frames[level].i++
+		if frames[level].hash(frames[level].i)&^1 != hash {
+			return nil
+		}
+		for level++; level < len(frames); level++ {
+			buf, err := f.dirBlock(frames[level-1].block())
+			if err != nil {
+				return err
+			}
+			if err := frames[level].load(buf[dxNodeOff:]); err != nil {
+				return fmt.Errorf("corrupt directory index in inode %d: %v", f.inum, err)
+			}
+		}
+	}
+}
+
+// load sets fr to the first entry of the index entries in buf.
+func (fr *dxFrame) load(buf []byte) error {
+	var cl dxCountLimit
+	if err := unpack(buf, &cl); err != nil {
+		return err
+	}
+	if cl.Count == 0 || cl.Count > cl.Limit || dxEntrySize*int(cl.Limit) > len(buf) {
+		return fmt.Errorf("bad count %d, limit %d", cl.Count, cl.Limit)
+	}
+	fr.entries = buf[:dxEntrySize*int(cl.Count)]
+	fr.i = 0
+	return nil
+}
+
+// dirBlock returns the directory's given logical block.
+func (f *File) dirBlock(block uint32) ([]byte, error) {
+	b, err := f.dblock(block)
+	if err != nil {
+		return nil, err
+	}
+	if b == 0 {
+		return nil, fmt.Errorf("missing block %d in directory inode %d", block, f.inum)
+	}
+	return f.fs.readData(b, 0, nil)
+}
+
+// dirHash returns the hash of name used to index directories.
+func dirHash(name []byte, version int, seed [4]uint32) (uint32, error) {
+	buf := [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+	if seed != [4]uint32{} {
+		buf = seed
+	}
+	signed := version <= dxHashTea
+
+	var hash uint32
+	switch version {
+	case dxHashLegacy, dxHashLegacyUnsigned:
+		hash = dxHackHash(name, signed)
+
+	case dxHashHalfMD4, dxHashHalfMD4Unsigned:
+		var in [8]uint32
+		for p := name; ; p = p[32:] {
+			str2hashbuf(p, in[:], signed)
+			halfMD4Transform(&buf, &in)
+			if len(p) <= 32 {
+				break
+			}
+		}
+		hash = buf[1]
+
+	case dxHashTea, dxHashTeaUnsigned:
+		var in [4]uint32
+		for p := name; ; p = p[16:] {
+			str2hashbuf(p, in[:], signed)
+			teaTransform(&buf, &in)
+			if len(p) <= 16 {
+				break
+			}
+		}
+		hash = buf[0]
+
+	default:
+		return 0, fmt.Errorf("unsupported directory hash version %d", version)
+	}
+
+	hash &^= 1
+	if hash == 0x7fffffff<<1 {
+		hash = 0x7ffffffe << 1
+	}
+	return hash, nil
+}
+
+// char returns c as a signed or unsigned char widened to 32 bits.
+func char(c byte, signed bool) uint32 {
+	if signed {
+		return uint32(int32(int8(c)))
+	}
+	return uint32(c)
+}
+
+func dxHackHash(name []byte, signed bool) uint32 {
+	hash0, hash1 := uint32(0x12a3fe2d), uint32(0x37abe8f9)
+	for _, c := range name {
+		hash := hash1 + (hash0 ^ char(c, signed)*7152373)
+		if hash&0x80000000 != 0 {
+			hash -= 0x7fffffff
+		}
+		hash1, hash0 = hash0, hash
+	}
+	return hash0 << 1
+}
+
+// str2hashbuf fills buf with the next bytes of msg, padded with its length.
+func str2hashbuf(msg []byte, buf []uint32, signed bool) {
+	pad := uint32(len(msg)) | uint32(len(msg))<<8
+	pad |= pad << 16
+
+	if len(msg) > 4*len(buf) {
+		msg = msg[:4*len(buf)]
+	}
+	val := pad
+	n := 0
+	for i, c := range msg {
+		val = char(c, signed) + val<<8
+		if i%4 == 3 {
+			buf[n] = val
+			n++
+			val = pad
+		}
+	}
+	if n < len(buf) {
+		buf[n] = val
+		n++
+	}
+	for ; n < len(buf); n++ {
+		buf[n] = pad
+	}
+}
+
+func rol32(x uint32, s uint) uint32 {
+	return x<<s | x>>(32-s)
+}
+
+func halfMD4Transform(buf *[4]uint32, in *[8]uint32) {
+	const (
+		k2 = 013240474631
+		k3 = 015666365641
+	)
+	f := func(x, y, z uint32) uint32 { return z ^ (x & (y ^ z)) }
+	g := func(x, y, z uint32) uint32 { return (x & y) + ((x ^ y) & z) }
+	h := func(x, y, z uint32) uint32 { return x ^ y ^ z }
+
+	a, b, c, d := buf[0], buf[1], buf[2], buf[3]
+
+	// Round 1
+	a = rol32(a+f(b, c, d)+in[0], 3)
+	d = rol32(d+f(a, b, c)+in[1], 7)
+	c = rol32(c+f(d, a, b)+in[2], 11)
+	b = rol32(b+f(c, d, a)+in[3], 19)
+	a = rol32(a+f(b, c, d)+in[4], 3)
+	d = rol32(d+f(a, b, c)+in[5], 7)
+	c = rol32(c+f(d, a, b)+in[6], 11)
+	b = rol32(b+f(c, d, a)+in[7], 19)
+
+	// Round 2
+	a = rol32(a+g(b, c, d)+in[1]+k2, 3)
+	d = rol32(d+g(a, b, c)+in[3]+k2, 5)
+	c = rol32(c+g(d, a, b)+in[5]+k2, 9)
+	b = rol32(b+g(c, d, a)+in[7]+k2, 13)
+	a = rol32(a+g(b, c, d)+in[0]+k2, 3)
+	d = rol32(d+g(a, b, c)+in[2]+k2, 5)
+	c = rol32(c+g(d, a, b)+in[4]+k2, 9)
+	b = rol32(b+g(c, d, a)+in[6]+k2, 13)
+
+	// Round 3
+	a = rol32(a+h(b, c, d)+in[3]+k3, 3)
+	d = rol32(d+h(a, b, c)+in[7]+k3, 9)
+	c = rol32(c+h(d, a, b)+in[2]+k3, 11)
+	b = rol32(b+h(c, d, a)+in[6]+k3, 15)
+	a = rol32(a+h(b, c, d)+in[1]+k3, 3)
+	d = rol32(d+h(a, b, c)+in[5]+k3, 9)
+	c = rol32(c+h(d, a, b)+in[0]+k3, 11)
+	b = rol32(b+h(c, d, a)+in[4]+k3, 15)
+
+	buf[0] += a
+	buf[1] += b
+	buf[2] += c
+	buf[3] += d
+}
+
+func teaTransform(buf *[4]uint32, in *[4]uint32) {
+	const delta = 0x9E3779B9
+	var sum uint32
+	b0, b1 := buf[0], buf[1]
+	a, b, c, d := in[0], in[1], in[2], in[3]
+	for n := 0; n < 16; n++ {
+		sum += delta
+		b0 += ((b1 << 4) + a) ^ (b1 + sum) ^ ((b1 >> 5) + b)
+		b1 += ((b0 << 4) + c) ^ (b0 + sum) ^ ((b0 >> 5) + d)
+	}
+	buf[0] += b0
+	buf[1] += b1
+}
@@ -0,0 +1,120 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext2
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Inodes larger than diskInodeSize hold extra fields,
+// starting with their own size, followed by in-inode
+// extended attributes.
+
+const (
+	ctimeExtraOff = 4 // offsets of i_ctime_extra etc. in extra fields
+	mtimeExtraOff = 8
+	atimeExtraOff = 12
+	crtimeOff     = 16 // creation time, followed by its extra field
+	extraIsize    = 32 // size of extra fields written in new inodes
+
+	xattrMagic       = 0xEA020000
+	xattrRefcountOff = 4 // offset of reference count in an attribute block
+	xattrEntrySize   = 16
+	xattrIndexSys    = 7 // "system." prefix
+	inlineDataXattr  = "data"
+	inlineDirHeader  = 4 // parent inode number at start of inline directory
+)
+
+type xattrEntry struct {
+	Namelen   uint8  /* length of name */
+	Nameindex uint8  /* attribute name index */
+	Valueoffs uint16 /* offset in disk block of value */
+	Valueinum uint32 /* inode in which the value is stored */
+	Valuesize uint32 /* size of attribute value */
+	Hash      uint32 /* hash value of name and value */
+}
+
+// extraSize returns the size of the inode's extra fields.
+func (f *File) extraSize() int {
+	if len(f.extra) < 2 {
+		return 0
+	}
+	n := int(binary.LittleEndian.Uint16(f.extra))
+	if n > len(f.extra) {
+		n = len(f.extra)
+	}
+	return n
+}
+
+// extraTime returns the extra time field at offset off
+// in the extra fields, if the inode has it.
+func (f *File) extraTime(off int) (uint32, bool) {
+	if f.extraSize() < off+4 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(f.extra[off:]), true
+}
+
+// xattr returns the value of the extended attribute with the given
+// name index and name stored in the inode, or nil if there is none.
+func (f *File) xattr(index uint8, name string) ([]byte, error) {
+	buf := f.extra[f.extraSize():]
+	if len(buf) < 4 || binary.LittleEndian.Uint32(buf) != xattrMagic {
+		return nil, nil
+	}
+	buf = buf[4:]
+	for p := buf; len(p) >= 4 && binary.LittleEndian.Uint32(p) != 0; {
+		var e xattrEntry
+		if err := unpack(p, &e); err != nil {
+			return nil, err
+		}
+		n := (xattrEntrySize + int(e.Namelen) + 3) &^ 3
+		if n > len(p) {
+			return nil, fmt.Errorf("corrupt extended attribute in inode %d", f.inum)
+		}
+		if e.Nameindex == index && string(p[xattrEntrySize:xattrEntrySize+int(e.Namelen)]) == name {
+			if e.Valueinum != 0 {
+				return nil, fmt.Errorf("extended attribute stored in inode %d not supported", e.Valueinum)
+			}
+			if int(e.Valueoffs)+int(e.Valuesize) > len(buf) {
+				return nil, fmt.Errorf("corrupt extended attribute in inode %d", f.inum)
+			}
+			return buf[e.Valueoffs : int(e.Valueoffs)+int(e.Valuesize)], nil
+		}
+		p = p[n:]
+	}
+	return nil, nil
+}
+
+// inlineData returns the content of a file stored in its inode:
+// the block pointers followed by the system.data extended attribute.
+func (f *File) inlineData() ([]byte, error) {
+	more, err := f.xattr(xattrIndexSys, inlineDataXattr)
+	if err != nil {
+		return nil, err
+	}
+	return append(f.blockBytes(), more...), nil
+}
+
+// walkInlineDir is walkDir for a directory stored in its inode.
+// The directory holds the parent's inode number and then entries,
+// but no entries for . and .., which walkInlineDir supplies.
+func (f *File) walkInlineDir(run func(name []byte, ino uint32) bool) error {
+	buf := f.blockBytes()
+	if !run([]byte("."), f.inum) || !run([]byte(".."), binary.LittleEndian.Uint32(buf)) {
+		return nil
+	}
+	more, err := walkDirents(buf[inlineDirHeader:], run)
+	if !more || err != nil {
+		return err
+	}
+	data, err := f.xattr(xattrIndexSys, inlineDataXattr)
+	if err != nil {
+		return err
+	}
+	_, err = walkDirents(data, run)
+	return err
+}
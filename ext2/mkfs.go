@@ -0,0 +1,512 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext2
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+)
+
+// MkfsOptions holds the settings for a new file system.
+// Zero fields take default values.
+type MkfsOptions struct {
+	BlockSize     int       // 1024, 2048, or 4096; default 1024, or 4096 from 512 MB
+	InodeSize     int       // default 256
+	BytesPerInode int       // space per inode; default 4096, or 16384 from 512 MB
+	Inodes        int       // number of inodes; overrides BytesPerInode
+	Label         string    // volume label, at most 16 bytes
+	UUID          [16]byte  // default derived from the other settings
+	Time          time.Time // creation time and time of changes; default now
+}
+
+const (
+	lostFoundSize = 16 << 10 // initial size of lost+found, as in mke2fs
+	minDataBlocks = 50       // minimum data blocks in the last group
+	maxPerGroup   = 65528    // maximum blocks or inodes per group, for 16-bit counts
+)
+
+// Mkfs creates a new, empty EXT2 file system in the first size bytes
+// of rw and returns it opened for writing, as by InitWrite.
+// The file system holds only the root directory and lost+found.
+//
+// Mkfs writes only the metadata, so unused blocks keep their old
+// content: for a reproducible image, rw should start zeroed,
+// as a newly created file is.  The FS records the MkfsOptions Time
+// as the time of every change made through it, so that, with a fixed
+// Time, the same sequence of changes produces the same image.
+func Mkfs(rw ReadWriterAt, size int64, opt *MkfsOptions) (*FS, error) {
+	var o MkfsOptions
+	if opt != nil {
+		o = *opt
+	}
+	if o.BlockSize == 0 {
+		o.BlockSize = 1024
+		if size >= 512<<20 {
+			o.BlockSize = 4096
+		}
+	}
+	if o.BlockSize < minBlockSize || o.BlockSize > maxBlockSize || o.BlockSize&(o.BlockSize-1) != 0 {
+		return nil, fmt.Errorf("invalid block size %d", o.BlockSize)
+	}
+	if o.InodeSize == 0 {
+		o.InodeSize = 256
+	}
+	if o.InodeSize < diskInodeSize || o.InodeSize > o.BlockSize || o.InodeSize&(o.InodeSize-1) != 0 {
+		return nil, fmt.Errorf("invalid inode size %d", o.InodeSize)
+	}
+	if o.BytesPerInode == 0 {
+		o.BytesPerInode = 4096
+		if size >= 512<<20 {
+			o.BytesPerInode = 16384
+		}
+	}
+	if o.BytesPerInode < o.BlockSize {
+		return nil, fmt.Errorf("invalid bytes per inode %d", o.BytesPerInode)
+	}
+	if len(o.Label) > 16 {
+		return nil, fmt.Errorf("label %q longer than 16 bytes", o.Label)
+	}
+	if o.Time.IsZero() {
+		o.Time = time.Now()
+	}
+	if o.UUID == [16]byte{} {
+		h := sha256.New()
+		fmt.Fprintf(h, "%d %d %d %d %d %q %d", size, o.BlockSize, o.InodeSize, o.BytesPerInode, o.Inodes, o.Label, o.Time.UnixNano())
+		copy(o.UUID[:], h.Sum(nil))
+		o.UUID[6] = o.UUID[6]&0x0f | 0x40 // version 4
+		o.UUID[8] = o.UUID[8]&0x3f | 0x80 // variant
+	}
+
+	l, err := planLayout(size, &o)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.write(rw); err != nil {
+		return nil, err
+	}
+
+	fs, err := InitWrite(rw)
+	if err != nil {
+		return nil, err
+	}
+	now := o.Time
+	fs.now = func() time.Time { return now }
+
+	// The root directory is a reserved inode, already marked in use.
+	g, err := fs.group(0)
+	if err != nil {
+		return nil, err
+	}
+	g.addUsedDirs(+1)
+	root := fs.initFile(rootInode, ifdir|0755)
+	if err := root.initDir(rootInode); err != nil {
+		return nil, err
+	}
+
+	lf, err := root.Mkdir("lost+found", 0700)
+	if err != nil {
+		return nil, err
+	}
+	// Give lost+found room, so that e2fsck need not
+	// allocate blocks when reconnecting files.
+	empty := make([]byte, fs.BlockSize)
+	putDirent(empty, 0, fs.BlockSize, "", 0)
+	nblock := uint32(lostFoundSize / fs.BlockSize)
+	for i := uint32(1); i < nblock; i++ {
+		b, _, err := lf.bmap(i)
+		if err != nil {
+			return nil, err
+		}
+		if err := fs.writeBlock(b, empty); err != nil {
+			return nil, err
+		}
+	}
+	lf.setSize(int64(nblock) * int64(fs.BlockSize))
+	if err := fs.writeInode(lf); err != nil {
+		return nil, err
+	}
+
+	if err := fs.Sync(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// An mkfsLayout is the layout of a new file system.
+type mkfsLayout struct {
+	fs        *FS // superblock and geometry only
+	inodeBlks uint32
+	ndesc     uint32 // group descriptor blocks
+	groups    []diskGroup
+}
+
+// planLayout computes the layout of a file system of size bytes.
+func planLayout(size int64, o *MkfsOptions) (*mkfsLayout, error) {
+	bs := uint32(o.BlockSize)
+	nblock := size / int64(bs)
+	if nblock >= 1<<32 {
+		return nil, fmt.Errorf("file system too large for %d-byte blocks", bs)
+	}
+	var first uint32
+	if bs == minBlockSize {
+		first = 1
+	}
+	bpg := 8 * bs
+	if bpg > maxPerGroup {
+		bpg = maxPerGroup
+	}
+	ipb := bs / uint32(o.InodeSize)
+	ninode := int64(o.Inodes)
+	if ninode == 0 {
+		ninode = size / int64(o.BytesPerInode)
+	}
+
+	fs := &FS{BlockSize: int(bs), inodeSize: uint32(o.InodeSize), inodesPerBlock: ipb, descSize: diskGroupSize, firstMetaBg: ^uint32(0)}
+	fs.super = diskSuper{
+		Magic:           superMagic,
+		State:           validFS,
+		Errors:          1, // continue
+		Revlevel:        1,
+		Firstdatablock:  first,
+		Blockspergroup:  bpg,
+		Fragpergroup:    bpg,
+		Maxmntcount:     0xffff,
+		Firstino:        firstInode,
+		Inosize:         uint16(o.InodeSize),
+		Featurecompat:   compatDirIndex,
+		Featureincompat: incompatFiletype,
+		Featurerocompat: rocompatSparseSuper | rocompatLargeFile,
+		Uuid:            o.UUID,
+		Defhashversion:  dxHashHalfMD4,
+		Flags:           superFlagSigned,
+	}
+	for b := bs; b > minBlockSize; b >>= 1 {
+		fs.super.Logblocksize++
+	}
+	fs.super.Logfragsize = fs.super.Logblocksize
+	copy(fs.super.Volumename[:], o.Label)
+	seed := sha256.Sum256(o.UUID[:])
+	for i := range fs.super.Hashseed {
+		fs.super.Hashseed[i] = binary.LittleEndian.Uint32(seed[4*i:])
+	}
+	if o.InodeSize > diskInodeSize {
+		fs.super.Minextraisize = extraIsize
+		fs.super.Wantextraisize = extraIsize
+	}
+	t := uint32(o.Time.Unix())
+	fs.super.Wtime = t
+	fs.super.Lastcheck = t
+	fs.super.Mkfstime = t
+
+	l := &mkfsLayout{fs: fs}
+	for {
+		if nblock <= int64(first) {
+			return nil, fmt.Errorf("file system size %d too small", size)
+		}
+		ngroup := uint32((nblock - int64(first) + int64(bpg) - 1) / int64(bpg))
+		ipg := uint32((ninode + int64(ngroup) - 1) / int64(ngroup))
+		round := ipb
+		if round < 8 {
+			round = 8
+		}
+		if ipg*ngroup < firstInode+1 {
+			ipg = (firstInode + ngroup) / ngroup
+		}
+		ipg = (ipg + round - 1) / round * round
+		if ipg > 8*bs || ipg > maxPerGroup {
+			return nil, fmt.Errorf("too many inodes (%d) for %d block groups", ninode, ngroup)
+		}
+
+		fs.NumBlock = nblock
+		fs.numGroup = ngroup
+		fs.blocksPerGroup = bpg
+		fs.inodesPerGroup = ipg
+		fs.firstBlock = first
+		fs.descPerBlock = bs / diskGroupSize
+		l.inodeBlks = ipg / ipb
+		l.ndesc = (ngroup + fs.descPerBlock - 1) / fs.descPerBlock
+
+		// Drop a last group too small to hold its metadata
+		// and some data, as mke2fs does.
+		last := fs.groupBlocks(ngroup - 1)
+		need := l.overhead(ngroup-1) + minDataBlocks
+		if ngroup == 1 {
+			// root, lost+found, and its indirect block
+			need = l.overhead(0) + lostFoundSize/bs + 2
+		}
+		if last < need {
+			if ngroup == 1 {
+				return nil, fmt.Errorf("file system size %d too small", size)
+			}
+			nblock -= int64(last)
+			continue
+		}
+		break
+	}
+
+	var freeBlocks uint32
+	for gnum := uint32(0); gnum < fs.numGroup; gnum++ {
+		start := l.start(gnum)
+		bb := start + l.overhead(gnum) - 2 - l.inodeBlks
+		n := fs.groupBlocks(gnum)
+		g := diskGroup{
+			Bitblock:        bb,
+			Inodebitblock:   bb + 1,
+			Inodeaddr:       bb + 2,
+			Freeblockscount: uint16(n - l.overhead(gnum)),
+			Freeinodescount: uint16(fs.inodesPerGroup),
+		}
+		if gnum == 0 {
+			g.Freeinodescount -= firstInode - 1
+		}
+		freeBlocks += uint32(g.Freeblockscount)
+		l.groups = append(l.groups, g)
+	}
+	fs.super.Nblock = uint32(fs.NumBlock)
+	fs.super.Ninode = fs.numGroup * fs.inodesPerGroup
+	fs.super.Inospergroup = fs.inodesPerGroup
+	fs.super.Freeblockcount = freeBlocks
+	fs.super.Freeinodecount = fs.super.Ninode - (firstInode - 1)
+	return l, nil
+}
+
+// start returns the first block of group gnum.
+func (l *mkfsLayout) start(gnum uint32) uint32 {
+	return l.fs.firstBlock + gnum*l.fs.blocksPerGroup
+}
+
+// overhead returns the number of metadata blocks at the start of group gnum.
+func (l *mkfsLayout) overhead(gnum uint32) uint32 {
+	n := 2 + l.inodeBlks
+	if l.fs.hasSuper(gnum) {
+		n += 1 + l.ndesc
+	}
+	return n
+}
+
+// write writes the metadata for the new file system to rw.
+func (l *mkfsLayout) write(rw ReadWriterAt) error {
+	fs := l.fs
+	bs := int64(fs.BlockSize)
+	w := func(data []byte, block uint32) error {
+		_, err := rw.WriteAt(data, int64(block)*bs)
+		return err
+	}
+
+	desc := make([]byte, int64(l.ndesc)*bs)
+	for i := range l.groups {
+		copy(desc[i*diskGroupSize:], pack(&l.groups[i]))
+	}
+	zero := make([]byte, int64(l.inodeBlks)*bs)
+	for gnum, g := range l.groups {
+		gnum := uint32(gnum)
+		start := l.start(gnum)
+		if fs.hasSuper(gnum) {
+			super := fs.super
+			super.Blockgroupnr = uint16(gnum)
+			off := int64(start) * bs
+			if gnum == 0 {
+				off = superOff
+			}
+			if _, err := rw.WriteAt(pack(&super), off); err != nil {
+				return err
+			}
+			if err := w(desc, start+1); err != nil {
+				return err
+			}
+		}
+
+		// Mark the metadata and the padding past the end
+		// of the group in use in the block bitmap.
+		bitmap := make([]byte, bs)
+		n := fs.groupBlocks(gnum)
+		for i := uint32(0); i < 8*uint32(bs); i++ {
+			if i < l.overhead(gnum) || i >= n {
+				setBit(bitmap, uint64(i))
+			}
+		}
+		if err := w(bitmap, g.Bitblock); err != nil {
+			return err
+		}
+
+		// Likewise the reserved inodes and padding in the inode bitmap.
+		bitmap = make([]byte, bs)
+		for i := uint32(0); i < 8*uint32(bs); i++ {
+			if gnum == 0 && i < firstInode-1 || i >= fs.inodesPerGroup {
+				setBit(bitmap, uint64(i))
+			}
+		}
+		if err := w(bitmap, g.Inodebitblock); err != nil {
+			return err
+		}
+		if err := w(zero, g.Inodeaddr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyFS copies the files and directories in src into the directory f,
+// preserving their modes, modification times, and symbolic link targets,
+// and gives f the mode and modification time of src's root.
+// Runs of zero blocks in regular files become holes.
+//
+// If owner is not nil, it gives the user and group IDs for each file.
+// Otherwise they come from the FileInfo's Sys result, as for os.DirFS
+// on Unix systems, or are 0.  Device files need that Sys result
+// for their device numbers.
+func (f *File) CopyFS(src fs.FS, owner func(fs.FileInfo) (uid, gid int)) error {
+	info, err := fs.Stat(src, ".")
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("copying %v: not a directory", info.Mode())
+	}
+	if err := f.copyDir(src, ".", owner); err != nil {
+		return err
+	}
+	if err := f.Chmod(info.Mode()); err != nil {
+		return err
+	}
+	return f.copyAttr(info, owner)
+}
+
+func (f *File) copyDir(src fs.FS, dir string, owner func(fs.FileInfo) (uid, gid int)) error {
+	entries, err := fs.ReadDir(src, dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := path.Join(dir, e.Name())
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if err := f.copyEntry(src, name, info, owner); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// copyEntry copies the file called name in src,
+// described by info, into the directory f.
+func (f *File) copyEntry(src fs.FS, name string, info fs.FileInfo, owner func(fs.FileInfo) (uid, gid int)) error {
+	var c *File
+	var err error
+	base := path.Base(name)
+	switch mode := info.Mode(); mode.Type() {
+	case fs.ModeDir:
+		if c, err = f.Mkdir(base, mode); err != nil {
+			return err
+		}
+		if err := c.copyDir(src, name, owner); err != nil {
+			return err
+		}
+	case fs.ModeSymlink:
+		target, err := fs.ReadLink(src, name)
+		if err != nil {
+			return err
+		}
+		if c, err = f.Symlink(base, target); err != nil {
+			return err
+		}
+	case 0:
+		if c, err = f.Create(base, mode); err != nil {
+			return err
+		}
+		r, err := src.Open(name)
+		if err != nil {
+			return err
+		}
+		err = c.copyData(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	case fs.ModeNamedPipe, fs.ModeSocket:
+		if c, err = f.Create(base, mode); err != nil {
+			return err
+		}
+	case fs.ModeDevice, fs.ModeDevice | fs.ModeCharDevice:
+		major, minor, ok := fileDev(info)
+		if !ok {
+			return fmt.Errorf("unknown device number")
+		}
+		if c, err = f.Mknod(base, mode, major, minor); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported file type %v", mode)
+	}
+	return c.copyAttr(info, owner)
+}
+
+// copyAttr sets the owner and times of f from info.
+func (f *File) copyAttr(info fs.FileInfo, owner func(fs.FileInfo) (uid, gid int)) error {
+	var uid, gid int
+	if owner != nil {
+		uid, gid = owner(info)
+	} else {
+		uid, gid, _ = fileOwner(info)
+	}
+	if err := f.Chown(uid, gid); err != nil {
+		return err
+	}
+	return f.Chtimes(info.ModTime(), info.ModTime())
+}
+
+// copyData writes the data read from r to the new file f,
+// leaving holes for blocks of zeros.
+func (f *File) copyData(r io.Reader) error {
+	bs := f.fs.BlockSize
+	buf := make([]byte, 64*bs)
+	var off int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		// Write runs of blocks that are not all zero.
+		for i := 0; i < n; {
+			j := i
+			for j < n && !isZero(buf[j:min(j+bs, n)]) {
+				j += bs
+			}
+			j = min(j, n)
+			if j > i {
+				if _, err := f.WriteAt(buf[i:j], off+int64(i)); err != nil {
+					return err
+				}
+				i = j
+			} else {
+				i += bs
+			}
+		}
+		off += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if off > f.Size() {
+		return f.Truncate(off)
+	}
+	return nil
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext2
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMkfs(t *testing.T) {
+	tests := []struct {
+		size int64
+		opt  MkfsOptions
+	}{
+		{1 << 20, MkfsOptions{}},
+		{20 << 20, MkfsOptions{Label: "three groups"}},
+		{8<<20 + 3<<10, MkfsOptions{}}, // last group dropped
+		{10 << 20, MkfsOptions{BlockSize: 2048, Inodes: 100}},
+		{64 << 20, MkfsOptions{BlockSize: 4096, InodeSize: 128}},
+	}
+	for _, tt := range tests {
+		tt.opt.Time = testTime
+		img := make(memImage, tt.size)
+		fs, err := Mkfs(img, tt.size, &tt.opt)
+		if err != nil {
+			t.Errorf("Mkfs(%d, %+v): %v", tt.size, tt.opt, err)
+			continue
+		}
+		if err := fs.Check(); err != nil {
+			t.Errorf("Mkfs(%d, %+v): Check: %v", tt.size, tt.opt, err)
+		}
+		e2fsck(t, img)
+
+		lf := lookup(t, fs, "lost+found")
+		if !lf.IsDir() || lf.Size() != lostFoundSize {
+			t.Errorf("Mkfs(%d, %+v): lost+found: IsDir=%v Size=%d", tt.size, tt.opt, lf.IsDir(), lf.Size())
+		}
+	}
+
+	if _, err := Mkfs(make(memImage, 16<<10), 16<<10, nil); err == nil {
+		t.Errorf("Mkfs of 16 kB succeeded")
+	}
+}
+
+var (
+	time1 = time.Date(2019, 1, 2, 3, 4, 5, 6, time.UTC)
+	time2 = time.Date(2018, 6, 7, 8, 9, 10, 0, time.UTC)
+)
+
+var testTree = fstest.MapFS{
+	".":             {Mode: fs.ModeDir | 0711, ModTime: time1},
+	"hello.txt":     {Data: []byte("hello, world\n"), Mode: 0644, ModTime: time1},
+	"empty":         {Mode: 0600, ModTime: time2},
+	"bin":           {Mode: fs.ModeDir | 0755, ModTime: time2},
+	"bin/tool":      {Data: []byte(dataLines()), Mode: fs.ModeSetuid | 0755, ModTime: time1},
+	"sparse":        {Data: append(make([]byte, 200<<10), "end"...), Mode: 0644, ModTime: time2},
+	"link":          {Data: []byte("hello.txt"), Mode: fs.ModeSymlink | 0777, ModTime: time2},
+	"dir/sub/slow":  {Data: []byte(strings.Repeat("../", 30) + "hello.txt"), Mode: fs.ModeSymlink | 0777, ModTime: time1},
+	"dir/sub/fifo":  {Mode: fs.ModeNamedPipe | 0620, ModTime: time1},
+	"dir/tmp":       {Mode: fs.ModeDir | fs.ModeSticky | 0777, ModTime: time1},
+	"dir/tmp/owned": {Data: []byte("mine\n"), Mode: 0400, ModTime: time2},
+}
+
+func testOwner(info fs.FileInfo) (uid, gid int) {
+	if info.Name() == "owned" {
+		return 1000, 100000
+	}
+	return 0, 0
+}
+
+func mkfsTree(t *testing.T) memImage {
+	img := make(memImage, 4<<20)
+	fs, err := Mkfs(img, int64(len(img)), &MkfsOptions{Time: testTime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := fs.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := root.CopyFS(testTree, testOwner); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	return img
+}
+
+func TestCopyFS(t *testing.T) {
+	img := mkfsTree(t)
+	e2fsck(t, img)
+	if !bytes.Equal(img, mkfsTree(t)) {
+		t.Errorf("images differ")
+	}
+
+	efs, err := Init(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := efs.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, mt := root.Mode(), root.ModTime(); m != os.ModeDir|0711 || !mt.Equal(time1) {
+		t.Errorf("root: Mode, ModTime = %v, %v, want %v, %v", m, mt, os.ModeDir|0711, time1)
+	}
+	for name, file := range testTree {
+		if name == "." {
+			continue
+		}
+		f := lookup(t, efs, name)
+		if m := f.Mode(); m != file.Mode {
+			t.Errorf("%s: Mode = %v, want %v", name, m, file.Mode)
+		}
+		if mt := f.ModTime(); !mt.Equal(file.ModTime) {
+			t.Errorf("%s: ModTime = %v, want %v", name, mt, file.ModTime)
+		}
+		switch file.Mode.Type() {
+		case 0:
+			if data := readFile(t, f); !bytes.Equal(data, file.Data) {
+				t.Errorf("%s: read %d bytes %.20q, want %d bytes %.20q", name, len(data), data, len(file.Data), file.Data)
+			}
+		case fs.ModeSymlink:
+			if target, err := f.ReadLink(); err != nil || target != string(file.Data) {
+				t.Errorf("%s: ReadLink = %q, %v, want %q", name, target, err, file.Data)
+			}
+		}
+	}
+
+	owned := lookup(t, efs, "dir/tmp/owned")
+	if uid, gid := owned.Uid(), owned.Gid(); uid != 1000 || gid != 100000 {
+		t.Errorf("dir/tmp/owned: owner %d:%d, want 1000:100000", uid, gid)
+	}
+	if n := lookup(t, efs, "sparse").ino.Nblock; n != 2*(1+1) {
+		t.Errorf("sparse: %d sectors allocated, want %d", n, 2*(1+1))
+	}
+}
+
+func TestCopyDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "a/b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a/b/file"), []byte("data\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "a/b/file"), time1, time1); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("b/file", filepath.Join(dir, "a/link")); err != nil {
+		t.Skip(err)
+	}
+
+	img := make(memImage, 1<<20)
+	fs, err := Mkfs(img, int64(len(img)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := fs.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := root.CopyFS(os.DirFS(dir), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	e2fsck(t, img)
+
+	f := lookup(t, fs, "a/b/file")
+	if data := readFile(t, f); string(data) != "data\n" {
+		t.Errorf("a/b/file: read %q, want %q", data, "data\n")
+	}
+	if m, mt := f.Mode(), f.ModTime(); m != 0640 || !mt.Equal(time1) {
+		t.Errorf("a/b/file: Mode, ModTime = %v, %v, want %v, %v", m, mt, os.FileMode(0640), time1)
+	}
+	if runtime.GOOS != "windows" && (f.Uid() != os.Getuid() || f.Gid() != os.Getgid()) {
+		t.Errorf("a/b/file: owner %d:%d, want %d:%d", f.Uid(), f.Gid(), os.Getuid(), os.Getgid())
+	}
+	if target, err := lookup(t, fs, "a/link").ReadLink(); err != nil || target != "b/file" {
+		t.Errorf("a/link: ReadLink = %q, %v, want %q", target, err, "b/file")
+	}
+}
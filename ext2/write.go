@@ -0,0 +1,1129 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxLinks is the maximum link count.  With the dir_nlink feature,
+// a directory with more subdirectories has a link count of 1.
+const maxLinks = 65000
+
+// A ReadWriterAt is the storage for a file system opened for writing.
+type ReadWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// InitWrite returns an FS reading from and writing to
+// the file system stored in rw.
+//
+// Changes are written to rw as they are made, except for the free
+// block and inode counts in the superblock and group descriptors,
+// which are written by Sync and Close.
+//
+// InitWrite refuses file systems that use features it cannot
+// maintain, such as extents and checksums, and file systems that
+// were not cleanly unmounted.  New files use indirect blocks, and
+// changing a hash-indexed directory removes its index, as e2fsprogs does.
+func InitWrite(rw ReadWriterAt) (*FS, error) {
+	fs, err := Init(rw)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWritable(&fs.super); err != nil {
+		return nil, err
+	}
+	fs.w = rw
+	fs.files = make(map[uint32]*File)
+	return fs, nil
+}
+
+// Sync writes the superblock and any changed group descriptors.
+func (fs *FS) Sync() error {
+	if fs.w == nil {
+		return nil
+	}
+	for gnum, g := range fs.g {
+		if g == nil || !g.dirty {
+			continue
+		}
+		off := int64(fs.BlockSize)*int64(fs.groupDescBlock(uint32(gnum))) + int64(uint32(gnum)%fs.descPerBlock*fs.descSize)
+		data := pack(&g.diskGroup)
+		if fs.descSize >= diskGroupSize64 {
+			data = append(data, pack(&g.hi)...)
+		}
+		if err := fs.writeAt(data, off); err != nil {
+			return err
+		}
+		g.dirty = false
+	}
+	if fs.superDirty {
+		if err := fs.writeAt(pack(&fs.super), superOff); err != nil {
+			return err
+		}
+		fs.superDirty = false
+	}
+	return nil
+}
+
+// Close syncs the file system and then closes the file
+// opened by Open or OpenWrite, if any.
+func (fs *FS) Close() error {
+	err := fs.Sync()
+	if fs.c != nil {
+		if cerr := fs.c.Close(); err == nil {
+			err = cerr
+		}
+		fs.c = nil
+	}
+	return err
+}
+
+func (fs *FS) writable() error {
+	if fs.w == nil {
+		return fmt.Errorf("file system opened read-only")
+	}
+	return nil
+}
+
+// writeAt writes data at offset off in the file system,
+// updating any cached copy.
+func (fs *FS) writeAt(data []byte, off int64) error {
+	if err := fs.writable(); err != nil {
+		return err
+	}
+	if _, err := fs.w.WriteAt(data, off); err != nil {
+		return err
+	}
+	for i := range fs.cache {
+		b := &fs.cache[i]
+		if b.buf == nil || b.off < 0 || off+int64(len(data)) <= b.off || b.off+int64(len(b.buf)) <= off {
+			continue
+		}
+		if b.off <= off && off+int64(len(data)) <= b.off+int64(len(b.buf)) {
+			copy(b.buf[off-b.off:], data)
+		} else {
+			b.off = -1 // partial overlap; reload
+		}
+	}
+	return nil
+}
+
+// readBlock returns a copy of the given block, for modification.
+func (fs *FS) readBlock(b uint64) ([]byte, error) {
+	buf, err := fs.read(int64(b)*int64(fs.BlockSize), 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf[:fs.BlockSize]...), nil
+}
+
+func (fs *FS) writeBlock(b uint64, data []byte) error {
+	return fs.writeAt(data, int64(b)*int64(fs.BlockSize))
+}
+
+// groupBlocks returns the number of blocks in group gnum.
+// Only the last group can be short.
+func (fs *FS) groupBlocks(gnum uint32) uint32 {
+	start := int64(fs.firstBlock) + int64(gnum)*int64(fs.blocksPerGroup)
+	if n := fs.NumBlock - start; n < int64(fs.blocksPerGroup) {
+		return uint32(n)
+	}
+	return fs.blocksPerGroup
+}
+
+func (fs *FS) firstIno() uint32 {
+	if fs.super.Revlevel >= 1 {
+		return fs.super.Firstino
+	}
+	return firstInode
+}
+
+// allocBit sets the first clear bit among the first n bits of
+// the bitmap block, searching from bit start and wrapping around.
+// It reports whether there was a clear bit.
+func (fs *FS) allocBit(bitmap uint64, start, n uint32) (uint32, bool, error) {
+	buf, err := fs.readBlock(bitmap)
+	if err != nil {
+		return 0, false, err
+	}
+	for i := uint32(0); i < n; i++ {
+		bit := (start + i) % n
+		if bit&7 == 0 && buf[bit>>3] == 0xff && i+8 <= n {
+			i += 7
+			continue
+		}
+		if buf[bit>>3]&(1<<(bit&7)) == 0 {
+			buf[bit>>3] |= 1 << (bit & 7)
+			return bit, true, fs.writeBlock(bitmap, buf)
+		}
+	}
+	return 0, false, nil
+}
+
+// freeBit clears the bit in the bitmap block,
+// reporting whether it was set.
+func (fs *FS) freeBit(bitmap uint64, bit uint32) (bool, error) {
+	buf, err := fs.readBlock(bitmap)
+	if err != nil {
+		return false, err
+	}
+	if buf[bit>>3]&(1<<(bit&7)) == 0 {
+		return false, nil
+	}
+	buf[bit>>3] &^= 1 << (bit & 7)
+	return true, fs.writeBlock(bitmap, buf)
+}
+
+// allocBlock allocates a block, preferring goal or a block soon after it.
+func (fs *FS) allocBlock(goal uint64) (uint64, error) {
+	if goal < uint64(fs.firstBlock) || goal >= uint64(fs.NumBlock) {
+		goal = uint64(fs.firstBlock)
+	}
+	goal -= uint64(fs.firstBlock)
+	start := uint32(goal / uint64(fs.blocksPerGroup))
+	for i := uint32(0); i < fs.numGroup; i++ {
+		gnum := (start + i) % fs.numGroup
+		g, err := fs.group(gnum)
+		if err != nil {
+			return 0, err
+		}
+		if g.freeBlocks() == 0 {
+			continue
+		}
+		var first uint32
+		if i == 0 {
+			first = uint32(goal % uint64(fs.blocksPerGroup))
+		}
+		bit, ok, err := fs.allocBit(g.blockBitmap(), first, fs.groupBlocks(gnum))
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+		g.addFreeBlocks(-1)
+		fs.super.Freeblockcount--
+		fs.superDirty = true
+		return uint64(fs.firstBlock) + uint64(gnum)*uint64(fs.blocksPerGroup) + uint64(bit), nil
+	}
+	return 0, fmt.Errorf("no free blocks")
+}
+
+func (fs *FS) freeBlock(block uint64) error {
+	if block < uint64(fs.firstBlock) || block >= uint64(fs.NumBlock) {
+		return fmt.Errorf("freeing block number %d out of range", block)
+	}
+	b := block - uint64(fs.firstBlock)
+	g, err := fs.group(uint32(b / uint64(fs.blocksPerGroup)))
+	if err != nil {
+		return err
+	}
+	ok, err := fs.freeBit(g.blockBitmap(), uint32(b%uint64(fs.blocksPerGroup)))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("freeing free block %d", block)
+	}
+	g.addFreeBlocks(+1)
+	fs.super.Freeblockcount++
+	fs.superDirty = true
+	return nil
+}
+
+// allocInode allocates an inode, preferring the group holding inode near.
+func (fs *FS) allocInode(near uint32, dir bool) (uint32, error) {
+	start := (near - 1) / fs.inodesPerGroup
+	for i := uint32(0); i < fs.numGroup; i++ {
+		gnum := (start + i) % fs.numGroup
+		g, err := fs.group(gnum)
+		if err != nil {
+			return 0, err
+		}
+		if g.freeInodes() == 0 {
+			continue
+		}
+		bit, ok, err := fs.allocBit(g.inodeBitmap(), 0, fs.inodesPerGroup)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+		inum := gnum*fs.inodesPerGroup + bit + 1
+		if inum < fs.firstIno() {
+			return 0, fmt.Errorf("reserved inode %d not marked in use", inum)
+		}
+		g.addFreeInodes(-1)
+		if dir {
+			g.addUsedDirs(+1)
+		}
+		fs.super.Freeinodecount--
+		fs.superDirty = true
+		return inum, nil
+	}
+	return 0, fmt.Errorf("no free inodes")
+}
+
+func (fs *FS) freeInode(inum uint32, dir bool) error {
+	g, ioff, err := fs.igroup(inum)
+	if err != nil {
+		return err
+	}
+	ok, err := fs.freeBit(g.inodeBitmap(), ioff)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("freeing free inode %d", inum)
+	}
+	g.addFreeInodes(+1)
+	if dir {
+		g.addUsedDirs(-1)
+	}
+	fs.super.Freeinodecount++
+	fs.superDirty = true
+	return nil
+}
+
+// writeInode writes f's inode to disk.
+func (fs *FS) writeInode(f *File) error {
+	addr, voff, err := fs.inodeAddr(f.inum)
+	if err != nil {
+		return err
+	}
+	data := append(pack(&f.ino), f.extra...)
+	return fs.writeAt(data, addr+int64(voff))
+}
+
+// newFile allocates a new inode with the given mode,
+// near the directory that will hold it.
+// The caller must set the link count and write the inode.
+func (fs *FS) newFile(dir *File, mode uint16) (*File, error) {
+	inum, err := fs.allocInode(dir.inum, mode&ifmt == ifdir)
+	if err != nil {
+		return nil, err
+	}
+	return fs.initFile(inum, mode), nil
+}
+
+// initFile returns a File for the newly allocated inode inum,
+// with the given mode and all times set to now.
+func (fs *FS) initFile(inum uint32, mode uint16) *File {
+	f := &File{fs: fs, inum: inum}
+	f.ino.Mode = mode
+	if fs.inodeSize > diskInodeSize {
+		f.extra = make([]byte, fs.inodeSize-diskInodeSize)
+		if len(f.extra) >= extraIsize {
+			binary.LittleEndian.PutUint16(f.extra, extraIsize)
+		}
+	}
+	now := fs.now()
+	f.setTimes(now, now, now)
+	if f.extraSize() >= crtimeOff+8 {
+		var sec uint32
+		f.setTime(&sec, crtimeOff+4, now)
+		binary.LittleEndian.PutUint32(f.extra[crtimeOff:], sec)
+	}
+	fs.files[inum] = f
+	return f
+}
+
+// freeFile frees the blocks and inode of f, which has no links left.
+func (fs *FS) freeFile(f *File) error {
+	if f.hasBlockMap() {
+		if err := f.freeBlocks(0); err != nil {
+			return err
+		}
+	}
+	if f.ino.Fileacl != 0 {
+		if err := fs.releaseXattrBlock(uint64(f.ino.Fileacl)); err != nil {
+			return err
+		}
+		f.ino.Fileacl = 0
+	}
+	f.ino.Size = 0
+	f.ino.Diracl = 0
+	f.ino.Dtime = uint32(fs.now().Unix())
+	if err := fs.writeInode(f); err != nil {
+		return err
+	}
+	delete(fs.files, f.inum)
+	return fs.freeInode(f.inum, f.IsDir())
+}
+
+// releaseXattrBlock drops a reference to a shared extended attribute block,
+// freeing it when no references remain.
+func (fs *FS) releaseXattrBlock(b uint64) error {
+	buf, err := fs.readBlock(b)
+	if err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(buf) != xattrMagic {
+		return fmt.Errorf("corrupt extended attribute block %d", b)
+	}
+	if refs := binary.LittleEndian.Uint32(buf[xattrRefcountOff:]); refs > 1 {
+		binary.LittleEndian.PutUint32(buf[xattrRefcountOff:], refs-1)
+		return fs.writeBlock(b, buf)
+	}
+	return fs.freeBlock(b)
+}
+
+// setTime sets the inode time *sec, with extra field at offset off, to t.
+func (f *File) setTime(sec *uint32, off int, t time.Time) {
+	s := t.Unix()
+	*sec = uint32(s)
+	if f.extraSize() >= off+4 {
+		extra := uint32((s-int64(int32(s)))>>32)&3 | uint32(t.Nanosecond())<<2
+		binary.LittleEndian.PutUint32(f.extra[off:], extra)
+	}
+}
+
+func (f *File) setTimes(atime, mtime, ctime time.Time) {
+	f.setTime(&f.ino.Atime, atimeExtraOff, atime)
+	f.setTime(&f.ino.Mtime, mtimeExtraOff, mtime)
+	f.setTime(&f.ino.Ctime, ctimeExtraOff, ctime)
+}
+
+// touch records a change to f's inode and, if modified is set, its content.
+func (f *File) touch(modified bool) {
+	now := f.fs.now()
+	f.setTime(&f.ino.Ctime, ctimeExtraOff, now)
+	if modified {
+		f.setTime(&f.ino.Mtime, mtimeExtraOff, now)
+	}
+}
+
+func (f *File) setSize(size int64) {
+	f.ino.Size = uint32(size)
+	if f.ino.Mode&ifmt == ifreg {
+		f.ino.Diracl = uint32(size >> 32)
+		if size >= 1<<31 && f.fs.super.Revlevel >= 1 && !f.fs.rocompat(rocompatLargeFile) {
+			f.fs.super.Featurerocompat |= rocompatLargeFile
+			f.fs.superDirty = true
+		}
+	}
+}
+
+// isFastSymlink reports whether f is a symbolic link
+// storing its target in the block pointers.
+func (f *File) isFastSymlink() bool {
+	if f.ino.Mode&ifmt != iflnk || f.ino.Flags&(extentsFl|inlineDataFl) != 0 {
+		return false
+	}
+	// The inode's block count includes any extended attribute block.
+	eaBlocks := uint32(0)
+	if f.ino.Fileacl != 0 {
+		eaBlocks = uint32(f.fs.BlockSize / bytesPerSector)
+	}
+	return f.ino.Nblock == eaBlocks
+}
+
+// hasBlockMap reports whether f's block pointers map its data
+// using direct and indirect blocks.
+func (f *File) hasBlockMap() bool {
+	if f.ino.Flags&(extentsFl|inlineDataFl) != 0 {
+		return false
+	}
+	switch f.ino.Mode & ifmt {
+	case ifreg, ifdir:
+		return true
+	case iflnk:
+		return !f.isFastSymlink()
+	}
+	return false
+}
+
+// allocBlock allocates a block for f, zeroing it if zero is set.
+func (f *File) allocBlock(zero bool) (uint64, error) {
+	goal := f.next
+	if goal == 0 {
+		goal = uint64(f.fs.firstBlock) + uint64((f.inum-1)/f.fs.inodesPerGroup)*uint64(f.fs.blocksPerGroup)
+	}
+	b, err := f.fs.allocBlock(goal)
+	if err != nil {
+		return 0, err
+	}
+	f.next = b + 1
+	f.ino.Nblock += uint32(f.fs.BlockSize / bytesPerSector)
+	if zero {
+		if err := f.fs.writeBlock(b, make([]byte, f.fs.BlockSize)); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// bmap is like dblock but allocates the block if it is missing,
+// along with any indirect blocks needed to map it.
+// It reports whether the block was newly allocated,
+// in which case its content is undefined.
+// The caller must write f's inode.
+func (f *File) bmap(block uint32) (b uint64, isNew bool, err error) {
+	p := uint32(f.fs.BlockSize / 4)
+	var ptr *uint32
+	var path []uint32
+	switch lb := block; {
+	case lb < numDirBlocks:
+		ptr = &f.ino.Block[lb]
+	case lb-numDirBlocks < p:
+		lb -= numDirBlocks
+		ptr, path = &f.ino.Block[indBlock], []uint32{lb}
+	case lb-numDirBlocks-p < p*p:
+		lb -= numDirBlocks + p
+		ptr, path = &f.ino.Block[ind2Block], []uint32{lb / p, lb % p}
+	case uint64(lb-numDirBlocks-p-p*p) < uint64(p)*uint64(p)*uint64(p):
+		lb -= numDirBlocks + p + p*p
+		ptr, path = &f.ino.Block[ind3Block], []uint32{lb / (p * p), lb / p % p, lb % p}
+	default:
+		return 0, false, fmt.Errorf("block number %d out of range", block)
+	}
+
+	if *ptr == 0 {
+		nb, err := f.allocBlock(len(path) > 0)
+		if err != nil {
+			return 0, false, err
+		}
+		*ptr = uint32(nb)
+		isNew = len(path) == 0
+	}
+	b = uint64(*ptr)
+	for i, off := range path {
+		buf, err := f.fs.readBlock(b)
+		if err != nil {
+			return 0, false, err
+		}
+		next := binary.LittleEndian.Uint32(buf[4*off:])
+		if next == 0 {
+			last := i == len(path)-1
+			nb, err := f.allocBlock(!last)
+			if err != nil {
+				return 0, false, err
+			}
+			next = uint32(nb)
+			binary.LittleEndian.PutUint32(buf[4*off:], next)
+			if err := f.fs.writeBlock(b, buf); err != nil {
+				return 0, false, err
+			}
+			isNew = last
+		}
+		b = uint64(next)
+	}
+	return b, isNew, nil
+}
+
+// freeBlocks frees the blocks holding logical blocks first and later.
+// The caller must write f's inode.
+func (f *File) freeBlocks(first uint64) error {
+	for i := first; i < numDirBlocks; i++ {
+		if err := f.freeTree(&f.ino.Block[i], 0, i, first); err != nil {
+			return err
+		}
+	}
+	p := uint64(f.fs.BlockSize / 4)
+	start, span := uint64(numDirBlocks), p
+	for i := indBlock; i <= ind3Block; i++ {
+		if err := f.freeTree(&f.ino.Block[i], i-indBlock+1, start, first); err != nil {
+			return err
+		}
+		start += span
+		span *= p
+	}
+	return nil
+}
+
+// freeTree frees the blocks holding logical blocks first and later
+// in the tree rooted at block *ptr, an indirect block of the given depth
+// (or a data block for depth 0) mapping logical blocks from start.
+// It clears *ptr if it frees the root.
+func (f *File) freeTree(ptr *uint32, depth int, start, first uint64) error {
+	if *ptr == 0 {
+		return nil
+	}
+	p := uint64(f.fs.BlockSize / 4)
+	span := uint64(1)
+	for i := 0; i < depth; i++ {
+		span *= p
+	}
+	if start+span <= first {
+		return nil
+	}
+	if depth > 0 {
+		buf, err := f.fs.readBlock(uint64(*ptr))
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < p; i++ {
+			b := binary.LittleEndian.Uint32(buf[4*i:])
+			if err := f.freeTree(&b, depth-1, start+i*(span/p), first); err != nil {
+				return err
+			}
+			binary.LittleEndian.PutUint32(buf[4*i:], b)
+		}
+		if start < first {
+			// Part of the tree remains.
+			return f.fs.writeBlock(uint64(*ptr), buf)
+		}
+	}
+	if err := f.fs.freeBlock(uint64(*ptr)); err != nil {
+		return err
+	}
+	*ptr = 0
+	f.ino.Nblock -= uint32(f.fs.BlockSize / bytesPerSector)
+	return nil
+}
+
+// WriteAt implements the io.WriterAt interface for regular files,
+// allocating blocks as needed and extending the file if the data
+// ends past its current size.
+func (f *File) WriteAt(p []byte, off int64) (n int, err error) {
+	if err := f.fs.writable(); err != nil {
+		return 0, err
+	}
+	if f.ino.Mode&ifmt != ifreg {
+		return 0, fmt.Errorf("not a regular file")
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+
+	defer func() {
+		if end := off + int64(n); end > f.Size() {
+			f.setSize(end)
+		}
+		f.touch(true)
+		if werr := f.fs.writeInode(f); err == nil {
+			err = werr
+		}
+	}()
+
+	bs := int64(f.fs.BlockSize)
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos/bs >= 1<<32 {
+			return n, fmt.Errorf("file too large")
+		}
+		boff := int(pos % bs)
+		m := len(p) - n
+		if m > int(bs)-boff {
+			m = int(bs) - boff
+		}
+		b, isNew, err := f.bmap(uint32(pos / bs))
+		if err != nil {
+			return n, err
+		}
+		var buf []byte
+		switch {
+		case m == int(bs):
+			buf = p[n : n+m]
+		case isNew:
+			buf = make([]byte, bs)
+			copy(buf[boff:], p[n:n+m])
+		default:
+			if buf, err = f.fs.readBlock(b); err != nil {
+				return n, err
+			}
+			copy(buf[boff:], p[n:n+m])
+		}
+		if err := f.fs.writeBlock(b, buf); err != nil {
+			return n, err
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// Truncate changes the size of the regular file f,
+// freeing any blocks past the new end.
+func (f *File) Truncate(size int64) error {
+	if err := f.fs.writable(); err != nil {
+		return err
+	}
+	if f.ino.Mode&ifmt != ifreg {
+		return fmt.Errorf("not a regular file")
+	}
+	if size < 0 {
+		return fmt.Errorf("negative size")
+	}
+	if size < f.Size() {
+		bs := int64(f.fs.BlockSize)
+		if err := f.freeBlocks(uint64((size + bs - 1) / bs)); err != nil {
+			return err
+		}
+		// Zero the rest of the last block,
+		// so that growing the file again reads zeros.
+		if r := size % bs; r != 0 {
+			b, err := f.dblock(uint32(size / bs))
+			if err != nil {
+				return err
+			}
+			if b != 0 {
+				buf, err := f.fs.readBlock(b)
+				if err != nil {
+					return err
+				}
+				for i := range buf[r:] {
+					buf[r+int64(i)] = 0
+				}
+				if err := f.fs.writeBlock(b, buf); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	f.setSize(size)
+	f.touch(true)
+	return f.fs.writeInode(f)
+}
+
+// inodeMode returns the inode mode bits for mode.
+func inodeMode(mode os.FileMode) (uint16, error) {
+	m := uint16(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= isuid
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= isgid
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= isvtx
+	}
+	switch mode & os.ModeType {
+	case 0:
+		m |= ifreg
+	case os.ModeDir:
+		m |= ifdir
+	case os.ModeSymlink:
+		m |= iflnk
+	case os.ModeNamedPipe:
+		m |= ififo
+	case os.ModeSocket:
+		m |= ifsock
+	case os.ModeDevice:
+		m |= ifblk
+	case os.ModeDevice | os.ModeCharDevice:
+		m |= ifchr
+	default:
+		return 0, fmt.Errorf("unsupported file mode %v", mode)
+	}
+	return m, nil
+}
+
+// fileType returns the directory entry file type for the inode mode.
+func (fs *FS) fileType(mode uint16) uint8 {
+	if !fs.incompat(incompatFiletype) {
+		return 0
+	}
+	switch mode & ifmt {
+	case ifreg:
+		return 1
+	case ifdir:
+		return 2
+	case ifchr:
+		return 3
+	case ifblk:
+		return 4
+	case ififo:
+		return 5
+	case ifsock:
+		return 6
+	case iflnk:
+		return 7
+	}
+	return 0
+}
+
+func putDirent(buf []byte, ino uint32, recLen int, name string, ftype uint8) {
+	binary.LittleEndian.PutUint32(buf, ino)
+	binary.LittleEndian.PutUint16(buf[4:], uint16(recLen))
+	buf[6] = uint8(len(name))
+	buf[7] = ftype
+	copy(buf[minDirentSize:], name)
+}
+
+// checkNewName returns an error if f is not a writable directory
+// in which a new entry called name can be created.
+func (f *File) checkNewName(name string) error {
+	if err := f.fs.writable(); err != nil {
+		return err
+	}
+	if !f.IsDir() {
+		return fmt.Errorf("file is not a directory")
+	}
+	if name == "" || name == "." || name == ".." || len(name) > nameLen || strings.ContainsAny(name, "/\x00") {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	ino, err := f.lookupInode(name)
+	if err != nil {
+		return err
+	}
+	if ino != 0 {
+		return fmt.Errorf("%s already exists", name)
+	}
+	return nil
+}
+
+// addEntry adds a directory entry for name, which refers to
+// inode ino with the given mode, to the directory f.
+func (f *File) addEntry(name string, ino uint32, mode uint16) error {
+	need := dirlen(len(name))
+	ftype := f.fs.fileType(mode)
+	bs := f.fs.BlockSize
+	nblock := uint32(f.Size() / int64(bs))
+	for i := uint32(0); i < nblock; i++ {
+		b, err := f.dblock(i)
+		if err != nil {
+			return err
+		}
+		if b == 0 {
+			continue
+		}
+		buf, err := f.fs.readBlock(b)
+		if err != nil {
+			return err
+		}
+		for off := 0; off < bs; {
+			var de diskDirent
+			if err := unpack(buf[off:], &de); err != nil {
+				return err
+			}
+			recLen := int(de.Reclen)
+			if recLen < minDirentSize || off+recLen > bs {
+				return fmt.Errorf("corrupt directory entry")
+			}
+			used := 0
+			if de.Ino != 0 {
+				used = dirlen(int(de.Namlen))
+			}
+			if recLen-used >= need {
+				if used > 0 {
+					binary.LittleEndian.PutUint16(buf[off+4:], uint16(used))
+				}
+				putDirent(buf[off+used:], ino, recLen-used, name, ftype)
+				return f.dirChanged(b, buf)
+			}
+			off += recLen
+		}
+	}
+
+	b, _, err := f.bmap(nblock)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, bs)
+	putDirent(buf, ino, bs, name, ftype)
+	f.setSize(int64(nblock+1) * int64(bs))
+	return f.dirChanged(b, buf)
+}
+
+// removeEntry removes the directory entry for name from the directory f,
+// returning the inode number it referred to.
+func (f *File) removeEntry(name string) (uint32, error) {
+	bs := f.fs.BlockSize
+	nblock := uint32(f.Size() / int64(bs))
+	for i := uint32(0); i < nblock; i++ {
+		b, err := f.dblock(i)
+		if err != nil {
+			return 0, err
+		}
+		if b == 0 {
+			continue
+		}
+		buf, err := f.fs.readBlock(b)
+		if err != nil {
+			return 0, err
+		}
+		prev := -1
+		for off := 0; off < bs; {
+			var de diskDirent
+			if err := unpack(buf[off:], &de); err != nil {
+				return 0, err
+			}
+			recLen := int(de.Reclen)
+			if recLen < minDirentSize+int(de.Namlen) || off+recLen > bs {
+				return 0, fmt.Errorf("corrupt directory entry")
+			}
+			if de.Ino != 0 && string(buf[off+minDirentSize:off+minDirentSize+int(de.Namlen)]) == name {
+				if prev < 0 {
+					binary.LittleEndian.PutUint32(buf[off:], 0)
+				} else {
+					prevLen := int(binary.LittleEndian.Uint16(buf[prev+4:]))
+					binary.LittleEndian.PutUint16(buf[prev+4:], uint16(prevLen+recLen))
+				}
+				return de.Ino, f.dirChanged(b, buf)
+			}
+			prev = off
+			off += recLen
+		}
+	}
+	return 0, fmt.Errorf("file not found")
+}
+
+// dirChanged writes buf to the directory block b
+// and updates the directory's inode.
+func (f *File) dirChanged(b uint64, buf []byte) error {
+	if err := f.fs.writeBlock(b, buf); err != nil {
+		return err
+	}
+	// The index is now out of date.
+	// Without it, the directory is read as a plain list.
+	f.ino.Flags &^= indexFl
+	f.touch(true)
+	return f.fs.writeInode(f)
+}
+
+// Create creates a new, empty file called name in the directory f.
+// The file type in mode must be a regular file, named pipe, or socket.
+func (f *File) Create(name string, mode os.FileMode) (*File, error) {
+	switch mode & os.ModeType {
+	case 0, os.ModeNamedPipe, os.ModeSocket:
+		// okay
+	default:
+		return nil, fmt.Errorf("cannot create file with mode %v", mode)
+	}
+	m, err := inodeMode(mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkNewName(name); err != nil {
+		return nil, err
+	}
+	c, err := f.fs.newFile(f, m)
+	if err != nil {
+		return nil, err
+	}
+	c.ino.Nlink = 1
+	if err := f.fs.writeInode(c); err != nil {
+		return nil, err
+	}
+	if err := f.addEntry(name, c.inum, m); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Mkdir creates a new directory called name in the directory f.
+func (f *File) Mkdir(name string, perm os.FileMode) (*File, error) {
+	m, err := inodeMode(perm&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky) | os.ModeDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkNewName(name); err != nil {
+		return nil, err
+	}
+	nlink := f.ino.Nlink
+	switch {
+	case nlink == 1:
+		// too many to count
+	case nlink+1 < maxLinks:
+		nlink++
+	case f.fs.rocompat(rocompatDirNlink):
+		nlink = 1
+	default:
+		return nil, fmt.Errorf("too many links")
+	}
+	d, err := f.fs.newFile(f, m)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.initDir(f.inum); err != nil {
+		return nil, err
+	}
+	f.ino.Nlink = nlink
+	if err := f.addEntry(name, d.inum, m); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// initDir writes the first block of the new directory f,
+// holding the . and .. entries, and the directory's inode.
+func (f *File) initDir(parent uint32) error {
+	b, _, err := f.bmap(0)
+	if err != nil {
+		return err
+	}
+	bs := f.fs.BlockSize
+	buf := make([]byte, bs)
+	putDirent(buf, f.inum, dirlen(1), ".", f.fs.fileType(ifdir))
+	putDirent(buf[dirlen(1):], parent, bs-dirlen(1), "..", f.fs.fileType(ifdir))
+	if err := f.fs.writeBlock(b, buf); err != nil {
+		return err
+	}
+	f.setSize(int64(bs))
+	f.ino.Nlink = 2
+	return f.fs.writeInode(f)
+}
+
+// Symlink creates a new symbolic link called name,
+// pointing at target, in the directory f.
+func (f *File) Symlink(name, target string) (*File, error) {
+	if target == "" || len(target) >= f.fs.BlockSize {
+		return nil, fmt.Errorf("invalid symlink target length %d", len(target))
+	}
+	if err := f.checkNewName(name); err != nil {
+		return nil, err
+	}
+	l, err := f.fs.newFile(f, iflnk|0777)
+	if err != nil {
+		return nil, err
+	}
+	if len(target) < 4*numBlocks {
+		buf := make([]byte, 4*numBlocks)
+		copy(buf, target)
+		for i := range l.ino.Block {
+			l.ino.Block[i] = binary.LittleEndian.Uint32(buf[4*i:])
+		}
+	} else {
+		b, _, err := l.bmap(0)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, f.fs.BlockSize)
+		copy(buf, target)
+		if err := f.fs.writeBlock(b, buf); err != nil {
+			return nil, err
+		}
+	}
+	l.setSize(int64(len(target)))
+	l.ino.Nlink = 1
+	if err := f.fs.writeInode(l); err != nil {
+		return nil, err
+	}
+	if err := f.addEntry(name, l.inum, iflnk); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Mknod creates a new device file called name in the directory f.
+// The file type in mode must be a block or character device.
+func (f *File) Mknod(name string, mode os.FileMode, major, minor uint32) (*File, error) {
+	if mode&os.ModeDevice == 0 || mode&os.ModeType&^(os.ModeDevice|os.ModeCharDevice) != 0 {
+		return nil, fmt.Errorf("cannot make device with mode %v", mode)
+	}
+	m, err := inodeMode(mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkNewName(name); err != nil {
+		return nil, err
+	}
+	c, err := f.fs.newFile(f, m)
+	if err != nil {
+		return nil, err
+	}
+	// Linux stores small device numbers in the old 16-bit
+	// encoding and others in its 32-bit encoding.
+	if major < 256 && minor < 256 {
+		c.ino.Block[0] = major<<8 | minor
+	} else {
+		c.ino.Block[1] = minor&0xff | major<<8 | (minor&^0xff)<<12
+	}
+	c.ino.Nlink = 1
+	if err := f.fs.writeInode(c); err != nil {
+		return nil, err
+	}
+	if err := f.addEntry(name, c.inum, m); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Chmod changes the permission bits of f,
+// including the setuid, setgid, and sticky bits.
+func (f *File) Chmod(mode os.FileMode) error {
+	if err := f.fs.writable(); err != nil {
+		return err
+	}
+	m, err := inodeMode(mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky))
+	if err != nil {
+		return err
+	}
+	f.ino.Mode = f.ino.Mode&ifmt | m&^ifmt
+	f.touch(false)
+	return f.fs.writeInode(f)
+}
+
+// Chown changes the user and group IDs of f.
+func (f *File) Chown(uid, gid int) error {
+	if err := f.fs.writable(); err != nil {
+		return err
+	}
+	if uint64(uid) > 1<<32-1 || uint64(gid) > 1<<32-1 {
+		return fmt.Errorf("invalid owner %d:%d", uid, gid)
+	}
+	f.ino.Uid, f.ino.Uidhi = uint16(uid), uint16(uid>>16)
+	f.ino.Gid, f.ino.Gidhi = uint16(gid), uint16(gid>>16)
+	f.touch(false)
+	return f.fs.writeInode(f)
+}
+
+// Chtimes changes the access and modification times of f.
+func (f *File) Chtimes(atime, mtime time.Time) error {
+	if err := f.fs.writable(); err != nil {
+		return err
+	}
+	f.setTimes(atime, mtime, f.fs.now())
+	return f.fs.writeInode(f)
+}
+
+// Remove removes the entry called name from the directory f.
+// If the entry is a directory, it must be empty.
+// Removing the last link to a file frees its blocks and inode.
+func (f *File) Remove(name string) error {
+	if err := f.fs.writable(); err != nil {
+		return err
+	}
+	if name == "." || name == ".." {
+		return fmt.Errorf("cannot remove %s", name)
+	}
+	ino, err := f.lookupInode(name)
+	if err != nil {
+		return err
+	}
+	if ino == 0 {
+		return fmt.Errorf("file not found")
+	}
+	c, err := f.fs.File(ino)
+	if err != nil {
+		return err
+	}
+	if c.IsDir() {
+		empty := true
+		err := c.walkDir(func(name []byte, ino uint32) bool {
+			empty = string(name) == "." || string(name) == ".."
+			return empty
+		})
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return fmt.Errorf("directory not empty")
+		}
+		if f.ino.Nlink != 1 {
+			f.ino.Nlink--
+		}
+		c.ino.Nlink = 0
+	} else {
+		c.ino.Nlink--
+	}
+	if _, err := f.removeEntry(name); err != nil {
+		return err
+	}
+	if c.ino.Nlink > 0 {
+		c.touch(false)
+		return f.fs.writeInode(c)
+	}
+	return f.fs.freeFile(c)
+}
@@ -0,0 +1,314 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ext2
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A memImage is a file system image held in memory.
+type memImage []byte
+
+func (m memImage) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m memImage) WriteAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > int64(len(m)) {
+		return 0, fmt.Errorf("write past end of image")
+	}
+	return copy(m[off:], p), nil
+}
+
+var testTime = time.Date(2021, 2, 3, 4, 5, 6, 7, time.UTC)
+
+func openWriteImage(t *testing.T, name string) (*FS, memImage) {
+	img := memImage(readImage(t, name))
+	fs, err := InitWrite(img)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	fs.now = func() time.Time { return testTime }
+	return fs, img
+}
+
+// e2fsck runs e2fsck -fn on the image, if e2fsck is installed.
+func e2fsck(t *testing.T, img memImage) {
+	if _, err := exec.LookPath("e2fsck"); err != nil {
+		return
+	}
+	dir, err := ioutil.TempDir("", "ext2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "img")
+	if err := ioutil.WriteFile(file, img, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("e2fsck", "-fn", file).CombinedOutput(); err != nil {
+		t.Errorf("e2fsck: %v\n%s", err, out)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, name := range images {
+		if err := openImage(t, name).Check(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	// Mark a free block in use.
+	data := readImage(t, "ext2")
+	fs := openImage(t, "ext2")
+	g, err := fs.group(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[int64(g.blockBitmap())*int64(fs.BlockSize)+int64(fs.BlockSize/8-1)] ^= 0x80
+	fs, err = Init(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("block %d marked in use but not used", fs.BlockSize-1+int(fs.firstBlock))
+	if err := fs.Check(); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Check with bad bitmap = %v, want %s", err, want)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	fs, img := openWriteImage(t, "ext2")
+	root, err := fs.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new directory with a file big enough for double indirect blocks.
+	dir, err := root.Mkdir("new", 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := dir.Create("file", 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := []byte(strings.Repeat(dataLines(), 4))
+	for off := 0; off < len(big); off += 10000 {
+		end := off + 10000
+		if end > len(big) {
+			end = len(big)
+		}
+		if _, err := f.WriteAt(big[off:end], int64(off)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Overwrite the middle of an existing file, and write
+	// past the end of the sparse file, using triple indirect blocks.
+	data := lookup(t, fs, "data.bin")
+	if _, err := data.WriteAt([]byte("LINE"), 10); err != nil {
+		t.Fatal(err)
+	}
+	sparse := lookup(t, fs, "sparse")
+	if _, err := sparse.WriteAt([]byte("more"), 80<<20); err != nil {
+		t.Fatal(err)
+	}
+	if err := lookup(t, fs, "holes").Truncate(3<<20 + 5); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := root.Symlink("fast2", "new/file"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dir.Symlink("slow2", strings.Repeat("y", 200)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding to an indexed directory drops its index.
+	bigDir := lookup(t, fs, "big")
+	for i := 0; i < 100; i++ {
+		if _, err := bigDir.Create(fmt.Sprintf("added-%d", i), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if bigDir.isIndexed() {
+		t.Errorf("big is still indexed")
+	}
+	if _, err := bigDir.Create("added-7", 0600); err == nil {
+		t.Errorf("Create of existing file succeeded")
+	}
+
+	for _, name := range []string{"hello.txt", "fast", "slow"} {
+		if err := root.Remove(name); err != nil {
+			t.Errorf("Remove(%s): %v", name, err)
+		}
+	}
+	if err := bigDir.Remove("name-3-000"); err != nil {
+		t.Error(err)
+	}
+	if err := root.Remove("dir"); err == nil {
+		t.Errorf("Remove of non-empty directory succeeded")
+	}
+	sub := lookup(t, fs, "dir/sub")
+	if err := sub.Remove("x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := lookup(t, fs, "dir").Remove("sub"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Check(); err != nil {
+		t.Fatalf("Check after writing: %v", err)
+	}
+	e2fsck(t, img)
+
+	// Read everything back from a fresh read-only FS.
+	fs, err = Init(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := lookup(t, fs, "new/file")
+	if got := readFile(t, file); !bytes.Equal(got, big) {
+		t.Errorf("new/file: read %d bytes, want %d", len(got), len(big))
+	}
+	if m := file.Mode(); m != 0640 {
+		t.Errorf("new/file: Mode = %v, want %v", m, os.FileMode(0640))
+	}
+	if mt := file.ModTime(); !mt.Equal(testTime) {
+		t.Errorf("new/file: ModTime = %v, want %v", mt, testTime)
+	}
+	if m := lookup(t, fs, "new").Mode(); m != os.ModeDir|0750 {
+		t.Errorf("new: Mode = %v, want %v", m, os.ModeDir|0750)
+	}
+	want := dataLines()
+	want = want[:10] + "LINE" + want[14:]
+	if got := readFile(t, lookup(t, fs, "data.bin")); string(got) != want {
+		t.Errorf("data.bin: wrong data after overwrite")
+	}
+	sparse = lookup(t, fs, "sparse")
+	if sparse.Size() != 80<<20+4 {
+		t.Errorf("sparse: Size = %d, want %d", sparse.Size(), 80<<20+4)
+	}
+	buf := make([]byte, 8)
+	for off, want := range map[int64]string{70<<20 - 4: "\x00\x00\x00\x00tail", 80<<20 - 4: "\x00\x00\x00\x00more"} {
+		if _, err := sparse.ReadAt(buf, off); err != nil || string(buf) != want {
+			t.Errorf("sparse: ReadAt(%d) = %q, %v, want %q", off, buf, err, want)
+		}
+	}
+	if size := lookup(t, fs, "holes").Size(); size != 3<<20+5 {
+		t.Errorf("holes: Size = %d, want %d", size, 3<<20+5)
+	}
+	links := map[string]string{"fast2": "new/file", "new/slow2": strings.Repeat("y", 200)}
+	for name, target := range links {
+		if got, err := lookup(t, fs, name).ReadLink(); err != nil || got != target {
+			t.Errorf("ReadLink(%s) = %q, %v, want %q", name, got, err, target)
+		}
+	}
+	dirs, err := lookup(t, fs, "big").ReadDir()
+	if err != nil || len(dirs) != 402+100-1 {
+		t.Errorf("big: ReadDir returned %d entries, %v, want %d", len(dirs), err, 402+100-1)
+	}
+	for _, name := range []string{"hello.txt", "fast", "slow", "big/name-3-000"} {
+		if f, err := lookup(t, fs, filepath.Dir(name)).Lookup(filepath.Base(name)); err == nil {
+			t.Errorf("%s: still present as inode %d", name, f.inum)
+		}
+	}
+	if _, err := lookup(t, fs, "dir").Lookup("sub"); err == nil {
+		t.Errorf("dir/sub: still present")
+	}
+}
+
+func TestWriteFull(t *testing.T) {
+	fs, img := openWriteImage(t, "ext2")
+	root, err := fs.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := root.Create("full", 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := make([]byte, 64<<10)
+	var off int64
+	for {
+		n, err := f.WriteAt(chunk, off)
+		off += int64(n)
+		if err != nil {
+			if err.Error() != "no free blocks" {
+				t.Fatalf("WriteAt: %v", err)
+			}
+			break
+		}
+	}
+	if f.Size() != off {
+		t.Errorf("Size = %d, want %d", f.Size(), off)
+	}
+	if err := fs.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Check(); err != nil {
+		t.Fatalf("Check after filling: %v", err)
+	}
+
+	// Freeing the file returns all its blocks.
+	if err := f.Truncate(100); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.Remove("full"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Check(); err != nil {
+		t.Fatalf("Check after removing: %v", err)
+	}
+	orig := openImage(t, "ext2")
+	if fs.super.Freeblockcount != orig.super.Freeblockcount || fs.super.Freeinodecount != orig.super.Freeinodecount {
+		t.Errorf("free counts %d, %d, want %d, %d", fs.super.Freeblockcount, fs.super.Freeinodecount, orig.super.Freeblockcount, orig.super.Freeinodecount)
+	}
+	e2fsck(t, img)
+}
+
+func TestWriteUnsupported(t *testing.T) {
+	for _, name := range []string{"ext4", "meta"} {
+		_, err := InitWrite(memImage(readImage(t, name)))
+		if err == nil || !strings.Contains(err.Error(), "extent") {
+			t.Errorf("InitWrite(%s) = %v, want error about extents", name, err)
+		}
+	}
+
+	fs := openImage(t, "ext2")
+	f := lookup(t, fs, "hello.txt")
+	if _, err := f.WriteAt([]byte("x"), 0); err == nil {
+		t.Errorf("WriteAt on read-only file system succeeded")
+	}
+	root, err := fs.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := root.Create("x", 0666); err == nil {
+		t.Errorf("Create on read-only file system succeeded")
+	}
+}
@@ -5,7 +5,10 @@
 // Package gf256 implements arithmetic over the Galois Field GF(256).
 package gf256
 
-import "strconv"
+import (
+	"errors"
+	"strconv"
+)
 
 // A Field represents an instance of GF(256) defined by a specific polynomial.
 type Field struct {
@@ -239,3 +242,205 @@ func (rs *RSEncoder) ECC(data []byte, check []byte) {
 	copy(check, p[len(data):])
 	rs.p = p
 }
+
+// An RSDecoder implements Reed-Solomon decoding
+// over a given field using a given number of error correction bytes.
+// It corrects the codewords written by an RSEncoder
+// with the same field and number of error correction bytes.
+type RSDecoder struct {
+	f *Field
+	c int
+}
+
+// NewRSDecoder returns a new Reed-Solomon decoder
+// over the given field and number of error correction bytes.
+func NewRSDecoder(f *Field, c int) *RSDecoder {
+	return &RSDecoder{f: f, c: c}
+}
+
+// ErrTooManyErrors is returned by Correct when a codeword
+// has more errors than can be corrected.
+var ErrTooManyErrors = errors.New("gf256: too many errors")
+
+// Correct corrects, in place, the codeword formed by data followed by check.
+// The erasures list the indexes, counting from the start of data
+// and continuing into check, of bytes known to be wrong.
+// Correct can fix e unknown errors along with r erasures
+// provided 2e+r is at most the number of error correction bytes.
+// It returns the number of bytes it changed.
+func (rs *RSDecoder) Correct(data, check []byte, erasures []int) (int, error) {
+	if len(check) != rs.c {
+		panic("gf256: invalid check byte length")
+	}
+	n := len(data) + len(check)
+	if n > 255 {
+		panic("gf256: codeword too long")
+	}
+	if len(erasures) > rs.c {
+		return 0, ErrTooManyErrors
+	}
+	f := rs.f
+	at := func(i int) *byte {
+		if i < len(data) {
+			return &data[i]
+		}
+		return &check[i-len(data)]
+	}
+
+	// Compute the syndromes s[j] = r(α^j), where r is the
+	// received polynomial with data[0] as its leading coefficient.
+	// If they are all zero, there is nothing to correct.
+	syndromes := func() ([]byte, bool) {
+		s := make([]byte, rs.c)
+		zero := true
+		for j := range s {
+			x := f.Exp(j)
+			var v byte
+			for i := 0; i < n; i++ {
+				v = f.Mul(v, x) ^ *at(i)
+			}
+			s[j] = v
+			if v != 0 {
+				zero = false
+			}
+		}
+		return s, zero
+	}
+	s, zero := syndromes()
+	if zero {
+		return 0, nil
+	}
+
+	// The byte at index i is the coefficient of x^(n-1-i),
+	// so its locator is α^(n-1-i).
+	// Polynomials below are stored lowest degree first.
+
+	// Erasure locator: Γ(x) = Π (1 - X_k x).
+	gamma := []byte{1}
+	for _, i := range erasures {
+		if i < 0 || i >= n {
+			panic("gf256: invalid erasure index")
+		}
+		gamma = f.polyMul(gamma, []byte{1, f.Exp(n - 1 - i)})
+	}
+
+	// Berlekamp-Massey, initialized with the erasure locator,
+	// finds the error-and-erasure locator Λ.
+	ne := len(erasures)
+	lambda := append([]byte(nil), gamma...)
+	prev := append([]byte(nil), gamma...)
+	l := ne
+	m := 1
+	b := byte(1)
+	for k := ne; k < rs.c; k++ {
+		var d byte
+		for i, c := range lambda {
+			if i <= k {
+				d ^= f.Mul(c, s[k-i])
+			}
+		}
+		if d == 0 {
+			m++
+			continue
+		}
+		// λ -= d/b x^m prev
+		scale := f.Mul(d, f.Inv(b))
+		next := make([]byte, len(prev)+m)
+		if len(lambda) > len(next) {
+			next = make([]byte, len(lambda))
+		}
+		copy(next, lambda)
+		for i, c := range prev {
+			next[i+m] ^= f.Mul(c, scale)
+		}
+		if 2*l <= k+ne {
+			l = k + 1 + ne - l
+			prev, b, m = lambda, d, 1
+		} else {
+			m++
+		}
+		lambda = next
+	}
+	lambda = trim(lambda)
+	if len(lambda)-1 != l || 2*(l-ne)+ne > rs.c {
+		return 0, ErrTooManyErrors
+	}
+
+	// Chien search: the roots of Λ are the inverse error locators.
+	var pos []int
+	for i := 0; i < n; i++ {
+		if f.polyEval(lambda, f.Exp(255-(n-1-i))) == 0 {
+			pos = append(pos, i)
+		}
+	}
+	if len(pos) != l {
+		return 0, ErrTooManyErrors
+	}
+
+	// Forney: the error value at locator X is
+	// X Ω(1/X) / Λ'(1/X), where Ω = SΛ mod x^c.
+	omega := f.polyMul(s, lambda)
+	if len(omega) > rs.c {
+		omega = omega[:rs.c]
+	}
+	deriv := make([]byte, len(lambda)-1)
+	for i := 1; i < len(lambda); i += 2 {
+		deriv[i-1] = lambda[i]
+	}
+	errs := make([]byte, len(pos))
+	for k, i := range pos {
+		xinv := f.Exp(255 - (n - 1 - i))
+		den := f.polyEval(deriv, xinv)
+		if den == 0 {
+			return 0, ErrTooManyErrors
+		}
+		errs[k] = f.Mul(f.Exp(n-1-i), f.Mul(f.polyEval(omega, xinv), f.Inv(den)))
+	}
+
+	// Apply the corrections and double-check that the result
+	// is a codeword, undoing the corrections if not.
+	fixed := 0
+	for k, i := range pos {
+		*at(i) ^= errs[k]
+		if errs[k] != 0 {
+			fixed++
+		}
+	}
+	if _, zero := syndromes(); !zero {
+		for k, i := range pos {
+			*at(i) ^= errs[k]
+		}
+		return 0, ErrTooManyErrors
+	}
+	return fixed, nil
+}
+
+// polyMul returns the product of the polynomials p and q,
+// stored lowest degree first.
+func (f *Field) polyMul(p, q []byte) []byte {
+	r := make([]byte, len(p)+len(q)-1)
+	for i, x := range p {
+		for j, y := range q {
+			r[i+j] ^= f.Mul(x, y)
+		}
+	}
+	return r
+}
+
+// polyEval returns the value of the polynomial p,
+// stored lowest degree first, at x.
+func (f *Field) polyEval(p []byte, x byte) byte {
+	var v byte
+	for i := len(p) - 1; i >= 0; i-- {
+		v = f.Mul(v, x) ^ p[i]
+	}
+	return v
+}
+
+// trim returns p with its high-order zero coefficients removed.
+func trim(p []byte) []byte {
+	for len(p) > 1 && p[len(p)-1] == 0 {
+		p = p[:len(p)-1]
+	}
+	return p
+}
@@ -7,6 +7,7 @@ package gf256
 import (
 	"bytes"
 	"fmt"
+	"math/rand"
 	"testing"
 )
 
@@ -192,3 +193,73 @@ func generates(α, poly int) bool {
 	}
 	return true
 }
+
+func TestCorrect(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, c := range []int{2, 7, 10, 17, 30} {
+		enc := NewRSEncoder(f, c)
+		dec := NewRSDecoder(f, c)
+		for iter := 0; iter < 200; iter++ {
+			data := make([]byte, 1+r.Intn(255-c))
+			r.Read(data)
+			check := make([]byte, c)
+			enc.ECC(data, check)
+			n := len(data) + c
+			want := append(append([]byte(nil), data...), check...)
+
+			// Damage the codeword with e errors and ne erasures, 2e+ne <= c.
+			ne := r.Intn(c + 1)
+			e := r.Intn((c-ne)/2 + 1)
+			perm := r.Perm(n)
+			erasures := perm[:ne]
+			nbad := 0
+			for k, i := range perm[:ne+e] {
+				old := want[i]
+				v := byte(r.Intn(256))
+				if k >= ne {
+					for v == old {
+						v = byte(r.Intn(256))
+					}
+				}
+				if v != old {
+					nbad++
+				}
+				if i < len(data) {
+					data[i] = v
+				} else {
+					check[i-len(data)] = v
+				}
+			}
+
+			fixed, err := dec.Correct(data, check, erasures)
+			if err != nil {
+				t.Errorf("c=%d n=%d e=%d ne=%d: Correct: %v", c, n, e, ne, err)
+				continue
+			}
+			if have := append(append([]byte(nil), data...), check...); !bytes.Equal(have, want) {
+				t.Errorf("c=%d n=%d e=%d ne=%d: Correct = %x, want %x", c, n, e, ne, have, want)
+				continue
+			}
+			if fixed != nbad {
+				t.Errorf("c=%d n=%d e=%d ne=%d: Correct fixed %d bytes, want %d", c, n, e, ne, fixed, nbad)
+			}
+		}
+	}
+}
+
+func TestCorrectTooMany(t *testing.T) {
+	data := []byte{0x10, 0x20, 0x0c, 0x56, 0x61, 0x80, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11}
+	check := []byte{0xa5, 0x24, 0xd4, 0xc1, 0xed, 0x36, 0xc7, 0x87, 0x2c, 0x55}
+	dec := NewRSDecoder(f, len(check))
+	bad := append([]byte(nil), data...)
+	for i := 0; i < 6; i++ {
+		bad[i] ^= 0xff
+	}
+	save := append([]byte(nil), bad...)
+	if _, err := dec.Correct(bad, check, nil); err != ErrTooManyErrors {
+		t.Errorf("Correct with 6 errors: err = %v, want ErrTooManyErrors", err)
+	}
+	if !bytes.Equal(bad, save) {
+		t.Errorf("failed Correct modified data")
+	}
+}
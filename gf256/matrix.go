@@ -0,0 +1,257 @@
+// Copyright 2010 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gf256
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// A Matrix is a rectangular matrix with entries in a Field.
+type Matrix struct {
+	f    *Field
+	rows int
+	cols int
+	m    []byte // entries, row by row
+}
+
+// ErrSingular is returned when inverting or solving with a singular matrix.
+var ErrSingular = errors.New("gf256: singular matrix")
+
+// NewMatrix returns a rows×cols zero matrix over f.
+func NewMatrix(f *Field, rows, cols int) *Matrix {
+	if rows < 0 || cols < 0 {
+		panic("gf256: invalid matrix size")
+	}
+	return &Matrix{f, rows, cols, make([]byte, rows*cols)}
+}
+
+// Identity returns the n×n identity matrix over f.
+func Identity(f *Field, n int) *Matrix {
+	m := NewMatrix(f, n, n)
+	for i := 0; i < n; i++ {
+		m.m[i*n+i] = 1
+	}
+	return m
+}
+
+// Vandermonde returns the len(xs)×cols Vandermonde matrix over f,
+// in which row i is 1, xs[i], xs[i]^2, ..., xs[i]^(cols-1).
+// Any cols rows with distinct xs form an invertible matrix.
+func Vandermonde(f *Field, xs []byte, cols int) *Matrix {
+	m := NewMatrix(f, len(xs), cols)
+	for i, x := range xs {
+		v := byte(1)
+		for j := 0; j < cols; j++ {
+			m.m[i*cols+j] = v
+			v = f.Mul(v, x)
+		}
+	}
+	return m
+}
+
+// Cauchy returns the len(xs)×len(ys) Cauchy matrix over f,
+// whose entry (i, j) is 1/(xs[i] + ys[j]).
+// The xs must be distinct from each other and from the ys, and vice versa.
+// Every square submatrix of a Cauchy matrix is invertible.
+func Cauchy(f *Field, xs, ys []byte) *Matrix {
+	m := NewMatrix(f, len(xs), len(ys))
+	for i, x := range xs {
+		for j, y := range ys {
+			if x == y {
+				panic("gf256: Cauchy matrix with xs[i] == ys[j]")
+			}
+			m.m[i*m.cols+j] = f.Inv(x ^ y)
+		}
+	}
+	return m
+}
+
+// Field returns the field containing m's entries.
+func (m *Matrix) Field() *Field { return m.f }
+
+// Rows returns the number of rows in m.
+func (m *Matrix) Rows() int { return m.rows }
+
+// Cols returns the number of columns in m.
+func (m *Matrix) Cols() int { return m.cols }
+
+// At returns the entry in row i, column j.
+func (m *Matrix) At(i, j int) byte {
+	m.index(i, j)
+	return m.m[i*m.cols+j]
+}
+
+// Set sets the entry in row i, column j to x.
+func (m *Matrix) Set(i, j int, x byte) {
+	m.index(i, j)
+	m.m[i*m.cols+j] = x
+}
+
+func (m *Matrix) index(i, j int) {
+	if i < 0 || i >= m.rows || j < 0 || j >= m.cols {
+		panic("gf256: matrix index out of range")
+	}
+}
+
+// Row returns row i of m.  The result shares storage with m.
+func (m *Matrix) Row(i int) []byte {
+	m.index(i, 0)
+	return m.m[i*m.cols : (i+1)*m.cols]
+}
+
+// Clone returns a copy of m.
+func (m *Matrix) Clone() *Matrix {
+	n := NewMatrix(m.f, m.rows, m.cols)
+	copy(n.m, m.m)
+	return n
+}
+
+// SubRows returns the matrix made of the given rows of m, in order.
+func (m *Matrix) SubRows(rows ...int) *Matrix {
+	n := NewMatrix(m.f, len(rows), m.cols)
+	for k, i := range rows {
+		copy(n.Row(k), m.Row(i))
+	}
+	return n
+}
+
+// Equal reports whether m and n are the same matrix.
+func (m *Matrix) Equal(n *Matrix) bool {
+	return m.f == n.f && m.rows == n.rows && m.cols == n.cols && bytes.Equal(m.m, n.m)
+}
+
+func (m *Matrix) String() string {
+	var buf bytes.Buffer
+	for i := 0; i < m.rows; i++ {
+		fmt.Fprintf(&buf, "%x\n", m.Row(i))
+	}
+	return buf.String()
+}
+
+// Mul returns the matrix product m×n.
+func (m *Matrix) Mul(n *Matrix) *Matrix {
+	if m.f != n.f {
+		panic("gf256: matrices over different fields")
+	}
+	if m.cols != n.rows {
+		panic("gf256: matrix size mismatch")
+	}
+	p := NewMatrix(m.f, m.rows, n.cols)
+	for i := 0; i < m.rows; i++ {
+		row := p.Row(i)
+		for k, x := range m.Row(i) {
+			if x == 0 {
+				continue
+			}
+			for j, y := range n.Row(k) {
+				row[j] ^= m.f.Mul(x, y)
+			}
+		}
+	}
+	return p
+}
+
+// MulVec returns the product of m and the column vector v.
+func (m *Matrix) MulVec(v []byte) []byte {
+	if len(v) != m.cols {
+		panic("gf256: matrix size mismatch")
+	}
+	out := make([]byte, m.rows)
+	for i := range out {
+		var s byte
+		for j, x := range m.Row(i) {
+			s ^= m.f.Mul(x, v[j])
+		}
+		out[i] = s
+	}
+	return out
+}
+
+// Invert returns the inverse of the square matrix m.
+// It returns ErrSingular if m has no inverse.
+func (m *Matrix) Invert() (*Matrix, error) {
+	if m.rows != m.cols {
+		panic("gf256: inverting non-square matrix")
+	}
+	inv := Identity(m.f, m.rows)
+	if err := m.Clone().gaussJordan(inv); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Solve returns the vector x such that m×x = b,
+// for the square matrix m.
+// It returns ErrSingular if there is no unique solution.
+func (m *Matrix) Solve(b []byte) ([]byte, error) {
+	if m.rows != m.cols || len(b) != m.rows {
+		panic("gf256: matrix size mismatch")
+	}
+	x := NewMatrix(m.f, len(b), 1)
+	copy(x.m, b)
+	if err := m.Clone().gaussJordan(x); err != nil {
+		return nil, err
+	}
+	return x.m, nil
+}
+
+// gaussJordan reduces the square matrix m to the identity,
+// applying the same row operations to aug.
+func (m *Matrix) gaussJordan(aug *Matrix) error {
+	f := m.f
+	n := m.rows
+	for c := 0; c < n; c++ {
+		// Find a pivot and move it into place.
+		p := c
+		for p < n && m.m[p*n+c] == 0 {
+			p++
+		}
+		if p == n {
+			return ErrSingular
+		}
+		if p != c {
+			swap(m.Row(p), m.Row(c))
+			swap(aug.Row(p), aug.Row(c))
+		}
+
+		// Scale the pivot row so the pivot is 1.
+		if k := m.m[c*n+c]; k != 1 {
+			k = f.Inv(k)
+			scale(f, m.Row(c), k)
+			scale(f, aug.Row(c), k)
+		}
+
+		// Clear the rest of the column.
+		for r := 0; r < n; r++ {
+			if k := m.m[r*n+c]; r != c && k != 0 {
+				addMul(f, m.Row(r), m.Row(c), k)
+				addMul(f, aug.Row(r), aug.Row(c), k)
+			}
+		}
+	}
+	return nil
+}
+
+func swap(x, y []byte) {
+	for i := range x {
+		x[i], y[i] = y[i], x[i]
+	}
+}
+
+// scale sets x = k*x.
+func scale(f *Field, x []byte, k byte) {
+	for i := range x {
+		x[i] = f.Mul(x[i], k)
+	}
+}
+
+// addMul sets x = x + k*y.
+func addMul(f *Field, x, y []byte, k byte) {
+	for i := range x {
+		x[i] ^= f.Mul(k, y[i])
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2010 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gf256
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestMatrixInvert(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for n := 1; n <= 10; n++ {
+		m := NewMatrix(f, n, n)
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				m.Set(i, j, byte(r.Intn(256)))
+			}
+		}
+		inv, err := m.Invert()
+		if err == ErrSingular {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := m.Mul(inv); !p.Equal(Identity(f, n)) {
+			t.Errorf("m × m⁻¹ =\n%v\nm =\n%v", p, m)
+		}
+	}
+
+	m := NewMatrix(f, 2, 2)
+	m.Set(0, 0, 3)
+	m.Set(0, 1, 5)
+	m.Set(1, 0, f.Mul(3, 7))
+	m.Set(1, 1, f.Mul(5, 7))
+	if _, err := m.Invert(); err != ErrSingular {
+		t.Errorf("Invert of singular matrix: %v", err)
+	}
+}
+
+// TestErasure checks the use of Vandermonde and Cauchy
+// matrices for erasure coding: any k of the n encoded
+// symbols suffice to recover the k data symbols.
+func TestErasure(t *testing.T) {
+	const k, n = 4, 7
+	data := []byte{0x12, 0x34, 0x56, 0x78}
+
+	xs := make([]byte, n)
+	for i := range xs {
+		xs[i] = byte(i + 1)
+	}
+	ys := []byte{0x80, 0x81, 0x82, 0x83}
+	for _, enc := range []*Matrix{Vandermonde(f, xs, k), Cauchy(f, xs, ys)} {
+		coded := enc.MulVec(data)
+		for _, rows := range [][]int{{0, 1, 2, 3}, {3, 4, 5, 6}, {0, 2, 4, 6}, {6, 1, 5, 2}} {
+			b := make([]byte, k)
+			for i, r := range rows {
+				b[i] = coded[r]
+			}
+			out, err := enc.SubRows(rows...).Solve(b)
+			if err != nil {
+				t.Fatalf("Solve(rows %v): %v", rows, err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Errorf("Solve(rows %v) = %x, want %x", rows, out, data)
+			}
+		}
+	}
+}
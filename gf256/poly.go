@@ -0,0 +1,215 @@
+// Copyright 2010 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gf256
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A Poly is a polynomial with coefficients in a Field.
+// Polys are immutable: the arithmetic methods return new values.
+type Poly struct {
+	f *Field
+	c []byte // coefficients, lowest degree first, with no trailing zeros
+}
+
+// NewPoly returns the polynomial over f with the given coefficients,
+// lowest degree first: coef[i] is the coefficient of x^i.
+func NewPoly(f *Field, coef ...byte) *Poly {
+	c := make([]byte, len(coef))
+	copy(c, coef)
+	return &Poly{f, strip(c)}
+}
+
+// strip returns p with its high-order zero coefficients removed.
+// Unlike trim, it strips the zero polynomial down to no coefficients.
+func strip(p []byte) []byte {
+	for len(p) > 0 && p[len(p)-1] == 0 {
+		p = p[:len(p)-1]
+	}
+	return p
+}
+
+// Field returns the field containing p's coefficients.
+func (p *Poly) Field() *Field {
+	return p.f
+}
+
+// Degree returns the degree of p.
+// The zero polynomial has degree -1.
+func (p *Poly) Degree() int {
+	return len(p.c) - 1
+}
+
+// Coef returns the coefficient of x^i in p.
+func (p *Poly) Coef(i int) byte {
+	if i < 0 || i >= len(p.c) {
+		return 0
+	}
+	return p.c[i]
+}
+
+// Coefs returns a copy of p's coefficients, lowest degree first.
+func (p *Poly) Coefs() []byte {
+	c := make([]byte, len(p.c))
+	copy(c, p.c)
+	return c
+}
+
+// IsZero reports whether p is the zero polynomial.
+func (p *Poly) IsZero() bool {
+	return len(p.c) == 0
+}
+
+// Equal reports whether p and q are the same polynomial.
+func (p *Poly) Equal(q *Poly) bool {
+	return p.f == q.f && bytes.Equal(p.c, q.c)
+}
+
+func (p *Poly) String() string {
+	if len(p.c) == 0 {
+		return "0"
+	}
+	var buf bytes.Buffer
+	for i := len(p.c) - 1; i >= 0; i-- {
+		if p.c[i] == 0 {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString(" + ")
+		}
+		switch {
+		case i == 0:
+			fmt.Fprintf(&buf, "%#x", p.c[i])
+		case p.c[i] != 1:
+			fmt.Fprintf(&buf, "%#x·", p.c[i])
+		}
+		switch {
+		case i == 1:
+			buf.WriteString("x")
+		case i > 1:
+			fmt.Fprintf(&buf, "x^%d", i)
+		}
+	}
+	return buf.String()
+}
+
+func (p *Poly) check(q *Poly) {
+	if p.f != q.f {
+		panic("gf256: polynomials over different fields")
+	}
+}
+
+// Eval returns the value of p at x.
+func (p *Poly) Eval(x byte) byte {
+	return p.f.polyEval(p.c, x)
+}
+
+// Add returns the sum p+q, which is also the difference p-q.
+func (p *Poly) Add(q *Poly) *Poly {
+	p.check(q)
+	n := len(p.c)
+	if len(q.c) > n {
+		n = len(q.c)
+	}
+	c := make([]byte, n)
+	copy(c, p.c)
+	for i, x := range q.c {
+		c[i] ^= x
+	}
+	return &Poly{p.f, strip(c)}
+}
+
+// Scale returns the product of p and the constant x.
+func (p *Poly) Scale(x byte) *Poly {
+	c := make([]byte, len(p.c))
+	for i, y := range p.c {
+		c[i] = p.f.Mul(x, y)
+	}
+	return &Poly{p.f, strip(c)}
+}
+
+// Mul returns the product p*q.
+func (p *Poly) Mul(q *Poly) *Poly {
+	p.check(q)
+	if len(p.c) == 0 || len(q.c) == 0 {
+		return &Poly{f: p.f}
+	}
+	return &Poly{p.f, strip(p.f.polyMul(p.c, q.c))}
+}
+
+// DivMod returns the quotient and remainder of p divided by q.
+// It panics if q is the zero polynomial.
+func (p *Poly) DivMod(q *Poly) (quo, rem *Poly) {
+	p.check(q)
+	if len(q.c) == 0 {
+		panic("gf256: division by zero polynomial")
+	}
+	f := p.f
+	r := p.Coefs()
+	nq := len(q.c)
+	inv := f.Inv(q.c[nq-1])
+	var d []byte
+	if len(r) >= nq {
+		d = make([]byte, len(r)-nq+1)
+	}
+	for i := len(r) - nq; i >= 0; i-- {
+		k := f.Mul(r[i+nq-1], inv)
+		d[i] = k
+		if k == 0 {
+			continue
+		}
+		for j, y := range q.c {
+			r[i+j] ^= f.Mul(k, y)
+		}
+	}
+	return &Poly{f, strip(d)}, &Poly{f, strip(r)}
+}
+
+// Monic returns p scaled so that its leading coefficient is 1.
+// The zero polynomial is returned unchanged.
+func (p *Poly) Monic() *Poly {
+	if len(p.c) == 0 {
+		return p
+	}
+	return p.Scale(p.f.Inv(p.c[len(p.c)-1]))
+}
+
+// GCD returns the monic greatest common divisor of p and q.
+// If both are zero, GCD returns the zero polynomial.
+func GCD(p, q *Poly) *Poly {
+	p.check(q)
+	for !q.IsZero() {
+		_, r := p.DivMod(q)
+		p, q = q, r
+	}
+	return p.Monic()
+}
+
+// Interpolate returns the polynomial of lowest degree that passes through
+// the points (xs[i], ys[i]).  The xs must be distinct.
+func Interpolate(f *Field, xs, ys []byte) *Poly {
+	if len(xs) != len(ys) {
+		panic("gf256: mismatched interpolation points")
+	}
+	// Lagrange: sum over i of ys[i] * prod over j≠i of (x - xs[j]) / (xs[i] - xs[j]).
+	sum := NewPoly(f)
+	for i := range xs {
+		term := NewPoly(f, ys[i])
+		for j := range xs {
+			if j == i {
+				continue
+			}
+			d := xs[i] ^ xs[j]
+			if d == 0 {
+				panic("gf256: duplicate interpolation point")
+			}
+			term = term.Mul(NewPoly(f, xs[j], 1)).Scale(f.Inv(d))
+		}
+		sum = sum.Add(term)
+	}
+	return sum
+}
@@ -0,0 +1,86 @@
+// Copyright 2010 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gf256
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func randPoly(r *rand.Rand, deg int) *Poly {
+	c := make([]byte, deg+1)
+	for i := range c {
+		c[i] = byte(r.Intn(256))
+	}
+	c[deg] |= 1
+	return NewPoly(f, c...)
+}
+
+func TestPolyEval(t *testing.T) {
+	// (x + 2)(x + 3) = x^2 + x + 6 over GF(256).
+	p := NewPoly(f, 2, 1).Mul(NewPoly(f, 3, 1))
+	if want := NewPoly(f, 6, 1, 1); !p.Equal(want) {
+		t.Errorf("(x+2)(x+3) = %v, want %v", p, want)
+	}
+	for x := 0; x < 256; x++ {
+		v := p.Eval(byte(x))
+		if (v == 0) != (x == 2 || x == 3) {
+			t.Errorf("p(%#x) = %#x", x, v)
+		}
+	}
+	if s := p.String(); s != "x^2 + x + 0x6" {
+		t.Errorf("String = %q", s)
+	}
+	if d := NewPoly(f, 0, 0).Degree(); d != -1 {
+		t.Errorf("zero polynomial has degree %d", d)
+	}
+}
+
+func TestPolyDivMod(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		p := randPoly(r, r.Intn(20))
+		q := randPoly(r, r.Intn(10))
+		quo, rem := p.DivMod(q)
+		if rem.Degree() >= q.Degree() {
+			t.Fatalf("%v / %v: remainder %v has degree >= divisor", p, q, rem)
+		}
+		if back := quo.Mul(q).Add(rem); !back.Equal(p) {
+			t.Fatalf("%v / %v = %v rem %v, but q*quo+rem = %v", p, q, quo, rem, back)
+		}
+	}
+}
+
+func TestGCD(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		g := randPoly(r, 1+r.Intn(5)).Monic()
+		a := randPoly(r, r.Intn(6))
+		b := randPoly(r, r.Intn(6))
+		d := GCD(g.Mul(a), g.Mul(b))
+		if _, rem := d.DivMod(g); !rem.IsZero() {
+			t.Fatalf("GCD(%v, %v) = %v, not divisible by %v", g.Mul(a), g.Mul(b), d, g)
+		}
+		if d.Coef(d.Degree()) != 1 {
+			t.Fatalf("GCD %v is not monic", d)
+		}
+	}
+	if d := GCD(NewPoly(f, 2, 1), NewPoly(f, 3, 1)); !d.Equal(NewPoly(f, 1)) {
+		t.Errorf("GCD(x+2, x+3) = %v, want 1", d)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	p := randPoly(r, 5)
+	xs := []byte{1, 7, 9, 33, 200, 255}
+	ys := make([]byte, len(xs))
+	for i, x := range xs {
+		ys[i] = p.Eval(x)
+	}
+	if q := Interpolate(f, xs, ys); !q.Equal(p) {
+		t.Errorf("Interpolate = %v, want %v", q, p)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2010 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package shamir implements Shamir's secret sharing over GF(256).
+//
+// Split divides a secret into n shares such that any k of them
+// can be combined to recover the secret, while k-1 or fewer shares
+// reveal nothing about it.  Each byte of the secret is shared
+// independently, as the constant term of a random polynomial of
+// degree k-1; each share holds the value of those polynomials at
+// a different nonzero point.
+//
+// A share is one byte longer than the secret: the first byte is
+// the share's evaluation point and the rest are the values.
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"code.google.com/p/rsc/gf256"
+)
+
+// field is the field used for sharing,
+// the same one used by QR codes.
+var field = gf256.NewField(0x11d, 2)
+
+// Split splits secret into n shares, any k of which
+// suffice to reconstruct it.  It requires 1 <= k <= n <= 255.
+func Split(secret []byte, n, k int) ([][]byte, error) {
+	return split(rand.Reader, secret, n, k)
+}
+
+func split(r io.Reader, secret []byte, n, k int) ([][]byte, error) {
+	if k < 1 || n < k || n > 255 {
+		return nil, fmt.Errorf("shamir: invalid threshold %d of %d shares", k, n)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("shamir: empty secret")
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, 1+len(secret))
+		shares[i][0] = byte(i + 1)
+	}
+	coef := make([]byte, k)
+	for j, s := range secret {
+		coef[0] = s
+		if _, err := io.ReadFull(r, coef[1:]); err != nil {
+			return nil, err
+		}
+		p := gf256.NewPoly(field, coef...)
+		for _, share := range shares {
+			share[1+j] = p.Eval(share[0])
+		}
+	}
+	for i := range coef {
+		coef[i] = 0
+	}
+	return shares, nil
+}
+
+// Combine reconstructs a secret from shares created by Split.
+// If given fewer shares than the threshold passed to Split,
+// Combine returns a meaningless result: the shares themselves
+// do not record the threshold.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("shamir: no shares")
+	}
+	n := len(shares[0])
+	if n < 2 {
+		return nil, errors.New("shamir: share too short")
+	}
+	xs := make([]byte, len(shares))
+	for i, share := range shares {
+		if len(share) != n {
+			return nil, errors.New("shamir: shares have different lengths")
+		}
+		if share[0] == 0 {
+			return nil, errors.New("shamir: invalid share")
+		}
+		for _, x := range xs[:i] {
+			if x == share[0] {
+				return nil, errors.New("shamir: duplicate share")
+			}
+		}
+		xs[i] = share[0]
+	}
+
+	// The secret is the value of each polynomial at 0,
+	// which by Lagrange interpolation is a weighted sum
+	// of the shares: weight i is the product over j ≠ i
+	// of xs[j] / (xs[i] - xs[j]).
+	w := make([]byte, len(xs))
+	for i, xi := range xs {
+		w[i] = 1
+		for j, xj := range xs {
+			if j != i {
+				w[i] = field.Mul(w[i], field.Mul(xj, field.Inv(xi^xj)))
+			}
+		}
+	}
+	secret := make([]byte, n-1)
+	for i, share := range shares {
+		for j, y := range share[1:] {
+			secret[j] ^= field.Mul(w[i], y)
+		}
+	}
+	return secret, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2010 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package shamir
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("Split returned %d shares, want 5", len(shares))
+	}
+
+	// Every subset of 3 or more shares recovers the secret.
+	for mask := 0; mask < 1<<5; mask++ {
+		var subset [][]byte
+		for i := range shares {
+			if mask&(1<<uint(i)) != 0 {
+				subset = append(subset, shares[i])
+			}
+		}
+		if len(subset) < 3 {
+			continue
+		}
+		out, err := Combine(subset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, secret) {
+			t.Errorf("Combine(subset %#x) = %q, want %q", mask, out, secret)
+		}
+	}
+
+	// Two shares are not enough.
+	if out, _ := Combine(shares[:2]); bytes.Equal(out, secret) {
+		t.Errorf("Combine with 2 of 3 shares recovered secret")
+	}
+}
+
+func TestThresholdOne(t *testing.T) {
+	secret := []byte{0, 1, 2, 255}
+	shares, err := Split(secret, 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range shares {
+		if !bytes.Equal(s[1:], secret) {
+			t.Errorf("threshold 1 share = %x, want secret %x", s[1:], secret)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, nk := range [][2]int{{3, 0}, {2, 3}, {256, 2}} {
+		if _, err := Split([]byte("x"), nk[0], nk[1]); err == nil {
+			t.Errorf("Split(n=%d, k=%d) succeeded", nk[0], nk[1])
+		}
+	}
+	if _, err := Split(nil, 3, 2); err == nil {
+		t.Errorf("Split of empty secret succeeded")
+	}
+
+	shares, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range [][][]byte{
+		nil,
+		{shares[0], shares[0]},
+		{shares[0], shares[1][:3]},
+		{shares[0], append([]byte{0}, shares[1][1:]...)},
+	} {
+		if _, err := Combine(bad); err == nil {
+			t.Errorf("Combine(%x) succeeded", bad)
+		}
+	}
+}
@@ -3,11 +3,21 @@
 // license that can be found in the LICENSE file.
 
 // Package keychain implements access to the passwords and other keys
-// stored in the system-provided keychain.
+// stored in the system-provided keychain and in other password stores.
+//
+// The package-level functions use the Default store, which consults,
+// in order:
+//
+//   - the environment (see EnvStore);
+//   - on OS X, the system keychain;
+//   - the encrypted file named by $KEYCHAIN_FILE (default $HOME/.keychain),
+//     if the password for it is given in $KEYCHAIN_PASSWORD (see FileStore);
+//   - the netrc file named by $NETRC (default $HOME/.netrc) (see NetrcStore).
+//
+// Set stores passwords in the first of these that can hold them.
+// The environment and the netrc file are only read, never written.
 package keychain
 
-// BUG(rsc): Package keychain is only implemented on OS X.
-
 import (
 	"fmt"
 )
@@ -16,7 +26,7 @@ import (
 // to the named server.  If the user argument is non-empty, UserPasswd
 // restricts its search to passwords for the named user.
 func UserPasswd(server, preferredUser string) (user, passwd string, err error) {
-	user, passwd, err = userPasswd(server, preferredUser)
+	user, passwd, err = Default.UserPasswd(server, preferredUser)
 	if err != nil {
 		if preferredUser != "" {
 			err = fmt.Errorf("loading password for %s@%s: %v", preferredUser, server, err)
@@ -26,3 +36,25 @@ func UserPasswd(server, preferredUser string) (user, passwd string, err error) {
 	}
 	return
 }
+
+// Set records passwd as the password for user at the named server,
+// replacing any existing password for that user and server.
+func Set(server, user, passwd string) error {
+	if err := Default.Set(server, user, passwd); err != nil {
+		return fmt.Errorf("saving password for %s@%s: %v", user, server, err)
+	}
+	return nil
+}
+
+// Delete removes the password for user at the named server.
+// If user is empty, Delete removes the passwords for all users
+// of the server.
+func Delete(server, user string) error {
+	if err := Default.Delete(server, user); err != nil {
+		if user != "" {
+			return fmt.Errorf("deleting password for %s@%s: %v", user, server, err)
+		}
+		return fmt.Errorf("deleting password for %s: %v", server, err)
+	}
+	return nil
+}
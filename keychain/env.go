@@ -0,0 +1,53 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package keychain
+
+import (
+	"os"
+	"strings"
+)
+
+// An EnvStore is a read-only Store that takes passwords from
+// environment variables, for use on build machines and in other
+// settings with no keychain.  The user name and password for a
+// server such as s3.amazonaws.com are read from the variables
+// KEYCHAIN_S3_AMAZONAWS_COM_USER and KEYCHAIN_S3_AMAZONAWS_COM_PASSWORD:
+// the server name is converted to upper case and each character other
+// than a letter or digit is replaced by an underscore.
+type EnvStore struct{}
+
+// envPrefix returns the prefix of the variables for server.
+func envPrefix(server string) string {
+	return "KEYCHAIN_" + strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z':
+			return r - 'a' + 'A'
+		case 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return r
+		}
+		return '_'
+	}, server) + "_"
+}
+
+func (EnvStore) UserPasswd(server, user string) (user1, passwd string, err error) {
+	prefix := envPrefix(server)
+	passwd, ok := os.LookupEnv(prefix + "PASSWORD")
+	if !ok {
+		return "", "", ErrNotFound
+	}
+	user1 = os.Getenv(prefix + "USER")
+	if user != "" && user != user1 {
+		return "", "", ErrNotFound
+	}
+	return user1, passwd, nil
+}
+
+func (EnvStore) Set(server, user, passwd string) error {
+	return ErrReadOnly
+}
+
+func (EnvStore) Delete(server, user string) error {
+	return ErrReadOnly
+}
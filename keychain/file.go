@@ -0,0 +1,138 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package keychain
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"code.google.com/p/rsc/crypt"
+)
+
+// A FileStore is a Store kept in a file encrypted with package crypt.
+// A FileStore for a file that does not exist is empty;
+// the file is created by the first call to Set.
+type FileStore struct {
+	file     string
+	password string
+	mu       sync.Mutex
+}
+
+// NewFileStore returns a FileStore using the named file,
+// encrypted with the given password.
+func NewFileStore(file, password string) *FileStore {
+	return &FileStore{file: file, password: password}
+}
+
+// A fileEntry is a single password in a FileStore.
+type fileEntry struct {
+	Server string
+	User   string
+	Passwd string
+}
+
+// load reads and decrypts the entries in the file.
+func (s *FileStore) load() ([]fileEntry, error) {
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err = crypt.Decrypt(s.password, data)
+	if err != nil {
+		return nil, err
+	}
+	var list []fileEntry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// save encrypts and writes the entries to the file,
+// replacing it only once the new contents are safely written.
+func (s *FileStore) save(list []fileEntry) error {
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	data, err = crypt.Encrypt(s.password, data)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.file), filepath.Base(s.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), s.file); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) UserPasswd(server, user string) (user1, passwd string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, err := s.load()
+	if err != nil {
+		return "", "", err
+	}
+	for _, e := range list {
+		if e.Server == server && (user == "" || e.User == user) {
+			return e.User, e.Passwd, nil
+		}
+	}
+	return "", "", ErrNotFound
+}
+
+func (s *FileStore) Set(server, user, passwd string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, err := s.load()
+	if err != nil {
+		return err
+	}
+	for i, e := range list {
+		if e.Server == server && e.User == user {
+			list[i].Passwd = passwd
+			return s.save(list)
+		}
+	}
+	return s.save(append(list, fileEntry{server, user, passwd}))
+}
+
+func (s *FileStore) Delete(server, user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, err := s.load()
+	if err != nil {
+		return err
+	}
+	var keep []fileEntry
+	for _, e := range list {
+		if e.Server != server || user != "" && e.User != user {
+			keep = append(keep, e)
+		}
+	}
+	if len(keep) == len(list) {
+		return ErrNotFound
+	}
+	return s.save(keep)
+}
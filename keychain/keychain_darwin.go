@@ -0,0 +1,239 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+// +build cgo
+
+package keychain
+
+/*
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+#include <CoreServices/CoreServices.h>
+
+#cgo LDFLAGS: -framework CoreFoundation -framework Security
+
+static char*
+mac2c(CFStringRef s)
+{
+	char *p;
+	int n;
+
+	n = CFStringGetLength(s)*8;	
+	p = malloc(n);
+	CFStringGetCString(s, p, n, kCFStringEncodingUTF8);
+	return p;
+}
+
+static void
+seterror(OSStatus st, char **error)
+{
+	CFStringRef str;
+
+	str = SecCopyErrorMessageString(st, NULL);
+	*error = mac2c(str);
+	CFRelease(str);
+}
+
+OSStatus
+keychain_getpasswd(char *user0, char *server, char **user, char **passwd, char **error)
+{
+	OSStatus st;
+	UInt32 len;
+	void *data;
+	SecKeychainItemRef it;
+
+	*user = NULL;
+	*passwd = NULL;
+	*error = NULL;
+
+	st = SecKeychainFindInternetPassword(
+		NULL,  // default keychain
+		strlen(server), server,
+		0, NULL,  // security domain
+		strlen(user0), user0,  // account name
+		0, NULL,  // path
+		0,  // port
+		0,  // protocol type
+		kSecAuthenticationTypeDefault,
+		&len,
+		&data,
+		&it);
+	if(st != 0) {
+		seterror(st, error);
+		return st;
+	}
+	*passwd = malloc(len+1);
+	memmove(*passwd, data, len);
+	(*passwd)[len] = '\0';
+	SecKeychainItemFreeContent(NULL, data);
+
+	SecKeychainAttribute attr = {kSecAccountItemAttr, 0, NULL};
+	SecKeychainAttributeList attrl = {1, &attr};
+	st = SecKeychainItemCopyContent(
+		it,
+		NULL,
+		&attrl,
+		0, NULL);
+	if(st != 0) {
+		seterror(st, error);
+		return st;
+	}
+	data = attr.data;
+	len = attr.length;
+	*user = malloc(len+1);
+	memmove(*user, data, len);
+	(*user)[len] = '\0';
+	SecKeychainItemFreeContent(&attrl, NULL);
+	CFRelease(it);
+	return 0;
+}
+
+OSStatus
+keychain_setpasswd(char *server, char *user, char *passwd, char **error)
+{
+	OSStatus st;
+	SecKeychainItemRef it;
+
+	*error = NULL;
+	st = SecKeychainFindInternetPassword(
+		NULL,  // default keychain
+		strlen(server), server,
+		0, NULL,  // security domain
+		strlen(user), user,  // account name
+		0, NULL,  // path
+		0,  // port
+		0,  // protocol type
+		kSecAuthenticationTypeDefault,
+		NULL, NULL,  // password
+		&it);
+	if(st == 0) {
+		st = SecKeychainItemModifyAttributesAndData(it, NULL, strlen(passwd), passwd);
+		CFRelease(it);
+	} else if(st == errSecItemNotFound) {
+		st = SecKeychainAddInternetPassword(
+			NULL,  // default keychain
+			strlen(server), server,
+			0, NULL,  // security domain
+			strlen(user), user,  // account name
+			0, NULL,  // path
+			0,  // port
+			kSecProtocolTypeAny,
+			kSecAuthenticationTypeDefault,
+			strlen(passwd), passwd,
+			NULL);
+	}
+	if(st != 0)
+		seterror(st, error);
+	return st;
+}
+
+OSStatus
+keychain_deletepasswd(char *server, char *user, char **error)
+{
+	OSStatus st;
+	SecKeychainItemRef it;
+
+	*error = NULL;
+	st = SecKeychainFindInternetPassword(
+		NULL,  // default keychain
+		strlen(server), server,
+		0, NULL,  // security domain
+		strlen(user), user,  // account name
+		0, NULL,  // path
+		0,  // port
+		0,  // protocol type
+		kSecAuthenticationTypeDefault,
+		NULL, NULL,  // password
+		&it);
+	if(st == 0) {
+		st = SecKeychainItemDelete(it);
+		CFRelease(it);
+	}
+	if(st != 0)
+		seterror(st, error);
+	return st;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// systemStore is the system keychain, if there is one.
+var systemStore Store = macStore{}
+
+// A macStore is the OS X keychain.
+type macStore struct{}
+
+// macError returns the error for the status st and message cError.
+func macError(st C.OSStatus, cError *C.char) error {
+	if st == C.errSecItemNotFound {
+		return ErrNotFound
+	}
+	return errors.New(C.GoString(cError))
+}
+
+func (macStore) UserPasswd(server, user string) (user1, passwd string, err error) {
+	cServer := C.CString(server)
+	cUser := C.CString(user)
+	defer C.free(unsafe.Pointer(cServer))
+	defer C.free(unsafe.Pointer(cUser))
+
+	var cUser1, cPasswd, cError *C.char
+	st := C.keychain_getpasswd(cUser, cServer, &cUser1, &cPasswd, &cError)
+	defer C.free(unsafe.Pointer(cUser1))
+	defer C.free(unsafe.Pointer(cPasswd))
+	defer C.free(unsafe.Pointer(cError))
+
+	if st != 0 {
+		return "", "", macError(st, cError)
+	}
+
+	return C.GoString(cUser1), C.GoString(cPasswd), nil
+}
+
+func (macStore) Set(server, user, passwd string) error {
+	cServer := C.CString(server)
+	cUser := C.CString(user)
+	cPasswd := C.CString(passwd)
+	defer C.free(unsafe.Pointer(cServer))
+	defer C.free(unsafe.Pointer(cUser))
+	defer C.free(unsafe.Pointer(cPasswd))
+
+	var cError *C.char
+	st := C.keychain_setpasswd(cServer, cUser, cPasswd, &cError)
+	defer C.free(unsafe.Pointer(cError))
+	if st != 0 {
+		return macError(st, cError)
+	}
+	return nil
+}
+
+func (macStore) Delete(server, user string) error {
+	cServer := C.CString(server)
+	cUser := C.CString(user)
+	defer C.free(unsafe.Pointer(cServer))
+	defer C.free(unsafe.Pointer(cUser))
+
+	// An empty user matches any account,
+	// so delete until there are no more.
+	for n := 0; ; n++ {
+		var cError *C.char
+		var err error
+		if st := C.keychain_deletepasswd(cServer, cUser, &cError); st != 0 {
+			err = macError(st, cError)
+		}
+		C.free(unsafe.Pointer(cError))
+		switch {
+		case err == nil && user == "":
+			continue
+		case err == ErrNotFound && n > 0:
+			return nil
+		}
+		return err
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package keychain
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// A NetrcStore is a read-only Store that takes passwords from a netrc
+// file, as used by ftp and curl.  Lookups consult the machine entries
+// and then the default entry.
+type NetrcStore struct {
+	file string
+}
+
+// NewNetrcStore returns a NetrcStore using the named file.
+// A file that does not exist is treated as empty.
+func NewNetrcStore(file string) *NetrcStore {
+	return &NetrcStore{file: file}
+}
+
+// A netrcEntry is a machine or default entry in a netrc file.
+type netrcEntry struct {
+	machine   string
+	isDefault bool
+	login     string
+	password  string
+}
+
+// parseNetrc parses the netrc file data.
+func parseNetrc(data string) []netrcEntry {
+	var list []netrcEntry
+	var cur *netrcEntry
+	end := func() {
+		if cur != nil {
+			list = append(list, *cur)
+			cur = nil
+		}
+	}
+
+	i := 0
+	// token returns the next token and its starting offset,
+	// which is len(data) at the end of the data.
+	token := func() (tok string, start int) {
+		for i < len(data) {
+			if c := data[i]; c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+				i++
+			} else if c == '#' {
+				for i < len(data) && data[i] != '\n' {
+					i++
+				}
+			} else {
+				break
+			}
+		}
+		if i >= len(data) {
+			return "", i
+		}
+		start = i
+		if data[i] == '"' {
+			var b []byte
+			for i++; i < len(data) && data[i] != '"'; i++ {
+				if data[i] == '\\' && i+1 < len(data) {
+					i++
+				}
+				b = append(b, data[i])
+			}
+			i++
+			return string(b), start
+		}
+		for i < len(data) && !strings.ContainsRune(" \t\r\n", rune(data[i])) {
+			i++
+		}
+		return data[start:i], start
+	}
+
+	for {
+		tok, start := token()
+		if start >= len(data) {
+			end()
+			return list
+		}
+		switch tok {
+		case "machine":
+			end()
+			cur = &netrcEntry{}
+			cur.machine, _ = token()
+		case "default":
+			end()
+			cur = &netrcEntry{isDefault: true}
+		case "login", "password", "account":
+			val, _ := token()
+			if cur == nil {
+				break
+			}
+			switch tok {
+			case "login":
+				cur.login = val
+			case "password":
+				cur.password = val
+			}
+		case "macdef":
+			// A macro definition runs to the next blank line.
+			end()
+			token()
+			if j := strings.Index(data[i:], "\n\n"); j >= 0 {
+				i += j + 2
+			} else {
+				i = len(data)
+			}
+		}
+	}
+}
+
+func (s *NetrcStore) read() (string, error) {
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(data), err
+}
+
+func (s *NetrcStore) UserPasswd(server, user string) (user1, passwd string, err error) {
+	data, err := s.read()
+	if err != nil {
+		return "", "", err
+	}
+	list := parseNetrc(data)
+	for _, def := range []bool{false, true} {
+		for _, e := range list {
+			if e.isDefault == def && (def || e.machine == server) && (user == "" || e.login == user) {
+				return e.login, e.password, nil
+			}
+		}
+	}
+	return "", "", ErrNotFound
+}
+
+func (s *NetrcStore) Set(server, user, passwd string) error {
+	return ErrReadOnly
+}
+
+func (s *NetrcStore) Delete(server, user string) error {
+	return ErrReadOnly
+}
@@ -0,0 +1,11 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !darwin || !cgo
+// +build !darwin !cgo
+
+package keychain
+
+// systemStore is the system keychain, if there is one.
+var systemStore Store
@@ -0,0 +1,113 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package keychain
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotFound is returned by a Store when it holds
+// no password for the requested server and user.
+var ErrNotFound = errors.New("password not found")
+
+// ErrReadOnly is returned by the Set and Delete methods
+// of a Store that cannot be changed.
+var ErrReadOnly = errors.New("password store is read-only")
+
+// A Store holds passwords, indexed by server and user name.
+type Store interface {
+	// UserPasswd returns the user name and password for the server.
+	// If user is non-empty, only passwords for that user are considered.
+	// If there are none, UserPasswd returns ErrNotFound.
+	UserPasswd(server, user string) (user1, passwd string, err error)
+
+	// Set records passwd as the password for user at server.
+	Set(server, user, passwd string) error
+
+	// Delete removes the password for user at server, or the
+	// passwords for all users of server if user is empty.
+	// If there are none, Delete returns ErrNotFound.
+	Delete(server, user string) error
+}
+
+// A Chain is a Store that consults a list of stores in turn.
+type Chain []Store
+
+// UserPasswd returns the password found in the first store that has one.
+func (c Chain) UserPasswd(server, user string) (user1, passwd string, err error) {
+	var first error
+	for _, s := range c {
+		user1, passwd, err = s.UserPasswd(server, user)
+		if err == nil {
+			return user1, passwd, nil
+		}
+		if err != ErrNotFound && first == nil {
+			first = err
+		}
+	}
+	if first != nil {
+		return "", "", first
+	}
+	return "", "", ErrNotFound
+}
+
+// Set records the password in the first store that is not read-only.
+func (c Chain) Set(server, user, passwd string) error {
+	for _, s := range c {
+		if err := s.Set(server, user, passwd); err != ErrReadOnly {
+			return err
+		}
+	}
+	return ErrReadOnly
+}
+
+// Delete removes the password from every store that is not read-only.
+func (c Chain) Delete(server, user string) error {
+	found := false
+	for _, s := range c {
+		switch err := s.Delete(server, user); err {
+		case nil:
+			found = true
+		case ErrNotFound, ErrReadOnly:
+			// ignore
+		default:
+			return err
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Default is the store used by the package-level functions.
+// It is set at startup as described in the package comment,
+// but programs may replace it.
+var Default Store = defaultStore()
+
+func defaultStore() Store {
+	c := Chain{EnvStore{}}
+	if systemStore != nil {
+		c = append(c, systemStore)
+	}
+	home := os.Getenv("HOME")
+	if pw := os.Getenv("KEYCHAIN_PASSWORD"); pw != "" {
+		file := os.Getenv("KEYCHAIN_FILE")
+		if file == "" {
+			file = filepath.Join(home, ".keychain")
+		}
+		c = append(c, NewFileStore(file, pw))
+	}
+	netrc := os.Getenv("NETRC")
+	if netrc == "" && home != "" {
+		netrc = filepath.Join(home, ".netrc")
+	}
+	if netrc != "" {
+		c = append(c, NewNetrcStore(netrc))
+	}
+	return c
+}
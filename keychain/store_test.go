@@ -0,0 +1,171 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package keychain
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testStore checks the basic operations of a writable store s.
+func testStore(t *testing.T, s Store) {
+	if _, _, err := s.UserPasswd("example.com", ""); err != ErrNotFound {
+		t.Fatalf("UserPasswd in empty store: %v, want ErrNotFound", err)
+	}
+	for _, e := range []struct{ server, user, passwd string }{
+		{"example.com", "alice", "secret"},
+		{"example.com", "bob", "pass word"},
+		{"other.com", "alice", `"quoted"\`},
+		{"example.com", "alice", "new secret"},
+	} {
+		if err := s.Set(e.server, e.user, e.passwd); err != nil {
+			t.Fatalf("Set(%q, %q): %v", e.server, e.user, err)
+		}
+	}
+	check := func(server, user, wantUser, wantPasswd string) {
+		u, p, err := s.UserPasswd(server, user)
+		if err != nil || u != wantUser || p != wantPasswd {
+			t.Errorf("UserPasswd(%q, %q) = %q, %q, %v, want %q, %q, nil", server, user, u, p, err, wantUser, wantPasswd)
+		}
+	}
+	check("example.com", "", "alice", "new secret")
+	check("example.com", "bob", "bob", "pass word")
+	check("other.com", "alice", "alice", `"quoted"\`)
+	if _, _, err := s.UserPasswd("example.com", "carol"); err != ErrNotFound {
+		t.Errorf("UserPasswd for missing user: %v, want ErrNotFound", err)
+	}
+
+	if err := s.Delete("example.com", "alice"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	check("example.com", "", "bob", "pass word")
+	if err := s.Delete("example.com", "alice"); err != ErrNotFound {
+		t.Errorf("second Delete: %v, want ErrNotFound", err)
+	}
+	if err := s.Delete("example.com", ""); err != nil {
+		t.Fatalf("Delete all: %v", err)
+	}
+	if _, _, err := s.UserPasswd("example.com", ""); err != ErrNotFound {
+		t.Errorf("UserPasswd after Delete: %v, want ErrNotFound", err)
+	}
+	check("other.com", "", "alice", `"quoted"\`)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "keychain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestFileStore(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "keychain")
+	testStore(t, NewFileStore(file, "password"))
+
+	if _, _, err := NewFileStore(file, "wrong").UserPasswd("other.com", ""); err == nil || err == ErrNotFound {
+		t.Errorf("UserPasswd with wrong password: %v, want decryption error", err)
+	}
+}
+
+const netrcFile = `# my hosts
+machine ftp.example.com
+	login anon password guest
+macdef init
+cd /pub
+machine fake.com login x password y
+
+machine s3.amazonaws.com login AKIA password "sec ret"
+default login anonymous password me@example.com
+`
+
+func TestNetrcStore(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "netrc")
+	if err := ioutil.WriteFile(file, []byte(netrcFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := NewNetrcStore(file)
+	for _, tt := range []struct{ server, user, passwd string }{
+		{"ftp.example.com", "anon", "guest"},
+		{"s3.amazonaws.com", "", "sec ret"},
+		{"unknown.com", "anonymous", "me@example.com"},
+	} {
+		u, p, err := s.UserPasswd(tt.server, "")
+		if err != nil || (tt.user != "" && u != tt.user) || p != tt.passwd {
+			t.Errorf("UserPasswd(%q) = %q, %q, %v, want %q, %q", tt.server, u, p, err, tt.user, tt.passwd)
+		}
+	}
+	// The fake.com entry is part of the macro definition.
+	if u, _, err := s.UserPasswd("fake.com", ""); err != nil || u != "anonymous" {
+		t.Errorf("UserPasswd(fake.com) = %q, %v, want default entry", u, err)
+	}
+
+	if err := s.Set("new.com", "me", "pw"); err != ErrReadOnly {
+		t.Errorf("Set: %v, want ErrReadOnly", err)
+	}
+	if err := s.Delete("ftp.example.com", ""); err != ErrReadOnly {
+		t.Errorf("Delete: %v, want ErrReadOnly", err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != netrcFile {
+		t.Errorf("netrc changed:\n%s", data)
+	}
+}
+
+func TestEnvStore(t *testing.T) {
+	os.Setenv("KEYCHAIN_TEST_EXAMPLE_COM_USER", "alice")
+	os.Setenv("KEYCHAIN_TEST_EXAMPLE_COM_PASSWORD", "secret")
+	defer os.Unsetenv("KEYCHAIN_TEST_EXAMPLE_COM_USER")
+	defer os.Unsetenv("KEYCHAIN_TEST_EXAMPLE_COM_PASSWORD")
+
+	var s EnvStore
+	u, p, err := s.UserPasswd("test.example.com", "")
+	if err != nil || u != "alice" || p != "secret" {
+		t.Errorf("UserPasswd = %q, %q, %v, want alice, secret, nil", u, p, err)
+	}
+	if _, _, err := s.UserPasswd("test.example.com", "bob"); err != ErrNotFound {
+		t.Errorf("UserPasswd for other user: %v, want ErrNotFound", err)
+	}
+	if err := s.Set("test.example.com", "bob", "x"); err != ErrReadOnly {
+		t.Errorf("Set: %v, want ErrReadOnly", err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	netrc := filepath.Join(dir, "netrc")
+	c := Chain{EnvStore{}, NewFileStore(filepath.Join(dir, "keychain"), "password"), NewNetrcStore(netrc)}
+	testStore(t, c)
+
+	// The netrc file is consulted but never written.
+	if err := ioutil.WriteFile(netrc, []byte(netrcFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if u, p, err := c.UserPasswd("ftp.example.com", ""); err != nil || u != "anon" || p != "guest" {
+		t.Errorf("UserPasswd(ftp.example.com) = %q, %q, %v, want anon, guest, nil", u, p, err)
+	}
+
+	os.Setenv("KEYCHAIN_OTHER_COM_USER", "env")
+	os.Setenv("KEYCHAIN_OTHER_COM_PASSWORD", "from env")
+	defer os.Unsetenv("KEYCHAIN_OTHER_COM_USER")
+	defer os.Unsetenv("KEYCHAIN_OTHER_COM_PASSWORD")
+	u, p, err := c.UserPasswd("other.com", "")
+	if err != nil || u != "env" || p != "from env" {
+		t.Errorf("UserPasswd = %q, %q, %v, want env, from env, nil", u, p, err)
+	}
+	if err := (Chain{EnvStore{}, NewNetrcStore(netrc)}).Set("x.com", "u", "p"); err != ErrReadOnly {
+		t.Errorf("Set in read-only chain: %v, want ErrReadOnly", err)
+	}
+}
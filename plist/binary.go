@@ -0,0 +1,641 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plist
+
+// Binary (bplist00) property lists.
+//
+// A binary plist is the header "bplist00", a sequence of objects,
+// an offset table giving the file offset of each object, and a
+// 32-byte trailer giving the sizes of offsets and object references,
+// the number of objects, the top-level object, and the location of
+// the offset table.  Arrays, sets, and dicts refer to their elements
+// by object number, an index into the offset table.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+	"unicode/utf16"
+)
+
+var binaryHeader = []byte("bplist00")
+
+// A UID is a binary plist UID object, used by NSKeyedArchiver
+// to refer to other objects in the archive.
+type UID uint64
+
+// appleEpoch is the time origin for binary and XML plist dates.
+var appleEpoch = time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	uidType  = reflect.TypeOf(UID(0))
+)
+
+type binaryDecoder struct {
+	data     []byte
+	offsets  []uint64
+	refSize  int
+	decoding []bool // objects being decoded, to detect cycles
+	left     int    // objects left to decode, to bound work on shared objects
+}
+
+func unmarshalBinary(data []byte, v reflect.Value) error {
+	if len(data) < len(binaryHeader)+32 {
+		return fmt.Errorf("binary plist too short")
+	}
+	trailer := data[len(data)-32:]
+	offSize := int(trailer[6])
+	refSize := int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:])
+	top := binary.BigEndian.Uint64(trailer[16:])
+	tableOff := binary.BigEndian.Uint64(trailer[24:])
+
+	end := uint64(len(data) - 32)
+	if offSize < 1 || offSize > 8 || refSize < 1 || refSize > 8 ||
+		numObjects == 0 || top >= numObjects ||
+		tableOff < uint64(len(binaryHeader)) || tableOff > end ||
+		numObjects > (end-tableOff)/uint64(offSize) {
+		return fmt.Errorf("malformed binary plist trailer")
+	}
+
+	d := &binaryDecoder{data: data[:tableOff], refSize: refSize}
+	d.offsets = make([]uint64, numObjects)
+	table := data[tableOff:]
+	for i := range d.offsets {
+		off := readUint(table[i*offSize : (i+1)*offSize])
+		if off < uint64(len(binaryHeader)) || off >= tableOff {
+			return fmt.Errorf("malformed binary plist offset table")
+		}
+		d.offsets[i] = off
+	}
+	d.decoding = make([]bool, numObjects)
+	// Every object after the top one is decoded by following a
+	// reference, at least one byte long, in the data.  More decodings
+	// than that mean that arrays or dicts are shared, which can make
+	// the work grow exponentially with the size of the data.
+	d.left = len(d.data)
+	return d.unmarshal(top, v)
+}
+
+// readUint returns the big-endian unsigned integer in b.
+func readUint(b []byte) uint64 {
+	var x uint64
+	for _, c := range b {
+		x = x<<8 | uint64(c)
+	}
+	return x
+}
+
+// object returns the marker byte of object ref
+// and the data following it.
+func (d *binaryDecoder) object(ref uint64) (marker byte, body []byte, err error) {
+	if ref >= uint64(len(d.offsets)) {
+		return 0, nil, fmt.Errorf("binary plist object reference %d out of range", ref)
+	}
+	off := d.offsets[ref]
+	return d.data[off], d.data[off+1:], nil
+}
+
+// count returns the element count encoded in the low nibble of
+// the marker, or in the integer object that follows it,
+// along with the data after the count.
+func (d *binaryDecoder) count(marker byte, body []byte) (uint64, []byte, error) {
+	n := uint64(marker & 0xF)
+	if n != 0xF {
+		return n, body, nil
+	}
+	if len(body) < 1 || body[0]>>4 != 0x1 {
+		return 0, nil, fmt.Errorf("malformed binary plist object count")
+	}
+	size := 1 << (body[0] & 0xF)
+	if size > 8 || len(body) < 1+size {
+		return 0, nil, fmt.Errorf("malformed binary plist object count")
+	}
+	return readUint(body[1 : 1+size]), body[1+size:], nil
+}
+
+// bytesOf returns the first n*size bytes of body,
+// checking that they exist.
+func bytesOf(body []byte, n uint64, size int) ([]byte, error) {
+	if n > uint64(len(body))/uint64(size) {
+		return nil, fmt.Errorf("binary plist object extends past end of data")
+	}
+	return body[:n*uint64(size)], nil
+}
+
+// refs returns n object references read from body.
+func (d *binaryDecoder) refs(body []byte, n uint64) ([]uint64, error) {
+	b, err := bytesOf(body, n, d.refSize)
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]uint64, n)
+	for i := range refs {
+		refs[i] = readUint(b[i*d.refSize : (i+1)*d.refSize])
+	}
+	return refs, nil
+}
+
+// unmarshal decodes object ref into v.
+func (d *binaryDecoder) unmarshal(ref uint64, v reflect.Value) error {
+	marker, body, err := d.object(ref)
+	if err != nil {
+		return err
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	if isGeneric(v) {
+		t, err := binaryGeneric(marker, body)
+		if err != nil || t == nil {
+			return err
+		}
+		elem := reflect.New(t).Elem()
+		if err := d.unmarshal(ref, elem); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	}
+
+	if d.decoding[ref] {
+		return fmt.Errorf("binary plist object %d contains itself", ref)
+	}
+	d.decoding[ref] = true
+	defer func() { d.decoding[ref] = false }()
+	if d.left--; d.left < 0 {
+		return fmt.Errorf("binary plist has too many object references")
+	}
+
+	switch marker >> 4 {
+	case 0x0:
+		switch marker {
+		case 0x00: // null
+			return nil
+		case 0x08, 0x09:
+			return setBool(v, marker == 0x09)
+		}
+
+	case 0x1:
+		size := 1 << (marker & 0xF)
+		b, err := bytesOf(body, 1, size)
+		if err != nil {
+			return err
+		}
+		switch size {
+		case 1, 2, 4, 8:
+			return setInt(v, int64(readUint(b)))
+		case 16:
+			// 128-bit integers hold values that do not fit in
+			// 64 bits signed.  Go can represent those whose high
+			// half is zero (unsigned) or only sign extension.
+			if readUint(b[:8]) == 0 {
+				return setUint(v, readUint(b[8:]))
+			}
+			return setInt(v, int64(readUint(b[8:])))
+		}
+
+	case 0x2:
+		size := 1 << (marker & 0xF)
+		b, err := bytesOf(body, 1, size)
+		if err != nil {
+			return err
+		}
+		switch size {
+		case 4:
+			return setReal(v, float64(math.Float32frombits(uint32(readUint(b)))))
+		case 8:
+			return setReal(v, math.Float64frombits(readUint(b)))
+		}
+
+	case 0x3:
+		if marker != 0x33 {
+			break
+		}
+		b, err := bytesOf(body, 1, 8)
+		if err != nil {
+			return err
+		}
+		return setDate(v, dateFromSeconds(math.Float64frombits(readUint(b))))
+
+	case 0x4, 0x5, 0x6:
+		n, body, err := d.count(marker, body)
+		if err != nil {
+			return err
+		}
+		size := 1
+		if marker>>4 == 0x6 {
+			size = 2
+		}
+		b, err := bytesOf(body, n, size)
+		if err != nil {
+			return err
+		}
+		switch marker >> 4 {
+		case 0x4:
+			return setData(v, b)
+		case 0x5:
+			return setString(v, string(b))
+		case 0x6:
+			u := make([]uint16, n)
+			for i := range u {
+				u[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+			}
+			return setString(v, string(utf16.Decode(u)))
+		}
+
+	case 0x8:
+		size := int(marker&0xF) + 1
+		b, err := bytesOf(body, 1, size)
+		if err != nil {
+			return err
+		}
+		if size > 8 {
+			break
+		}
+		return setUID(v, UID(readUint(b)))
+
+	case 0xA, 0xC:
+		n, body, err := d.count(marker, body)
+		if err != nil {
+			return err
+		}
+		refs, err := d.refs(body, n)
+		if err != nil {
+			return err
+		}
+		if v.Kind() != reflect.Slice {
+			return fmt.Errorf("cannot unmarshal array into non-slice %s", v.Type())
+		}
+		for _, r := range refs {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.unmarshal(r, elem); err != nil {
+				return err
+			}
+			v.Set(reflect.Append(v, elem))
+		}
+		return nil
+
+	case 0xD:
+		n, body, err := d.count(marker, body)
+		if err != nil {
+			return err
+		}
+		refs, err := d.refs(body, 2*n)
+		if err != nil {
+			return err
+		}
+		if err := checkDict(v); err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			var key string
+			if err := d.unmarshal(refs[i], reflect.ValueOf(&key).Elem()); err != nil {
+				return err
+			}
+			if elem, set := dictEntry(v, key); elem.IsValid() {
+				if err := d.unmarshal(refs[n+i], elem); err != nil {
+					return err
+				}
+				set()
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown binary plist object type %#02x", marker)
+}
+
+// binaryGeneric returns the Go type used to hold the object with
+// the given marker and body when decoding into an empty interface.
+// It returns a nil type for null objects, which are not stored.
+func binaryGeneric(marker byte, body []byte) (reflect.Type, error) {
+	switch marker >> 4 {
+	case 0x0:
+		switch marker {
+		case 0x00:
+			return nil, nil
+		case 0x08, 0x09:
+			return boolType, nil
+		}
+	case 0x1:
+		// Unsigned 128-bit integers too big for int64
+		// are stored as uint64.
+		if marker == 0x14 && len(body) >= 16 && readUint(body[:8]) == 0 && readUint(body[8:16]) > math.MaxInt64 {
+			return uint64Type, nil
+		}
+		return int64Type, nil
+	case 0x2:
+		return float64Type, nil
+	case 0x3:
+		return timeType, nil
+	case 0x4:
+		return bytesType, nil
+	case 0x5, 0x6:
+		return stringType, nil
+	case 0x8:
+		return uidType, nil
+	case 0xA, 0xC:
+		return sliceType, nil
+	case 0xD:
+		return mapType, nil
+	}
+	return nil, fmt.Errorf("unknown binary plist object type %#02x", marker)
+}
+
+// dateFromSeconds returns the time sec seconds after the plist epoch.
+func dateFromSeconds(sec float64) time.Time {
+	whole := math.Floor(sec)
+	return appleEpoch.Add(time.Duration(whole) * time.Second).
+		Add(time.Duration((sec - whole) * 1e9))
+}
+
+func setBool(v reflect.Value, b bool) error {
+	if v.Kind() != reflect.Bool {
+		return fmt.Errorf("cannot unmarshal bool into non-bool %s", v.Type())
+	}
+	v.SetBool(b)
+	return nil
+}
+
+func setInt(v reflect.Value, i int64) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(i) {
+			return fmt.Errorf("integer %d overflows %s", i, v.Type())
+		}
+		v.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if i < 0 || v.OverflowUint(uint64(i)) {
+			return fmt.Errorf("integer %d overflows %s", i, v.Type())
+		}
+		v.SetUint(uint64(i))
+		return nil
+	}
+	return fmt.Errorf("cannot unmarshal integer into non-int %s", v.Type())
+}
+
+func setUint(v reflect.Value, u uint64) error {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.OverflowUint(u) {
+			return fmt.Errorf("integer %d overflows %s", u, v.Type())
+		}
+		v.SetUint(u)
+		return nil
+	}
+	if u > math.MaxInt64 {
+		return fmt.Errorf("integer %d overflows %s", u, v.Type())
+	}
+	return setInt(v, int64(u))
+}
+
+func setReal(v reflect.Value, f float64) error {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(f)
+		return nil
+	}
+	return fmt.Errorf("cannot unmarshal real into non-float %s", v.Type())
+}
+
+func setDate(v reflect.Value, t time.Time) error {
+	if v.Type() != timeType {
+		return fmt.Errorf("cannot unmarshal date into non-time %s", v.Type())
+	}
+	v.Set(reflect.ValueOf(t))
+	return nil
+}
+
+func setData(v reflect.Value, b []byte) error {
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.Uint8 {
+		return fmt.Errorf("cannot unmarshal data into non-[]byte %s", v.Type())
+	}
+	v.SetBytes(append([]byte{}, b...))
+	return nil
+}
+
+func setString(v reflect.Value, s string) error {
+	if v.Kind() != reflect.String {
+		return fmt.Errorf("cannot unmarshal string into non-string %s", v.Type())
+	}
+	v.SetString(s)
+	return nil
+}
+
+func setUID(v reflect.Value, u UID) error {
+	if v.Type() != uidType {
+		return setInt(v, int64(u))
+	}
+	v.SetUint(uint64(u))
+	return nil
+}
+
+// isBinary reports whether data is a binary plist.
+func isBinary(data []byte) bool {
+	return bytes.HasPrefix(data, binaryHeader)
+}
+
+// A binaryEncoder collects the objects of a binary plist.
+// Leaf objects with identical encodings are stored once.
+type binaryEncoder struct {
+	objs   []binaryObject
+	leaves map[string]int
+}
+
+// A binaryObject is an encoded object: the complete encoding of
+// a leaf, or the marker and count of an array or dict, followed
+// by the object numbers in refs.
+type binaryObject struct {
+	data []byte
+	refs []int
+}
+
+// writeBinary writes the binary plist for val to buf.
+func writeBinary(buf *bytes.Buffer, val interface{}) {
+	e := &binaryEncoder{leaves: make(map[string]int)}
+	top := e.add(val)
+	refSize := uintSize(uint64(len(e.objs) - 1))
+
+	buf.Write(binaryHeader)
+	offsets := make([]uint64, len(e.objs))
+	for i, obj := range e.objs {
+		offsets[i] = uint64(buf.Len())
+		buf.Write(obj.data)
+		for _, r := range obj.refs {
+			putUint(buf, uint64(r), refSize)
+		}
+	}
+	tableOff := uint64(buf.Len())
+	offSize := uintSize(tableOff)
+	for _, off := range offsets {
+		putUint(buf, off, offSize)
+	}
+
+	var trailer [32]byte
+	trailer[6] = byte(offSize)
+	trailer[7] = byte(refSize)
+	binary.BigEndian.PutUint64(trailer[8:], uint64(len(e.objs)))
+	binary.BigEndian.PutUint64(trailer[16:], uint64(top))
+	binary.BigEndian.PutUint64(trailer[24:], tableOff)
+	buf.Write(trailer[:])
+}
+
+// add adds val and the values it contains to e,
+// returning val's object number.
+func (e *binaryEncoder) add(val interface{}) int {
+	switch val := val.(type) {
+	case []interface{}:
+		n := len(e.objs)
+		e.objs = append(e.objs, binaryObject{})
+		refs := make([]int, len(val))
+		for i, elem := range val {
+			refs[i] = e.add(elem)
+		}
+		e.objs[n] = binaryObject{marker(0xA, len(val)), refs}
+		return n
+	case *dict:
+		n := len(e.objs)
+		e.objs = append(e.objs, binaryObject{})
+		refs := make([]int, 2*len(val.keys))
+		for i, key := range val.keys {
+			refs[i] = e.add(key)
+		}
+		for i, elem := range val.vals {
+			refs[len(val.keys)+i] = e.add(elem)
+		}
+		e.objs[n] = binaryObject{marker(0xD, len(val.keys)), refs}
+		return n
+	}
+
+	data := encodeLeaf(val)
+	if n, ok := e.leaves[string(data)]; ok {
+		return n
+	}
+	n := len(e.objs)
+	e.objs = append(e.objs, binaryObject{data: data})
+	e.leaves[string(data)] = n
+	return n
+}
+
+// encodeLeaf returns the binary encoding of a value
+// that is not an array or dict.
+func encodeLeaf(val interface{}) []byte {
+	var buf bytes.Buffer
+	switch val := val.(type) {
+	case bool:
+		if val {
+			buf.WriteByte(0x09)
+		} else {
+			buf.WriteByte(0x08)
+		}
+	case int64:
+		if val < 0 {
+			buf.WriteByte(0x13)
+			putUint(&buf, uint64(val), 8)
+		} else {
+			putInt(&buf, uint64(val))
+		}
+	case uint64:
+		putInt(&buf, val)
+	case float64:
+		buf.WriteByte(0x23)
+		putUint(&buf, math.Float64bits(val), 8)
+	case time.Time:
+		sec := float64(val.Unix()-appleEpoch.Unix()) + float64(val.Nanosecond())/1e9
+		buf.WriteByte(0x33)
+		putUint(&buf, math.Float64bits(sec), 8)
+	case []byte:
+		buf.Write(marker(0x4, len(val)))
+		buf.Write(val)
+	case string:
+		if isASCII(val) {
+			buf.Write(marker(0x5, len(val)))
+			buf.WriteString(val)
+			break
+		}
+		u := utf16.Encode([]rune(val))
+		buf.Write(marker(0x6, len(u)))
+		for _, c := range u {
+			putUint(&buf, uint64(c), 2)
+		}
+	case UID:
+		size := uintSize(uint64(val))
+		buf.WriteByte(0x80 | byte(size-1))
+		putUint(&buf, uint64(val), size)
+	default:
+		panic(fmt.Sprintf("plist: unexpected value %T", val))
+	}
+	return buf.Bytes()
+}
+
+// marker returns the marker byte for an object of the given type
+// with n elements, followed by an integer object holding n if n
+// does not fit in the marker.
+func marker(typ byte, n int) []byte {
+	if n < 0xF {
+		return []byte{typ<<4 | byte(n)}
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(typ<<4 | 0xF)
+	putInt(&buf, uint64(n))
+	return buf.Bytes()
+}
+
+// putInt writes a non-negative integer object holding x to buf,
+// using the smallest size that holds it.
+// Values that do not fit in 64 bits signed are written as 128-bit integers.
+func putInt(buf *bytes.Buffer, x uint64) {
+	switch size := uintSize(x); {
+	case x > math.MaxInt64:
+		buf.WriteByte(0x14)
+		putUint(buf, 0, 8)
+		putUint(buf, x, 8)
+	case size == 8:
+		buf.WriteByte(0x13)
+		putUint(buf, x, 8)
+	default:
+		buf.WriteByte(0x10 | byte(size>>1))
+		putUint(buf, x, size)
+	}
+}
+
+// uintSize returns the number of bytes, 1, 2, 4, or 8,
+// needed to hold x.
+func uintSize(x uint64) int {
+	switch {
+	case x <= 0xFF:
+		return 1
+	case x <= 0xFFFF:
+		return 2
+	case x <= 0xFFFFFFFF:
+		return 4
+	}
+	return 8
+}
+
+// putUint writes the low size bytes of x to buf, big-endian.
+func putUint(buf *bytes.Buffer, x uint64, size int) {
+	for i := size - 1; i >= 0; i-- {
+		buf.WriteByte(byte(x >> uint(8*i)))
+	}
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,161 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plist
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// binaryPlist was written by Python's plistlib.
+var binaryPlist = "" +
+	"\x62\x70\x6c\x69\x73\x74\x30\x30\xde\x01\x02\x03\x04\x05\x06\x07" +
+	"\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13\x14\x19\x1d\x1e" +
+	"\x1f\x23\x24\x25\x26\x53\x42\x69\x67\x54\x42\x6c\x6f\x62\x55\x43" +
+	"\x6f\x75\x6e\x74\x58\x44\x69\x73\x61\x62\x6c\x65\x64\x57\x45\x6e" +
+	"\x61\x62\x6c\x65\x64\x57\x49\x67\x6e\x6f\x72\x65\x64\x54\x4c\x69" +
+	"\x73\x74\x54\x4e\x61\x6d\x65\x53\x4e\x65\x67\x56\x4e\x65\x73\x74" +
+	"\x65\x64\x55\x52\x61\x74\x69\x6f\x53\x52\x65\x66\x57\x55\x6e\x69" +
+	"\x63\x6f\x64\x65\x54\x57\x68\x65\x6e\x13\x00\x00\x01\x00\x00\x00" +
+	"\x00\x00\x44\x00\x01\x02\xff\x10\x2a\x08\x09\xa2\x15\x16\x10\x01" +
+	"\xd1\x17\x18\x51\x78\x10\x02\xa3\x1a\x1b\x1c\x51\x61\x51\x62\x51" +
+	"\x63\x53\x72\x73\x63\x13\xff\xff\xff\xff\xff\xff\xff\xfb\xd2\x20" +
+	"\x21\x22\x18\x54\x74\x65\x78\x74\x54\x74\x79\x70\x65\x58\x2e\x75" +
+	"\x6e\x69\x73\x6f\x6e\x2e\x23\x3f\xe0\x00\x00\x00\x00\x00\x00\x80" +
+	"\x07\x69\x00\x68\x00\xe9\x00\x6c\x00\x6c\x00\x6f\x00\x2c\x00\x20" +
+	"\x4e\x16\x75\x4c\x33\x41\xb5\x03\x2f\xbf\x00\x00\x00\x08\x25\x29" +
+	"\x2e\x34\x3d\x45\x4d\x52\x57\x5b\x62\x68\x6c\x74\x79\x82\x87\x89" +
+	"\x8a\x8b\x8e\x90\x93\x95\x97\x9b\x9d\x9f\xa1\xa5\xae\xb3\xb8\xbd" +
+	"\xc6\xcf\xd1\xe4\x00\x00\x00\x00\x00\x00\x01\x01\x00\x00\x00\x00" +
+	"\x00\x00\x00\x27\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+	"\x00\x00\x00\xed"
+
+type binaryStruct struct {
+	Name     string
+	Unicode  string
+	Count    int
+	Big      int64
+	Neg      int
+	Ratio    float64
+	When     time.Time
+	Blob     []byte
+	Ref      UID
+	Enabled  bool
+	Disabled bool
+	List     []string
+	Nested   *Exclude2
+}
+
+func TestUnmarshalBinary(t *testing.T) {
+	var v binaryStruct
+	if err := Unmarshal([]byte(binaryPlist), &v); err != nil {
+		t.Fatal(err)
+	}
+	want := binaryStruct{
+		Name:     "rsc",
+		Unicode:  "héllo, 世界",
+		Count:    42,
+		Big:      1 << 40,
+		Neg:      -5,
+		Ratio:    0.5,
+		When:     time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+		Blob:     []byte{0, 1, 2, 0xff},
+		Ref:      7,
+		Enabled:  true,
+		Disabled: false,
+		List:     []string{"a", "b", "c"},
+		Nested:   &Exclude2{Type: 2, Text: ".unison."},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Unmarshal:\nhave %+v\nwant %+v", v, want)
+	}
+}
+
+func TestUnmarshalBinaryErrors(t *testing.T) {
+	// An array containing itself.
+	cycle := "bplist00" + "\xa1\x00" + "\x08" +
+		"\x00\x00\x00\x00\x00\x00\x01\x01" +
+		"\x00\x00\x00\x00\x00\x00\x00\x01" +
+		"\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"\x00\x00\x00\x00\x00\x00\x00\x0a"
+	var list [][]int
+	if err := Unmarshal([]byte(cycle), &list); err == nil || !strings.Contains(err.Error(), "contains itself") {
+		t.Errorf("Unmarshal(cycle) = %v, want error about cycle", err)
+	}
+
+	// Arrays each holding the next one twice, so that
+	// decoding the first reaches the last 2⁶³ times.
+	const n = 64
+	fan := []byte("bplist00")
+	var table []byte
+	for i := 0; i < n-1; i++ {
+		table = append(table, byte(len(fan)))
+		fan = append(fan, 0xa2, byte(i+1), byte(i+1))
+	}
+	table = append(table, byte(len(fan)))
+	fan = append(fan, 0x08)
+	tableOff := len(fan)
+	fan = append(fan, table...)
+	fan = append(fan, 0, 0, 0, 0, 0, 0, 1, 1)
+	fan = append(fan, 0, 0, 0, 0, 0, 0, 0, n)
+	fan = append(fan, 0, 0, 0, 0, 0, 0, 0, 0)
+	fan = append(fan, 0, 0, 0, 0, 0, 0, 0, byte(tableOff))
+	var fanv interface{}
+	if err := Unmarshal(fan, &fanv); err == nil || !strings.Contains(err.Error(), "too many") {
+		t.Errorf("Unmarshal(fan-out) = %v, want error about too many references", err)
+	}
+
+	for n := len(binaryHeader); n < len(binaryPlist); n += 7 {
+		var v binaryStruct
+		if err := Unmarshal([]byte(binaryPlist[:n]), &v); err == nil {
+			t.Errorf("Unmarshal of %d-byte prefix succeeded", n)
+		}
+	}
+
+	var wrong struct{ Name int }
+	if err := Unmarshal([]byte(binaryPlist), &wrong); err == nil {
+		t.Errorf("Unmarshal of string into int succeeded")
+	}
+}
+
+func TestUnmarshalBinaryGeneric(t *testing.T) {
+	var v interface{}
+	if err := Unmarshal([]byte(binaryPlist), &v); err != nil {
+		t.Fatal(err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Unmarshal stored %T, want map[string]interface{}", v)
+	}
+	want := map[string]interface{}{
+		"Name":     "rsc",
+		"Unicode":  "héllo, 世界",
+		"Count":    int64(42),
+		"Big":      int64(1 << 40),
+		"Neg":      int64(-5),
+		"Ratio":    0.5,
+		"When":     time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+		"Blob":     []byte{0, 1, 2, 0xff},
+		"Ref":      UID(7),
+		"Enabled":  true,
+		"Disabled": false,
+		"Ignored":  []interface{}{int64(1), map[string]interface{}{"x": int64(2)}},
+		"List":     []interface{}{"a", "b", "c"},
+		"Nested":   map[string]interface{}{"type": int64(2), "text": ".unison."},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Unmarshal:\nhave %#v\nwant %#v", m, want)
+	}
+
+	var tags map[string]int
+	if err := Unmarshal([]byte(binaryPlist), &struct{ Nested *map[string]interface{} }{}); err != nil {
+		t.Error(err)
+	}
+	if err := Unmarshal([]byte(binaryPlist), &tags); err == nil {
+		t.Errorf("Unmarshal of mixed dict into map[string]int succeeded")
+	}
+}
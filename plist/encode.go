@@ -0,0 +1,311 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plist
+
+// Plist encoding.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Format is a plist file format.
+type Format int
+
+const (
+	XMLFormat    Format = iota // XML, as in .plist files edited by hand
+	BinaryFormat               // binary bplist00, as written by most Apple tools
+)
+
+// Marshal returns the plist encoding of v in the given format.
+//
+// Structs and maps with string keys are encoded as dicts,
+// slices and arrays as arrays (except []byte, which is encoded as data),
+// and time.Time values as dates.  Booleans, integers, floating-point
+// numbers, and strings are encoded as the corresponding plist types,
+// and a UID as a UID (in XML, as a dict with a single key, CF$UID).
+// Pointers and interfaces are encoded as the values they point to.
+//
+// Each exported struct field becomes a dict entry, using the field name
+// as the key unless the field's plist tag gives another.  The tag option
+// omitempty (as in `plist:"name,omitempty"`) omits the entry if the field
+// has an empty value: false, 0, an empty string, slice, or map, a nil
+// pointer or interface, or the zero time.  The tag "-" omits the field
+// always.  Nil pointers and interfaces are always omitted, because plists
+// have no null value.
+//
+// Dict keys are written in sorted order, so that the encoding
+// of a given value is always the same.
+func Marshal(v interface{}, format Format) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf, format).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// An Encoder writes plists to an output stream.
+type Encoder struct {
+	w      io.Writer
+	format Format
+}
+
+// NewEncoder returns a new encoder that writes plists
+// in the given format to w.
+func NewEncoder(w io.Writer, format Format) *Encoder {
+	return &Encoder{w: w, format: format}
+}
+
+// Encode writes the plist encoding of v to the stream.
+// See Marshal for details about the conversion.
+func (e *Encoder) Encode(v interface{}) error {
+	val, ok, err := toValue(reflect.ValueOf(v))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("cannot encode nil value in plist")
+	}
+	var buf bytes.Buffer
+	switch e.format {
+	case XMLFormat:
+		writeXML(&buf, val)
+	case BinaryFormat:
+		writeBinary(&buf, val)
+	default:
+		return fmt.Errorf("unknown plist format %d", int(e.format))
+	}
+	_, err = e.w.Write(buf.Bytes())
+	return err
+}
+
+// A dict is an encoded dict, with its keys in sorted order.
+type dict struct {
+	keys []string
+	vals []interface{}
+}
+
+func (d *dict) Len() int           { return len(d.keys) }
+func (d *dict) Less(i, j int) bool { return d.keys[i] < d.keys[j] }
+func (d *dict) Swap(i, j int) {
+	d.keys[i], d.keys[j] = d.keys[j], d.keys[i]
+	d.vals[i], d.vals[j] = d.vals[j], d.vals[i]
+}
+
+// toValue converts v to the values written by the encoders:
+// bool, int64, uint64, float64, string, []byte, time.Time, UID,
+// []interface{} for arrays, and *dict for dicts.
+// It returns ok == false for nil pointers and interfaces.
+func toValue(v reflect.Value) (val interface{}, ok bool, err error) {
+	if !v.IsValid() {
+		return nil, false, nil
+	}
+	switch v.Type() {
+	case timeType:
+		return v.Interface().(time.Time), true, nil
+	case uidType:
+		return UID(v.Uint()), true, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, false, nil
+		}
+		return toValue(v.Elem())
+
+	case reflect.Bool:
+		return v.Bool(), true, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint(), true, nil
+
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true, nil
+
+	case reflect.String:
+		return v.String(), true, nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return b, true, nil
+		}
+		list := []interface{}{}
+		for i := 0; i < v.Len(); i++ {
+			elem, ok, err := toValue(v.Index(i))
+			if err != nil {
+				return nil, false, err
+			}
+			if !ok {
+				return nil, false, fmt.Errorf("cannot encode nil array element in plist")
+			}
+			list = append(list, elem)
+		}
+		return list, true, nil
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, false, fmt.Errorf("cannot encode map with non-string key type %s in plist", v.Type().Key())
+		}
+		d := new(dict)
+		for _, k := range v.MapKeys() {
+			elem, ok, err := toValue(v.MapIndex(k))
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				d.keys = append(d.keys, k.String())
+				d.vals = append(d.vals, elem)
+			}
+		}
+		sort.Sort(d)
+		return d, true, nil
+
+	case reflect.Struct:
+		d := new(dict)
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			key, omitempty, skip := parseTag(t.Field(i))
+			if skip || omitempty && isEmpty(v.Field(i)) {
+				continue
+			}
+			elem, ok, err := toValue(v.Field(i))
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				d.keys = append(d.keys, key)
+				d.vals = append(d.vals, elem)
+			}
+		}
+		sort.Sort(d)
+		for i := 1; i < len(d.keys); i++ {
+			if d.keys[i] == d.keys[i-1] {
+				return nil, false, fmt.Errorf("duplicate key %q in %s", d.keys[i], t)
+			}
+		}
+		return d, true, nil
+	}
+	return nil, false, fmt.Errorf("cannot encode %s in plist", v.Type())
+}
+
+// isEmpty reports whether v is empty, for omitempty.
+func isEmpty(v reflect.Value) bool {
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).IsZero()
+	}
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+`
+
+// writeXML writes the XML plist for val to buf,
+// in the same layout as Apple's tools.
+func writeXML(buf *bytes.Buffer, val interface{}) {
+	buf.WriteString(xmlHeader)
+	writeXMLValue(buf, val, 0)
+	buf.WriteString("</plist>\n")
+}
+
+func writeXMLValue(buf *bytes.Buffer, val interface{}, depth int) {
+	indent := strings.Repeat("\t", depth)
+	buf.WriteString(indent)
+	switch val := val.(type) {
+	case bool:
+		if val {
+			buf.WriteString("<true/>\n")
+		} else {
+			buf.WriteString("<false/>\n")
+		}
+	case int64:
+		fmt.Fprintf(buf, "<integer>%d</integer>\n", val)
+	case uint64:
+		fmt.Fprintf(buf, "<integer>%d</integer>\n", val)
+	case float64:
+		fmt.Fprintf(buf, "<real>%s</real>\n", formatReal(val))
+	case string:
+		buf.WriteString("<string>")
+		xml.EscapeText(buf, []byte(val))
+		buf.WriteString("</string>\n")
+	case []byte:
+		fmt.Fprintf(buf, "<data>%s</data>\n", base64.StdEncoding.EncodeToString(val))
+	case time.Time:
+		fmt.Fprintf(buf, "<date>%s</date>\n", val.UTC().Format(xmlDateFormat))
+	case UID:
+		fmt.Fprintf(buf, "<dict>\n%s\t<key>CF$UID</key>\n%s\t<integer>%d</integer>\n%s</dict>\n", indent, indent, val, indent)
+	case []interface{}:
+		if len(val) == 0 {
+			buf.WriteString("<array/>\n")
+			break
+		}
+		buf.WriteString("<array>\n")
+		for _, elem := range val {
+			writeXMLValue(buf, elem, depth+1)
+		}
+		buf.WriteString(indent + "</array>\n")
+	case *dict:
+		if len(val.keys) == 0 {
+			buf.WriteString("<dict/>\n")
+			break
+		}
+		buf.WriteString("<dict>\n")
+		for i, key := range val.keys {
+			buf.WriteString(indent + "\t<key>")
+			xml.EscapeText(buf, []byte(key))
+			buf.WriteString("</key>\n")
+			writeXMLValue(buf, val.vals[i], depth+1)
+		}
+		buf.WriteString(indent + "</dict>\n")
+	default:
+		panic(fmt.Sprintf("plist: unexpected value %T", val))
+	}
+}
+
+// xmlDateFormat is the format of dates in XML plists.
+const xmlDateFormat = "2006-01-02T15:04:05Z"
+
+// formatReal formats f for an XML plist.
+func formatReal(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+infinity"
+	case math.IsInf(f, -1):
+		return "-infinity"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
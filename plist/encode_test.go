@@ -0,0 +1,229 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plist
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type encodeStruct struct {
+	Name    string
+	Count   int
+	Enabled bool
+	List    []string
+	Nested  *encodeNested
+	Renamed string `plist:"other"`
+	Empty   string `plist:",omitempty"`
+	Skip    string `plist:"-"`
+	skip    string
+}
+
+type encodeNested struct {
+	Items []encodeItem `plist:"items"`
+}
+
+type encodeItem struct {
+	Text string `plist:"text"`
+	Type int    `plist:"type"`
+}
+
+var encodeValue = encodeStruct{
+	Name:    "a <b> & c",
+	Count:   42,
+	Enabled: true,
+	List:    []string{"x", "y"},
+	Nested:  &encodeNested{Items: []encodeItem{{".unison.", 2}}},
+	Renamed: "renamed",
+	Skip:    "skip",
+	skip:    "skip",
+}
+
+var encodeXML = xmlPrefix + `<plist version="1.0">
+<dict>
+	<key>Count</key>
+	<integer>42</integer>
+	<key>Enabled</key>
+	<true/>
+	<key>List</key>
+	<array>
+		<string>x</string>
+		<string>y</string>
+	</array>
+	<key>Name</key>
+	<string>a &lt;b&gt; &amp; c</string>
+	<key>Nested</key>
+	<dict>
+		<key>items</key>
+		<array>
+			<dict>
+				<key>text</key>
+				<string>.unison.</string>
+				<key>type</key>
+				<integer>2</integer>
+			</dict>
+		</array>
+	</dict>
+	<key>other</key>
+	<string>renamed</string>
+</dict>
+</plist>
+`
+
+func TestMarshalXML(t *testing.T) {
+	data, err := Marshal(&encodeValue, XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != encodeXML {
+		t.Errorf("Marshal = %s\nwant %s", data, encodeXML)
+	}
+
+	var out encodeStruct
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := encodeValue
+	want.Skip, want.skip = "", ""
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("Unmarshal(Marshal(v)) = %+v, want %+v", out, want)
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	in := binaryStruct{
+		Name:    "rsc",
+		Unicode: "héllo, 世界",
+		Count:   1 << 40,
+		Big:     -1 << 40,
+		Neg:     -5,
+		Ratio:   0.5,
+		When:    time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+		Blob:    []byte{0, 1, 2, 0xff},
+		Ref:     7,
+		Enabled: true,
+		List:    []string{"a", "b", "a"},
+		Nested:  &Exclude2{Type: 2, Text: ".unison."},
+	}
+	data, err := Marshal(in, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out binaryStruct
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Unmarshal(Marshal(v)) = %+v, want %+v", out, in)
+	}
+
+	// The repeated "a" is stored once.
+	if n := bytes.Count(data, []byte{0x51, 'a'}); n != 1 {
+		t.Errorf("string \"a\" stored %d times, want 1", n)
+	}
+}
+
+func TestMarshalBinaryLarge(t *testing.T) {
+	// Enough objects and data to need 2-byte references
+	// and offsets, and long counts.
+	var in struct {
+		List []string
+		Big  uint64
+	}
+	for i := 0; i < 300; i++ {
+		in.List = append(in.List, strings.Repeat("x", i))
+	}
+	in.Big = 1<<64 - 1
+	data, err := Marshal(in, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trailer := data[len(data)-32:]; trailer[6] != 2 || trailer[7] != 2 {
+		t.Errorf("offset, ref sizes = %d, %d, want 2, 2", trailer[6], trailer[7])
+	}
+	var out struct {
+		List []string
+		Big  uint64
+	}
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Unmarshal(Marshal(v)) mismatch")
+	}
+}
+
+func TestMarshalDeterministic(t *testing.T) {
+	m := map[string]int{}
+	for _, k := range strings.Fields("the quick brown fox jumps over the lazy dog") {
+		m[k] = len(k)
+	}
+	for _, format := range []Format{XMLFormat, BinaryFormat} {
+		first, err := Marshal(m, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			data, err := Marshal(m, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, first) {
+				t.Fatalf("format %d: Marshal output differs between calls", format)
+			}
+		}
+	}
+	data, _ := Marshal(m, XMLFormat)
+	if i, j := bytes.Index(data, []byte("brown")), bytes.Index(data, []byte("quick")); i < 0 || j < i {
+		t.Errorf("keys not sorted:\n%s", data)
+	}
+}
+
+func TestMarshalEmpty(t *testing.T) {
+	var in struct {
+		List []int
+		Dict struct{}
+	}
+	data, err := Marshal(in, XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("<array/>")) || !bytes.Contains(data, []byte("<dict/>")) {
+		t.Errorf("Marshal = %s, want <array/> and <dict/>", data)
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf, XMLFormat).Encode(&encodeValue); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != encodeXML {
+		t.Errorf("Encode = %s\nwant %s", buf.Bytes(), encodeXML)
+	}
+}
+
+var marshalErrorTests = []interface{}{
+	nil,
+	(*encodeStruct)(nil),
+	map[int]string{1: "x"},
+	make(chan int),
+	[]*int{nil},
+	struct {
+		A string `plist:"x"`
+		B string `plist:"x"`
+	}{},
+}
+
+func TestMarshalErrors(t *testing.T) {
+	for _, v := range marshalErrorTests {
+		if _, err := Marshal(v, XMLFormat); err == nil {
+			t.Errorf("Marshal(%#v) succeeded, want error", v)
+		}
+	}
+}
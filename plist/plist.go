@@ -2,14 +2,20 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package plist implements parsing of Apple plist files.
+// Package plist implements encoding and decoding of Apple plist files,
+// in both the XML and binary formats.
 package plist
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
+	"html"
+	"math"
 	"reflect"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func next(data []byte) (skip, tag, rest []byte) {
@@ -30,7 +36,27 @@ func next(data []byte) (skip, tag, rest []byte) {
 	return data[:i], data[i:j], data[j:]
 }
 
+// Unmarshal parses the plist in data and stores the result in
+// the value pointed to by v.  The plist may be in XML or binary
+// (bplist00) format; Unmarshal detects which.
+//
+// Dicts are stored in structs, with each key stored in the field of
+// the same name or with that name in a plist struct tag, or in maps
+// with string keys.  Keys with no corresponding field are ignored.
+// Arrays are stored in slices, data in byte slices, dates in
+// time.Time values, and strings, integers, reals, and booleans
+// in values of the corresponding Go kinds.
+//
+// To decode a plist of unknown structure, pass a pointer to an
+// empty interface value.  Unmarshal stores dicts in it as
+// map[string]interface{}, arrays as []interface{}, integers as int64,
+// reals as float64, dates as time.Time, and data as []byte.
+// Strings, booleans, and binary plist UIDs are stored as
+// string, bool, and UID.
 func Unmarshal(data []byte, v interface{}) error {
+	if isBinary(data) {
+		return unmarshalBinary(data, reflect.ValueOf(v))
+	}
 	_, tag, data := next(data)
 	if bytes.HasPrefix(tag, []byte("<?xml")) {
 		_, tag, data = next(data)
@@ -53,8 +79,26 @@ func Unmarshal(data []byte, v interface{}) error {
 	return nil
 }
 
+// xmlGeneric gives the Go type used to hold each kind of
+// XML plist value when decoding into an empty interface.
+var xmlGeneric = map[string]reflect.Type{
+	"<dict>":    mapType,
+	"<dict/>":   mapType,
+	"<array>":   sliceType,
+	"<array/>":  sliceType,
+	"<string>":  stringType,
+	"<string/>": stringType,
+	"<integer>": int64Type,
+	"<real>":    float64Type,
+	"<true/>":   boolType,
+	"<false/>":  boolType,
+	"<date>":    timeType,
+	"<data>":    bytesType,
+	"<data/>":   bytesType,
+}
+
 func unmarshalValue(data []byte, v reflect.Value) (rest []byte, err error) {
-	_, tag, data := next(data)
+	_, tag, rest := next(data)
 	if tag == nil {
 		return nil, fmt.Errorf("unexpected end of data")
 	}
@@ -66,13 +110,34 @@ func unmarshalValue(data []byte, v reflect.Value) (rest []byte, err error) {
 		v = v.Elem()
 	}
 
+	if isGeneric(v) {
+		t := xmlGeneric[string(tag)]
+		if t == nil {
+			return nil, fmt.Errorf("unexpected tag %s", tag)
+		}
+		if t == int64Type {
+			// Integers too big for int64 are stored as uint64.
+			body, _, _ := element(rest, "integer")
+			if _, err := strconv.ParseInt(strings.TrimSpace(body), 0, 64); err != nil {
+				if _, err := strconv.ParseUint(strings.TrimSpace(body), 0, 64); err == nil {
+					t = uint64Type
+				}
+			}
+		}
+		elem := reflect.New(t).Elem()
+		if rest, err = unmarshalValue(data, elem); err != nil {
+			return nil, err
+		}
+		v.Set(elem)
+		return rest, nil
+	}
+	data = rest
+
 	switch stag := string(tag); stag {
 	case "<dict>":
-		t := v.Type()
-		if v.Kind() != reflect.Struct {
-			return nil, fmt.Errorf("cannot unmarshal <dict> into non-struct %s", v.Type())
+		if err := checkDict(v); err != nil {
+			return nil, err
 		}
-	Dict:
 		for {
 			_, tag, data = next(data)
 			if len(tag) == 0 {
@@ -84,27 +149,21 @@ func unmarshalValue(data []byte, v reflect.Value) (rest []byte, err error) {
 			if string(tag) != "<key>" {
 				return nil, fmt.Errorf("unexpected tag %s inside <dict>", tag)
 			}
-			var body []byte
-			body, tag, data = next(data)
-			if len(tag) == 0 {
-				return nil, fmt.Errorf("eof inside <dict>")
-			}
-			if string(tag) != "</key>" {
-				return nil, fmt.Errorf("unexpected tag %s inside <dict>", tag)
+			var key string
+			key, data, err = element(data, "key")
+			if err != nil {
+				return nil, err
 			}
-			name := string(body)
-			var i int
-			for i = 0; i < t.NumField(); i++ {
-				f := t.Field(i)
-				if f.Name == name || f.Tag.Get("plist") == name {
-					data, err = unmarshalValue(data, v.Field(i))
-					continue Dict
-				}
+			elem, set := dictEntry(v, key)
+			if !elem.IsValid() {
+				data, err = skipValue(data)
+			} else {
+				data, err = unmarshalValue(data, elem)
 			}
-			data, err = skipValue(data)
 			if err != nil {
 				return nil, err
 			}
+			set()
 		}
 		return data, nil
 
@@ -131,48 +190,106 @@ func unmarshalValue(data []byte, v reflect.Value) (rest []byte, err error) {
 		}
 		return data, nil
 
-	case "<string>":
-		if v.Kind() != reflect.String {
-			return nil, fmt.Errorf("cannot unmarshal <string> into non-string %s", v.Type())
-		}
-		body, etag, data := next(data)
-		if len(etag) == 0 {
-			return nil, fmt.Errorf("eof inside <string>")
-		}
-		if string(etag) != "</string>" {
-			return nil, fmt.Errorf("expected </string> but got %s", etag)
+	case "<dict/>":
+		return data, checkDict(v)
+
+	case "<array/>":
+		if v.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("cannot unmarshal <array> into non-slice %s", v.Type())
 		}
-		v.SetString(string(body)) // TODO: unescape
 		return data, nil
 
-	case "<integer>":
-		if v.Kind() != reflect.Int {
-			return nil, fmt.Errorf("cannot unmarshal <integer> into non-int %s", v.Type())
-		}
-		body, etag, data := next(data)
-		if len(etag) == 0 {
-			return nil, fmt.Errorf("eof inside <integer>")
+	case "<string/>":
+		return data, setString(v, "")
+
+	case "<data/>":
+		return data, setData(v, []byte{})
+
+	case "<true/>", "<false/>":
+		return data, setBool(v, stag == "<true/>")
+
+	case "<string>", "<integer>", "<real>", "<date>", "<data>":
+		name := stag[1 : len(stag)-1]
+		body, data, err := element(data, name)
+		if err != nil {
+			return nil, err
 		}
-		if string(etag) != "</integer>" {
-			return nil, fmt.Errorf("expected </integer> but got %s", etag)
+		switch name {
+		case "string":
+			err = setString(v, body)
+		case "integer":
+			err = setXMLInt(v, body)
+		case "real":
+			var f float64
+			if f, err = parseReal(body); err == nil {
+				err = setReal(v, f)
+			}
+		case "date":
+			var t time.Time
+			if t, err = time.Parse(time.RFC3339, strings.TrimSpace(body)); err != nil {
+				err = fmt.Errorf("invalid date in <date> tag: %s", body)
+			} else {
+				err = setDate(v, t.UTC())
+			}
+		case "data":
+			var b []byte
+			if b, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), "")); err != nil {
+				err = fmt.Errorf("invalid base64 in <data> tag")
+			} else {
+				err = setData(v, b)
+			}
 		}
-		i, err := strconv.Atoi(string(body))
 		if err != nil {
-			return nil, fmt.Errorf("non-integer in <integer> tag: %s", body)
+			return nil, err
 		}
-		v.SetInt(int64(i))
-		return data, nil
-
-	case "<true/>", "<false/>":
-		if v.Kind() != reflect.Bool {
-			return nil, fmt.Errorf("cannot unmarshal %s into non-bool %s", stag, v.Type())
-		}
-		v.SetBool(stag == "<true/>")
 		return data, nil
 	}
 	return nil, fmt.Errorf("unexpected tag %s", tag)
 }
 
+// element returns the unescaped text following the start tag <name>
+// up to the end tag </name>, and the data after the end tag.
+// Stray markup in the text, which some plist writers
+// fail to escape, is kept as is.
+func element(data []byte, name string) (body string, rest []byte, err error) {
+	end := "</" + name + ">"
+	i := bytes.Index(data, []byte(end))
+	if i < 0 {
+		return "", nil, fmt.Errorf("eof inside <%s>", name)
+	}
+	return html.UnescapeString(string(data[:i])), data[i+len(end):], nil
+}
+
+// setXMLInt stores the integer in the text s into v.
+func setXMLInt(v reflect.Value, s string) error {
+	s = strings.TrimSpace(s)
+	if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return setInt(v, i)
+	}
+	if u, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return setUint(v, u)
+	}
+	return fmt.Errorf("non-integer in <integer> tag: %s", s)
+}
+
+// parseReal parses the text of a <real> tag.
+func parseReal(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "inf", "+inf", "infinity", "+infinity":
+		return math.Inf(1), nil
+	case "-inf", "-infinity":
+		return math.Inf(-1), nil
+	case "nan":
+		return math.NaN(), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("non-real in <real> tag: %s", s)
+	}
+	return f, nil
+}
+
 func skipValue(data []byte) (rest []byte, err error) {
 	n := 0
 	for {
@@ -199,3 +316,96 @@ func skipValue(data []byte) (rest []byte, err error) {
 	}
 	return data, nil
 }
+
+var (
+	mapType     = reflect.TypeOf(map[string]interface{}{})
+	sliceType   = reflect.TypeOf([]interface{}{})
+	stringType  = reflect.TypeOf("")
+	int64Type   = reflect.TypeOf(int64(0))
+	uint64Type  = reflect.TypeOf(uint64(0))
+	float64Type = reflect.TypeOf(float64(0))
+	boolType    = reflect.TypeOf(false)
+	bytesType   = reflect.TypeOf([]byte(nil))
+)
+
+// isGeneric reports whether v is an empty interface,
+// which holds whatever type of value is decoded into it.
+func isGeneric(v reflect.Value) bool {
+	return v.Kind() == reflect.Interface && v.NumMethod() == 0
+}
+
+// checkDict checks that a dict can be stored in v,
+// allocating a map if necessary.
+func checkDict(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() != timeType {
+			return nil
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		return nil
+	}
+	return fmt.Errorf("cannot unmarshal dict into %s", v.Type())
+}
+
+// dictEntry returns the value to hold the dict entry with the given key
+// when decoding into v, which has been checked by checkDict, along
+// with a function to call once the value has been decoded.
+// If the entry should be ignored, dictEntry returns an invalid Value.
+func dictEntry(v reflect.Value, key string) (elem reflect.Value, set func()) {
+	if v.Kind() == reflect.Map {
+		elem = reflect.New(v.Type().Elem()).Elem()
+		return elem, func() { v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem) }
+	}
+	if i := fieldByKey(v.Type(), key); i >= 0 {
+		return v.Field(i), func() {}
+	}
+	return reflect.Value{}, func() {}
+}
+
+// fieldByKey returns the index of the field in struct type t
+// that holds the dict entry with the given key, or -1.
+func fieldByKey(t reflect.Type, key string) int {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, skip := parseTag(f)
+		if skip {
+			continue
+		}
+		if f.Name == key || name == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseTag returns the dict key for struct field f, whether the
+// field has the omitempty option, and whether the field should
+// be skipped entirely.  The plist struct tag has the form
+// "key,omitempty", where either part may be omitted;
+// the tag "-" means to skip the field.
+func parseTag(f reflect.StructField) (key string, omitempty, skip bool) {
+	if f.PkgPath != "" {
+		// Unexported.
+		return "", false, true
+	}
+	tag := f.Tag.Get("plist")
+	if tag == "-" {
+		return "", false, true
+	}
+	key = tag
+	if i := strings.Index(tag, ","); i >= 0 {
+		key = tag[:i]
+		omitempty = tag[i+1:] == "omitempty"
+	}
+	if key == "" {
+		key = f.Name
+	}
+	return key, omitempty, false
+}
@@ -5,8 +5,10 @@
 package plist
 
 import (
+	"math"
 	"reflect"
 	"testing"
+	"time"
 )
 
 var thePlist = `<plist version="1.0">
@@ -147,3 +149,134 @@ func TestUnmarshal(t *testing.T) {
 		}
 	}
 }
+
+var typesPlist = xmlPrefix + `<plist version="1.0">
+<dict>
+	<key>Ratio</key>
+	<real>0.5</real>
+	<key>Small</key>
+	<real>-1.25e-3</real>
+	<key>Inf</key>
+	<real>+infinity</real>
+	<key>When</key>
+	<date>2012-03-04T05:06:07Z</date>
+	<key>Blob</key>
+	<data>
+	AAEC
+	/w==
+	</data>
+	<key>None</key>
+	<data/>
+	<key>Big</key>
+	<integer>-1099511627776</integer>
+	<key>Huge</key>
+	<integer>18446744073709551615</integer>
+	<key>Name</key>
+	<string>a &lt;b&gt; &amp; c</string>
+	<key>Tags</key>
+	<dict>
+		<key>x</key>
+		<integer>1</integer>
+		<key>y</key>
+		<integer>2</integer>
+	</dict>
+</dict>
+</plist>
+`
+
+type typesStruct struct {
+	Ratio float64
+	Small float32
+	Inf   float64
+	When  time.Time
+	Blob  []byte
+	None  []byte
+	Big   int64
+	Huge  uint64
+	Name  string
+	Tags  map[string]int
+}
+
+func TestUnmarshalTypes(t *testing.T) {
+	var v typesStruct
+	if err := Unmarshal([]byte(typesPlist), &v); err != nil {
+		t.Fatal(err)
+	}
+	want := typesStruct{
+		Ratio: 0.5,
+		Small: -1.25e-3,
+		Inf:   math.Inf(1),
+		When:  time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC),
+		Blob:  []byte{0, 1, 2, 0xff},
+		None:  []byte{},
+		Big:   -1 << 40,
+		Huge:  1<<64 - 1,
+		Name:  "a <b> & c",
+		Tags:  map[string]int{"x": 1, "y": 2},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Unmarshal:\nhave %+v\nwant %+v", v, want)
+	}
+}
+
+func TestUnmarshalGeneric(t *testing.T) {
+	var v interface{}
+	if err := Unmarshal([]byte(thePlist), &v); err != nil {
+		t.Fatal(err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Unmarshal stored %T, want map[string]interface{}", v)
+	}
+	if m["BucketName"] != "rsc" || m["Enabled"] != true || m["Disabled"] != false {
+		t.Errorf("Unmarshal = %v", m)
+	}
+	paths, ok := m["IgnoredRelativePaths"].([]interface{})
+	if !ok || len(paths) != 4 || paths[1] != "/go/pkg" {
+		t.Errorf("IgnoredRelativePaths = %#v", m["IgnoredRelativePaths"])
+	}
+	ex := m["Excludes"].(map[string]interface{})["excludes"].([]interface{})[0].(map[string]interface{})
+	if ex["type"] != int64(2) || ex["text"] != ".unison." {
+		t.Errorf("excludes = %#v", ex)
+	}
+
+	v = nil
+	if err := Unmarshal([]byte(typesPlist), &v); err != nil {
+		t.Fatal(err)
+	}
+	m = v.(map[string]interface{})
+	if m["Ratio"] != 0.5 || m["Big"] != int64(-1<<40) || m["Huge"] != uint64(1<<64-1) {
+		t.Errorf("Unmarshal = %v", m)
+	}
+	if when, ok := m["When"].(time.Time); !ok || !when.Equal(time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)) {
+		t.Errorf("When = %#v", m["When"])
+	}
+	if blob, ok := m["Blob"].([]byte); !ok || string(blob) != "\x00\x01\x02\xff" {
+		t.Errorf("Blob = %#v", m["Blob"])
+	}
+}
+
+var unmarshalErrorTests = []string{
+	`<plist version="1.0"><real>x</real></plist>`,
+	`<plist version="1.0"><date>yesterday</date></plist>`,
+	`<plist version="1.0"><data>!!</data></plist>`,
+	`<plist version="1.0"><integer>1.5</integer></plist>`,
+	`<plist version="1.0"><dict><key>Name</key><integer>1</integer></dict></plist>`,
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	for _, in := range unmarshalErrorTests {
+		var v struct {
+			Name string
+		}
+		var f float64
+		var d time.Time
+		var b []byte
+		var i int
+		for _, p := range []interface{}{&v, &f, &d, &b, &i} {
+			if err := Unmarshal([]byte(in), p); err == nil {
+				t.Errorf("Unmarshal(%q, %T) succeeded", in, p)
+			}
+		}
+	}
+}
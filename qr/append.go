@@ -0,0 +1,141 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package qr
+
+// Structured append: spreading a message over several codes.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+
+	"code.google.com/p/rsc/qr/coding"
+)
+
+// A Part is one code in a structured append sequence.
+type Part struct {
+	Index  int    // position in the sequence, from 0
+	Total  int    // number of codes in the sequence
+	Parity byte   // XOR of all the bytes in the full message
+	Text   string // text held in this code
+}
+
+// parity returns the structured append parity of text.
+func parity(text string) byte {
+	var p byte
+	for i := 0; i < len(text); i++ {
+		p ^= text[i]
+	}
+	return p
+}
+
+// EncodeAppend returns a sequence of codes that together hold text,
+// for text too long to fit in a single code.  Each code begins with
+// a structured append header giving its position in the sequence,
+// so that a reader can reassemble the message with Join.
+// Each code except the last is as large as possible;
+// a sequence holds at most 16 codes.
+// UTF-8 sequences in text are not split between codes.
+func EncodeAppend(text string, level Level) ([]*Code, error) {
+	const header = 4 + 4 + 4 + 8
+	if text == "" {
+		return nil, errors.New("no text to encode")
+	}
+
+	// Cut text greedily into pieces that fit in a single code.
+	var pieces []string
+	for rest := text; rest != ""; {
+		// Binary search for the longest prefix that fits:
+		// the cost of encoding a prefix never decreases
+		// as the prefix grows.
+		lo, hi := 0, len(rest)+1
+		for hi-lo > 1 {
+			mid := (lo + hi) / 2
+			if _, _, ok := segments(rest[:mid], level, header, 1, 40); ok {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		n := lo
+		for n < len(rest) && n > 0 && !utf8.RuneStart(rest[n]) {
+			n--
+		}
+		if n == 0 {
+			n = lo
+		}
+		if n == 0 {
+			return nil, errors.New("text too long to encode as QR")
+		}
+		pieces = append(pieces, rest[:n])
+		rest = rest[n:]
+		if len(pieces) > 16 {
+			return nil, errors.New("text too long to encode as 16 QR codes")
+		}
+	}
+
+	p := parity(text)
+	var codes []*Code
+	for i, piece := range pieces {
+		list, v, _ := segments(piece, level, header, 1, 40)
+		a := coding.Append{Index: i, Total: len(pieces), Parity: p}
+		c, _, err := bestMask(append([]coding.Encoding{a}, list...), v, level)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, c)
+	}
+	return codes, nil
+}
+
+// Join reassembles a message from the parts of a structured
+// append sequence, given in any order.  It checks that all the
+// parts belong to the same sequence, that none are missing,
+// and that the message matches the sequence's parity byte.
+func Join(parts []*Part) (string, error) {
+	if len(parts) == 0 {
+		return "", errors.New("no QR codes to join")
+	}
+	total, p := parts[0].Total, parts[0].Parity
+	for _, part := range parts {
+		if part.Total != total || part.Parity != p {
+			return "", errors.New("QR codes are from different sequences")
+		}
+	}
+
+	list := make([]*Part, len(parts))
+	copy(list, parts)
+	sort.Sort(byIndex(list))
+	for i, part := range list {
+		if i > 0 && part.Index == list[i-1].Index {
+			return "", fmt.Errorf("duplicate QR code %d of %d", part.Index+1, total)
+		}
+	}
+	for i := 0; i < total; i++ {
+		if i >= len(list) || list[i].Index != i {
+			return "", fmt.Errorf("missing QR code %d of %d", i+1, total)
+		}
+	}
+	if len(list) > total {
+		return "", fmt.Errorf("invalid QR code %d of %d", list[total].Index+1, total)
+	}
+
+	var buf bytes.Buffer
+	for _, part := range list {
+		buf.WriteString(part.Text)
+	}
+	if parity(buf.String()) != p {
+		return "", errors.New("QR structured append parity mismatch")
+	}
+	return buf.String(), nil
+}
+
+type byIndex []*Part
+
+func (x byIndex) Len() int           { return len(x) }
+func (x byIndex) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+func (x byIndex) Less(i, j int) bool { return x[i].Index < x[j].Index }
@@ -0,0 +1,344 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package coding
+
+// QR decoding.
+
+import (
+	"fmt"
+
+	"code.google.com/p/rsc/gf256"
+)
+
+// SizeVersion returns the version of a QR code with size pixels on a side.
+func SizeVersion(size int) (Version, error) {
+	if size < 21 || size > 177 || (size-17)%4 != 0 {
+		return 0, fmt.Errorf("invalid QR size %d", size)
+	}
+	return Version((size - 17) / 4), nil
+}
+
+// ReadFormat reads the format pixels of c and returns
+// the error correction level and mask they record.
+// Each copy of the format information can tolerate
+// up to three incorrect pixels.
+func ReadFormat(c *Code) (Level, Mask, error) {
+	v, err := SizeVersion(c.Size)
+	if err != nil {
+		return 0, 0, err
+	}
+	p, err := vplan(v)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := fplan(L, 0, p); err != nil {
+		return 0, 0, err
+	}
+
+	// Each format bit appears twice.
+	// Collect the two copies separately.
+	var fb [2]uint32
+	var seen uint32
+	for y, row := range p.Pixel {
+		for x, pix := range row {
+			if pix.Role() != Format {
+				continue
+			}
+			o := pix.Offset()
+			i := 0
+			if seen&(1<<o) != 0 {
+				i = 1
+			}
+			seen |= 1 << o
+			if c.Black(x, y) {
+				fb[i] |= 1 << o
+			}
+		}
+	}
+
+	best, bestDist := -1, 16
+	for f := 0; f < 32; f++ {
+		want := formatBits(Level(f>>3), Mask(f&7))
+		for _, have := range fb {
+			if d := hamming(want, have); d < bestDist {
+				best, bestDist = f, d
+			}
+		}
+	}
+	if bestDist > 3 {
+		return 0, 0, fmt.Errorf("unreadable QR format information")
+	}
+	return Level(best >> 3), Mask(best & 7), nil
+}
+
+// formatBits returns the 15 format pixels, as they appear
+// in the code, for the given level and mask.
+func formatBits(l Level, m Mask) uint32 {
+	p := &Plan{Pixel: grid(21)}
+	fplan(l, m, p)
+	var fb uint32
+	for _, row := range p.Pixel {
+		for _, pix := range row {
+			if pix.Role() == Format && pix&Black != 0 {
+				fb |= 1 << pix.Offset()
+			}
+		}
+	}
+	return fb
+}
+
+// ReadVersion returns the version of c.
+// For versions 7 and up, which record the version in the code itself,
+// ReadVersion reads the version pixels, tolerating up to three
+// incorrect pixels in each copy.
+// For smaller codes, the version is determined by c.Size.
+func ReadVersion(c *Code) (Version, error) {
+	v, err := SizeVersion(c.Size)
+	if err != nil {
+		return 0, err
+	}
+	if v < 7 {
+		return v, nil
+	}
+
+	// See vplan for the layout.
+	var pat [2]int
+	siz := c.Size
+	i := uint(0)
+	for x := 0; x < 6; x++ {
+		for y := 0; y < 3; y++ {
+			if c.Black(x, siz-11+y) {
+				pat[0] |= 1 << i
+			}
+			if c.Black(siz-11+y, x) {
+				pat[1] |= 1 << i
+			}
+			i++
+		}
+	}
+
+	best, bestDist := Version(0), 19
+	for v := Version(7); v <= MaxVersion; v++ {
+		for _, have := range pat {
+			if d := hamming(uint32(vtab[v].pattern), uint32(have)); d < bestDist {
+				best, bestDist = v, d
+			}
+		}
+	}
+	if bestDist > 3 {
+		return 0, fmt.Errorf("unreadable QR version information")
+	}
+	return best, nil
+}
+
+// hamming returns the number of bits in which x and y differ.
+func hamming(x, y uint32) int {
+	n := 0
+	for z := x ^ y; z != 0; z &= z - 1 {
+		n++
+	}
+	return n
+}
+
+// Decode returns the encodings stored in c,
+// which must use p's version, level, and mask.
+// Decode corrects as many errors in c as the
+// error correction level allows.
+func (p *Plan) Decode(c *Code) ([]Encoding, error) {
+	if c.Size != len(p.Pixel) {
+		return nil, fmt.Errorf("cannot decode %d-pixel code using %d-pixel plan", c.Size, len(p.Pixel))
+	}
+
+	// Collect the data and check bytes, undoing the mask.
+	// The pixel offsets take care of the interleaving.
+	bytes := make([]byte, p.DataBytes+p.CheckBytes)
+	for y, row := range p.Pixel {
+		for x, pix := range row {
+			switch pix.Role() {
+			case Data, Check:
+				black := c.Black(x, y)
+				if pix&Invert != 0 {
+					black = !black
+				}
+				if black {
+					o := pix.Offset()
+					bytes[o/8] |= 1 << uint(7-o&7)
+				}
+			}
+		}
+	}
+
+	// Correct each block.
+	data, check := bytes[:p.DataBytes], bytes[p.DataBytes:]
+	db := p.DataBytes / p.Blocks
+	extra := p.DataBytes % p.Blocks
+	nc := p.CheckBytes / p.Blocks
+	rs := gf256.NewRSDecoder(Field, nc)
+	for i := 0; i < p.Blocks; i++ {
+		if i == p.Blocks-extra {
+			db++
+		}
+		if _, err := rs.Correct(data[:db], check[:nc], nil); err != nil {
+			return nil, fmt.Errorf("too many errors in QR block %d", i)
+		}
+		data = data[db:]
+		check = check[nc:]
+	}
+
+	return parse(bytes[:p.DataBytes], p.Version)
+}
+
+// parse parses the data bytes of a version v code
+// into a list of encodings.
+func parse(data []byte, v Version) ([]Encoding, error) {
+	// The terminator is 4 zero bits in a QR code.
+	// In a Micro QR code, it is the mode indicator and
+	// length of an empty numeric segment.
+	term := 4
+	if v.Micro() {
+		term = v.modeLen() + numLen[v.sizeClass()]
+	}
+
+	var list []Encoding
+	r := &bitReader{b: data}
+	for r.left() >= term {
+		var mode uint
+		if v.Micro() {
+			// Translate to the QR mode indicators.
+			m := r.read(v.modeLen())
+			if m > 3 {
+				return nil, fmt.Errorf("unsupported QR data mode %d", m)
+			}
+			mode = [4]uint{1, 2, 4, 8}[m]
+		} else {
+			mode = r.read(4)
+		}
+		if mode == 0 {
+			break
+		}
+		switch mode {
+		case 1:
+			n := int(r.read(numLen[v.sizeClass()]))
+			if n == 0 && v.Micro() {
+				return list, nil
+			}
+			s := make([]byte, 0, n)
+			for ; n >= 3; n -= 3 {
+				w := r.read(10)
+				if w >= 1000 {
+					return nil, fmt.Errorf("invalid numeric data")
+				}
+				s = append(s, byte('0'+w/100), byte('0'+w/10%10), byte('0'+w%10))
+			}
+			switch n {
+			case 1:
+				w := r.read(4)
+				if w >= 10 {
+					return nil, fmt.Errorf("invalid numeric data")
+				}
+				s = append(s, byte('0'+w))
+			case 2:
+				w := r.read(7)
+				if w >= 100 {
+					return nil, fmt.Errorf("invalid numeric data")
+				}
+				s = append(s, byte('0'+w/10), byte('0'+w%10))
+			}
+			list = append(list, Num(s))
+		case 2:
+			n := int(r.read(alphaLen[v.sizeClass()]))
+			s := make([]byte, 0, n)
+			for ; n >= 2; n -= 2 {
+				w := r.read(11)
+				if w >= 45*45 {
+					return nil, fmt.Errorf("invalid alphanumeric data")
+				}
+				s = append(s, alphabet[w/45], alphabet[w%45])
+			}
+			if n == 1 {
+				w := r.read(6)
+				if w >= 45 {
+					return nil, fmt.Errorf("invalid alphanumeric data")
+				}
+				s = append(s, alphabet[w])
+			}
+			list = append(list, Alpha(s))
+		case 3:
+			var a Append
+			a.Index = int(r.read(4))
+			a.Total = int(r.read(4)) + 1
+			a.Parity = byte(r.read(8))
+			list = append(list, a)
+		case 4:
+			n := int(r.read(stringLen[v.sizeClass()]))
+			s := make([]byte, n)
+			for i := range s {
+				s[i] = byte(r.read(8))
+			}
+			list = append(list, String(s))
+		case 7:
+			var e uint
+			switch w := r.read(8); {
+			case w&0x80 == 0:
+				e = w
+			case w&0xC0 == 0x80:
+				e = (w&0x3F)<<8 | r.read(8)
+			case w&0xE0 == 0xC0:
+				e = (w&0x1F)<<16 | r.read(16)
+			default:
+				return nil, fmt.Errorf("invalid ECI designator")
+			}
+			list = append(list, ECI(e))
+		case 8:
+			n := int(r.read(kanjiLen[v.sizeClass()]))
+			s := make([]byte, 0, 2*n)
+			for ; n > 0; n-- {
+				w := r.read(13)
+				c := w/0xC0<<8 | w%0xC0
+				if c < 0x1F00 {
+					c += 0x8140
+				} else {
+					c += 0xC140
+				}
+				s = append(s, byte(c>>8), byte(c))
+			}
+			list = append(list, Kanji(s))
+		default:
+			return nil, fmt.Errorf("unsupported QR data mode %d", mode)
+		}
+		if r.err {
+			return nil, fmt.Errorf("truncated QR data")
+		}
+	}
+	return list, nil
+}
+
+// A bitReader reads bits from a byte slice, most significant bit first.
+type bitReader struct {
+	b    []byte
+	nbit int  // number of bits already read
+	err  bool // read past end of b
+}
+
+func (r *bitReader) left() int {
+	return 8*len(r.b) - r.nbit
+}
+
+func (r *bitReader) read(nbit int) uint {
+	if nbit > r.left() {
+		r.err = true
+		r.nbit = 8 * len(r.b)
+		return 0
+	}
+	var v uint
+	for ; nbit > 0; nbit-- {
+		v <<= 1
+		if r.b[r.nbit/8]&(1<<uint(7-r.nbit&7)) != 0 {
+			v |= 1
+		}
+		r.nbit++
+	}
+	return v
+}
@@ -0,0 +1,185 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package coding
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	text := []Encoding{Alpha("HI"), Num("123"), String("x")}
+	for v := Version(1); v <= 40; v++ {
+		for l := L; l <= H; l++ {
+			m := Mask(int(v) % 8)
+			p, err := NewPlan(v, l, m)
+			if err != nil {
+				t.Fatalf("NewPlan(%v, %v, %d): %v", v, l, m, err)
+			}
+			c, err := p.Encode(text...)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if vv, err := ReadVersion(c); vv != v || err != nil {
+				t.Errorf("%v/%v: ReadVersion = %v, %v", v, l, vv, err)
+			}
+			if ll, mm, err := ReadFormat(c); ll != l || mm != m || err != nil {
+				t.Errorf("%v/%v: ReadFormat = %v, %d, %v, want %v, %d", v, l, ll, mm, err, l, m)
+			}
+			out, err := p.Decode(c)
+			if err != nil {
+				t.Errorf("%v/%v: Decode: %v", v, l, err)
+				continue
+			}
+			if !reflect.DeepEqual(out, text) {
+				t.Errorf("%v/%v: Decode = %v, want %v", v, l, out, text)
+			}
+		}
+	}
+}
+
+func TestReadFormatDamaged(t *testing.T) {
+	p, err := NewPlan(7, Q, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Encode(String("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flip the pixels of the upper left copy of the format and version information.
+	for i := 0; i < 6; i++ {
+		c.Bitmap[i*c.Stride+1] ^= 0x80
+		c.Bitmap[i*c.Stride+(c.Size-10)/8] ^= 1 << uint(7-(c.Size-10)&7)
+	}
+	if l, m, err := ReadFormat(c); l != Q || m != 5 || err != nil {
+		t.Errorf("ReadFormat = %v, %d, %v, want Q, 5, nil", l, m, err)
+	}
+	if v, err := ReadVersion(c); v != 7 || err != nil {
+		t.Errorf("ReadVersion = %v, %v, want 7, nil", v, err)
+	}
+}
+
+func TestDecodeDamaged(t *testing.T) {
+	text := []Encoding{String("hello, world")}
+	p, err := NewPlan(5, M, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Encode(text...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Version 5-M has two blocks with 24 check bytes each,
+	// so it can correct 12 bytes in each block.
+	// Flip a 6x6 square of data pixels, which touches
+	// at most 9 or so bytes.
+	for y := 15; y < 21; y++ {
+		for x := 15; x < 21; x++ {
+			c.Bitmap[y*c.Stride+x/8] ^= 1 << uint(7-x&7)
+		}
+	}
+	out, err := p.Decode(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, text) {
+		t.Errorf("Decode = %v, want %v", out, text)
+	}
+
+	// Flipping every data pixel is too much damage.
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if r := p.Pixel[y][x].Role(); r == Data || r == Check {
+				c.Bitmap[y*c.Stride+x/8] ^= 1 << uint(7-x&7)
+			}
+		}
+	}
+	if out, err := p.Decode(c); err == nil {
+		t.Errorf("Decode of inverted code = %v, want error", out)
+	}
+}
+
+func TestKanjiECI(t *testing.T) {
+	// The example from the QR spec: 点茗.
+	var b Bits
+	k := Kanji("\x93\x5f\xe4\xaa")
+	if err := k.Check(); err != nil {
+		t.Fatal(err)
+	}
+	k.Encode(&b, 1)
+	if b.Bits() != k.Bits(1) {
+		t.Errorf("Kanji.Encode wrote %d bits, Bits = %d", b.Bits(), k.Bits(1))
+	}
+	// 1000 00000010 0110110011111 1101010101010
+	b.Pad(-b.Bits() & 7)
+	if want := []byte{0x80, 0x26, 0xcf, 0xea, 0xa8}; !bytes.Equal(b.Bytes(), want) {
+		t.Errorf("Kanji.Encode = %x, want %x", b.Bytes(), want)
+	}
+	if err := Kanji("\x93").Check(); err == nil {
+		t.Errorf("odd-length Kanji passed Check")
+	}
+	if err := Kanji("AB").Check(); err == nil {
+		t.Errorf("ASCII Kanji passed Check")
+	}
+
+	for _, e := range []ECI{UTF8, 127, 128, 16383, 16384, 999999} {
+		b.Reset()
+		e.Encode(&b, 1)
+		if b.Bits() != e.Bits(1) {
+			t.Errorf("%v.Encode wrote %d bits, Bits = %d", e, b.Bits(), e.Bits(1))
+		}
+	}
+	if err := ECI(1000000).Check(); err == nil {
+		t.Errorf("ECI(1000000) passed Check")
+	}
+
+	text := []Encoding{ECI(16384), Kanji("\x93\x5f\xe4\xaa\x88\x9f"), UTF8, String("héllo")}
+	for _, v := range []Version{1, 10, 27} {
+		p, err := NewPlan(v, L, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := p.Encode(text...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := p.Decode(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, text) {
+			t.Errorf("%v: Decode = %v, want %v", v, out, text)
+		}
+	}
+}
+
+func TestAppend(t *testing.T) {
+	text := []Encoding{Append{Index: 2, Total: 16, Parity: 0xa5}, String("part three")}
+	p, err := NewPlan(2, M, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Encode(text...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := p.Decode(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, text) {
+		t.Errorf("Decode = %v, want %v", out, text)
+	}
+	for _, a := range []Append{{0, 0, 0}, {0, 17, 0}, {3, 3, 0}, {-1, 2, 0}} {
+		if err := a.Check(); err == nil {
+			t.Errorf("%v passed Check", a)
+		}
+	}
+	if err := checkMicro(text[0], M4); err == nil {
+		t.Errorf("Append allowed in Micro QR")
+	}
+}
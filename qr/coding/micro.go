@@ -0,0 +1,203 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package coding
+
+// Micro QR codes.
+
+import (
+	"fmt"
+
+	"code.google.com/p/rsc/gf256"
+)
+
+// The Micro QR versions.
+// A Micro QR code with version Mn has 2n+9 pixels on a side.
+// Micro QR codes have a single position square and
+// hold at most 35 digits (M4 at level L).
+const (
+	M1 Version = -1 - iota
+	M2
+	M3
+	M4
+)
+
+// Micro reports whether v is a Micro QR version.
+func (v Version) Micro() bool {
+	return v < 0
+}
+
+// mtab gives the number of data bits and check bytes in a Micro QR code,
+// indexed by version (1-4 for M1-M4) and level (L, M, Q).
+// A zero entry means the version does not offer that level.
+// M1 only detects errors; it is listed as level L.
+var mtab = [5][3]struct {
+	bits  int
+	check int
+}{
+	{},
+	{{20, 2}},                       // M1
+	{{40, 5}, {32, 6}},              // M2
+	{{84, 6}, {68, 8}},              // M3
+	{{128, 8}, {112, 10}, {80, 14}}, // M4
+}
+
+// microSymbol gives the symbol number recorded in
+// the format pixels for version Mn at level L.
+// Higher levels count up from there.
+var microSymbol = [5]uint32{0, 0, 1, 3, 5}
+
+// microMask maps the four Micro QR masks to the
+// equivalent QR masks (see mfunc).
+var microMask = [4]Mask{1, 4, 6, 7}
+
+// checkMicro checks that the encoding t can be used in version v.
+func checkMicro(t Encoding, v Version) error {
+	if !v.Micro() {
+		return nil
+	}
+	ok := true
+	switch t.(type) {
+	case Alpha:
+		ok = alphaLen[v.sizeClass()] > 0
+	case String:
+		ok = stringLen[v.sizeClass()] > 0
+	case Kanji:
+		ok = kanjiLen[v.sizeClass()] > 0
+	case ECI, Append:
+		ok = false
+	}
+	if !ok {
+		return fmt.Errorf("Micro QR version %v cannot hold %v", v, t)
+	}
+	return nil
+}
+
+func (b *Bits) addMicroCheckBytes(v Version, l Level) {
+	nd := v.DataBits(l)
+	if b.nbit > nd {
+		panic("qr: too much data")
+	}
+
+	// Terminator, cut short if the code is full.
+	n := 2*int(-v) + 1
+	if n > nd-b.nbit {
+		n = nd - b.nbit
+	}
+	b.Write(0, n)
+
+	// Pad bytes, with the final 4-bit data byte
+	// in M1 and M3 left zero.
+	b.Write(0, -b.nbit&7)
+	for pad := uint(0xec); b.nbit+8 <= nd; pad ^= 0xec ^ 0x11 {
+		b.Write(pad, 8)
+	}
+	b.Write(0, v.DataBytes(l)*8-b.nbit)
+
+	check := mtab[-v][l].check
+	chk := make([]byte, check)
+	gf256.NewRSEncoder(Field, check).ECC(b.Bytes(), chk)
+	b.Append(chk)
+}
+
+// microPlan returns a Plan for a Micro QR code.
+func microPlan(v Version, l Level, mask Mask) (*Plan, error) {
+	if v < M4 {
+		return nil, fmt.Errorf("invalid QR version %d", int(v))
+	}
+	if v.DataBits(l) == 0 {
+		return nil, fmt.Errorf("Micro QR version %v does not support level %v", v, l)
+	}
+	if mask < 0 || mask > 3 {
+		return nil, fmt.Errorf("invalid Micro QR mask %d", int(mask))
+	}
+
+	p := &Plan{
+		Version:    v,
+		Level:      l,
+		Mask:       mask,
+		DataBytes:  v.DataBytes(l),
+		CheckBytes: mtab[-v][l].check,
+		Blocks:     1,
+	}
+	siz := 2*int(-v) + 9
+	m := grid(siz)
+	p.Pixel = m
+
+	// Position box and timing along the top and left edges.
+	posBox(m, 0, 0)
+	for i := 8; i < siz; i++ {
+		p := Timing.Pixel()
+		if i&1 == 0 {
+			p |= Black
+		}
+		m[0][i] = p
+		m[i][0] = p
+	}
+
+	// Format pixels, next to the position box.
+	fb := (microSymbol[-v] + uint32(l)) << 12
+	fb |= uint32(mask) << 10
+	fb |= formatCheck(fb)
+	invert := uint32(0x4445)
+	for i := uint(0); i < 15; i++ {
+		pix := Format.Pixel() + OffsetPixel(i)
+		if (fb>>i)&1 == 1 {
+			pix |= Black
+		}
+		if (invert>>i)&1 == 1 {
+			pix ^= Invert | Black
+		}
+		if i < 8 {
+			m[i+1][8] = pix
+		} else {
+			m[8][15-i] = pix
+		}
+	}
+
+	// Data and check pixels.
+	// In M1 and M3 only the top 4 bits of
+	// the last data byte appear in the code.
+	dataBits := v.DataBits(l)
+	src := make([]Pixel, 0, dataBits+p.CheckBytes*8)
+	for i := 0; i < dataBits; i++ {
+		src = append(src, Data.Pixel()|OffsetPixel(uint(i)))
+	}
+	for i := 0; i < p.CheckBytes*8; i++ {
+		src = append(src, Check.Pixel()|OffsetPixel(uint(p.DataBytes*8+i)))
+	}
+
+	// Sweep pairs of columns from the right edge, alternately
+	// up and down, as in lplan.  Column 0 is all timing and
+	// format, so there is no need to skip over a timing column.
+	up := true
+	for x := siz - 1; x > 0; x -= 2 {
+		for i := 0; i < siz; i++ {
+			y := i
+			if up {
+				y = siz - 1 - i
+			}
+			for _, xx := range []int{x, x - 1} {
+				if m[y][xx].Role() == 0 {
+					m[y][xx], src = src[0], src[1:]
+				}
+			}
+		}
+		up = !up
+	}
+	if len(src) != 0 {
+		panic("qr: micro data/check math")
+	}
+
+	// Mask.
+	f := mfunc[microMask[mask]]
+	for y, row := range m {
+		for x, pix := range row {
+			if r := pix.Role(); (r == Data || r == Check) && f(y, x) {
+				row[x] ^= Black | Invert
+			}
+		}
+	}
+	return p, nil
+}
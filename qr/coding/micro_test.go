@@ -0,0 +1,127 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package coding
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMicroCodewords(t *testing.T) {
+	// The example from the QR spec: 01234567 in M2-L.
+	var b Bits
+	Num("01234567").Encode(&b, M2)
+	b.AddCheckBytes(M2, L)
+	want := []byte{0x40, 0x18, 0xac, 0xc3, 0x00, 0x86, 0x0d, 0x22, 0xae, 0x30}
+	if !bytes.Equal(b.Bytes(), want) {
+		t.Errorf("M2-L 01234567 = %x, want %x", b.Bytes(), want)
+	}
+}
+
+func TestMicroPlan(t *testing.T) {
+	for v := M1; v >= M4; v-- {
+		for l := L; l <= H; l++ {
+			for m := Mask(0); m < 4; m++ {
+				p, err := NewPlan(v, l, m)
+				if v.DataBits(l) == 0 {
+					if err == nil {
+						t.Errorf("NewPlan(%v, %v, %d) succeeded", v, l, m)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("NewPlan(%v, %v, %d): %v", v, l, m, err)
+					continue
+				}
+				if siz := len(p.Pixel); siz != 2*int(-v)+9 {
+					t.Errorf("%v: size %d", v, siz)
+				}
+				// Every pixel has a role, and the data and check
+				// pixels cover exactly the data bits and check bytes.
+				n := 0
+				for _, row := range p.Pixel {
+					for _, pix := range row {
+						switch pix.Role() {
+						case 0:
+							t.Fatalf("%v-%v: unassigned pixel", v, l)
+						case Data, Check:
+							n++
+						}
+					}
+				}
+				if want := v.DataBits(l) + 8*p.CheckBytes; n != want {
+					t.Errorf("%v-%v: %d data+check pixels, want %d", v, l, n, want)
+				}
+			}
+		}
+	}
+	if _, err := NewPlan(M2, L, 4); err == nil {
+		t.Errorf("NewPlan(M2, L, 4) succeeded")
+	}
+}
+
+func TestMicroDecode(t *testing.T) {
+	tests := []struct {
+		v    Version
+		l    Level
+		text []Encoding
+	}{
+		{M1, L, []Encoding{Num("12345")}},
+		{M2, L, []Encoding{Alpha("AB"), Num("123")}},
+		{M2, M, []Encoding{Num("123456")}},
+		{M3, L, []Encoding{String("hi"), Num("42"), Kanji("\x93\x5f")}},
+		{M3, M, []Encoding{Alpha("QR CODE")}},
+		{M4, L, []Encoding{String("hello, world")}},
+		{M4, Q, []Encoding{Num("0123456789")}},
+	}
+	for _, tt := range tests {
+		for m := Mask(0); m < 4; m++ {
+			p, err := NewPlan(tt.v, tt.l, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := p.Encode(tt.text...)
+			if err != nil {
+				t.Errorf("%v-%v: Encode(%v): %v", tt.v, tt.l, tt.text, err)
+				continue
+			}
+			out, err := p.Decode(c)
+			if err != nil {
+				t.Errorf("%v-%v: Decode: %v", tt.v, tt.l, err)
+				continue
+			}
+			if !reflect.DeepEqual(out, tt.text) {
+				t.Errorf("%v-%v: Decode = %v, want %v", tt.v, tt.l, out, tt.text)
+			}
+		}
+	}
+
+	p, err := NewPlan(M2, L, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []Encoding{String("x"), ECI(UTF8), Num("12345678901234")} {
+		if _, err := p.Encode(text); err == nil {
+			t.Errorf("M2-L: Encode(%v) succeeded", text)
+		}
+	}
+}
+
+func TestMicroSegment(t *testing.T) {
+	if list := Segment("abc", M2); list != nil {
+		t.Errorf("Segment(abc, M2) = %v, want nil", list)
+	}
+	list := Segment("ABC123456", M2)
+	want := []Encoding{Alpha("ABC"), Num("123456")}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("Segment(ABC123456, M2) = %v, want %v", list, want)
+	}
+	list = Segment("1234567890", M1)
+	want = []Encoding{Num("1234567"), Num("890")}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("Segment(1234567890, M1) = %v, want %v", list, want)
+	}
+}
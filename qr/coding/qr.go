@@ -21,16 +21,27 @@ var Field = gf256.NewField(0x11d, 2)
 // a QR code with version v has 4v+17 pixels on a side.
 // Versions number from 1 to 40: the larger the version,
 // the more information the code can store.
+//
+// The Micro QR versions M1 through M4 are represented
+// by negative numbers; see Micro.
 type Version int
 
 const MinVersion = 1
 const MaxVersion = 40
 
 func (v Version) String() string {
+	if v.Micro() {
+		return "M" + strconv.Itoa(int(-v))
+	}
 	return strconv.Itoa(int(v))
 }
 
+// sizeClass returns the index into the character count
+// length tables (numLen and so on) for version v.
 func (v Version) sizeClass() int {
+	if v.Micro() {
+		return 2 + int(-v)
+	}
 	if v <= 9 {
 		return 0
 	}
@@ -42,16 +53,54 @@ func (v Version) sizeClass() int {
 
 // DataBytes returns the number of data bytes that can be
 // stored in a QR code with the given version and level.
+// For Micro QR versions M1 and M3, the last data byte
+// holds only 4 bits; see DataBits.
 func (v Version) DataBytes(l Level) int {
+	if v.Micro() {
+		return (v.DataBits(l) + 7) / 8
+	}
 	vt := &vtab[v]
 	lev := &vt.level[l]
 	return vt.bytes - lev.nblock*lev.check
 }
 
+// DataBits returns the number of data bits that can be
+// stored in a QR code with the given version and level.
+func (v Version) DataBits(l Level) int {
+	if v.Micro() {
+		if l < L || l > Q {
+			return 0
+		}
+		return mtab[-v][l].bits
+	}
+	return v.DataBytes(l) * 8
+}
+
+// modeLen returns the number of bits in a mode indicator.
+func (v Version) modeLen() int {
+	if v.Micro() {
+		return int(-v) - 1
+	}
+	return 4
+}
+
+// writeMode writes the mode indicator for a segment:
+// qr in regular QR codes, micro in Micro QR codes.
+func writeMode(b *Bits, v Version, qr, micro uint) {
+	if v.Micro() {
+		b.Write(micro, v.modeLen())
+	} else {
+		b.Write(qr, 4)
+	}
+}
+
 // Encoding implements a QR data encoding scheme.
-// The implementations--Numeric, Alphanumeric, and String--specify
+// The implementations--Numeric, Alphanumeric, String, and Kanji--specify
 // the character set and the mapping from UTF-8 to code bits.
 // The more restrictive the mode, the fewer code bits are needed.
+// ECI is also an Encoding, although it encodes no text: it records
+// the character set of the String data that follows it.
+// So is Append, which marks a code as part of a longer message.
 type Encoding interface {
 	Check() error
 	Bits(v Version) int
@@ -126,14 +175,22 @@ func (s Num) Check() error {
 	return nil
 }
 
-var numLen = [3]int{10, 12, 14}
+// The character count lengths, indexed by sizeClass:
+// versions 1-9, 10-26, 27-40, then M1, M2, M3, M4.
+// A zero length means the mode is not available.
+var (
+	numLen    = [7]int{10, 12, 14, 3, 4, 5, 6}
+	alphaLen  = [7]int{9, 11, 13, 0, 3, 4, 5}
+	stringLen = [7]int{8, 16, 16, 0, 0, 4, 5}
+	kanjiLen  = [7]int{8, 10, 12, 0, 0, 3, 4}
+)
 
 func (s Num) Bits(v Version) int {
-	return 4 + numLen[v.sizeClass()] + (10*len(s)+2)/3
+	return v.modeLen() + numLen[v.sizeClass()] + (10*len(s)+2)/3
 }
 
 func (s Num) Encode(b *Bits, v Version) {
-	b.Write(1, 4)
+	writeMode(b, v, 1, 0)
 	b.Write(uint(len(s)), numLen[v.sizeClass()])
 	var i int
 	for i = 0; i+3 <= len(s); i += 3 {
@@ -169,14 +226,12 @@ func (s Alpha) Check() error {
 	return nil
 }
 
-var alphaLen = [3]int{9, 11, 13}
-
 func (s Alpha) Bits(v Version) int {
-	return 4 + alphaLen[v.sizeClass()] + (11*len(s)+1)/2
+	return v.modeLen() + alphaLen[v.sizeClass()] + (11*len(s)+1)/2
 }
 
 func (s Alpha) Encode(b *Bits, v Version) {
-	b.Write(2, 4)
+	writeMode(b, v, 2, 1)
 	b.Write(uint(len(s)), alphaLen[v.sizeClass()])
 	var i int
 	for i = 0; i+2 <= len(s); i += 2 {
@@ -202,20 +257,138 @@ func (s String) Check() error {
 	return nil
 }
 
-var stringLen = [3]int{8, 16, 16}
-
 func (s String) Bits(v Version) int {
-	return 4 + stringLen[v.sizeClass()] + 8*len(s)
+	return v.modeLen() + stringLen[v.sizeClass()] + 8*len(s)
 }
 
 func (s String) Encode(b *Bits, v Version) {
-	b.Write(4, 4)
+	writeMode(b, v, 4, 2)
 	b.Write(uint(len(s)), stringLen[v.sizeClass()])
 	for i := 0; i < len(s); i++ {
 		b.Write(uint(s[i]), 8)
 	}
 }
 
+// Kanji is the encoding for Kanji characters.
+// The string holds the characters in Shift JIS, two bytes per
+// character, and each character must be in the range 0x8140 to 0x9FFC
+// or 0xE040 to 0xEBBF.
+type Kanji string
+
+func (s Kanji) String() string {
+	return fmt.Sprintf("Kanji(%#q)", string(s))
+}
+
+func (s Kanji) Check() error {
+	if len(s)%2 != 0 {
+		return fmt.Errorf("odd-length Shift JIS string %#q", string(s))
+	}
+	for i := 0; i < len(s); i += 2 {
+		c := uint(s[i])<<8 | uint(s[i+1])
+		if !(0x8140 <= c && c <= 0x9FFC || 0xE040 <= c && c <= 0xEBBF) || s[i+1] < 0x40 || s[i+1] == 0x7F || s[i+1] > 0xFC {
+			return fmt.Errorf("non-Kanji string %#q", string(s))
+		}
+	}
+	return nil
+}
+
+func (s Kanji) Bits(v Version) int {
+	return v.modeLen() + kanjiLen[v.sizeClass()] + 13*(len(s)/2)
+}
+
+func (s Kanji) Encode(b *Bits, v Version) {
+	writeMode(b, v, 8, 3)
+	b.Write(uint(len(s)/2), kanjiLen[v.sizeClass()])
+	for i := 0; i+2 <= len(s); i += 2 {
+		c := uint(s[i])<<8 | uint(s[i+1])
+		if c >= 0xE040 {
+			c -= 0xC140
+		} else {
+			c -= 0x8140
+		}
+		b.Write((c>>8)*0xC0+c&0xFF, 13)
+	}
+}
+
+// An ECI is an Extended Channel Interpretation segment,
+// which tells the reader how to interpret the 8-bit data
+// in the String segments that follow it.
+// Valid ECI assignment numbers are 0 through 999999.
+// Micro QR codes cannot hold ECI segments.
+type ECI int
+
+// Common ECI assignment numbers.
+const (
+	ISO8859_1 ECI = 3
+	ShiftJIS  ECI = 20
+	UTF8      ECI = 26
+)
+
+func (e ECI) String() string {
+	return fmt.Sprintf("ECI(%d)", int(e))
+}
+
+func (e ECI) Check() error {
+	if e < 0 || e > 999999 {
+		return fmt.Errorf("invalid ECI assignment number %d", int(e))
+	}
+	return nil
+}
+
+func (e ECI) Bits(v Version) int {
+	switch {
+	case e < 1<<7:
+		return 4 + 8
+	case e < 1<<14:
+		return 4 + 16
+	}
+	return 4 + 24
+}
+
+func (e ECI) Encode(b *Bits, v Version) {
+	b.Write(7, 4)
+	switch {
+	case e < 1<<7:
+		b.Write(uint(e), 8)
+	case e < 1<<14:
+		b.Write(2<<14|uint(e), 16)
+	default:
+		b.Write(6<<21|uint(e), 24)
+	}
+}
+
+// An Append is a Structured Append header, which marks a code
+// as one of a sequence of up to 16 codes holding a single message.
+// It must be the first segment in the code.
+// Micro QR codes cannot hold Structured Append headers.
+type Append struct {
+	Index  int  // position of this code in the sequence, from 0
+	Total  int  // number of codes in the sequence, 1 to 16
+	Parity byte // XOR of all the data bytes in the message
+}
+
+func (a Append) String() string {
+	return fmt.Sprintf("Append(%d/%d, %#02x)", a.Index+1, a.Total, a.Parity)
+}
+
+func (a Append) Check() error {
+	if a.Total < 1 || a.Total > 16 || a.Index < 0 || a.Index >= a.Total {
+		return fmt.Errorf("invalid structured append position %d of %d", a.Index, a.Total)
+	}
+	return nil
+}
+
+func (a Append) Bits(v Version) int {
+	return 4 + 4 + 4 + 8
+}
+
+func (a Append) Encode(b *Bits, v Version) {
+	b.Write(3, 4)
+	b.Write(uint(a.Index), 4)
+	b.Write(uint(a.Total-1), 4)
+	b.Write(uint(a.Parity), 8)
+}
+
 // A Pixel describes a single pixel in a QR code.
 type Pixel uint32
 
@@ -360,6 +533,9 @@ type Plan struct {
 // NewPlan returns a Plan for a QR code with the given
 // version, level, and mask.
 func NewPlan(version Version, level Level, mask Mask) (*Plan, error) {
+	if version.Micro() {
+		return microPlan(version, level, mask)
+	}
 	p, err := vplan(version)
 	if err != nil {
 		return nil, err
@@ -399,6 +575,10 @@ func (b *Bits) Pad(n int) {
 }
 
 func (b *Bits) AddCheckBytes(v Version, l Level) {
+	if v.Micro() {
+		b.addMicroCheckBytes(v, l)
+		return
+	}
 	nd := v.DataBytes(l)
 	if b.nbit < nd*8 {
 		b.Pad(nd*8 - b.nbit)
@@ -434,10 +614,13 @@ func (p *Plan) Encode(text ...Encoding) (*Code, error) {
 		if err := t.Check(); err != nil {
 			return nil, err
 		}
+		if err := checkMicro(t, p.Version); err != nil {
+			return nil, err
+		}
 		t.Encode(&b, p.Version)
 	}
-	if b.Bits() > p.DataBytes*8 {
-		return nil, fmt.Errorf("cannot encode %d bits into %d-bit code", b.Bits(), p.DataBytes*8)
+	if nd := p.Version.DataBits(p.Level); b.Bits() > nd {
+		return nil, fmt.Errorf("cannot encode %d bits into %d-bit code", b.Bits(), nd)
 	}
 	b.AddCheckBytes(p.Version, p.Level)
 	bytes := b.Bytes()
@@ -608,14 +791,7 @@ func fplan(l Level, m Mask, p *Plan) error {
 	// Format pixels.
 	fb := uint32(l^1) << 13 // level: L=01, M=00, Q=11, H=10
 	fb |= uint32(m) << 10   // mask
-	const formatPoly = 0x537
-	rem := fb
-	for i := 14; i >= 10; i-- {
-		if rem&(1<<uint(i)) != 0 {
-			rem ^= formatPoly << uint(i-10)
-		}
-	}
-	fb |= rem
+	fb |= formatCheck(fb)
 	invert := uint32(0x5412)
 	siz := len(p.Pixel)
 	for i := uint(0); i < 15; i++ {
@@ -648,6 +824,18 @@ func fplan(l Level, m Mask, p *Plan) error {
 	return nil
 }
 
+// formatCheck returns the BCH check bits for the format bits fb.
+func formatCheck(fb uint32) uint32 {
+	const formatPoly = 0x537
+	rem := fb
+	for i := 14; i >= 10; i-- {
+		if rem&(1<<uint(i)) != 0 {
+			rem ^= formatPoly << uint(i-10)
+		}
+	}
+	return rem
+}
+
 // lplan edits a version-only Plan to add information
 // about the error correction levels.
 func lplan(v Version, l Level, p *Plan) error {
@@ -0,0 +1,157 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package coding
+
+// Mixed-mode segmentation.
+
+import "strings"
+
+// Segment splits text into a list of Num, Alpha, and String encodings,
+// choosing the split that needs the fewest bits in a code of version v.
+// The best split depends only on which of the version ranges 1-9, 10-26,
+// and 27-40 contains v, because those ranges determine the size of
+// each segment's length field.
+// Micro QR versions offer fewer encodings; if text cannot be
+// encoded in version v at all, Segment returns nil.
+func Segment(text string, v Version) []Encoding {
+	// Dynamic programming over the bytes of text.
+	// The state records the mode of the current segment
+	// and, for Num and Alpha, the number of characters in it
+	// modulo the group size, which determines the cost of
+	// adding one more character: Num packs 3 digits into 10 bits
+	// (4, 7, 10 bits for 1, 2, 3 digits), Alpha packs 2 characters
+	// into 11 bits (6, 11 bits for 1, 2 characters).
+	const (
+		num0   = iota // Num, length%3 == 0
+		num1          // Num, length%3 == 1
+		num2          // Num, length%3 == 2
+		alpha0        // Alpha, length%2 == 0
+		alpha1        // Alpha, length%2 == 1
+		byte0         // String
+		nstate
+		none = -1
+	)
+	sc := v.sizeClass()
+	ml := v.modeLen()
+	header := [nstate]int{
+		num0: ml + numLen[sc], num1: ml + numLen[sc], num2: ml + numLen[sc],
+		alpha0: ml + alphaLen[sc], alpha1: ml + alphaLen[sc],
+		byte0: ml + stringLen[sc],
+	}
+	// Appending a character in state s costs cost[s] bits
+	// and moves to state next[s].
+	cost := [nstate]int{num0: 4, num1: 3, num2: 3, alpha0: 6, alpha1: 5, byte0: 8}
+	next := [nstate]int{num0: num1, num1: num2, num2: num0, alpha0: alpha1, alpha1: alpha0, byte0: byte0}
+
+	n := len(text)
+	if n == 0 {
+		return nil
+	}
+	const inf = 1 << 30
+	// best[i][s] is the fewest bits needed to encode text[:i]
+	// ending in state s, and from[i][s] is the state before
+	// text[i-1] was added.  split[i][s] records whether
+	// text[i-1] began a new segment.
+	best := make([][nstate]int, n+1)
+	from := make([][nstate]int, n+1)
+	split := make([][nstate]bool, n+1)
+	for s := range best[0] {
+		best[0][s] = inf
+	}
+	for i := 0; i < n; i++ {
+		c := text[i]
+		var ok [nstate]bool
+		if '0' <= c && c <= '9' {
+			ok[num0], ok[num1], ok[num2] = true, true, true
+		}
+		if strings.IndexByte(alphabet, c) >= 0 && alphaLen[sc] > 0 {
+			ok[alpha0], ok[alpha1] = true, true
+		}
+		if stringLen[sc] > 0 {
+			ok[byte0] = true
+		}
+		for s := range best[i+1] {
+			best[i+1][s] = inf
+		}
+		try := func(s, prev int, bits int, isSplit bool) {
+			if bits < best[i+1][s] {
+				best[i+1][s] = bits
+				from[i+1][s] = prev
+				split[i+1][s] = isSplit
+			}
+		}
+		for prev := none; prev < nstate; prev++ {
+			var bits int
+			if prev == none {
+				if i > 0 {
+					continue
+				}
+			} else {
+				bits = best[i][prev]
+				if bits == inf {
+					continue
+				}
+				// Continue the current segment.
+				if ok[prev] {
+					try(next[prev], prev, bits+cost[prev], false)
+				}
+			}
+			// Start a new segment.
+			for _, s := range []int{num0, alpha0, byte0} {
+				if ok[s] {
+					try(next[s], prev, bits+header[s]+cost[s], true)
+				}
+			}
+		}
+	}
+
+	// Find the best final state and walk backward
+	// to find where the segments start.
+	end := 0
+	for s := range best[n] {
+		if best[n][s] < best[n][end] {
+			end = s
+		}
+	}
+	if best[n][end] == inf {
+		return nil
+	}
+	var starts []int
+	var modes []int
+	for i, s := n, end; i > 0; i-- {
+		if split[i][s] {
+			starts = append(starts, i-1)
+			modes = append(modes, s)
+		}
+		s = from[i][s]
+	}
+
+	var list []Encoding
+	for k := len(starts) - 1; k >= 0; k-- {
+		lo, hi := starts[k], n
+		if k > 0 {
+			hi = starts[k-1]
+		}
+		seg := text[lo:hi]
+		var enc func(string) Encoding
+		var max int
+		switch modes[k] {
+		case num0, num1, num2:
+			enc, max = func(s string) Encoding { return Num(s) }, 1<<uint(numLen[sc])-1
+		case alpha0, alpha1:
+			enc, max = func(s string) Encoding { return Alpha(s) }, 1<<uint(alphaLen[sc])-1
+		default:
+			enc, max = func(s string) Encoding { return String(s) }, 1<<uint(stringLen[sc])-1
+		}
+		// Segments longer than their length field allows
+		// must be split further.
+		for len(seg) > max {
+			list = append(list, enc(seg[:max]))
+			seg = seg[max:]
+		}
+		list = append(list, enc(seg))
+	}
+	return list
+}
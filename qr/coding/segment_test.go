@@ -0,0 +1,92 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package coding
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func bits(list []Encoding, v Version) int {
+	n := 0
+	for _, e := range list {
+		n += e.Bits(v)
+	}
+	return n
+}
+
+var segmentTests = []struct {
+	text string
+	v    Version
+	out  []Encoding
+}{
+	{"", 1, nil},
+	{"0123456789", 1, []Encoding{Num("0123456789")}},
+	{"HELLO WORLD", 1, []Encoding{Alpha("HELLO WORLD")}},
+	{"hello, world", 1, []Encoding{String("hello, world")}},
+	{"ORDER-12345678901234 ship to Bob", 1, []Encoding{Alpha("ORDER-"), Num("12345678901234"), String(" ship to Bob")}},
+	{"a1", 1, []Encoding{String("a1")}},
+	{"ABC123", 1, []Encoding{Alpha("ABC123")}},
+	{"abc" + strings.Repeat("0", 300) + "def", 10, []Encoding{String("abc"), Num(strings.Repeat("0", 300)), String("def")}},
+	{strings.Repeat("1", 1100), 1, []Encoding{Num(strings.Repeat("1", 1023)), Num(strings.Repeat("1", 77))}},
+}
+
+func TestSegment(t *testing.T) {
+	for _, tt := range segmentTests {
+		out := Segment(tt.text, tt.v)
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("Segment(%.30q, %v) = %v, want %v", tt.text, tt.v, out, tt.out)
+		}
+	}
+}
+
+func TestSegmentOptimal(t *testing.T) {
+	// Compare against an exhaustive search over all
+	// segmentations of short strings drawn from a small alphabet.
+	const chars = "1A-a"
+	var all func(s string) [][]Encoding
+	all = func(s string) [][]Encoding {
+		if s == "" {
+			return [][]Encoding{nil}
+		}
+		var out [][]Encoding
+		for i := 1; i <= len(s); i++ {
+			for _, e := range []Encoding{Num(s[:i]), Alpha(s[:i]), String(s[:i])} {
+				if e.Check() != nil {
+					continue
+				}
+				for _, rest := range all(s[i:]) {
+					out = append(out, append([]Encoding{e}, rest...))
+				}
+			}
+		}
+		return out
+	}
+	for n := 0; n < 4*4*4*4; n++ {
+		var buf []byte
+		for k := n; k > 0; k /= 4 {
+			buf = append(buf, chars[k%4])
+		}
+		text := string(buf) + "111"
+		best := -1
+		for _, list := range all(text) {
+			if b := bits(list, 1); best < 0 || b < best {
+				best = b
+			}
+		}
+		list := Segment(text, 1)
+		if b := bits(list, 1); b != best {
+			t.Errorf("Segment(%q) = %v, %d bits, want %d bits", text, list, b, best)
+		}
+		var s string
+		for _, e := range list {
+			s += string(reflect.ValueOf(e).String())
+		}
+		if s != text {
+			t.Errorf("Segment(%q) = %v, covers %q", text, list, s)
+		}
+	}
+}
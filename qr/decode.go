@@ -0,0 +1,574 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package qr
+
+// QR decoder.
+//
+// The decoder binarizes the image, finds the three position squares
+// by scanning for their 1:1:3:1:1 dark:light:dark:light:dark profile,
+// maps the QR pixel grid onto the image using those squares
+// (and the lower right alignment square, when there is one),
+// and then samples the grid and hands it to package coding.
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"code.google.com/p/rsc/qr/coding"
+)
+
+// Decode returns the text encoded by the QR code in m.
+// The code may be scaled, rotated, or viewed at a moderate angle,
+// but it must be surrounded by a light quiet zone.
+// Kanji segments are returned as Shift JIS bytes,
+// and 8-bit data is returned as is, whatever its ECI.
+func Decode(m image.Image) (string, error) {
+	part, err := DecodePart(m)
+	if err != nil {
+		return "", err
+	}
+	return part.Text, nil
+}
+
+// DecodePart is like Decode but also returns the code's position
+// in a structured append sequence (see EncodeAppend).
+// A code without a structured append header is reported
+// as the only code in its sequence.
+func DecodePart(m image.Image) (*Part, error) {
+	b := binarize(m)
+	fp, err := b.findPositions()
+	if err != nil {
+		return nil, err
+	}
+	c, err := b.sample(fp)
+	if err != nil {
+		return nil, err
+	}
+	return decodePart(c)
+}
+
+// decodePart returns the text encoded by c,
+// along with its structured append header.
+func decodePart(c *coding.Code) (*Part, error) {
+	l, m, err := coding.ReadFormat(c)
+	if err != nil {
+		return nil, err
+	}
+	v, err := coding.ReadVersion(c)
+	if err != nil {
+		return nil, err
+	}
+	p, err := coding.NewPlan(v, l, m)
+	if err != nil {
+		return nil, err
+	}
+	list, err := p.Decode(c)
+	if err != nil {
+		return nil, err
+	}
+	var part *Part
+	var buf bytes.Buffer
+	for _, enc := range list {
+		switch enc := enc.(type) {
+		case coding.Append:
+			part = &Part{Index: enc.Index, Total: enc.Total, Parity: enc.Parity}
+		case coding.Num:
+			buf.WriteString(string(enc))
+		case coding.Alpha:
+			buf.WriteString(string(enc))
+		case coding.String:
+			buf.WriteString(string(enc))
+		case coding.Kanji:
+			buf.WriteString(string(enc))
+		}
+	}
+	if part == nil {
+		part = &Part{Total: 1, Parity: parity(buf.String())}
+	}
+	part.Text = buf.String()
+	return part, nil
+}
+
+// A bitmap is a binarized image.
+type bitmap struct {
+	w, h int
+	dark []bool
+}
+
+func (b *bitmap) in(x, y int) bool {
+	return 0 <= x && x < b.w && 0 <= y && y < b.h
+}
+
+// at reports whether the pixel at (x, y) is dark.
+// Pixels outside the image are light.
+func (b *bitmap) at(x, y int) bool {
+	return b.in(x, y) && b.dark[y*b.w+x]
+}
+
+// binarize converts m to a bitmap, choosing the
+// dark/light threshold using Otsu's method.
+func binarize(m image.Image) *bitmap {
+	r := m.Bounds()
+	w, h := r.Dx(), r.Dy()
+	gray := make([]byte, w*h)
+	if g, ok := m.(*image.Gray); ok {
+		for y := 0; y < h; y++ {
+			copy(gray[y*w:], g.Pix[(y+r.Min.Y-g.Rect.Min.Y)*g.Stride+r.Min.X-g.Rect.Min.X:][:w])
+		}
+	} else {
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				gray[y*w+x] = color.GrayModel.Convert(m.At(r.Min.X+x, r.Min.Y+y)).(color.Gray).Y
+			}
+		}
+	}
+
+	var hist [256]int
+	for _, v := range gray {
+		hist[v]++
+	}
+	total := float64(len(gray))
+	var sum float64
+	for v, n := range hist {
+		sum += float64(v * n)
+	}
+	var sumB, wB float64
+	var best float64
+	thresh := 128
+	for v, n := range hist {
+		wB += float64(n)
+		if wB == 0 {
+			continue
+		}
+		wF := total - wB
+		if wF == 0 {
+			break
+		}
+		sumB += float64(v * n)
+		mB := sumB / wB
+		mF := (sum - sumB) / wF
+		if between := wB * wF * (mB - mF) * (mB - mF); between > best {
+			best = between
+			thresh = v
+		}
+	}
+
+	b := &bitmap{w: w, h: h, dark: make([]bool, w*h)}
+	for i, v := range gray {
+		b.dark[i] = int(v) <= thresh
+	}
+	return b
+}
+
+// A finder is a candidate position square.
+type finder struct {
+	x, y   float64 // center
+	module float64 // estimated module (QR pixel) size
+	count  int     // number of times found
+}
+
+// runs measures the runs of a position square passing through (x, y)
+// along the direction (dx, dy), treating (x, y) as part of the middle run.
+// It returns the lengths of the five dark, light, dark, light, dark runs
+// and the offset of the center of the middle run from the center of (x, y).
+func (b *bitmap) runs(x, y, dx, dy int) (n [5]int, center float64, ok bool) {
+	// run returns the length of the run of pixels with the given
+	// color starting i steps from (x, y) and moving in direction dir.
+	run := func(i, dir int, dark bool) int {
+		k := 0
+		for {
+			xx, yy := x+(i+dir*k)*dx, y+(i+dir*k)*dy
+			if !b.in(xx, yy) || b.at(xx, yy) != dark {
+				return k
+			}
+			k++
+		}
+	}
+
+	back := run(0, -1, true)
+	n[1] = run(-back, -1, false)
+	n[0] = run(-back-n[1], -1, true)
+	fwd := run(0, +1, true)
+	n[3] = run(fwd, +1, false)
+	n[4] = run(fwd+n[3], +1, true)
+	n[2] = back + fwd - 1
+	return n, float64(fwd-back) / 2, finderRatio(n)
+}
+
+// finderRatio reports whether the run lengths n are
+// close enough to 1:1:3:1:1 to be a position square.
+func finderRatio(n [5]int) bool {
+	total := 0
+	for _, v := range n {
+		if v == 0 {
+			return false
+		}
+		total += v
+	}
+	if total < 7 {
+		return false
+	}
+	m := float64(total) / 7
+	tol := m / 2
+	for i, v := range n {
+		want := m
+		if i == 2 {
+			want = 3 * m
+		}
+		if math.Abs(float64(v)-want) >= tol*want/m {
+			return false
+		}
+	}
+	return true
+}
+
+// module returns the module size of a position square rotated by an
+// unknown angle θ, given the lengths a and d of its axis-aligned and
+// diagonal chords through the center.  For a square of side u,
+// a = u/cos θ and d = u/cos(45°-θ), where θ is reduced to [0°, 45°].
+func module(a, d float64) float64 {
+	x := math.Sqrt2/d - 1/a
+	return 1 / math.Sqrt(1/(a*a)+x*x) / 7
+}
+
+func sum(n [5]int) int {
+	return n[0] + n[1] + n[2] + n[3] + n[4]
+}
+
+// findPositions returns the centers of the three position squares,
+// in the order upper left, upper right, lower left.
+func (b *bitmap) findPositions() ([3]finder, error) {
+	var cand []finder
+	for y := 0; y < b.h; y++ {
+		for x := 0; x < b.w; x++ {
+			if !b.at(x, y) || b.at(x-1, y) {
+				continue
+			}
+			// x is the start of a dark run.
+			// See whether it is the middle of a position square.
+			_, off, ok := b.runs(x, y, 1, 0)
+			if !ok {
+				continue
+			}
+			cx := x + int(off+0.5)
+			nv, offv, ok := b.runs(cx, y, 0, 1)
+			if !ok {
+				continue
+			}
+			cy := y + int(offv+0.5)
+			nh, offh, ok := b.runs(cx, cy, 1, 0)
+			if !ok {
+				continue
+			}
+			nd1, _, ok := b.runs(cx, cy, 1, 1)
+			if !ok {
+				continue
+			}
+			nd2, _, ok := b.runs(cx, cy, 1, -1)
+			if !ok {
+				continue
+			}
+			f := finder{
+				x:      float64(cx) + offh + 0.5,
+				y:      float64(y) + offv + 0.5,
+				module: module(float64(sum(nh)+sum(nv))/2, float64(sum(nd1)+sum(nd2))/2*math.Sqrt2),
+				count:  1,
+			}
+			cand = addFinder(cand, f)
+		}
+	}
+
+	// Consider the most frequently seen candidates.
+	sort.Sort(byCount(cand))
+	if len(cand) > 10 {
+		cand = cand[:10]
+	}
+
+	// Pick the three that look most like the corners of a QR code.
+	var best [3]finder
+	bestScore := math.Inf(1)
+	for i := 0; i < len(cand); i++ {
+		for j := i + 1; j < len(cand); j++ {
+			for k := j + 1; k < len(cand); k++ {
+				fp, score := corners(cand[i], cand[j], cand[k])
+				if score < bestScore {
+					best, bestScore = fp, score
+				}
+			}
+		}
+	}
+	if bestScore > 0.5 {
+		return best, errors.New("no QR code found in image")
+	}
+	return best, nil
+}
+
+// addFinder adds f to the list of candidates, merging it
+// with an earlier candidate at the same location.
+func addFinder(cand []finder, f finder) []finder {
+	for i := range cand {
+		c := &cand[i]
+		if math.Abs(c.x-f.x) <= c.module && math.Abs(c.y-f.y) <= c.module &&
+			math.Abs(c.module-f.module) <= c.module/2 {
+			n := float64(c.count)
+			c.x = (c.x*n + f.x) / (n + 1)
+			c.y = (c.y*n + f.y) / (n + 1)
+			c.module = (c.module*n + f.module) / (n + 1)
+			c.count++
+			return cand
+		}
+	}
+	return append(cand, f)
+}
+
+type byCount []finder
+
+func (x byCount) Len() int           { return len(x) }
+func (x byCount) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+func (x byCount) Less(i, j int) bool { return x[i].count > x[j].count }
+
+// corners arranges the three candidates as upper left, upper right,
+// and lower left corners.  It also returns a score measuring how far
+// the candidates are from forming an isosceles right triangle with
+// similarly sized position squares; smaller is better.
+func corners(a, b, c finder) ([3]finder, float64) {
+	dist := func(p, q finder) float64 { return math.Hypot(p.x-q.x, p.y-q.y) }
+	ab, bc, ca := dist(a, b), dist(b, c), dist(c, a)
+
+	// The upper left corner is opposite the hypotenuse.
+	switch {
+	case ab >= bc && ab >= ca:
+		a, b, c = c, a, b
+		ab, bc, ca = ca, ab, bc
+	case ca >= ab && ca >= bc:
+		a, b, c = b, c, a
+		ab, bc, ca = bc, ca, ab
+	}
+	// Now bc is the hypotenuse and a is the upper left.
+	// With y pointing down, b is the upper right if
+	// (b-a) × (c-a) is positive.
+	if (b.x-a.x)*(c.y-a.y)-(b.y-a.y)*(c.x-a.x) < 0 {
+		b, c = c, b
+	}
+	if ab < 7*a.module || ca < 7*a.module {
+		return [3]finder{a, b, c}, math.Inf(1)
+	}
+
+	score := math.Abs(ab-ca) / bc
+	score += math.Abs(ab*ab+ca*ca-bc*bc) / (bc * bc)
+	m := (a.module + b.module + c.module) / 3
+	for _, f := range []finder{a, b, c} {
+		score += math.Abs(f.module-m) / m
+	}
+	return [3]finder{a, b, c}, score
+}
+
+// sample maps the QR pixel grid onto the image using the position
+// squares fp and returns the resulting code.
+func (b *bitmap) sample(fp [3]finder) (*coding.Code, error) {
+	tl, tr, bl := fp[0], fp[1], fp[2]
+	m := (tl.module + tr.module + bl.module) / 3
+	d := (math.Hypot(tr.x-tl.x, tr.y-tl.y)+math.Hypot(bl.x-tl.x, bl.y-tl.y))/(2*m) + 7
+	siz := 4*int(math.Floor((d-17)/4+0.5)) + 17
+
+	c, err := b.sampleSize(fp, siz)
+	if err != nil {
+		return nil, err
+	}
+
+	// Large codes record their version, which is a more
+	// reliable measure of size than the distance between
+	// position squares.
+	if v, err := coding.ReadVersion(c); err == nil && 17+4*int(v) != siz {
+		c, err = b.sampleSize(fp, 17+4*int(v))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// sampleSize samples a siz×siz QR code with position squares fp.
+func (b *bitmap) sampleSize(fp [3]finder, siz int) (*coding.Code, error) {
+	if _, err := coding.SizeVersion(siz); err != nil {
+		return nil, errors.New("no QR code found in image")
+	}
+	tl, tr, bl := fp[0], fp[1], fp[2]
+	s := float64(siz)
+
+	// Start with the parallelogram defined by the position squares.
+	src := [4]point{{3.5, 3.5}, {s - 3.5, 3.5}, {s - 3.5, s - 3.5}, {3.5, s - 3.5}}
+	dst := [4]point{{tl.x, tl.y}, {tr.x, tr.y}, {tr.x + bl.x - tl.x, tr.y + bl.y - tl.y}, {bl.x, bl.y}}
+	t := quadToQuad(src, dst)
+
+	// Codes with alignment squares have one near the lower right
+	// corner.  If we can find it, use it to correct for perspective.
+	if siz > 21 {
+		if ax, ay, ok := b.align(t, s-6.5, s-6.5); ok {
+			src[2] = point{s - 6.5, s - 6.5}
+			dst[2] = point{ax, ay}
+			t = quadToQuad(src, dst)
+		}
+	}
+
+	c := &coding.Code{Size: siz, Stride: (siz + 7) / 8}
+	c.Bitmap = make([]byte, c.Stride*siz)
+	for y := 0; y < siz; y++ {
+		for x := 0; x < siz; x++ {
+			ix, iy := t.apply(float64(x)+0.5, float64(y)+0.5)
+			if b.at(int(math.Floor(ix)), int(math.Floor(iy))) {
+				c.Bitmap[y*c.Stride+x/8] |= 1 << uint(7-x&7)
+			}
+		}
+	}
+	return c, nil
+}
+
+// align looks for an alignment square near QR coordinate (x, y)
+// under the transform t.  It returns the image coordinates of the
+// center of the square it finds.
+func (b *bitmap) align(t *transform, x, y float64) (ix, iy float64, ok bool) {
+	// The search radius, in QR pixels, grows with the code,
+	// because so does the perspective error in the estimate.
+	r := 4 + int(x)/8
+	best, bestDist := 0, 0
+	var bx, by float64
+	for dy := -2 * r; dy <= 2*r; dy++ {
+		for dx := -2 * r; dx <= 2*r; dx++ {
+			// Step by half pixels.
+			cx, cy := x+float64(dx)/2, y+float64(dy)/2
+			n := 0
+			for j := -2; j <= 2; j++ {
+				for i := -2; i <= 2; i++ {
+					px, py := t.apply(cx+float64(i), cy+float64(j))
+					want := i == 0 && j == 0 || i == -2 || i == 2 || j == -2 || j == 2
+					if b.at(int(math.Floor(px)), int(math.Floor(py))) == want {
+						n++
+					}
+				}
+			}
+			if d := dx*dx + dy*dy; n > best || n == best && d < bestDist {
+				best, bestDist = n, d
+				bx, by = cx, cy
+			}
+		}
+	}
+	if best < 22 {
+		return 0, 0, false
+	}
+
+	// Refine the position using the center of the dark middle pixel.
+	// Under perspective, t is only an approximation near (x, y),
+	// so a few template pixels can be misread even at the right spot.
+	// Accept a weaker match only if the middle pixel is isolated,
+	// as it is in a real alignment square.
+	ix, iy = t.apply(bx, by)
+	x1, y1 := t.apply(bx+1, by+1)
+	rad := int(math.Max(math.Abs(x1-ix), math.Abs(y1-iy))) + 1
+	if cx, cy, ok := b.centroid(int(math.Floor(ix)), int(math.Floor(iy)), rad); ok {
+		ix, iy = cx, cy
+	} else if best < 24 {
+		return 0, 0, false
+	}
+	return ix, iy, true
+}
+
+// centroid returns the centroid of the dark region containing (x, y),
+// provided it lies entirely within r pixels of (x, y).
+func (b *bitmap) centroid(x, y, r int) (cx, cy float64, ok bool) {
+	if !b.at(x, y) {
+		return 0, 0, false
+	}
+	seen := map[image.Point]bool{{x, y}: true}
+	todo := []image.Point{{x, y}}
+	var sx, sy int
+	for len(todo) > 0 {
+		p := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if p.X-x > r || x-p.X > r || p.Y-y > r || y-p.Y > r {
+			return 0, 0, false
+		}
+		sx += p.X
+		sy += p.Y
+		for _, d := range []image.Point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+			q := p.Add(d)
+			if !seen[q] && b.at(q.X, q.Y) {
+				seen[q] = true
+				todo = append(todo, q)
+			}
+		}
+	}
+	n := float64(len(seen))
+	return float64(sx)/n + 0.5, float64(sy)/n + 0.5, true
+}
+
+type point struct {
+	x, y float64
+}
+
+// A transform is a projective transformation of the plane,
+// represented as a 3×3 matrix acting on (x, y, 1).
+type transform [3][3]float64
+
+func (t *transform) apply(x, y float64) (float64, float64) {
+	d := t[2][0]*x + t[2][1]*y + t[2][2]
+	return (t[0][0]*x + t[0][1]*y + t[0][2]) / d, (t[1][0]*x + t[1][1]*y + t[1][2]) / d
+}
+
+// squareToQuad returns the transform mapping the unit square
+// (0,0), (1,0), (1,1), (0,1) to the quadrilateral q.
+func squareToQuad(q [4]point) *transform {
+	dx3 := q[0].x - q[1].x + q[2].x - q[3].x
+	dy3 := q[0].y - q[1].y + q[2].y - q[3].y
+	if dx3 == 0 && dy3 == 0 {
+		return &transform{
+			{q[1].x - q[0].x, q[2].x - q[1].x, q[0].x},
+			{q[1].y - q[0].y, q[2].y - q[1].y, q[0].y},
+			{0, 0, 1},
+		}
+	}
+	dx1, dx2 := q[1].x-q[2].x, q[3].x-q[2].x
+	dy1, dy2 := q[1].y-q[2].y, q[3].y-q[2].y
+	den := dx1*dy2 - dx2*dy1
+	g := (dx3*dy2 - dx2*dy3) / den
+	h := (dx1*dy3 - dx3*dy1) / den
+	return &transform{
+		{q[1].x - q[0].x + g*q[1].x, q[3].x - q[0].x + h*q[3].x, q[0].x},
+		{q[1].y - q[0].y + g*q[1].y, q[3].y - q[0].y + h*q[3].y, q[0].y},
+		{g, h, 1},
+	}
+}
+
+// adjugate returns the adjugate of t, which is a multiple
+// of its inverse and so represents the inverse transform.
+func (t *transform) adjugate() *transform {
+	return &transform{
+		{t[1][1]*t[2][2] - t[1][2]*t[2][1], t[0][2]*t[2][1] - t[0][1]*t[2][2], t[0][1]*t[1][2] - t[0][2]*t[1][1]},
+		{t[1][2]*t[2][0] - t[1][0]*t[2][2], t[0][0]*t[2][2] - t[0][2]*t[2][0], t[0][2]*t[1][0] - t[0][0]*t[1][2]},
+		{t[1][0]*t[2][1] - t[1][1]*t[2][0], t[0][1]*t[2][0] - t[0][0]*t[2][1], t[0][0]*t[1][1] - t[0][1]*t[1][0]},
+	}
+}
+
+// mul returns the transform applying u and then t.
+func (t *transform) mul(u *transform) *transform {
+	var r transform
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				r[i][j] += t[i][k] * u[k][j]
+			}
+		}
+	}
+	return &r
+}
+
+// quadToQuad returns the transform mapping the quadrilateral src to dst.
+func quadToQuad(src, dst [4]point) *transform {
+	return squareToQuad(dst).mul(squareToQuad(src).adjugate())
+}
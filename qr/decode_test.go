@@ -0,0 +1,178 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package qr
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+	"testing"
+)
+
+var decodeTests = []string{
+	"hello, world",
+	"0123456789012345678901234567890123456789",
+	"HTTP://GOLANG.ORG/",
+	"http://golang.org/pkg/image/?q=qr&x=1",
+	strings.Repeat("The quick brown fox jumps over the lazy dog. ", 20),
+}
+
+func TestDecode(t *testing.T) {
+	for _, text := range decodeTests {
+		for l := L; l <= H; l++ {
+			c, err := Encode(text, l)
+			if err != nil {
+				t.Errorf("Encode(%.20q, %v): %v", text, l, err)
+				continue
+			}
+			out, err := Decode(c.Image())
+			if err != nil {
+				t.Errorf("Decode(Encode(%.20q, %v)): %v", text, l, err)
+				continue
+			}
+			if out != text {
+				t.Errorf("Decode(Encode(%.20q, %v)) = %.20q", text, l, out)
+			}
+		}
+	}
+}
+
+func TestDecodePNG(t *testing.T) {
+	text := "hello, world"
+	c, err := Encode(text, M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := png.Decode(bytes.NewBuffer(c.PNG()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Decode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != text {
+		t.Errorf("Decode = %q, want %q", out, text)
+	}
+}
+
+// transformed returns a copy of c's image rotated by angle
+// radians and scaled by scale, on a light gray background.
+func transformed(c *Code, angle, scale float64) image.Image {
+	src := c.Image()
+	r := src.Bounds()
+	cx, cy := float64(r.Dx())/2, float64(r.Dy())/2
+	siz := int(float64(r.Dx())*scale*1.5) + 1
+	dst := image.NewGray(image.Rect(0, 0, siz, siz))
+	sin, cos := math.Sincos(angle)
+	for y := 0; y < siz; y++ {
+		for x := 0; x < siz; x++ {
+			dx := (float64(x) - float64(siz)/2) / scale
+			dy := (float64(y) - float64(siz)/2) / scale
+			sx := int(math.Floor(cx + dx*cos + dy*sin))
+			sy := int(math.Floor(cy - dx*sin + dy*cos))
+			v := uint8(0xE0)
+			if image.Pt(sx, sy).In(r) {
+				v = color.GrayModel.Convert(src.At(sx, sy)).(color.Gray).Y
+			}
+			dst.SetGray(x, y, color.Gray{v})
+		}
+	}
+	return dst
+}
+
+func TestDecodeRotated(t *testing.T) {
+	text := "http://golang.org/pkg/image/?q=qr&x=1"
+	c, err := Encode(text, Q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, angle := range []float64{0, 10, 45, 90, 135, 180, 200, 270} {
+		for _, scale := range []float64{0.5, 1.3} {
+			out, err := Decode(transformed(c, angle*math.Pi/180, scale))
+			if err != nil {
+				t.Errorf("angle %v scale %v: %v", angle, scale, err)
+				continue
+			}
+			if out != text {
+				t.Errorf("angle %v scale %v: Decode = %q, want %q", angle, scale, out, text)
+			}
+		}
+	}
+}
+
+func TestDecodeNoCode(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 100, 100))
+	if _, err := Decode(m); err == nil {
+		t.Errorf("Decode of blank image succeeded")
+	}
+}
+
+func TestDecodePerspective(t *testing.T) {
+	text := strings.Repeat("perspective ", 10)
+	c, err := Encode(text, M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := c.Image()
+	s := float64(src.Bounds().Dx())
+
+	// Map the code onto a trapezoid, as though photographed at an angle.
+	siz := int(1.2 * s)
+	dst := image.NewGray(image.Rect(0, 0, siz, siz))
+	quad := [4]point{{0.05 * s, 0.05 * s}, {1.1 * s, 0.1 * s}, {1.05 * s, 1.05 * s}, {0.1 * s, 1.15 * s}}
+	tt := quadToQuad(quad, [4]point{{0, 0}, {s, 0}, {s, s}, {0, s}})
+	for y := 0; y < siz; y++ {
+		for x := 0; x < siz; x++ {
+			sx, sy := tt.apply(float64(x)+0.5, float64(y)+0.5)
+			p := image.Pt(int(math.Floor(sx)), int(math.Floor(sy)))
+			v := uint8(0xFF)
+			if p.In(src.Bounds()) {
+				v = color.GrayModel.Convert(src.At(p.X, p.Y)).(color.Gray).Y
+			}
+			dst.SetGray(x, y, color.Gray{v})
+		}
+	}
+	out, err := Decode(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != text {
+		t.Errorf("Decode = %q, want %q", out, text)
+	}
+}
+
+func TestDecodeDamaged(t *testing.T) {
+	text := "http://golang.org/pkg/image/?q=qr&x=1"
+	c, err := Encode(text, H)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Scribble over the middle of the code.
+	src := c.Image()
+	m := image.NewGray(src.Bounds())
+	for y := m.Rect.Min.Y; y < m.Rect.Max.Y; y++ {
+		for x := m.Rect.Min.X; x < m.Rect.Max.X; x++ {
+			m.Set(x, y, src.At(x, y))
+		}
+	}
+	mid := m.Rect.Dx() / 2
+	w := 3 * c.Scale
+	for y := mid - w; y < mid+w; y++ {
+		for x := mid - 2*w; x < mid+2*w; x++ {
+			m.SetGray(x, y, color.Gray{0})
+		}
+	}
+	out, err := Decode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != text {
+		t.Errorf("Decode = %q, want %q", out, text)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package qr
+
+// Mask evaluation.
+
+// A Penalty is the mask evaluation score of a code,
+// broken down by the four rules in the QR specification.
+// Encoders choose the mask with the lowest total penalty,
+// on the theory that it will be easiest to scan.
+type Penalty struct {
+	Runs    int // rows and columns of 5 or more same-colored pixels
+	Blocks  int // 2x2 blocks of same-colored pixels
+	Finders int // 1:1:3:1:1 patterns that look like position boxes
+	Balance int // deviation from half black, half white
+}
+
+// Total returns the total penalty.
+func (p Penalty) Total() int {
+	return p.Runs + p.Blocks + p.Finders + p.Balance
+}
+
+// Penalty returns the mask evaluation score of c.
+func (c *Code) Penalty() Penalty {
+	var p Penalty
+	n := c.Size
+
+	// black reports whether the pixel at (x, y), or at (y, x)
+	// if transposed, is black.  The quiet zone is white.
+	black := func(x, y int, transpose bool) bool {
+		if transpose {
+			x, y = y, x
+		}
+		return c.Black(x, y)
+	}
+
+	for _, tr := range []bool{false, true} {
+		for y := 0; y < n; y++ {
+			// Runs of 5 or more score 3, plus 1 for each
+			// pixel beyond 5.
+			run := 1
+			for x := 1; x <= n; x++ {
+				if x < n && black(x, y, tr) == black(x-1, y, tr) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					p.Runs += 3 + run - 5
+				}
+				run = 1
+			}
+
+			// Dark-light-dark-dark-dark-light-dark with four
+			// light pixels on either side scores 40.
+			for x := 0; x+7 <= n; x++ {
+				if !finderAt(black, x, y, tr) {
+					continue
+				}
+				before, after := true, true
+				for i := 1; i <= 4; i++ {
+					before = before && !black(x-i, y, tr)
+					after = after && !black(x+6+i, y, tr)
+				}
+				if before || after {
+					p.Finders += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			b := c.Black(x, y)
+			if b {
+				dark++
+			}
+			if x+1 < n && y+1 < n && b == c.Black(x+1, y) &&
+				b == c.Black(x, y+1) && b == c.Black(x+1, y+1) {
+				p.Blocks += 3
+			}
+		}
+	}
+
+	// 10 points for each 5% away from 50% dark.
+	diff := dark*20 - n*n*10
+	if diff < 0 {
+		diff = -diff
+	}
+	p.Balance = diff / (n * n) * 10
+	return p
+}
+
+// finderAt reports whether the seven pixels starting
+// at (x, y) are dark-light-dark-dark-dark-light-dark.
+func finderAt(black func(x, y int, tr bool) bool, x, y int, tr bool) bool {
+	for i, want := range [7]bool{true, false, true, true, true, false, true} {
+		if black(x+i, y, tr) != want {
+			return false
+		}
+	}
+	return true
+}
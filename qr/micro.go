@@ -0,0 +1,80 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package qr
+
+import (
+	"errors"
+
+	"code.google.com/p/rsc/qr/coding"
+)
+
+// EncodeMicro returns a Micro QR encoding of text at the given error
+// correction level.  Micro QR codes are much smaller than QR codes
+// but hold at most 35 digits, 21 alphanumeric characters, or 15 bytes.
+// Level H is not available, and level Q only in the largest size.
+//
+// Like other codes, the Code returned by EncodeMicro has a quiet zone
+// of 4 pixels, even though Micro QR codes only need 2.
+func EncodeMicro(text string, level Level) (*Code, error) {
+	if level == H {
+		return nil, errors.New("level H not supported for Micro QR")
+	}
+	l := coding.Level(level)
+	for _, v := range []coding.Version{coding.M1, coding.M2, coding.M3, coding.M4} {
+		nd := v.DataBits(l)
+		if nd == 0 {
+			continue
+		}
+		list := coding.Segment(text, v)
+		if list == nil && text != "" {
+			continue
+		}
+		nbit := 0
+		for _, enc := range list {
+			nbit += enc.Bits(v)
+		}
+		if nbit > nd {
+			continue
+		}
+
+		// Pick the mask that puts the most black pixels
+		// along the right and bottom edges, as the spec says.
+		var best *coding.Code
+		var mask coding.Mask
+		bestScore := -1
+		for m := coding.Mask(0); m < 4; m++ {
+			p, err := coding.NewPlan(v, l, m)
+			if err != nil {
+				return nil, err
+			}
+			cc, err := p.Encode(list...)
+			if err != nil {
+				return nil, err
+			}
+			if s := microScore(cc); s > bestScore {
+				best, mask, bestScore = cc, m, s
+			}
+		}
+		return &Code{Bitmap: best.Bitmap, Size: best.Size, Stride: best.Stride, Scale: 8, Mask: mask}, nil
+	}
+	return nil, errors.New("text too long to encode as Micro QR")
+}
+
+// microScore returns the Micro QR mask evaluation score for c.
+func microScore(c *coding.Code) int {
+	var right, bottom int
+	for i := 1; i < c.Size; i++ {
+		if c.Black(c.Size-1, i) {
+			right++
+		}
+		if c.Black(i, c.Size-1) {
+			bottom++
+		}
+	}
+	if right > bottom {
+		right, bottom = bottom, right
+	}
+	return right*16 + bottom
+}
@@ -36,7 +36,7 @@ var pngHeader = []byte("\x89PNG\r\n\x1a\n")
 
 func (w *pngWriter) encode(c *Code) []byte {
 	scale := c.Scale
-	siz := c.Size
+	siz := c.Size + 2*c.quiet()
 
 	w.buf.Reset()
 
@@ -44,8 +44,8 @@ func (w *pngWriter) encode(c *Code) []byte {
 	w.buf.Write(pngHeader)
 
 	// Header block
-	binary.BigEndian.PutUint32(w.tmp[0:4], uint32((siz+8)*scale))
-	binary.BigEndian.PutUint32(w.tmp[4:8], uint32((siz+8)*scale))
+	binary.BigEndian.PutUint32(w.tmp[0:4], uint32(siz*scale))
+	binary.BigEndian.PutUint32(w.tmp[4:8], uint32(siz*scale))
 	w.tmp[8] = 1 // 1-bit
 	w.tmp[9] = 0 // gray
 	w.tmp[10] = 0
@@ -105,19 +105,9 @@ func (b *bitWriter) writeCode(c *Code) {
 	b.writeBits(1, 1, false) // final block
 	b.writeBits(1, 2, false) // compressed, fixed Huffman tables
 
-	// White border.
-	// First row.
-	b.byte(ftNone)
-	n := (scale*(siz+8) + 7) / 8
-	b.byte(255)
-	b.repeat(n-1, 1)
-	// 4*scale rows total.
-	b.repeat((4*scale-1)*(1+n), 1+n)
-
-	for i := 0; i < 4*scale; i++ {
-		b.adler32.WriteNByte(ftNone, 1)
-		b.adler32.WriteNByte(255, n)
-	}
+	q := c.quiet()
+	n := (scale*(siz+2*q) + 7) / 8
+	b.border(q*scale, n)
 
 	row := make([]byte, 1+n)
 	for y := 0; y < siz; y++ {
@@ -125,7 +115,7 @@ func (b *bitWriter) writeCode(c *Code) {
 		j := 1
 		var z uint8
 		nz := 0
-		for x := -4; x < siz+4; x++ {
+		for x := -q; x < siz+q; x++ {
 			// Raw data.
 			for i := 0; i < scale; i++ {
 				z <<= 1
@@ -140,7 +130,7 @@ func (b *bitWriter) writeCode(c *Code) {
 			}
 		}
 		if j < len(row) {
-			row[j] = z
+			row[j] = z << uint(8-nz)
 		}
 		for _, z := range row {
 			b.byte(z)
@@ -152,18 +142,7 @@ func (b *bitWriter) writeCode(c *Code) {
 		b.adler32.WriteN(row, scale)
 	}
 
-	// White border.
-	// First row.
-	b.byte(ftNone)
-	b.byte(255)
-	b.repeat(n-1, 1)
-	// 4*scale rows total.
-	b.repeat((4*scale-1)*(1+n), 1+n)
-
-	for i := 0; i < 4*scale; i++ {
-		b.adler32.WriteNByte(ftNone, 1)
-		b.adler32.WriteNByte(255, n)
-	}
+	b.border(q*scale, n)
 
 	// End of block.
 	b.hcode(256)
@@ -174,6 +153,32 @@ func (b *bitWriter) writeCode(c *Code) {
 	b.bytes.Write(b.tmp[0:4])
 }
 
+// border writes rows white rows of n bytes each.
+func (b *bitWriter) border(rows, n int) {
+	const ftNone = 0
+	if rows == 0 {
+		return
+	}
+
+	// First row.
+	b.byte(ftNone)
+	b.byte(255)
+	if n-1 < 3 {
+		for i := 1; i < n; i++ {
+			b.byte(255)
+		}
+	} else {
+		b.repeat(n-1, 1)
+	}
+	// Remaining rows are copies of the first.
+	b.repeat((rows-1)*(1+n), 1+n)
+
+	for i := 0; i < rows; i++ {
+		b.adler32.WriteNByte(ftNone, 1)
+		b.adler32.WriteNByte(255, n)
+	}
+}
+
 // A bitWriter is a write buffer for bit-oriented data like deflate.
 type bitWriter struct {
 	bytes bytes.Buffer
@@ -247,6 +252,9 @@ func (b *bitWriter) codex(c int, val int, nx uint) {
 }
 
 func (b *bitWriter) repeat(n, d int) {
+	if n == 0 {
+		return
+	}
 	for ; n >= 258+3; n -= 258 {
 		b.repeat1(258, d)
 	}
@@ -3,12 +3,13 @@
 // license that can be found in the LICENSE file.
 
 /*
-Package qr encodes QR codes.
+Package qr encodes and decodes QR codes.
 */
 package qr
 
 import (
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
 
@@ -28,44 +29,182 @@ const (
 
 // Encode returns an encoding of text at the given error correction level.
 func Encode(text string, level Level) (*Code, error) {
-	// Pick data encoding, smallest first.
-	// We could split the string and use different encodings
-	// but that seems like overkill for now.
-	var enc coding.Encoding
-	switch {
-	case coding.Num(text).Check() == nil:
-		enc = coding.Num(text)
-	case coding.Alpha(text).Check() == nil:
-		enc = coding.Alpha(text)
-	default:
-		enc = coding.String(text)
+	return EncodeOptions(text, level, nil)
+}
+
+// Options controls the encoding done by EncodeOptions.
+// The zero Options, like a nil *Options, gives the same
+// result as Encode.
+type Options struct {
+	// MinVersion and MaxVersion limit the QR versions (sizes)
+	// that can be used.  Zero means no limit.
+	MinVersion coding.Version
+	MaxVersion coding.Version
+
+	// If ForceMask is set, the code uses Mask.
+	// Otherwise the encoder chooses the mask with the
+	// lowest penalty score (see Code.Penalty).
+	ForceMask bool
+	Mask      coding.Mask
+
+	// If BoostLevel is set, the code uses the highest error
+	// correction level that fits in the chosen version,
+	// which is never lower than the level passed to EncodeOptions.
+	BoostLevel bool
+
+	// Scale and QuietZone set the corresponding fields
+	// of the returned Code.  A zero Scale means 8.
+	Scale     int
+	QuietZone int
+}
+
+// EncodeOptions is like Encode but takes additional options.
+func EncodeOptions(text string, level Level, opt *Options) (*Code, error) {
+	if opt == nil {
+		opt = new(Options)
+	}
+	minv, maxv := opt.MinVersion, opt.MaxVersion
+	if minv == 0 {
+		minv = 1
+	}
+	if maxv == 0 {
+		maxv = 40
+	}
+	if minv < 1 || maxv > 40 || minv > maxv {
+		return nil, fmt.Errorf("invalid QR version range %v to %v", minv, maxv)
+	}
+	if opt.ForceMask && (opt.Mask < 0 || opt.Mask > 7) {
+		return nil, fmt.Errorf("invalid QR mask %d", int(opt.Mask))
+	}
+	scale := opt.Scale
+	if scale == 0 {
+		scale = 8
+	}
+	if scale < 0 {
+		return nil, fmt.Errorf("invalid scale %d", scale)
 	}
 
-	// Pick size.
-	l := coding.Level(level)
-	var v coding.Version
-	for v = coding.MinVersion; ; v++ {
-		if v > coding.MaxVersion {
-			return nil, errors.New("text too long to encode as QR")
+	list, v, ok := segments(text, level, 0, minv, maxv)
+	if !ok {
+		return nil, errors.New("text too long to encode as QR")
+	}
+	if opt.BoostLevel {
+		nbit := 0
+		for _, enc := range list {
+			nbit += enc.Bits(v)
+		}
+		for l := H; l > level; l-- {
+			if nbit <= v.DataBytes(coding.Level(l))*8 {
+				level = l
+				break
+			}
+		}
+	}
+
+	var c *Code
+	var err error
+	if opt.ForceMask {
+		c, err = encode(list, v, level, opt.Mask)
+	} else {
+		c, _, err = bestMask(list, v, level)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Scale = scale
+	c.QuietZone = opt.QuietZone
+	return c, nil
+}
+
+// Penalties returns the penalty scores of the codes that
+// EncodeOptions would produce using each of the eight masks.
+// It ignores opt.ForceMask and opt.Mask.
+func Penalties(text string, level Level, opt *Options) ([8]Penalty, error) {
+	var p [8]Penalty
+	o := Options{ForceMask: true}
+	if opt != nil {
+		o = *opt
+		o.ForceMask = true
+	}
+	for m := range p {
+		o.Mask = coding.Mask(m)
+		c, err := EncodeOptions(text, level, &o)
+		if err != nil {
+			return p, err
+		}
+		p[m] = c.Penalty()
+	}
+	return p, nil
+}
+
+// bestMask returns the version v code holding list
+// with the lowest mask penalty, along with that penalty.
+func bestMask(list []coding.Encoding, v coding.Version, level Level) (*Code, Penalty, error) {
+	var best *Code
+	var bestPenalty Penalty
+	for m := coding.Mask(0); m < 8; m++ {
+		c, err := encode(list, v, level, m)
+		if err != nil {
+			return nil, Penalty{}, err
 		}
-		if enc.Bits(v) <= v.DataBytes(l)*8 {
-			break
+		if p := c.Penalty(); best == nil || p.Total() < bestPenalty.Total() {
+			best, bestPenalty = c, p
 		}
 	}
+	return best, bestPenalty, nil
+}
 
+// encode builds a version v code holding list.
+func encode(list []coding.Encoding, v coding.Version, level Level, mask coding.Mask) (*Code, error) {
 	// Build and execute plan.
-	p, err := coding.NewPlan(v, l, 0)
+	p, err := coding.NewPlan(v, coding.Level(level), mask)
 	if err != nil {
 		return nil, err
 	}
-	cc, err := p.Encode(enc)
+	cc, err := p.Encode(list...)
 	if err != nil {
 		return nil, err
 	}
+	return &Code{Bitmap: cc.Bitmap, Size: cc.Size, Stride: cc.Stride, Scale: 8, Mask: mask}, nil
+}
 
-	// TODO: Pick appropriate mask.
+// Segments returns the encodings that Encode uses for text at the
+// given error correction level, along with the QR version it picks.
+// Encode splits text into numeric, alphanumeric, and 8-bit segments,
+// choosing the split that fits in the smallest version.
+func Segments(text string, level Level) ([]coding.Encoding, coding.Version, error) {
+	list, v, ok := segments(text, level, 0, 1, 40)
+	if !ok {
+		return nil, 0, errors.New("text too long to encode as QR")
+	}
+	return list, v, nil
+}
 
-	return &Code{cc.Bitmap, cc.Size, cc.Stride, 8}, nil
+// segments is like Segments but leaves room for extra bits of
+// header in the code and only considers versions minv through maxv.
+// The final result reports whether text fits.
+// Empty text has an empty list and fits in any version.
+func segments(text string, level Level, extra int, minv, maxv coding.Version) ([]coding.Encoding, coding.Version, bool) {
+	// The best split depends only on the version range
+	// (see coding.Segment), so split once per range
+	// and then pick the smallest version in the range that fits.
+	l := coding.Level(level)
+	for _, r := range [][2]coding.Version{{1, 9}, {10, 26}, {27, 40}} {
+		if r[1] < minv || r[0] > maxv {
+			continue
+		}
+		list := coding.Segment(text, r[0])
+		nbit := extra
+		for _, enc := range list {
+			nbit += enc.Bits(r[0])
+		}
+		for v := r[0]; v <= r[1]; v++ {
+			if minv <= v && v <= maxv && nbit <= v.DataBytes(l)*8 {
+				return list, v, true
+			}
+		}
+	}
+	return nil, 0, false
 }
 
 // A Code is a square pixel grid.
@@ -75,6 +214,25 @@ type Code struct {
 	Size   int    // number of pixels on a side
 	Stride int    // number of bytes per row
 	Scale  int    // number of image pixels per QR pixel
+
+	// QuietZone is the width of the white border drawn around
+	// the code by Image and PNG, in QR pixels.  If QuietZone is zero,
+	// the border is 4 pixels wide.  If negative, there is no border.
+	QuietZone int
+
+	// Mask is the mask pattern used in the code.
+	Mask coding.Mask
+}
+
+// quiet returns the width of c's quiet zone.
+func (c *Code) quiet() int {
+	switch {
+	case c.QuietZone == 0:
+		return 4
+	case c.QuietZone < 0:
+		return 0
+	}
+	return c.QuietZone
 }
 
 // Black returns true if the pixel at (x,y) is black.
@@ -100,12 +258,13 @@ var (
 )
 
 func (c *codeImage) Bounds() image.Rectangle {
-	d := (c.Size + 8) * c.Scale
+	d := (c.Size + 2*c.quiet()) * c.Scale
 	return image.Rect(0, 0, d, d)
 }
 
 func (c *codeImage) At(x, y int) color.Color {
-	if c.Black(x, y) {
+	q := c.quiet()
+	if c.Black(x/c.Scale-q, y/c.Scale-q) {
 		return blackColor
 	}
 	return whiteColor
@@ -0,0 +1,262 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"code.google.com/p/rsc/qr/coding"
+)
+
+func TestSegments(t *testing.T) {
+	text := "ORDER-12345678901234 ship to Bob"
+	list, v, err := Segments(text, M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []coding.Encoding{coding.Alpha("ORDER-"), coding.Num("12345678901234"), coding.String(" ship to Bob")}
+	if !reflect.DeepEqual(list, want) || v != 2 {
+		t.Errorf("Segments(%q, M) = %v, %v, want %v, 2", text, list, v, want)
+	}
+	// A single 8-bit segment would need version 3.
+	if n := coding.String(text).Bits(2); n <= v.DataBytes(coding.M)*8 {
+		t.Errorf("String(%q) fits in version 2 (%d bits)", text, n)
+	}
+	c, err := Encode(text, M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Size != 25 {
+		t.Errorf("Encode(%q, M) has size %d, want 25", text, c.Size)
+	}
+	if out, err := Decode(c.Image()); out != text || err != nil {
+		t.Errorf("Decode(Encode(%q, M)) = %q, %v", text, out, err)
+	}
+
+	if _, _, err := Segments(strings.Repeat("x", 3000), L); err == nil {
+		t.Errorf("Segments of 3000 bytes succeeded")
+	}
+}
+
+func TestEncodeEmpty(t *testing.T) {
+	for l := L; l <= H; l++ {
+		c, err := Encode("", l)
+		if err != nil {
+			t.Errorf("Encode(\"\", %v): %v", l, err)
+			continue
+		}
+		if c.Size != 21 {
+			t.Errorf("Encode(\"\", %v) has size %d, want 21", l, c.Size)
+		}
+		if out, err := Decode(c.Image()); out != "" || err != nil {
+			t.Errorf("Decode(Encode(\"\", %v)) = %q, %v", l, out, err)
+		}
+	}
+}
+
+var microTests = []struct {
+	text  string
+	level Level
+	size  int
+}{
+	{"12345", L, 11},
+	{"HELLO", L, 13},
+	{"01234567", M, 13},
+	{"hello", L, 15},
+	{"0123456789012345678901234567890123", L, 17},
+	{"hello, world", M, 17},
+	{"1234567890", Q, 17},
+}
+
+func TestEncodeMicro(t *testing.T) {
+	for _, tt := range microTests {
+		c, err := EncodeMicro(tt.text, tt.level)
+		if err != nil {
+			t.Errorf("EncodeMicro(%q, %v): %v", tt.text, tt.level, err)
+			continue
+		}
+		if c.Size != tt.size {
+			t.Errorf("EncodeMicro(%q, %v) has size %d, want %d", tt.text, tt.level, c.Size, tt.size)
+		}
+		// The code must decode using the plan for its size, level, and mask.
+		cc := &coding.Code{Bitmap: c.Bitmap, Size: c.Size, Stride: c.Stride}
+		v := coding.Version(-(c.Size - 9) / 2)
+		found := false
+		for m := coding.Mask(0); m < 4; m++ {
+			p, err := coding.NewPlan(v, coding.Level(tt.level), m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			list, err := p.Decode(cc)
+			if err != nil {
+				continue
+			}
+			var s string
+			for _, enc := range list {
+				s += reflect.ValueOf(enc).String()
+			}
+			if s == tt.text {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("EncodeMicro(%q, %v) does not decode", tt.text, tt.level)
+		}
+	}
+
+	for _, text := range []string{"01234567890123456789012345678901234567", "hello, world, again"} {
+		if _, err := EncodeMicro(text, L); err == nil {
+			t.Errorf("EncodeMicro(%q, L) succeeded", text)
+		}
+	}
+	for _, text := range []string{"", "1"} {
+		if _, err := EncodeMicro(text, H); err == nil || !strings.Contains(err.Error(), "level H") {
+			t.Errorf("EncodeMicro(%q, H) = %v, want error about level H", text, err)
+		}
+	}
+}
+
+func TestEncodeAppend(t *testing.T) {
+	text := strings.Repeat("Structured append spreads one message over several codes. ", 80) + "¡Olé!"
+	codes, err := EncodeAppend(text, M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) < 2 {
+		t.Fatalf("EncodeAppend returned %d codes, want several", len(codes))
+	}
+	var parts []*Part
+	for i := len(codes) - 1; i >= 0; i-- {
+		part, err := DecodePart(codes[i].Image())
+		if err != nil {
+			t.Fatalf("DecodePart(code %d): %v", i, err)
+		}
+		if part.Index != i || part.Total != len(codes) || !utf8.ValidString(part.Text) {
+			t.Errorf("code %d: part %d of %d, text %.20q", i, part.Index, part.Total, part.Text)
+		}
+		parts = append(parts, part)
+	}
+	out, err := Join(parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != text {
+		t.Errorf("Join = %.40q..., want %.40q...", out, text)
+	}
+
+	if _, err := Join(parts[1:]); err == nil {
+		t.Errorf("Join with missing part succeeded")
+	}
+	if _, err := Join(append(parts, parts[0])); err == nil {
+		t.Errorf("Join with duplicate part succeeded")
+	}
+	bad := *parts[0]
+	bad.Text += "x"
+	if _, err := Join(append([]*Part{&bad}, parts[1:]...)); err == nil {
+		t.Errorf("Join with corrupt part succeeded")
+	}
+
+	if _, err := EncodeAppend(strings.Repeat("x", 17*3000), L); err == nil {
+		t.Errorf("EncodeAppend of huge text succeeded")
+	}
+}
+
+func TestDecodePartSingle(t *testing.T) {
+	c, err := Encode("hello, world", L)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err := DecodePart(c.Image())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Join([]*Part{part})
+	if err != nil || out != "hello, world" {
+		t.Errorf("Join(DecodePart(Encode(...))) = %q, %v", out, err)
+	}
+}
+
+func TestEncodeOptions(t *testing.T) {
+	text := "hello, world"
+	c, err := EncodeOptions(text, L, &Options{MinVersion: 5, ForceMask: true, Mask: 3, Scale: 3, QuietZone: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Size != 37 || c.Mask != 3 {
+		t.Errorf("Size, Mask = %d, %d, want 37, 3", c.Size, c.Mask)
+	}
+	if r := c.Image().Bounds(); r.Dx() != 3*37 {
+		t.Errorf("image width %d, want %d", r.Dx(), 3*37)
+	}
+	m, err := png.Decode(bytes.NewBuffer(c.PNG()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Bounds() != c.Image().Bounds() {
+		t.Errorf("PNG bounds %v, want %v", m.Bounds(), c.Image().Bounds())
+	}
+	for y := 0; y < m.Bounds().Dy(); y++ {
+		for x := 0; x < m.Bounds().Dx(); x++ {
+			if m.At(x, y) != c.Image().At(x, y) {
+				t.Fatalf("PNG and Image differ at %d,%d", x, y)
+			}
+		}
+	}
+
+	if _, err := EncodeOptions(strings.Repeat("x", 100), L, &Options{MaxVersion: 3}); err == nil {
+		t.Errorf("EncodeOptions with small MaxVersion succeeded")
+	}
+	if _, err := EncodeOptions(text, L, &Options{MinVersion: 10, MaxVersion: 9}); err == nil {
+		t.Errorf("EncodeOptions with empty version range succeeded")
+	}
+
+	// Boosting the level should use the same size code.
+	c, err = EncodeOptions(text, L, &Options{BoostLevel: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := &coding.Code{Bitmap: c.Bitmap, Size: c.Size, Stride: c.Stride}
+	l, _, err := coding.ReadFormat(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Size != 21 || l != coding.Level(M) {
+		t.Errorf("boosted code has size %d, level %v, want 21, M", c.Size, l)
+	}
+	if out, err := Decode(c.Image()); err != nil || out != text {
+		t.Errorf("Decode(boosted) = %q, %v", out, err)
+	}
+}
+
+func TestPenalty(t *testing.T) {
+	c := &Code{Bitmap: make([]byte, 3*21), Size: 21, Stride: 3}
+	want := Penalty{Runs: 2 * 21 * (3 + 16), Blocks: 20 * 20 * 3, Balance: 100}
+	if p := c.Penalty(); p != want {
+		t.Errorf("blank Penalty = %+v, want %+v", p, want)
+	}
+
+	text := "http://golang.org/pkg/image/?q=qr&x=1"
+	p, err := Penalties(text, M, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = Encode(text, M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for m := range p {
+		if p[m].Total() < p[c.Mask].Total() {
+			t.Errorf("Encode chose mask %d (penalty %d), but mask %d has penalty %d", c.Mask, p[c.Mask].Total(), m, p[m].Total())
+		}
+	}
+	if p[c.Mask] != c.Penalty() {
+		t.Errorf("Penalties()[%d] = %+v, but Penalty() = %+v", c.Mask, p[c.Mask], c.Penalty())
+	}
+}
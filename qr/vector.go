@@ -0,0 +1,243 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package qr
+
+// Vector (SVG, PDF, EPS) writers for QR codes.
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+)
+
+// VectorOptions controls the drawing of a Code
+// by the SVG, PDF, and EPS methods.
+// A nil *VectorOptions means to use the defaults.
+type VectorOptions struct {
+	// Module is the size of a single QR pixel, in output units:
+	// SVG user units or PostScript and PDF points.
+	// If Module is zero, the code's Scale is used.
+	Module float64
+
+	// QuietZone is the width of the blank border around the code,
+	// in QR pixels.  If QuietZone is zero, the code's own QuietZone
+	// is used, as for PNG and Image.  If negative, there is no border.
+	QuietZone int
+
+	// Foreground and Background are the colors of the black and white
+	// QR pixels.  If nil, they default to black and white.
+	// A fully transparent background is not drawn at all.
+	Foreground color.Color
+	Background color.Color
+}
+
+// vector holds the resolved drawing parameters.
+type vector struct {
+	module float64
+	quiet  int
+	fg, bg color.Color
+	drawBG bool
+	n      int // number of QR pixels on a side, including quiet zone
+}
+
+func (c *Code) vector(opt *VectorOptions) *vector {
+	if opt == nil {
+		opt = new(VectorOptions)
+	}
+	v := &vector{
+		module: opt.Module,
+		quiet:  opt.QuietZone,
+		fg:     opt.Foreground,
+		bg:     opt.Background,
+	}
+	if v.module <= 0 {
+		v.module = float64(c.Scale)
+	}
+	switch {
+	case v.quiet == 0:
+		v.quiet = c.quiet()
+	case v.quiet < 0:
+		v.quiet = 0
+	}
+	if v.fg == nil {
+		v.fg = blackColor
+	}
+	if v.bg == nil {
+		v.bg = whiteColor
+	}
+	_, _, _, a := v.bg.RGBA()
+	v.drawBG = a != 0
+	v.n = c.Size + 2*v.quiet
+	return v
+}
+
+// size returns the width (and height) of the drawing, in output units.
+func (v *vector) size() float64 {
+	return v.module * float64(v.n)
+}
+
+// runs calls f for each horizontal run of black pixels in c,
+// giving the run's starting position and length in QR pixels,
+// relative to the upper left corner of the quiet zone.
+func (c *Code) runs(quiet int, f func(x, y, n int)) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; {
+			if !c.Black(x, y) {
+				x++
+				continue
+			}
+			x0 := x
+			for x < c.Size && c.Black(x, y) {
+				x++
+			}
+			f(quiet+x0, quiet+y, x-x0)
+		}
+	}
+}
+
+// num formats x compactly for use in SVG, PDF, and PostScript.
+func num(x float64) string {
+	return strconv.FormatFloat(x, 'f', -1, 64)
+}
+
+// rgb returns the color components of c, scaled to [0, 1].
+func rgb(c color.Color) (r, g, b float64) {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return float64(n.R) / 255, float64(n.G) / 255, float64(n.B) / 255
+}
+
+// hex returns c as an SVG color and opacity.
+func hex(c color.Color) (string, float64) {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B), float64(n.A) / 255
+}
+
+// SVG returns an SVG image displaying the code.
+// The black pixels are drawn as a single path,
+// with each horizontal run of pixels a single rectangle.
+func (c *Code) SVG(opt *VectorOptions) []byte {
+	v := c.vector(opt)
+	var buf bytes.Buffer
+	size := num(v.size())
+	fmt.Fprintf(&buf, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(&buf, "<svg xmlns=\"http://www.w3.org/2000/svg\" version=\"1.1\" width=\"%s\" height=\"%s\" viewBox=\"0 0 %d %d\" shape-rendering=\"crispEdges\">\n", size, size, v.n, v.n)
+	if v.drawBG {
+		col, op := hex(v.bg)
+		fmt.Fprintf(&buf, "<rect width=\"%d\" height=\"%d\" fill=\"%s\"%s/>\n", v.n, v.n, col, opacity(op))
+	}
+	col, op := hex(v.fg)
+	fmt.Fprintf(&buf, "<path fill=\"%s\"%s d=\"", col, opacity(op))
+	c.runs(v.quiet, func(x, y, n int) {
+		fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", x, y, n, n)
+	})
+	fmt.Fprintf(&buf, "\"/>\n</svg>\n")
+	return buf.Bytes()
+}
+
+func opacity(op float64) string {
+	if op == 1 {
+		return ""
+	}
+	return fmt.Sprintf(" fill-opacity=\"%s\"", num(math.Floor(op*1000+0.5)/1000))
+}
+
+// psOps names the drawing operators used by drawPS.
+// PostScript and PDF differ mainly in spelling.
+type psOps struct {
+	setrgb string // set fill color
+	concat string // concatenate matrix onto transform
+	rect   string // add or fill rectangle
+	fill   string // fill added rectangles; empty if rect fills
+}
+
+var (
+	epsOps = psOps{"setrgbcolor", "concat", "rectfill", ""}
+	pdfOps = psOps{"rg", "cm", "re", "f"}
+)
+
+// drawPS writes PostScript or PDF drawing commands for the code.
+func (c *Code) drawPS(buf *bytes.Buffer, v *vector, op psOps) {
+	s := num(v.size())
+	fill := func() {
+		if op.fill != "" {
+			fmt.Fprintf(buf, "%s\n", op.fill)
+		}
+	}
+	if v.drawBG {
+		r, g, b := rgb(v.bg)
+		fmt.Fprintf(buf, "%s %s %s %s\n", num(r), num(g), num(b), op.setrgb)
+		fmt.Fprintf(buf, "0 0 %s %s %s\n", s, s, op.rect)
+		fill()
+	}
+	r, g, b := rgb(v.fg)
+	fmt.Fprintf(buf, "%s %s %s %s\n", num(r), num(g), num(b), op.setrgb)
+
+	// Flip the y axis so that runs can be drawn top down, in QR pixels.
+	m := num(v.module)
+	if op.concat == "concat" {
+		fmt.Fprintf(buf, "[%s 0 0 -%s 0 %s] concat\n", m, m, s)
+	} else {
+		fmt.Fprintf(buf, "%s 0 0 -%s 0 %s %s\n", m, m, s, op.concat)
+	}
+	c.runs(v.quiet, func(x, y, n int) {
+		fmt.Fprintf(buf, "%d %d %d 1 %s\n", x, y, n, op.rect)
+	})
+	fill()
+}
+
+// EPS returns an Encapsulated PostScript drawing of the code,
+// suitable for including in other PostScript documents.
+func (c *Code) EPS(opt *VectorOptions) []byte {
+	v := c.vector(opt)
+	var buf bytes.Buffer
+	s := v.size()
+	fmt.Fprintf(&buf, "%%!PS-Adobe-3.0 EPSF-3.0\n")
+	fmt.Fprintf(&buf, "%%%%Creator: QR-EPS http://qr.swtch.com/\n")
+	fmt.Fprintf(&buf, "%%%%BoundingBox: 0 0 %d %d\n", int(math.Ceil(s)), int(math.Ceil(s)))
+	fmt.Fprintf(&buf, "%%%%HiResBoundingBox: 0 0 %s %s\n", num(s), num(s))
+	fmt.Fprintf(&buf, "%%%%EndComments\n")
+	fmt.Fprintf(&buf, "gsave\n")
+	c.drawPS(&buf, v, epsOps)
+	fmt.Fprintf(&buf, "grestore\n")
+	fmt.Fprintf(&buf, "%%%%EOF\n")
+	return buf.Bytes()
+}
+
+// PDF returns a single-page PDF document displaying the code.
+// The page is exactly the size of the code.
+func (c *Code) PDF(opt *VectorOptions) []byte {
+	v := c.vector(opt)
+	var content bytes.Buffer
+	c.drawPS(&content, v, pdfOps)
+
+	var buf bytes.Buffer
+	var offsets []int
+	obj := func(format string, args ...interface{}) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n", len(offsets))
+		fmt.Fprintf(&buf, format, args...)
+		fmt.Fprintf(&buf, "\nendobj\n")
+	}
+
+	s := num(v.size())
+	fmt.Fprintf(&buf, "%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj("<< /Type /Pages /Kids [3 0 R] /Count 1 >>")
+	obj("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Contents 4 0 R /Resources << >> >>", s, s)
+	obj("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.Bytes())
+	obj("<< /Producer (QR-PDF http://qr.swtch.com/) >>")
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n", len(offsets)+1)
+	fmt.Fprintf(&buf, "0000000000 65535 f \n")
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\n", len(offsets)+1, len(offsets))
+	fmt.Fprintf(&buf, "startxref\n%d\n%%%%EOF\n", xref)
+	return buf.Bytes()
+}
@@ -0,0 +1,156 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package qr
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// svgPixels parses the path drawn by SVG and returns
+// the set of QR pixels it covers.
+func svgPixels(t *testing.T, data []byte, quiet int) map[[2]int]bool {
+	var svg struct {
+		Width string `xml:"width,attr"`
+		Path  struct {
+			D string `xml:"d,attr"`
+		} `xml:"path"`
+	}
+	if err := xml.Unmarshal(data, &svg); err != nil {
+		t.Fatalf("parsing SVG: %v", err)
+	}
+	pix := make(map[[2]int]bool)
+	re := regexp.MustCompile(`M(\d+) (\d+)h(\d+)v1h-(\d+)z`)
+	d := svg.Path.D
+	for _, m := range re.FindAllStringSubmatch(d, -1) {
+		x, _ := strconv.Atoi(m[1])
+		y, _ := strconv.Atoi(m[2])
+		n, _ := strconv.Atoi(m[3])
+		for i := 0; i < n; i++ {
+			pix[[2]int{x + i - quiet, y - quiet}] = true
+		}
+	}
+	if re.ReplaceAllString(d, "") != "" {
+		t.Fatalf("unexpected path data %q", re.ReplaceAllString(d, ""))
+	}
+	return pix
+}
+
+func TestSVG(t *testing.T) {
+	c, err := Encode("hello, world", M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, quiet := range []int{0, 2, -1} {
+		data := c.SVG(&VectorOptions{QuietZone: quiet, Module: 2.5})
+		q := quiet
+		switch {
+		case q == 0:
+			q = 4
+		case q < 0:
+			q = 0
+		}
+		pix := svgPixels(t, data, q)
+		for y := 0; y < c.Size; y++ {
+			for x := 0; x < c.Size; x++ {
+				if pix[[2]int{x, y}] != c.Black(x, y) {
+					t.Fatalf("quiet %d: pixel %d,%d = %v, want %v", quiet, x, y, pix[[2]int{x, y}], c.Black(x, y))
+				}
+			}
+		}
+		want := fmt.Sprintf(`width="%v"`, 2.5*float64(c.Size+2*q))
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("quiet %d: SVG missing %s", quiet, want)
+		}
+	}
+}
+
+func TestSVGColors(t *testing.T) {
+	c, err := Encode("hello, world", M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := c.SVG(&VectorOptions{
+		Foreground: color.RGBA{0x00, 0x00, 0x80, 0xFF},
+		Background: color.Transparent,
+	})
+	if !bytes.Contains(data, []byte(`fill="#000080"`)) {
+		t.Errorf("SVG missing foreground color:\n%s", data)
+	}
+	if bytes.Contains(data, []byte("<rect")) {
+		t.Errorf("SVG draws transparent background:\n%s", data)
+	}
+}
+
+func TestPDF(t *testing.T) {
+	c, err := Encode("hello, world", M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := c.PDF(nil)
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) {
+		t.Fatalf("PDF header missing")
+	}
+	size := 8 * (c.Size + 8)
+	if !bytes.Contains(data, []byte(fmt.Sprintf("/MediaBox [0 0 %d %d]", size, size))) {
+		t.Errorf("PDF has wrong page size")
+	}
+
+	// Check the cross-reference table.
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if m == nil {
+		t.Fatalf("PDF trailer missing")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref does not point at xref")
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data[xref:], -1)
+	if len(entries) == 0 {
+		t.Fatalf("no xref entries")
+	}
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(data[off:], []byte(want)) {
+			t.Errorf("xref entry %d points at %.10q, want %q", i+1, data[off:], want)
+		}
+	}
+
+	// Check the content stream length.
+	m = regexp.MustCompile(`(?s)/Length (\d+) >>\nstream\n(.*?)endstream`).FindSubmatch(data)
+	if m == nil {
+		t.Fatalf("PDF content stream missing")
+	}
+	if n, _ := strconv.Atoi(string(m[1])); n != len(m[2]) {
+		t.Errorf("content stream /Length %d, have %d bytes", n, len(m[2]))
+	}
+}
+
+func TestEPS(t *testing.T) {
+	c, err := Encode("hello, world", M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := c.EPS(&VectorOptions{Module: 1.5, QuietZone: -1})
+	if !bytes.HasPrefix(data, []byte("%!PS-Adobe-3.0 EPSF-3.0\n")) {
+		t.Fatalf("EPS header missing")
+	}
+	s := 1.5 * float64(c.Size)
+	want := fmt.Sprintf("%%%%BoundingBox: 0 0 %d %d\n%%%%HiResBoundingBox: 0 0 %v %v\n", int(s+0.99), int(s+0.99), s, s)
+	if !bytes.Contains(data, []byte(want)) {
+		t.Errorf("EPS missing bounding box %q:\n%s", want, data)
+	}
+	n := bytes.Count(data, []byte(" rectfill\n"))
+	runs := 1 // background
+	c.runs(0, func(x, y, n int) { runs++ })
+	if n != runs {
+		t.Errorf("EPS has %d rectfill, want %d", n, runs)
+	}
+}